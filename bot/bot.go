@@ -14,6 +14,7 @@ import (
 	"github.com/aarondl/ultimateq/parse"
 	"log"
 	"net"
+	"sort"
 	"sync"
 	"time"
 )
@@ -23,10 +24,16 @@ const (
 	nAssumedServers = 1
 	// defaultReconnScale is how the config's ReconnTimeout is scaled.
 	defaultReconnScale = time.Second
+	// netEventsQuiet is how long the dispatch loop waits for more of a
+	// netsplit or netjoin before dispatching it.
+	netEventsQuiet = 2 * time.Second
 
 	// errFmtParsingIrcMessage is when the bot fails to parse a message
 	// during it's dispatch loop.
 	errFmtParsingIrcMessage = "bot: Failed to parse irc message (%v) (%s)\n"
+	// errFmtUpdatingState is when the state can't be updated by a message
+	// during the dispatch loop, the message is still dispatched.
+	errFmtUpdatingState = "bot: Failed to update state (%v) (%s)\n"
 	// errFmtReaderClosed is when a write fails due to a closed socket or
 	// a shutdown on the client.
 	errFmtReaderClosed = "bot: %v reader closed\n"
@@ -87,6 +94,19 @@ type Bot struct {
 	dispatcher   *dispatch.Dispatcher
 	commander    *commander.Commander
 	coreCommands *coreCommands
+	relay        *relayHandler
+
+	// Extensions
+	extensions map[string]*Extension
+	toggles    map[string]bool
+	// channels are the channel settings of each server, see setChannelSettings.
+	channels map[string]map[string]channelSettings
+
+	// Timed jobs
+	scheduler *scheduler
+
+	// Config file watching
+	watcher *configWatcher
 
 	// IoC and DI components mostly for testing.
 	attachHandlers bool
@@ -97,6 +117,12 @@ type Bot struct {
 	msgDispatchers sync.WaitGroup
 	protectStore   sync.RWMutex
 	protectServers sync.RWMutex
+	// protectExtensions protects the extension registry, the toggles are
+	// protected separately since they're checked while dispatching.
+	protectExtensions sync.RWMutex
+	protectToggles    sync.RWMutex
+	protectWatcher    sync.Mutex
+	protectChannels   sync.RWMutex
 	// protectConfig also provides locking for the server's config variables
 	// since they are the same config, just pointers to internal chunks.
 	protectConfig sync.RWMutex
@@ -137,6 +163,18 @@ func CreateBot(conf *config.Config) (*Bot, error) {
 	return createBot(conf, nil, nil, true, true)
 }
 
+// CreateBotWithProviders is like CreateBot but the connection and store
+// providers can be replaced. Nil providers fall back to the defaults. This
+// allows a whole bot to be run against something like the ircdtest package.
+func CreateBotWithProviders(conf *config.Config, connProv ConnProvider,
+	storeProv StoreProvider) (*Bot, error) {
+
+	if !CheckConfig(conf) {
+		return nil, errInvalidConfig
+	}
+	return createBot(conf, connProv, storeProv, true, true)
+}
+
 // Start runs the bot. A channel is returned, every time a server is killed
 // permanently it reports the error on this channel. When the channel is closed,
 // there are no more servers left to run and the program can safely exit.
@@ -147,6 +185,7 @@ func (b *Bot) Start() <-chan error {
 	}
 	b.protectServers.RUnlock()
 
+	b.scheduler.start()
 	go b.monitorServers()
 
 	return b.botEnd
@@ -157,8 +196,19 @@ func (b *Bot) Start() <-chan error {
 // are stopped it closes the botEnd channel.
 func (b *Bot) monitorServers() {
 	servers := 0
+	var ended []error
 	for {
+		// Deaths are queued so that a caller stopping servers without reading
+		// botEnd can't deadlock with the monitor.
+		var botEnd chan error
+		var err error
+		if len(ended) > 0 {
+			botEnd, err = b.botEnd, ended[0]
+		}
+
 		select {
+		case botEnd <- err:
+			ended = ended[1:]
 		case op := <-b.serverControl:
 			isStarted := op.server.started
 			if op.starting {
@@ -177,11 +227,11 @@ func (b *Bot) monitorServers() {
 			}
 		case op := <-b.serverEnd:
 			op.server.started = false
-			b.botEnd <- op.err
+			ended = append(ended, op.err)
 			servers--
 		}
 
-		if servers == 0 {
+		if servers == 0 && len(ended) == 0 {
 			close(b.botEnd)
 			break
 		}
@@ -212,6 +262,8 @@ func (b *Bot) startServer(srv *Server, writing, reading bool) {
 		return
 	}
 
+	srv.setQuitting(false)
+	srv.startBouncer()
 	for err == nil {
 		srv.setStatus(STATUS_CONNECTING)
 		err = srv.createIrcClient()
@@ -219,6 +271,7 @@ func (b *Bot) startServer(srv *Server, writing, reading bool) {
 
 		if err == nil {
 			srv.setStatus(STATUS_STARTED)
+			b.scheduler.wakeup()
 
 			srv.client.SpawnWorkers(writing, reading)
 			disconnect, err = b.dispatch(srv)
@@ -228,7 +281,7 @@ func (b *Bot) startServer(srv *Server, writing, reading bool) {
 		}
 
 		b.protectConfig.RLock()
-		if !disconnect || srv.conf.GetNoReconnect() {
+		if !disconnect || srv.conf.GetNoReconnect() || srv.isQuitting() {
 			b.protectConfig.RUnlock()
 			break
 		}
@@ -247,6 +300,7 @@ func (b *Bot) startServer(srv *Server, writing, reading bool) {
 	}
 
 	srv.Close()
+	srv.bouncer.close()
 	close(srv.killable)
 	b.serverEnd <- serverOp{srv, false, err}
 	srv.setStatus(STATUS_STOPPED)
@@ -255,9 +309,14 @@ func (b *Bot) startServer(srv *Server, writing, reading bool) {
 // dispatch starts dispatch loops on the server.
 func (b *Bot) dispatch(srv *Server) (disconnect bool, err error) {
 	var ircMsg *irc.Message
-	var parseErr error
+	var events []data.StateEvent
+	var parseErr, updateErr error
+	var seenSaved, seenPruned time.Time
 	readCh := srv.client.ReadChannel()
+	netTicker := time.NewTicker(netEventsQuiet / 2)
+	defer netTicker.Stop()
 
+	srv.startCaps()
 	b.dispatchMessage(srv, irc.NewMessage(irc.CONNECT, srv.name))
 	for err == nil && !disconnect {
 		select {
@@ -271,18 +330,51 @@ func (b *Bot) dispatch(srv *Server) (disconnect bool, err error) {
 				log.Printf(errFmtParsingIrcMessage, parseErr, msg)
 				break
 			}
+			events = nil
 			srv.protectState.Lock()
 			if srv.state != nil {
-				srv.state.Update(ircMsg)
+				if ircMsg.Name == irc.QUIT || ircMsg.Name == irc.NICK {
+					ircMsg.Channels = srv.state.GetUserChans(ircMsg.Sender)
+				}
+				events, updateErr = srv.state.Update(ircMsg)
 			}
 			srv.protectState.Unlock()
+			if updateErr != nil {
+				log.Printf(errFmtUpdatingState, updateErr, msg)
+				updateErr = nil
+			}
+			if ircMsg.Name == irc.ACCOUNT {
+				b.accountChanged(srv, ircMsg)
+			}
+			srv.handleCap(ircMsg)
+			srv.handleSasl(ircMsg)
+			srv.queries.handle(ircMsg)
+			srv.bouncer.relay(string(msg), ircMsg)
 			b.dispatchMessage(srv, ircMsg)
+			b.dispatchState(srv, events)
+			logResyncs(srv, events)
+			if time.Since(seenSaved) >= seenSaveInterval {
+				b.saveSeen(srv)
+				seenSaved = time.Now()
+			}
+			if time.Since(seenPruned) >= seenPruneInterval {
+				b.pruneSeen(srv)
+				seenPruned = time.Now()
+			}
+			if channel := srv.resyncDue(time.Now()); len(channel) > 0 {
+				srv.resync(channel)
+			}
+		case <-netTicker.C:
+			b.flushNetEvents(srv)
 		case srv.killable <- 0:
 			err = errServerKilled
 			break
 		}
 	}
 
+	b.saveSeen(srv)
+	srv.queries.abort()
+	srv.bouncer.disconnected()
 	b.dispatchMessage(srv, irc.NewMessage(irc.DISCONNECT, srv.name))
 	return
 }
@@ -296,8 +388,45 @@ func (b *Bot) dispatchMessage(s *Server, msg *irc.Message) {
 	s.commander.Dispatch(s.name, 0, msg, s.endpoint.DataEndpoint)
 }
 
-// Stop shuts down all connections and exits.
+// accountChanged logs a host out when it logs in to or out of a services
+// account, so access it was given by its old account isn't kept. If the new
+// account is linked the host is logged in by it again by its next command.
+func (b *Bot) accountChanged(s *Server, msg *irc.Message) {
+	b.protectStore.Lock()
+	defer b.protectStore.Unlock()
+	if b.store != nil {
+		b.store.Logout(s.name, msg.Sender)
+	}
+}
+
+// flushNetEvents dispatches the netsplit or netjoin the server's state is
+// gathering once it's been quiet for long enough, so it isn't held back until
+// the next message arrives.
+func (b *Bot) flushNetEvents(s *Server) {
+	var events []data.StateEvent
+	s.protectState.Lock()
+	if s.state != nil {
+		events = s.state.FlushNetEvents(netEventsQuiet)
+	}
+	s.protectState.Unlock()
+	if len(events) > 0 {
+		b.dispatchState(s, events)
+	}
+}
+
+// dispatchState sends the changes a message made to the server's state to both
+// the bot's dispatcher and the given server's.
+func (b *Bot) dispatchState(s *Server, events []data.StateEvent) {
+	b.dispatcher.DispatchState(events, s.endpoint)
+	s.dispatcher.DispatchState(events, s.endpoint)
+}
+
+// Stop shuts down all connections, scheduled jobs and config watching and
+// exits.
 func (b *Bot) Stop() {
+	b.scheduler.stop()
+	b.StopWatchingConfig()
+
 	b.protectServers.RLock()
 	defer b.protectServers.RUnlock()
 
@@ -418,6 +547,23 @@ func (b *Bot) GetEndpoint(server string) (endpoint *data.DataEndpoint) {
 	return
 }
 
+// GetIdentities retrieves the names of the servers that are identities of the
+// bot on a network, sorted by name. See config.Identity.
+func (b *Bot) GetIdentities(network string) (servers []string) {
+	b.protectServers.RLock()
+	defer b.protectServers.RUnlock()
+	b.protectConfig.RLock()
+	defer b.protectConfig.RUnlock()
+
+	for name, srv := range b.servers {
+		if srv.conf.GetNetwork() == network {
+			servers = append(servers, name)
+		}
+	}
+	sort.Strings(servers)
+	return
+}
+
 // createBot creates a bot from the given configuration, using the providers
 // given to create connections and protocol caps.
 func createBot(conf *config.Config, connProv ConnProvider,
@@ -435,6 +581,9 @@ func createBot(conf *config.Config, connProv ConnProvider,
 		serverStart:    make(chan bool),
 		serverStop:     make(chan bool),
 		serverEnd:      make(chan serverOp),
+		extensions:     make(map[string]*Extension),
+		toggles:        make(map[string]bool),
+		channels:       make(map[string]map[string]channelSettings),
 	}
 
 	b.caps = irc.CreateProtoCaps()
@@ -451,6 +600,9 @@ func createBot(conf *config.Config, connProv ConnProvider,
 			return nil, err
 		}
 	}
+	if err = b.loadToggles(); err != nil {
+		return nil, err
+	}
 
 	for name, srv := range conf.Servers {
 		server, err := b.createServer(srv)
@@ -460,6 +612,18 @@ func createBot(conf *config.Config, connProv ConnProvider,
 		b.servers[name] = server
 	}
 
+	if attachHandlers {
+		b.relay = &relayHandler{bot: b}
+		for _, event := range relayEvents {
+			b.dispatcher.Register(event, b.relay)
+		}
+	}
+
+	b.scheduler = createScheduler(b)
+	if err = b.scheduler.load(); err != nil {
+		return nil, err
+	}
+
 	if attachCommands && !conf.Global.GetNoStore() {
 		b.coreCommands, err = CreateCoreCommands(b)
 		if err != nil {
@@ -483,6 +647,9 @@ func (b *Bot) createServer(conf *config.Server) (*Server, error) {
 	}
 
 	s.createDispatching(conf.GetPrefix(), conf.GetChannels())
+	s.dispatchCore.Filter(b.IsExtensionEnabled)
+	s.dispatchCore.Prefixes(b.channelPrefix)
+	b.setChannelSettings(s.name, conf)
 
 	if !conf.GetNoState() {
 		if err := s.createState(); err != nil {
@@ -491,6 +658,11 @@ func (b *Bot) createServer(conf *config.Server) (*Server, error) {
 	}
 
 	s.createEndpoint(b.store, &b.protectStore)
+	s.queries = createQueries(s)
+	s.bouncer = createBouncer(s)
+	s.bouncer.configure(conf.GetBouncerListen(), conf.GetBouncerBuffer())
+	s.setCaps(conf.GetCaps())
+	s.setSasl(conf.GetSaslUser(), conf.GetSaslPass())
 
 	if b.attachHandlers {
 		s.handler = &coreHandler{bot: b}
@@ -505,6 +677,8 @@ func (b *Bot) createDispatching(prefix rune, channels []string) {
 	b.dispatchCore = dispatch.CreateDispatchCore(b.caps, channels...)
 	b.dispatcher = dispatch.CreateDispatcher(b.dispatchCore)
 	b.commander = commander.CreateCommander(prefix, b.dispatchCore)
+	b.dispatchCore.Filter(b.IsExtensionEnabled)
+	b.dispatchCore.Prefixes(b.channelPrefix)
 }
 
 // createStore creates a store from a filename.
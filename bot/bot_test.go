@@ -1,16 +1,21 @@
 package bot
 
 import (
+	"flag"
 	"github.com/aarondl/ultimateq/config"
 	"github.com/aarondl/ultimateq/data"
 	"github.com/aarondl/ultimateq/dispatch/commander"
+	"github.com/aarondl/ultimateq/inet"
 	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/ircdtest"
 	"github.com/aarondl/ultimateq/mocks"
 	"gopkg.in/check.v1"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	. "testing"
 	"time"
 )
@@ -57,6 +62,8 @@ func init() {
 
 var serverID = "irc.test.net"
 
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
 var fakeConfig = Configure().
 	Nick("nobody").
 	Altnick("nobody1").
@@ -97,6 +104,235 @@ func TestBot_Create(t *T) {
 	}
 }
 
+func TestBot_CreateBotWithProviders(t *T) {
+	t.Parallel()
+	connProvider := func(srv string) (net.Conn, error) {
+		return nil, io.EOF
+	}
+	b, err := CreateBotWithProviders(fakeConfig, connProvider, nil)
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if err = <-b.Start(); err != io.EOF {
+		t.Error("Expected the conn provider to be used, got:", err)
+	}
+
+	_, err = CreateBotWithProviders(Configure(), connProvider, nil)
+	if err != errInvalidConfig {
+		t.Error("Expected error:", errInvalidConfig, "got", err)
+	}
+}
+
+func TestBot_Ircdtest(t *T) {
+	t.Parallel()
+	ircd := ircdtest.CreateServer(serverID)
+	defer ircd.Close()
+	timeout := 2 * time.Second
+
+	conf := fakeConfig.Clone().GlobalContext().NoReconnect(false).
+		ReconnectTimeout(1)
+	b, err := createBot(conf, ircd.Dial, nil, true, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	b.servers[serverID].reconnScale = time.Millisecond
+	end := b.Start()
+
+	if err = ircd.WaitForRegistered(1, timeout); err != nil {
+		t.Fatal(err)
+	}
+	ep := b.GetEndpoint(serverID)
+	ircd.Join("fish!fishy@fish.net", "#chan")
+	ep.Join("#chan")
+	if _, err = ircd.WaitFor(`^WHO :#chan$`, timeout); err != nil {
+		t.Error("Expected the bot to request who on join:", err)
+	}
+
+	if err = ircd.Mode("fish", "#chan", "+o", "nobody"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	opped := waitForState(ep, timeout, func(state *data.State) bool {
+		modes := state.GetUsersChannelModes("nobody", "#chan")
+		return modes != nil && modes.HasMode('o')
+	})
+	if !opped {
+		t.Error("Expected the bot to be opped in the state.")
+	}
+
+	if err = ircd.Kick("fish", "#chan", "nobody", "out"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	kicked := waitForState(ep, timeout, func(state *data.State) bool {
+		return state.GetChannel("#chan") == nil
+	})
+	if !kicked {
+		t.Error("Expected the bot to no longer be on #chan in the state.")
+	}
+
+	ircd.Disconnect()
+	if err = ircd.WaitForRegistered(1, timeout); err != nil {
+		t.Error("Expected the bot to reconnect:", err)
+	}
+	if ircd.Dials() != 2 {
+		t.Error("Expected the bot to have dialed twice, got:", ircd.Dials())
+	}
+
+	ep.Join("#chan")
+	if err = ircd.WaitForJoin("nobody", "#chan", timeout); err != nil {
+		t.Error("Expected the bot to rejoin after reconnecting:", err)
+	}
+	state := ep.OpenState()
+	if state.Self.Nick() != "nobody" {
+		t.Error("Expected the state to be populated, got:", state.Self.Nick())
+	}
+	ep.CloseState()
+
+	b.Stop()
+	for _ = range end {
+	}
+}
+
+// waitForState checks the state of an endpoint until check is true, or the
+// timeout passes.
+func waitForState(ep *data.DataEndpoint, timeout time.Duration,
+	check func(*data.State) bool) bool {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		state := ep.OpenState()
+		ok := state != nil && check(state)
+		ep.CloseState()
+		if ok {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBot_Replay(t *T) {
+	t.Parallel()
+	timeout := 5 * time.Second
+	session, err := os.Open("testdata/session.rec")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	recording, err := inet.ReadRecording(session)
+	session.Close()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	replay := ircdtest.CreateReplayer(recording, 0)
+	defer replay.Close()
+
+	dir, err := ioutil.TempDir("", "ultimateq")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+	recordFile := filepath.Join(dir, "session.rec")
+
+	conf := fakeConfig.Clone().GlobalContext().RecordFile(recordFile)
+	b, err := createBot(conf, replay.Dial, nil, true, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	b.Register(irc.RPL_WELCOME, testHandler{
+		func(m *irc.Message, ep irc.Endpoint) {
+			ep.Join("#chan")
+		},
+	})
+	end := b.Start()
+
+	if err = replay.Wait(timeout); err != nil {
+		t.Fatal("Replay did not finish:", err)
+	}
+	if err = replay.WaitWritten(len(replay.Recorded()), timeout); err != nil {
+		t.Error("Bot did not write everything it did in the recording.")
+	}
+	err = ircdtest.CompareGolden("testdata/session.golden", replay.Written(),
+		*updateGolden)
+	if err != nil {
+		t.Error(err)
+	}
+
+	ep := b.GetEndpoint(serverID)
+	state := ep.OpenState()
+	if ch := state.GetChannel("#chan"); ch == nil {
+		t.Error("Expected to be on #chan.")
+	} else {
+		if ch.Topic() != "the topic" {
+			t.Error("Expected the topic to be set, got:", ch.Topic())
+		}
+		if ch.TopicSetter() != "fish" || ch.TopicTime().Unix() != 1401620000 {
+			t.Error("Expected the topic info to be set, got:",
+				ch.TopicSetter(), ch.TopicTime())
+		}
+		if ch.Created().Unix() != 1401610000 {
+			t.Error("Expected the creation time to be set, got:", ch.Created())
+		}
+		bans := ch.BanEntries()
+		if len(bans) != 1 || bans[0].Setter != "fish" ||
+			bans[0].Time.Unix() != 1401615000 {
+			t.Error("Expected the ban to be tracked with it's setter, got:",
+				bans)
+		}
+	}
+	if n := state.GetNChanUsers("#chan"); n != 2 {
+		t.Error("Expected 2 users on #chan, got:", n, state.GetChanUsers("#chan"))
+	}
+	if m := state.GetUsersChannelModes("nobody", "#chan"); m == nil ||
+		!m.HasMode('v') {
+		t.Error("Expected nobody to be voiced.")
+	}
+	if m := state.GetUsersChannelModes("fish", "#chan"); m == nil ||
+		!m.HasMode('o') {
+		t.Error("Expected fish to be opped.")
+	}
+	if u := state.GetUser("fish"); u == nil || u.Realname() != "Big Fish" {
+		t.Error("Expected fish's realname to be tracked from who.")
+	}
+	if state.IsOn("whale", "#chan") || state.IsOn("shark", "#chan") {
+		t.Error("Expected whale to have parted #chan.")
+	}
+	if state.GetUser("eel") != nil {
+		t.Error("Expected eel to be forgotten after quitting.")
+	}
+	ep.CloseState()
+
+	b.Stop()
+	for _ = range end {
+	}
+
+	// The global record file is named after each server.
+	file, err := os.Open(filepath.Join(dir, "session."+serverID+".rec"))
+	if err != nil {
+		t.Fatal("Expected the session to be recorded:", err)
+	}
+	defer file.Close()
+	lines, err := inet.ReadRecording(file)
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	var read, written int
+	for _, line := range lines {
+		if line.Sent {
+			written++
+		} else {
+			read++
+		}
+	}
+	if exp := len(replay.Written()); written != exp {
+		t.Errorf("Expected %d lines written in the recording, got %d",
+			exp, written)
+	}
+	if exp := len(recording) - len(replay.Recorded()); read != exp {
+		t.Errorf("Expected %d lines read in the recording, got %d", exp, read)
+	}
+}
+
 func TestBot_Start(t *T) {
 	t.Parallel()
 	connProvider := func(srv string) (net.Conn, error) {
@@ -255,6 +491,98 @@ func TestBot_Dispatch_ConnectDisconnect(t *T) {
 	}
 }
 
+type testStateHandler struct {
+	callback func(data.StateEvent, irc.Endpoint)
+}
+
+func (h testStateHandler) HandleState(ev data.StateEvent, ep irc.Endpoint) {
+	if h.callback != nil {
+		h.callback(ev, ep)
+	}
+}
+
+func TestBot_DispatchState(t *T) {
+	t.Parallel()
+	conn := mocks.CreateConn()
+	connProvider := func(srv string) (net.Conn, error) {
+		return conn, nil
+	}
+	b, _ := createBot(fakeConfig, connProvider, nil, false, false)
+
+	result := make(chan data.StateEvent, 1)
+	b.Register(irc.STATE, testStateHandler{
+		func(ev data.StateEvent, ep irc.Endpoint) {
+			if ep == nil || ep.GetKey() != serverID {
+				t.Error("Expected the server's endpoint, got:", ep)
+			}
+			result <- ev
+		},
+	})
+
+	end := b.Start()
+
+	go func() {
+		msg := []byte(":irc.test.net 001 nobody :Welcome nobody!nobody@h\r\n")
+		conn.Send(msg, len(msg), nil)
+		msg = []byte(":nobody!nobody@h JOIN #chan\r\n")
+		conn.Send(msg, len(msg), io.EOF)
+	}()
+
+	expect := data.UserJoined{Channel: "#chan", User: "nobody!nobody@h"}
+	if ev := <-result; ev != expect {
+		t.Error("Expected:", expect, "got:", ev)
+	}
+
+	for _ = range end {
+	}
+}
+
+func TestBot_FlushNetEvents(t *T) {
+	t.Parallel()
+	conn := mocks.CreateConn()
+	connProvider := func(srv string) (net.Conn, error) {
+		return conn, nil
+	}
+	b, _ := createBot(fakeConfig, connProvider, nil, false, false)
+
+	result := make(chan data.NetSplit, 1)
+	b.Register(irc.STATE, testStateHandler{
+		func(ev data.StateEvent, ep irc.Endpoint) {
+			if split, ok := ev.(data.NetSplit); ok {
+				result <- split
+			}
+		},
+	})
+
+	end := b.Start()
+
+	go func() {
+		for _, line := range []string{
+			":irc.test.net 001 nobody :Welcome nobody!nobody@h",
+			":nobody!nobody@h JOIN #chan",
+			":fish!fish@h JOIN #chan",
+			":fish!fish@h QUIT :hub.net leaf.net",
+		} {
+			msg := []byte(line + "\r\n")
+			conn.Send(msg, len(msg), nil)
+		}
+	}()
+
+	// Nothing follows the split, so it's only dispatched by the flush.
+	select {
+	case split := <-result:
+		if split.Hub != "hub.net" || len(split.Users["#chan"]) != 1 {
+			t.Error("Expected fish's netsplit, got:", split)
+		}
+	case <-time.After(4 * netEventsQuiet):
+		t.Error("Expected the netsplit to be flushed.")
+	}
+
+	b.Stop()
+	for _ = range end {
+	}
+}
+
 func TestBot_Reconnect(t *T) {
 	t.Parallel()
 	conn := mocks.CreateConn()
@@ -349,6 +677,115 @@ func TestBot_ReconnectKill(t *T) {
 	}
 }
 
+func TestBot_QuitNoReconnect(t *T) {
+	t.Parallel()
+	conn := mocks.CreateConn()
+	dials := make(chan int, 2)
+	connProvider := func(srv string) (net.Conn, error) {
+		dials <- 0
+		conn.ResetDeath()
+		return conn, nil
+	}
+
+	conf := fakeConfig.Clone().GlobalContext().NoReconnect(false).
+		ReconnectTimeout(1)
+	b, _ := createBot(conf, connProvider, nil, false, false)
+	srv := b.servers[serverID]
+	srv.reconnScale = time.Millisecond
+
+	listen := make(chan Status)
+	srv.addStatusListener(listen, STATUS_STARTED)
+
+	end := b.Start()
+	for <-listen != STATUS_STARTED {
+	}
+
+	quit := []byte("QUIT :bye\r\n")
+	go srv.Write(quit)
+	conn.Receive(len(quit), nil)
+	conn.Send(nil, 0, io.EOF)
+
+	for _ = range end {
+	}
+	if len(dials) != 1 {
+		t.Error("Expected the bot not to reconnect after quitting, dials:",
+			len(dials))
+	}
+}
+
+func TestBot_Identities(t *T) {
+	t.Parallel()
+	timeout := 2 * time.Second
+	ircd := ircdtest.CreateServer(serverID)
+	defer ircd.Close()
+	ircd.Caps("sasl")
+	ircd.Account("relay", "secret")
+	ircd.AddUser("fish!fishy@fish.net")
+
+	conf := fakeConfig.Clone().GlobalContext().FloodTimeout(100).
+		ServerContext(serverID).
+		Identity("relay").Nick("relay").Sasl("relay", "secret")
+	if !conf.IsValid() {
+		t.Fatal("Expected the config to be valid:", conf.Errors)
+	}
+	b, err := createBot(conf, ircd.Dial, nil, true, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	received := make(chan string, 2)
+	b.Register(irc.PRIVMSG, testHandler{
+		func(m *irc.Message, ep irc.Endpoint) {
+			received <- ep.GetKey() + " " + m.Args[0]
+		},
+	})
+	end := b.Start()
+	defer func() {
+		b.Stop()
+		for _ = range end {
+		}
+	}()
+
+	if err = ircd.WaitForRegistered(2, timeout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ircd.WaitFor(`^CAP END$`, timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	identities := b.GetIdentities(serverID)
+	if len(identities) != 2 || identities[0] != serverID ||
+		identities[1] != "relay" {
+		t.Error("Expected both identities on the network, got:", identities)
+	}
+	if ep := b.servers["relay"].endpoint; ep.GetNetwork() != serverID {
+		t.Error("Expected relay to be on the network, got:", ep.GetNetwork())
+	}
+	if b.servers[serverID].state == b.servers["relay"].state {
+		t.Error("Expected each identity to have it's own state.")
+	}
+	if b.servers["relay"].GetAccount() != "relay" ||
+		len(b.servers[serverID].GetAccount()) != 0 {
+		t.Error("Expected only relay to be logged in.")
+	}
+
+	ircd.Privmsg("fish", "nobody", "hi")
+	ircd.Privmsg("fish", "relay", "hi")
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-received:
+			got[r] = true
+		case <-time.After(timeout):
+			t.Fatal("Timed out waiting for messages.")
+		}
+	}
+	if !got[serverID+" nobody"] || !got["relay relay"] {
+		t.Error("Expected each identity to receive it's own message, got:",
+			got)
+	}
+}
+
 func TestBot_Register(t *T) {
 	t.Parallel()
 	b, _ := createBot(fakeConfig, nil, nil, false, false)
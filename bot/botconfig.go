@@ -51,6 +51,7 @@ func (b *Bot) ReplaceConfig(newConfig *config.Config) bool {
 		if serverConf := newConfig.GetServer(k); nil == serverConf {
 			b.stopServer(s)
 			delete(b.servers, k)
+			b.setChannelSettings(k, nil)
 			continue
 		} else {
 			s.rehashConfig(serverConf)
@@ -87,6 +88,12 @@ func (s *Server) rehashConfig(srvConfig *config.Server) {
 	setChannels := !contains(s.conf.GetChannels(), srvConfig.GetChannels())
 
 	s.conf = srvConfig
+	s.bot.setChannelSettings(s.name, s.conf)
+	s.setCaps(s.conf.GetCaps())
+	s.setSasl(s.conf.GetSaslUser(), s.conf.GetSaslPass())
+	s.setWhoisLifetime(s.conf.GetWhoisLifetime())
+	s.setResyncInterval(s.conf.GetResyncInterval())
+	s.bouncer.configure(s.conf.GetBouncerListen(), s.conf.GetBouncerBuffer())
 
 	if setNick {
 		s.Write([]byte(irc.NICK + " :" + s.conf.GetNick()))
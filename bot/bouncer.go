@@ -0,0 +1,463 @@
+package bot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/parse"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// bouncerName is the name the bouncer uses when it talks to clients.
+	bouncerName = "bouncer"
+	// bouncerFlags are the flags a user needs to attach to the bouncer, any
+	// of them will do.
+	bouncerFlags = "BG"
+	// bouncerQueue is how many lines may be waiting to be written to a client
+	// before it's considered too slow and dropped.
+	bouncerQueue = 512
+	// bouncerWriteTimeout is how long a client that is being dropped has to
+	// take the lines still queued for it.
+	bouncerWriteTimeout = 5 * time.Second
+	// bouncerRegisterTimeout is how long a client has to log in after it
+	// connects.
+	bouncerRegisterTimeout = 30 * time.Second
+	// errFmtBouncerListen occurs when the bouncer can't listen, the server
+	// carries on without it.
+	errFmtBouncerListen = "bot: %v failed to start bouncer (%v)\n"
+	// errFmtBouncerReplay occurs when a client attaching to the bouncer can't
+	// keep up with the lines it's being replayed, it's disconnected.
+	errFmtBouncerReplay = "bot: %v bouncer client %v could not keep up " +
+		"with the replay, disconnecting it\n"
+)
+
+var (
+	// errBouncerAuth occurs when a client fails to log in to the bouncer.
+	errBouncerAuth = errors.New("bot: Bouncer login failed.")
+)
+
+// bouncer lets irc clients attach to a server connection. Attached clients
+// see everything the server sends and anything they send is written to the
+// server, the bot keeps dispatching to it's handlers as usual. When a client
+// attaches it's told about the channels the bot is on from the state and
+// given the most recent lines that were sent to the bot.
+type bouncer struct {
+	server   *Server
+	listener net.Listener
+	clients  map[*bouncerClient]bool
+	address  string
+	welcome  []string
+	buffer   []string
+	size     int
+	// timeout is how long clients have to log in.
+	timeout time.Duration
+
+	protect sync.Mutex
+}
+
+// bouncerClient is an irc client attached to the bouncer.
+type bouncerClient struct {
+	conn   net.Conn
+	out    chan string
+	closed bool
+
+	protect sync.Mutex
+}
+
+// createBouncer creates a bouncer for a server, it does not listen until
+// listen is called.
+func createBouncer(s *Server) *bouncer {
+	return &bouncer{
+		server:  s,
+		clients: make(map[*bouncerClient]bool),
+		timeout: bouncerRegisterTimeout,
+	}
+}
+
+// configure sets the address to listen on and the number of lines to buffer,
+// they take effect the next time the bouncer listens.
+func (b *bouncer) configure(address string, size uint) {
+	b.protect.Lock()
+	defer b.protect.Unlock()
+	b.address = address
+	b.size = int(size)
+}
+
+// listen starts accepting irc clients if the bouncer has an address.
+func (b *bouncer) listen() error {
+	b.protect.Lock()
+	defer b.protect.Unlock()
+
+	if len(b.address) == 0 || b.listener != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", b.address)
+	if err != nil {
+		return err
+	}
+	b.listener = listener
+	b.buffer = nil
+
+	go b.accept(listener)
+	return nil
+}
+
+// close stops listening and drops every attached client.
+func (b *bouncer) close() {
+	b.protect.Lock()
+	defer b.protect.Unlock()
+
+	if b.listener != nil {
+		b.listener.Close()
+		b.listener = nil
+	}
+	b.detachAll()
+}
+
+// addr returns the address the bouncer is listening on, nil if it's not.
+func (b *bouncer) addr() net.Addr {
+	b.protect.Lock()
+	defer b.protect.Unlock()
+
+	if b.listener == nil {
+		return nil
+	}
+	return b.listener.Addr()
+}
+
+// accept accepts clients until the listener is closed.
+func (b *bouncer) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.serve(conn)
+	}
+}
+
+// serve registers a client, attaches it if it logs in and then relays what it
+// sends to the server.
+func (b *bouncer) serve(conn net.Conn) {
+	c := &bouncerClient{conn: conn, out: make(chan string, bouncerQueue)}
+	go c.writer()
+	defer func() {
+		b.protect.Lock()
+		delete(b.clients, c)
+		b.protect.Unlock()
+		c.close()
+	}()
+
+	b.protect.Lock()
+	timeout := b.timeout
+	b.protect.Unlock()
+
+	scanner := bufio.NewScanner(conn)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	if !b.register(c, scanner) {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if len(line) == 0 {
+			continue
+		}
+		msg, err := parse.Parse([]byte(line))
+		if err != nil {
+			continue
+		}
+
+		switch msg.Name {
+		case irc.PING:
+			c.send(":" + bouncerName + " PONG " + bouncerName + " :" +
+				strings.Join(msg.Args, " "))
+		case irc.QUIT:
+			c.send("ERROR :Closing Link: Detached from bouncer")
+			return
+		case irc.PONG, irc.CAP, "PASS", "USER":
+		default:
+			b.server.Write([]byte(line))
+			if msg.Name == irc.PRIVMSG || msg.Name == irc.NOTICE {
+				b.echo(c, line)
+			}
+		}
+	}
+}
+
+// register waits for a client to send PASS, NICK and USER and logs it in.
+// The password is given as username:password, or the username can be sent
+// with USER and only the password with PASS. The client's connection must have
+// a read deadline so clients that never log in are dropped.
+func (b *bouncer) register(c *bouncerClient, scanner *bufio.Scanner) bool {
+	var pass, nick, username string
+	for len(pass) == 0 || len(nick) == 0 || len(username) == 0 {
+		if !scanner.Scan() {
+			return false
+		}
+		msg, err := parse.Parse([]byte(scanner.Text()))
+		if err != nil || len(msg.Args) == 0 {
+			continue
+		}
+
+		switch msg.Name {
+		case "PASS":
+			pass = msg.Args[0]
+		case irc.NICK:
+			nick = msg.Args[0]
+		case "USER":
+			username = msg.Args[0]
+		case irc.CAP:
+			if strings.EqualFold(msg.Args[0], "LS") {
+				c.send(":" + bouncerName + " CAP * LS :")
+			}
+		case irc.PING:
+			c.send(":" + bouncerName + " PONG " + bouncerName + " :" +
+				msg.Args[0])
+		}
+	}
+
+	if i := strings.IndexByte(pass, ':'); i >= 0 {
+		username, pass = pass[:i], pass[i+1:]
+	}
+	err := b.server.bot.bouncerAuth(b.server.name, username, pass)
+	if err != nil {
+		c.send(fmt.Sprintf(":%s %s %s :Password incorrect", bouncerName,
+			irc.ERR_PASSWDMISMATCH, nick))
+		c.send("ERROR :Closing Link: " + err.Error())
+		return false
+	}
+	if b.server.GetStatus() != STATUS_STARTED {
+		c.send("ERROR :Closing Link: Not connected to " + b.server.name)
+		return false
+	}
+
+	return b.attach(c)
+}
+
+// bouncerAuth checks a username and password against the store.
+func (b *Bot) bouncerAuth(server, username, password string) error {
+	b.protectStore.RLock()
+	defer b.protectStore.RUnlock()
+
+	if b.store == nil {
+		return errBouncerAuth
+	}
+	ua, err := b.store.FindUser(username)
+	if err != nil {
+		return err
+	}
+	if ua == nil || !ua.VerifyPassword(password) ||
+		!ua.HasFlags(server, "", bouncerFlags) {
+		return errBouncerAuth
+	}
+	return nil
+}
+
+// attach sends the client the welcome, the channels the bot is on and the
+// recent lines, then starts relaying the server to it. Clients that can't keep
+// up with the replay aren't attached.
+func (b *bouncer) attach(c *bouncerClient) bool {
+	b.protect.Lock()
+	defer b.protect.Unlock()
+
+	if b.listener == nil {
+		return false
+	}
+
+	var lines []string
+	lines = append(lines, b.welcome...)
+	if state := b.server.endpoint.CopyState(); state != nil {
+		lines = append(lines, replayState(state)...)
+	}
+	lines = append(lines, b.buffer...)
+	for _, line := range lines {
+		if !c.send(line) {
+			log.Printf(errFmtBouncerReplay, b.server.name, c.conn.RemoteAddr())
+			return false
+		}
+	}
+
+	b.clients[c] = true
+	return true
+}
+
+// replayState creates the lines that tell a client which channels the bot is
+// on, who is in them and what their topics are.
+func replayState(state *data.State) (lines []string) {
+	if state.Self.User == nil {
+		return
+	}
+	self := state.Self.Nick()
+	host := state.Self.Host()
+
+	state.EachUserChan(self, func(uc *data.UserChannel) {
+		name := uc.Channel.Name()
+		lines = append(lines, ":"+host+" JOIN :"+name)
+		if topic := uc.Channel.Topic(); len(topic) > 0 {
+			lines = append(lines, fmt.Sprintf(":%s %s %s %s :%s",
+				bouncerName, irc.RPL_TOPIC, self, name, topic))
+		}
+
+		var names []string
+		state.EachChanUser(name, func(cu *data.ChannelUser) {
+			prefix := cu.StringSymbols()
+			if len(prefix) > 1 {
+				prefix = prefix[:1]
+			}
+			names = append(names, prefix+cu.User.Nick())
+		})
+		lines = append(lines, fmt.Sprintf(":%s %s %s = %s :%s", bouncerName,
+			irc.RPL_NAMREPLY, self, name, strings.Join(names, " ")))
+		lines = append(lines, fmt.Sprintf(":%s %s %s %s :End of NAMES list",
+			bouncerName, irc.RPL_ENDOFNAMES, self, name))
+	})
+	return
+}
+
+// relay gives a line from the server to the attached clients and remembers
+// the lines they will need when attaching later. It must be called for every
+// line in the order they arrive.
+func (b *bouncer) relay(line string, msg *irc.Message) {
+	b.protect.Lock()
+	defer b.protect.Unlock()
+
+	if b.listener == nil {
+		return
+	}
+
+	// Clients have not negotiated tags with the server so they're stripped.
+	if strings.HasPrefix(line, "@") {
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+
+	switch msg.Name {
+	case irc.PING, irc.PONG, irc.CAP, irc.BATCH, irc.ACK:
+		return
+	case irc.RPL_WELCOME:
+		b.welcome = []string{line}
+	case irc.RPL_YOURHOST, irc.RPL_CREATED, irc.RPL_MYINFO, irc.RPL_ISUPPORT:
+		b.welcome = append(b.welcome, line)
+	case irc.PRIVMSG, irc.NOTICE:
+		b.remember(line)
+	}
+
+	for c := range b.clients {
+		if !c.send(line) {
+			delete(b.clients, c)
+			c.close()
+		}
+	}
+}
+
+// remember adds a line to the buffer replayed to attaching clients. Not
+// thread safe.
+func (b *bouncer) remember(line string) {
+	if b.size == 0 {
+		return
+	}
+	if len(b.buffer) >= b.size {
+		b.buffer = append(b.buffer[:0], b.buffer[len(b.buffer)-b.size+1:]...)
+	}
+	b.buffer = append(b.buffer, line)
+}
+
+// echo shows a message one client sent to the other attached clients as
+// though the server had sent it.
+func (b *bouncer) echo(from *bouncerClient, line string) {
+	b.server.protectState.RLock()
+	var host string
+	if b.server.state != nil && b.server.state.Self.User != nil {
+		host = b.server.state.Self.Host()
+	}
+	b.server.protectState.RUnlock()
+	if len(host) == 0 {
+		return
+	}
+
+	line = ":" + host + " " + line
+	b.protect.Lock()
+	defer b.protect.Unlock()
+	b.remember(line)
+	for c := range b.clients {
+		if c != from {
+			c.send(line)
+		}
+	}
+}
+
+// disconnected drops every attached client since the connection they were
+// attached to is gone.
+func (b *bouncer) disconnected() {
+	b.protect.Lock()
+	defer b.protect.Unlock()
+	b.welcome = nil
+	b.detachAll()
+}
+
+// detachAll drops every attached client. Not thread safe.
+func (b *bouncer) detachAll() {
+	for c := range b.clients {
+		c.send("ERROR :Closing Link: Disconnected from " + b.server.name)
+		c.close()
+		delete(b.clients, c)
+	}
+}
+
+// send queues a line for the client, it returns false if the client is not
+// keeping up.
+func (c *bouncerClient) send(line string) bool {
+	c.protect.Lock()
+	defer c.protect.Unlock()
+
+	if c.closed {
+		return false
+	}
+	select {
+	case c.out <- line:
+		return true
+	default:
+		return false
+	}
+}
+
+// writer writes queued lines to the client. Once the client is closed the
+// lines that are still queued are written before the connection is closed.
+func (c *bouncerClient) writer() {
+	for line := range c.out {
+		if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+			break
+		}
+	}
+	c.conn.Close()
+}
+
+// close closes the client after the lines queued for it are written.
+func (c *bouncerClient) close() {
+	c.protect.Lock()
+	defer c.protect.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.out)
+	c.conn.SetWriteDeadline(time.Now().Add(bouncerWriteTimeout))
+}
+
+// startBouncer starts the bouncer if the server is configured to have one.
+func (s *Server) startBouncer() {
+	if err := s.bouncer.listen(); err != nil {
+		log.Printf(errFmtBouncerListen, s.name, err)
+	}
+}
@@ -0,0 +1,161 @@
+package bot
+
+import (
+	"bufio"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/ircdtest"
+	"net"
+	"strings"
+	. "testing"
+	"time"
+)
+
+// dialBouncer connects an irc client to the bouncer and logs in.
+func dialBouncer(t *T, b *Bot, pass string) *ircdtest.Client {
+	addr := b.servers[serverID].bouncer.addr()
+	if addr == nil {
+		t.Fatal("Expected the bouncer to be listening.")
+	}
+	c := ircdtest.DialClient(t, addr.String())
+	c.Send("CAP LS 302")
+	c.Send("PASS " + pass)
+	c.Send("NICK :client")
+	c.Send("USER client 0 * :Client")
+	return c
+}
+
+// waitBuffered waits for the bouncer to have buffered n lines.
+func waitBuffered(t *T, bnc *bouncer, n int) {
+	for i := 0; i < 200; i++ {
+		bnc.protect.Lock()
+		buffered := len(bnc.buffer)
+		bnc.protect.Unlock()
+		if buffered >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for the bouncer to buffer lines.")
+}
+
+func TestBouncer(t *T) {
+	t.Parallel()
+	timeout := 2 * time.Second
+	ircd := ircdtest.CreateServer(serverID)
+	defer ircd.Close()
+
+	store, err := data.CreateStore(data.MemStoreProvider)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	storeProv := func(string) (*data.Store, error) {
+		return store, nil
+	}
+	admin, _ := data.CreateUserAccess("admin", "secret")
+	admin.GrantServerFlags(serverID, "B")
+	nobody, _ := data.CreateUserAccess("nobody", "secret")
+	store.AddUser(admin)
+	store.AddUser(nobody)
+
+	conf := fakeConfig.Clone().GlobalContext().NoStore(false).
+		FloodTimeout(100).BouncerListen("127.0.0.1:0").BouncerBuffer(2)
+	b, err := createBot(conf, ircd.Dial, storeProv, true, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	end := b.Start()
+	if err = ircd.WaitForRegistered(1, timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	ep := b.GetEndpoint(serverID)
+	ircd.Join("fish!fishy@fish.net", "#chan")
+	ep.Join("#chan")
+	if err = ircd.WaitForJoin("nobody", "#chan", timeout); err != nil {
+		t.Fatal(err)
+	}
+	ircd.Topic("fish", "#chan", "fishy business")
+	ircd.Privmsg("fish", "#chan", "one")
+	ircd.Privmsg("fish", "#chan", "two")
+	ircd.Privmsg("fish", "#chan", "three")
+	waitBuffered(t, b.servers[serverID].bouncer, 2)
+
+	c := dialBouncer(t, b, "admin:wrong")
+	c.Expect(" " + irc.ERR_PASSWDMISMATCH + " client ")
+	c.Expect("ERROR")
+	c = dialBouncer(t, b, "nobody:secret")
+	c.Expect(" " + irc.ERR_PASSWDMISMATCH + " client ")
+
+	c = dialBouncer(t, b, "admin:secret")
+	c.Expect(" CAP * LS ")
+	c.Expect(" 001 nobody ")
+	c.Expect(" 005 nobody ")
+	c.Expect(":nobody!nobody@" + ircdtest.DefaultHost + " JOIN :#chan")
+	c.Expect(" 332 nobody #chan :fishy business")
+	names := c.Expect(" 353 nobody = #chan ")
+	if !strings.Contains(names, "fish") {
+		t.Error("Expected fish in the names, got:", names)
+	}
+	c.Expect(" 366 nobody #chan ")
+	if line := c.Expect("PRIVMSG #chan"); !strings.HasSuffix(line, ":two") {
+		t.Error("Expected the buffer to be trimmed, got:", line)
+	}
+	c.Expect("PRIVMSG #chan :three")
+
+	ircd.Privmsg("fish", "#chan", "live")
+	c.Expect(":fish!fishy@fish.net PRIVMSG #chan :live")
+
+	c.Send("PRIVMSG #chan :from the client")
+	if _, err = ircd.WaitFor(`^PRIVMSG #chan :from the client$`,
+		timeout); err != nil {
+		t.Error("Expected the client's message to reach the server:", err)
+	}
+
+	c.Send("PING :hi")
+	c.Expect("PONG " + bouncerName + " :hi")
+
+	bnc := b.servers[serverID].bouncer
+	client, _ := net.Pipe()
+	defer client.Close()
+	slow := &bouncerClient{conn: client, out: make(chan string, 1)}
+	if bnc.attach(slow) {
+		t.Error("Expected a client that can't take the replay to be dropped.")
+	}
+
+	bnc.protect.Lock()
+	bnc.timeout = 50 * time.Millisecond
+	bnc.protect.Unlock()
+	idle, err := net.Dial("tcp", bnc.addr().String())
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	idle.SetReadDeadline(time.Now().Add(timeout))
+	if _, err = bufio.NewReader(idle).ReadString('\n'); err == nil ||
+		strings.Contains(err.Error(), "timeout") {
+		t.Error("Expected a client that never logs in to be dropped:", err)
+	}
+	idle.Close()
+
+	b.Stop()
+	for _ = range end {
+	}
+	c.Expect("ERROR")
+}
+
+func TestBouncer_Remember(t *T) {
+	t.Parallel()
+	bnc := &bouncer{size: 2}
+	bnc.remember("a")
+	bnc.remember("b")
+	bnc.remember("c")
+	if len(bnc.buffer) != 2 || bnc.buffer[0] != "b" || bnc.buffer[1] != "c" {
+		t.Error("Expected only the most recent lines, got:", bnc.buffer)
+	}
+
+	bnc = &bouncer{}
+	bnc.remember("a")
+	if len(bnc.buffer) != 0 {
+		t.Error("Expected nothing to be kept, got:", bnc.buffer)
+	}
+}
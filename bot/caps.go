@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"strings"
+)
+
+// capNegotiation tracks the state of IRCv3 capability negotiation with a
+// server. Negotiation is started before registration and finished with
+// CAP END once the server has answered the capabilities requested from it.
+type capNegotiation struct {
+	negotiating    bool
+	authenticating bool
+	wanted         []string
+	available      []string
+	enabled        map[string]bool
+	account        string
+}
+
+// setCaps sets the capabilities to request the next time negotiation starts.
+func (s *Server) setCaps(wanted []string) {
+	s.protectCaps.Lock()
+	defer s.protectCaps.Unlock()
+	s.wantCaps = wanted
+}
+
+// startCaps begins capability negotiation if any capabilities are configured
+// for the server. It must be called before registration begins.
+func (s *Server) startCaps() {
+	s.protectCaps.Lock()
+	wanted := s.wantCaps
+	if len(s.sasl.user) > 0 && !inList(wanted, "sasl") {
+		wanted = append(append([]string{}, wanted...), "sasl")
+	}
+	s.capNeg = capNegotiation{
+		negotiating: len(wanted) > 0,
+		wanted:      wanted,
+		enabled:     make(map[string]bool),
+	}
+	s.protectCaps.Unlock()
+
+	if len(wanted) > 0 {
+		s.Write([]byte("CAP LS 302"))
+	}
+}
+
+// handleCap handles the server's side of capability negotiation.
+func (s *Server) handleCap(msg *irc.Message) {
+	if msg.Name != irc.CAP || len(msg.Args) < 3 {
+		return
+	}
+
+	var writes []string
+	s.protectCaps.Lock()
+	switch strings.ToUpper(msg.Args[1]) {
+	case "LS":
+		more := len(msg.Args) > 3 && msg.Args[2] == "*"
+		list := msg.Args[len(msg.Args)-1]
+		s.capNeg.available = append(s.capNeg.available,
+			strings.Fields(list)...)
+		if more {
+			break
+		}
+
+		if req := s.wantedCaps(s.capNeg.available); len(req) > 0 {
+			writes = append(writes, "CAP REQ :"+strings.Join(req, " "))
+		} else if s.capNeg.negotiating {
+			s.capNeg.negotiating = false
+			writes = append(writes, "CAP END")
+		}
+	case "NEW":
+		list := strings.Fields(msg.Args[len(msg.Args)-1])
+		s.capNeg.available = append(s.capNeg.available, list...)
+		if req := s.wantedCaps(list); len(req) > 0 {
+			writes = append(writes, "CAP REQ :"+strings.Join(req, " "))
+		}
+	case "ACK":
+		for _, name := range strings.Fields(msg.Args[len(msg.Args)-1]) {
+			if strings.HasPrefix(name, "-") {
+				delete(s.capNeg.enabled, name[1:])
+			} else {
+				s.capNeg.enabled[name] = true
+			}
+		}
+		if s.capNeg.negotiating && s.capNeg.enabled["sasl"] &&
+			len(s.sasl.user) > 0 && !s.capNeg.authenticating &&
+			len(s.capNeg.account) == 0 {
+
+			s.capNeg.authenticating = true
+			writes = append(writes, irc.AUTHENTICATE+" PLAIN")
+			break
+		}
+		fallthrough
+	case "NAK":
+		if s.capNeg.negotiating && !s.capNeg.authenticating {
+			s.capNeg.negotiating = false
+			writes = append(writes, "CAP END")
+		}
+	case "DEL":
+		for _, name := range strings.Fields(msg.Args[len(msg.Args)-1]) {
+			delete(s.capNeg.enabled, name)
+		}
+	}
+	s.protectCaps.Unlock()
+
+	for _, line := range writes {
+		s.Write([]byte(line))
+	}
+}
+
+// wantedCaps returns the configured capabilities that are in the list of
+// offered capabilities and are not enabled yet. Not thread safe.
+func (s *Server) wantedCaps(offered []string) (req []string) {
+	for _, want := range s.capNeg.wanted {
+		if s.capNeg.enabled[want] {
+			continue
+		}
+		for _, offer := range offered {
+			if i := strings.IndexByte(offer, '='); i >= 0 {
+				offer = offer[:i]
+			}
+			if offer == want {
+				req = append(req, want)
+				break
+			}
+		}
+	}
+	return
+}
+
+// HasCap checks if an IRCv3 capability has been enabled on the server.
+func (s *Server) HasCap(name string) bool {
+	s.protectCaps.RLock()
+	defer s.protectCaps.RUnlock()
+	return s.capNeg.enabled[name]
+}
+
+// HasCap checks if an IRCv3 capability has been enabled on the server.
+func (s *ServerEndpoint) HasCap(name string) bool {
+	return s.server.HasCap(name)
+}
@@ -0,0 +1,58 @@
+package bot
+
+import (
+	. "testing"
+)
+
+func TestCaps_Handle(t *T) {
+	t.Parallel()
+	conf := fakeConfig.Clone().GlobalContext().Caps("batch", "away-notify")
+	b, _ := createBot(conf, nil, nil, false, false)
+	srv := b.servers[serverID]
+	srv.startCaps()
+
+	srv.handleCap(msg(t, ":srv CAP * LS * :batch=1 sasl"))
+	srv.handleCap(msg(t, ":srv CAP * LS :multi-prefix"))
+	if len(srv.capNeg.available) != 3 || !srv.capNeg.negotiating {
+		t.Error("Expected a multiline LS to be collected, got:",
+			srv.capNeg.available)
+	}
+	if req := srv.wantedCaps(srv.capNeg.available); len(req) != 1 ||
+		req[0] != "batch" {
+		t.Error("Expected only batch to be wanted, got:", req)
+	}
+
+	srv.handleCap(msg(t, ":srv CAP * ACK :batch"))
+	if !srv.HasCap("batch") || srv.capNeg.negotiating {
+		t.Error("Expected batch to be enabled and negotiation to end.")
+	}
+
+	srv.handleCap(msg(t, ":srv CAP nobody NEW :away-notify"))
+	if req := srv.wantedCaps([]string{"away-notify"}); len(req) != 1 {
+		t.Error("Expected away-notify to be wanted, got:", req)
+	}
+	srv.handleCap(msg(t, ":srv CAP nobody ACK :away-notify -batch"))
+	if !srv.HasCap("away-notify") || srv.HasCap("batch") {
+		t.Error("Expected away-notify to replace batch.")
+	}
+
+	srv.handleCap(msg(t, ":srv CAP nobody DEL :away-notify"))
+	if srv.endpoint.HasCap("away-notify") {
+		t.Error("Expected away-notify to be removed.")
+	}
+}
+
+func TestCaps_None(t *T) {
+	t.Parallel()
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	srv := b.servers[serverID]
+	srv.startCaps()
+
+	if srv.capNeg.negotiating {
+		t.Error("Expected no negotiation without configured caps.")
+	}
+	srv.handleCap(msg(t, ":srv CAP * LS :batch"))
+	if srv.HasCap("batch") {
+		t.Error("Expected nothing to be enabled.")
+	}
+}
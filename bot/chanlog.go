@@ -0,0 +1,413 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/irc"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// chanLogNamespace is where privacy opt-outs are kept in the store.
+	chanLogNamespace = "chanlog.optout"
+	// chanLogDay is the layout of the date in a channel log's filename.
+	chanLogDay = "2006-01-02"
+	// errFmtChanLog occurs when a channel log can't be written, the event
+	// is dropped.
+	errFmtChanLog = "bot: Failed to write channel log %v (%v)\n"
+)
+
+// ChannelLogger writes the events in the channels the bot is on to files. It
+// must be registered for irc.RAW with Bot.Register to see the events, it's an
+// ordered handler so they're written in the order they were received. Logging
+// is configured per server with config.LogDir, LogFormat and LogIgnore, and
+// nothing is logged for servers without a log directory or in channels with
+// NoLog set.
+//
+// Logs are written to LogDir/network/#channel.YYYY-MM-DD.log (or .json), a
+// new file is started each day. Quits and nick changes are written to every
+// channel the user shared with the bot.
+//
+// People can opt out of being logged with OptOut, the opt-outs are kept in
+// the bot's store if it has one.
+type ChannelLogger struct {
+	bot   *Bot
+	files map[string]*chanLogFile
+	// optOut is keyed by server then lowercased nick.
+	optOut map[string]map[string]bool
+	now    func() time.Time
+	closed bool
+
+	protect sync.Mutex
+}
+
+// chanLogFile is an open channel log and the day it's for.
+type chanLogFile struct {
+	file *os.File
+	day  string
+}
+
+// chanLogEvent is an event written to a channel log.
+type chanLogEvent struct {
+	Time    time.Time `json:"time"`
+	Network string    `json:"network"`
+	Channel string    `json:"channel"`
+	Event   string    `json:"event"`
+	Nick    string    `json:"nick,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Host    string    `json:"host,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	Modes   string    `json:"modes,omitempty"`
+}
+
+// Channel log events.
+const (
+	chanLogPrivmsg = "privmsg"
+	chanLogAction  = "action"
+	chanLogNotice  = "notice"
+	chanLogJoin    = "join"
+	chanLogPart    = "part"
+	chanLogQuit    = "quit"
+	chanLogKick    = "kick"
+	chanLogNick    = "nick"
+	chanLogMode    = "mode"
+	chanLogTopic   = "topic"
+)
+
+// CreateChannelLogger creates a channel logger for the bot. The opt-outs are
+// read from the bot's store.
+func CreateChannelLogger(b *Bot) (*ChannelLogger, error) {
+	c := &ChannelLogger{
+		bot:    b,
+		files:  make(map[string]*chanLogFile),
+		optOut: make(map[string]map[string]bool),
+		now:    time.Now,
+	}
+
+	b.protectStore.RLock()
+	defer b.protectStore.RUnlock()
+	if b.store == nil {
+		return c, nil
+	}
+
+	err := b.store.EachData(chanLogNamespace, func(key string, _ []byte) {
+		if i := strings.IndexByte(key, ' '); i > 0 {
+			c.setOptOut(key[:i], key[i+1:], true)
+		}
+	})
+	return c, err
+}
+
+// OptOut stops the events of a nick on a server from being logged.
+func (c *ChannelLogger) OptOut(server, nick string) error {
+	c.protect.Lock()
+	c.setOptOut(server, nick, true)
+	c.protect.Unlock()
+
+	c.bot.protectStore.Lock()
+	defer c.bot.protectStore.Unlock()
+	if c.bot.store == nil {
+		return nil
+	}
+	return c.bot.store.SaveData(chanLogNamespace, optOutKey(server, nick),
+		[]byte{1})
+}
+
+// OptIn allows the events of a nick on a server to be logged again.
+func (c *ChannelLogger) OptIn(server, nick string) error {
+	c.protect.Lock()
+	c.setOptOut(server, nick, false)
+	c.protect.Unlock()
+
+	c.bot.protectStore.Lock()
+	defer c.bot.protectStore.Unlock()
+	if c.bot.store == nil {
+		return nil
+	}
+	return c.bot.store.DeleteData(chanLogNamespace, optOutKey(server, nick))
+}
+
+// IsOptedOut checks if a nick on a server has opted out of being logged.
+func (c *ChannelLogger) IsOptedOut(server, nick string) bool {
+	c.protect.Lock()
+	defer c.protect.Unlock()
+	return c.optOut[server][strings.ToLower(nick)]
+}
+
+// setOptOut records an opt-out in memory. Not thread safe.
+func (c *ChannelLogger) setOptOut(server, nick string, out bool) {
+	nick = strings.ToLower(nick)
+	if !out {
+		delete(c.optOut[server], nick)
+		return
+	}
+	if c.optOut[server] == nil {
+		c.optOut[server] = make(map[string]bool)
+	}
+	c.optOut[server][nick] = true
+}
+
+// optOutKey creates the store key for an opt-out.
+func optOutKey(server, nick string) string {
+	return server + " " + strings.ToLower(nick)
+}
+
+// Close closes the open log files, events handled after it are dropped.
+func (c *ChannelLogger) Close() (err error) {
+	c.protect.Lock()
+	defer c.protect.Unlock()
+	c.closed = true
+	for key, f := range c.files {
+		if e := f.file.Close(); e != nil {
+			err = e
+		}
+		delete(c.files, key)
+	}
+	return
+}
+
+// Ordered implements dispatch.OrderedHandler so the events are written in
+// the order they happened.
+func (c *ChannelLogger) Ordered() bool {
+	return true
+}
+
+// HandleRaw implements the dispatch.EventHandler interface so the logger can
+// see the events in channels.
+func (c *ChannelLogger) HandleRaw(msg *irc.Message, endpoint irc.Endpoint) {
+	server := endpoint.GetKey()
+	c.bot.protectServers.RLock()
+	srv, ok := c.bot.servers[server]
+	c.bot.protectServers.RUnlock()
+	if !ok || len(msg.Args) == 0 {
+		return
+	}
+
+	c.bot.protectConfig.RLock()
+	dir, format := srv.conf.GetLogDir(), srv.conf.GetLogFormat()
+	network, ignore := srv.conf.GetNetwork(), srv.conf.GetLogIgnore()
+	c.bot.protectConfig.RUnlock()
+	if len(dir) == 0 || isIgnored(ignore, msg.Sender) ||
+		c.IsOptedOut(server, msg.Nick()) {
+		return
+	}
+
+	nick, user, host := msg.Split()
+	ev := chanLogEvent{
+		Time:    msg.Time,
+		Network: network,
+		Nick:    nick,
+		User:    user,
+		Host:    host,
+	}
+	if ev.Time.IsZero() {
+		ev.Time = c.now()
+	}
+
+	var channels []string
+	switch msg.Name {
+	case irc.PRIVMSG, irc.NOTICE:
+		if len(msg.Args) < 2 || !srv.caps.IsChannel(msg.Args[0]) {
+			return
+		}
+		ev.Event, ev.Message = chanLogPrivmsg, msg.Args[1]
+		if msg.Name == irc.NOTICE {
+			ev.Event = chanLogNotice
+		}
+		if msg.IsCTCP() {
+			tag, data := msg.UnpackCTCP()
+			if msg.Name != irc.PRIVMSG || tag != "ACTION" {
+				return
+			}
+			ev.Event, ev.Message = chanLogAction, data
+		}
+		channels = msg.Args[:1]
+	case irc.JOIN:
+		ev.Event = chanLogJoin
+		channels = msg.SplitArgs(0)
+	case irc.PART:
+		ev.Event = chanLogPart
+		if len(msg.Args) > 1 {
+			ev.Message = msg.Args[1]
+		}
+		channels = msg.SplitArgs(0)
+	case irc.KICK:
+		if len(msg.Args) < 2 {
+			return
+		}
+		ev.Event, ev.Target = chanLogKick, msg.Args[1]
+		if len(msg.Args) > 2 {
+			ev.Message = msg.Args[2]
+		}
+		channels = msg.Args[:1]
+	case irc.QUIT:
+		ev.Event, ev.Message = chanLogQuit, msg.Args[0]
+		channels = msg.Channels
+	case irc.NICK:
+		ev.Event, ev.Target = chanLogNick, msg.Args[0]
+		channels = msg.Channels
+	case irc.MODE:
+		if !srv.caps.IsChannel(msg.Args[0]) || len(msg.Args) < 2 {
+			return
+		}
+		ev.Event, ev.Modes = chanLogMode, strings.Join(msg.Args[1:], " ")
+		if len(ev.Nick) == 0 {
+			ev.Nick = msg.Sender
+		}
+		channels = msg.Args[:1]
+	case irc.TOPIC:
+		if len(msg.Args) < 2 {
+			return
+		}
+		ev.Event, ev.Message = chanLogTopic, msg.Args[1]
+		channels = msg.Args[:1]
+	default:
+		return
+	}
+
+	var logged []string
+	c.bot.protectConfig.RLock()
+	for _, channel := range channels {
+		if !srv.conf.GetChannelNoLog(channel) {
+			logged = append(logged, channel)
+		}
+	}
+	c.bot.protectConfig.RUnlock()
+
+	for _, channel := range logged {
+		ev.Channel = channel
+		c.write(dir, format, ev)
+	}
+}
+
+// isIgnored checks if a sender matches one of the ignore masks. A mask
+// without a ! or @ is matched against the nick only.
+func isIgnored(masks []string, sender string) bool {
+	host := irc.Host(sender)
+	for _, mask := range masks {
+		if !strings.ContainsAny(mask, "!@") {
+			mask += "!*@*"
+		}
+		if irc.Mask(strings.ToLower(mask)).Match(
+			irc.Host(strings.ToLower(string(host)))) {
+			return true
+		}
+	}
+	return false
+}
+
+// write writes an event to it's channel's log, opening a new file if the
+// day has changed.
+func (c *ChannelLogger) write(dir, format string, ev chanLogEvent) {
+	var line string
+	if format == config.LogJSON {
+		buf, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		line = string(buf)
+	} else {
+		line = formatChanLog(ev)
+	}
+
+	c.protect.Lock()
+	defer c.protect.Unlock()
+	if c.closed {
+		return
+	}
+
+	day := ev.Time.Format(chanLogDay)
+	path := chanLogPath(dir, format, ev.Network, ev.Channel, day)
+	key := strings.ToLower(ev.Network + " " + ev.Channel)
+	f, ok := c.files[key]
+	if ok && (f.day != day || f.file.Name() != path) {
+		f.file.Close()
+		delete(c.files, key)
+		ok = false
+	}
+	if !ok {
+		var err error
+		if f, err = openChanLog(path, day, format, ev.Time); err != nil {
+			log.Printf(errFmtChanLog, path, err)
+			return
+		}
+		c.files[key] = f
+	}
+
+	if _, err := f.file.WriteString(line + "\n"); err != nil {
+		log.Printf(errFmtChanLog, path, err)
+	}
+}
+
+// chanLogPath creates the path to a channel's log for a day.
+func chanLogPath(dir, format, network, channel, day string) string {
+	ext := ".log"
+	if format == config.LogJSON {
+		ext = ".json"
+	}
+	clean := strings.NewReplacer("/", "_", "\\", "_", "..", "_")
+	return filepath.Join(dir, clean.Replace(strings.ToLower(network)),
+		clean.Replace(strings.ToLower(channel))+"."+day+ext)
+}
+
+// openChanLog opens a channel log for appending, text logs are started with
+// a line saying when they were opened.
+func openChanLog(path, day, format string, t time.Time) (*chanLogFile,
+	error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if format != config.LogJSON {
+		fmt.Fprintf(file, "--- Log opened %s\n",
+			t.Format("Mon Jan 02 15:04:05 2006"))
+	}
+	return &chanLogFile{file, day}, nil
+}
+
+// formatChanLog creates the text for an event like irssi would show it.
+func formatChanLog(ev chanLogEvent) string {
+	stamp := ev.Time.Format("15:04")
+	switch ev.Event {
+	case chanLogPrivmsg:
+		return fmt.Sprintf("%s <%s> %s", stamp, ev.Nick, ev.Message)
+	case chanLogAction:
+		return fmt.Sprintf("%s  * %s %s", stamp, ev.Nick, ev.Message)
+	case chanLogNotice:
+		return fmt.Sprintf("%s -%s:%s- %s", stamp, ev.Nick, ev.Channel,
+			ev.Message)
+	case chanLogJoin:
+		return fmt.Sprintf("%s -!- %s [%s@%s] has joined %s", stamp, ev.Nick,
+			ev.User, ev.Host, ev.Channel)
+	case chanLogPart:
+		return fmt.Sprintf("%s -!- %s [%s@%s] has left %s [%s]", stamp,
+			ev.Nick, ev.User, ev.Host, ev.Channel, ev.Message)
+	case chanLogQuit:
+		return fmt.Sprintf("%s -!- %s [%s@%s] has quit [%s]", stamp, ev.Nick,
+			ev.User, ev.Host, ev.Message)
+	case chanLogKick:
+		return fmt.Sprintf("%s -!- %s was kicked from %s by %s [%s]", stamp,
+			ev.Target, ev.Channel, ev.Nick, ev.Message)
+	case chanLogNick:
+		return fmt.Sprintf("%s -!- %s is now known as %s", stamp, ev.Nick,
+			ev.Target)
+	case chanLogMode:
+		return fmt.Sprintf("%s -!- mode/%s [%s] by %s", stamp, ev.Channel,
+			ev.Modes, ev.Nick)
+	case chanLogTopic:
+		return fmt.Sprintf("%s -!- %s changed the topic of %s to: %s", stamp,
+			ev.Nick, ev.Channel, ev.Message)
+	}
+	return ""
+}
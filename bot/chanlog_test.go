@@ -0,0 +1,274 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/ircdtest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	. "testing"
+	"time"
+)
+
+// waitForLog waits for the log file matching pattern to have n lines and
+// returns them without the leading timestamps.
+func waitForLog(t *T, pattern string, n int) []string {
+	var buf []byte
+	for i := 0; i < 200; i++ {
+		if paths, _ := filepath.Glob(pattern); len(paths) == 1 {
+			buf, _ = ioutil.ReadFile(paths[0])
+		}
+		lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+		if len(lines) >= n {
+			for i := 1; i < len(lines); i++ {
+				lines[i] = lines[i][len("15:04 "):]
+			}
+			return lines
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %v to have %d lines, got:\n%s", pattern, n, buf)
+	return nil
+}
+
+func TestChannelLogger(t *T) {
+	t.Parallel()
+	timeout := 2 * time.Second
+	dir, err := ioutil.TempDir("", "ultimateq")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ircd := ircdtest.CreateServer(serverID)
+	defer ircd.Close()
+	conf := fakeConfig.Clone().GlobalContext().FloodTimeout(100).
+		ServerContext(serverID).Network("Test").
+		LogDir(dir).LogIgnore("spam", "*!*@bots.net")
+	b, err := createBot(conf, ircd.Dial, nil, true, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	logger, err := CreateChannelLogger(b)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer logger.Close()
+	logger.OptOut(serverID, "Shy")
+	b.Register(irc.RAW, logger)
+
+	end := b.Start()
+	defer func() {
+		b.Stop()
+		for _ = range end {
+		}
+	}()
+	if err = ircd.WaitForRegistered(1, timeout); err != nil {
+		t.Fatal(err)
+	}
+	ep := b.GetEndpoint(serverID)
+	ep.Join("#a")
+	ep.Join("#b")
+	for _, ch := range []string{"#a", "#b"} {
+		if err = ircd.WaitForJoin("nobody", ch, timeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ircd.Join("fish!fishy@fish.net", "#a")
+	ircd.Join("fish!fishy@fish.net", "#b")
+	ircd.Join("spam!spam@spam.net", "#a")
+	ircd.Join("bot!bot@bots.net", "#a")
+	ircd.Join("shy!shy@shy.net", "#a")
+	ircd.Privmsg("spam", "#a", "buy now")
+	ircd.Privmsg("bot", "#a", "beep")
+	ircd.Privmsg("shy", "#a", "secret")
+	ircd.Privmsg("fish", "#a", "hello")
+	ircd.Privmsg("fish", "#a", "\x01ACTION waves\x01")
+	ircd.Notice("fish", "#a", "psst")
+	ircd.Topic("fish", "#a", "fishy business")
+	ircd.Mode("fish", "#a", "+v", "spam")
+	ircd.Kick("fish", "#a", "spam", "no spam")
+	ircd.Part("shy", "#a", "bye")
+	ircd.Rename("fish", "trout")
+	ircd.Quit("trout", "gone fishing")
+
+	lines := waitForLog(t, filepath.Join(dir, "test", "#a.*.log"), 11)
+	expected := []string{
+		"--- Log opened",
+		"-!- nobody [nobody@" + ircdtest.DefaultHost +
+			"] has joined #a",
+		"-!- fish [fishy@fish.net] has joined #a",
+		"<fish> hello",
+		" * fish waves",
+		"-fish:#a- psst",
+		"-!- fish changed the topic of #a to: fishy business",
+		"-!- mode/#a [+v spam] by fish",
+		"-!- spam was kicked from #a by fish [no spam]",
+		"-!- fish is now known as trout",
+		"-!- trout [fishy@fish.net] has quit [gone fishing]",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got:\n%s", len(expected),
+			strings.Join(lines, "\n"))
+	}
+	if !strings.HasPrefix(lines[0], expected[0]) {
+		t.Error("Expected the log to be opened, got:", lines[0])
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != expected[i] {
+			t.Errorf("Expected line %q, got %q", expected[i], lines[i])
+		}
+	}
+
+	lines = waitForLog(t, filepath.Join(dir, "test", "#b.*.log"), 5)
+	all := strings.Join(lines, "\n")
+	if len(lines) != 5 || !strings.Contains(all, "now known as trout") ||
+		!strings.Contains(all, "has quit") {
+		t.Error("Expected the nick and quit to be logged in #b, got:", lines)
+	}
+}
+
+func TestChannelLogger_Burst(t *T) {
+	t.Parallel()
+	timeout := 2 * time.Second
+	dir, err := ioutil.TempDir("", "ultimateq")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ircd := ircdtest.CreateServer(serverID)
+	defer ircd.Close()
+	conf := fakeConfig.Clone().GlobalContext().FloodTimeout(100).
+		ServerContext(serverID).Network("Test").LogDir(dir)
+	b, err := createBot(conf, ircd.Dial, nil, true, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	logger, err := CreateChannelLogger(b)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer logger.Close()
+	b.Register(irc.RAW, logger)
+
+	end := b.Start()
+	defer func() {
+		b.Stop()
+		for _ = range end {
+		}
+	}()
+	if err = ircd.WaitForRegistered(1, timeout); err != nil {
+		t.Fatal(err)
+	}
+	b.GetEndpoint(serverID).Join("#a")
+	if err = ircd.WaitForJoin("nobody", "#a", timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	const burst = 200
+	ircd.Join("fish!fishy@fish.net", "#a")
+	for i := 0; i < burst; i++ {
+		ircd.Privmsg("fish", "#a", fmt.Sprint(i))
+	}
+
+	lines := waitForLog(t, filepath.Join(dir, "test", "#a.*.log"), burst+3)
+	for i, line := range lines[3:] {
+		if expect := fmt.Sprintf("<fish> %d", i); line != expect {
+			t.Fatalf("Expected line %q, got %q", expect, line)
+		}
+	}
+}
+
+func TestChannelLogger_Rotation(t *T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "ultimateq")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	logger, _ := CreateChannelLogger(b)
+	defer logger.Close()
+
+	day1 := time.Date(2014, 3, 1, 23, 59, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Minute)
+	ev := chanLogEvent{Network: "net", Channel: "#Chan", Event: chanLogPrivmsg,
+		Nick: "fish", Message: "one"}
+	ev.Time = day1
+	logger.write(dir, config.LogJSON, ev)
+	ev.Time, ev.Message = day2, "two"
+	logger.write(dir, config.LogJSON, ev)
+
+	for i, day := range []string{"2014-03-01", "2014-03-02"} {
+		path := filepath.Join(dir, "net", "#chan."+day+".json")
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		var read chanLogEvent
+		if err = json.Unmarshal(buf, &read); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if read.Message != []string{"one", "two"}[i] || read.Nick != "fish" ||
+			read.Event != chanLogPrivmsg || read.Channel != "#Chan" {
+			t.Errorf("Expected the event to be written, got: %#v", read)
+		}
+	}
+	if len(logger.files) != 1 {
+		t.Error("Expected the old day's file to be closed.")
+	}
+}
+
+func TestChannelLogger_OptOut(t *T) {
+	t.Parallel()
+	store, err := data.CreateStore(data.MemStoreProvider)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	storeProv := func(string) (*data.Store, error) {
+		return store, nil
+	}
+	conf := fakeConfig.Clone().GlobalContext().NoStore(false)
+	b, err := createBot(conf, nil, storeProv, false, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	logger, _ := CreateChannelLogger(b)
+	if err = logger.OptOut(serverID, "Shy"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	logger.OptOut(serverID, "quiet")
+	logger.OptIn(serverID, "QUIET")
+
+	logger, err = CreateChannelLogger(b)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !logger.IsOptedOut(serverID, "shy") {
+		t.Error("Expected the opt-out to be kept in the store.")
+	}
+	if logger.IsOptedOut(serverID, "quiet") || logger.IsOptedOut("x", "shy") {
+		t.Error("Expected only shy to be opted out.")
+	}
+}
+
+func TestChannelLogger_Ignore(t *T) {
+	t.Parallel()
+	masks := []string{"Spam", "*!*@bots.net"}
+	if !isIgnored(masks, "spam!x@y") || !isIgnored(masks, "a!b@BOTS.net") {
+		t.Error("Expected the masks to match.")
+	}
+	if isIgnored(masks, "spammer!x@y") || isIgnored(masks, "a!b@c") {
+		t.Error("Expected the masks not to match.")
+	}
+}
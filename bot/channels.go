@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/irc"
+	"strings"
+)
+
+// channelSettings are the channel settings the dispatchers need. They're
+// copied out of the config so they can be read while dispatching without
+// locking the config.
+type channelSettings struct {
+	prefix     rune
+	extensions []string
+}
+
+// setChannelSettings copies the channel settings of a server's config, the
+// config must be locked by the caller. A nil config forgets the server's
+// settings.
+func (b *Bot) setChannelSettings(server string, conf *config.Server) {
+	var settings map[string]channelSettings
+	if conf != nil {
+		names := conf.GetChannelNames()
+		settings = make(map[string]channelSettings, len(names))
+		for _, name := range names {
+			settings[strings.ToLower(name)] = channelSettings{
+				prefix:     conf.GetChannelPrefix(name),
+				extensions: conf.GetChannelExtensions(name),
+			}
+		}
+	}
+
+	b.protectChannels.Lock()
+	defer b.protectChannels.Unlock()
+	if settings == nil {
+		delete(b.channels, server)
+	} else {
+		b.channels[server] = settings
+	}
+}
+
+// getChannelSettings gets the settings of a server's channel.
+func (b *Bot) getChannelSettings(server, channel string) (
+	settings channelSettings, ok bool) {
+
+	b.protectChannels.RLock()
+	defer b.protectChannels.RUnlock()
+	settings, ok = b.channels[server][strings.ToLower(channel)]
+	return
+}
+
+// channelPrefix is the dispatch.PrefixFilter used by the bot's commanders.
+// Only channels with settings have their own prefix.
+func (b *Bot) channelPrefix(server, channel string) rune {
+	if settings, ok := b.getChannelSettings(server, channel); ok {
+		return settings.prefix
+	}
+	return 0
+}
+
+// channelAllows checks that an extension is not left out of a channel's
+// extensions.
+func (b *Bot) channelAllows(name, server, channel string) bool {
+	settings, ok := b.getChannelSettings(server, channel)
+	if !ok || len(settings.extensions) == 0 {
+		return true
+	}
+	for _, ext := range settings.extensions {
+		if ext == name {
+			return true
+		}
+	}
+	return false
+}
+
+// joinChannels joins the channels with settings using their keys.
+func (c *coreHandler) joinChannels(server *Server, endpoint irc.Endpoint) {
+	server.bot.protectConfig.RLock()
+	var joins []string
+	for _, channel := range server.conf.GetChannelNames() {
+		if key := server.conf.GetChannelKey(channel); len(key) > 0 {
+			channel += " " + key
+		}
+		joins = append(joins, channel)
+	}
+	server.bot.protectConfig.RUnlock()
+
+	for _, join := range joins {
+		endpoint.Send(irc.JOIN + " " + join)
+	}
+}
+
+// autoMode gives ops or voice to people joining a channel if they match the
+// channel's AutoOp or AutoVoice masks.
+func (c *coreHandler) autoMode(server *Server, msg *irc.Message,
+	endpoint irc.Endpoint) {
+
+	nick := msg.Nick()
+	host := irc.Host(strings.ToLower(msg.Sender))
+	for _, channel := range msg.SplitArgs(0) {
+		server.bot.protectConfig.RLock()
+		op := matchesAny(server.conf.GetAutoOp(channel), host)
+		voice := !op && matchesAny(server.conf.GetAutoVoice(channel), host)
+		server.bot.protectConfig.RUnlock()
+
+		switch {
+		case op:
+			endpoint.Send(irc.MODE + " " + channel + " +o " + nick)
+		case voice:
+			endpoint.Send(irc.MODE + " " + channel + " +v " + nick)
+		}
+	}
+}
+
+// matchesAny checks if a lowercased host matches any of the masks.
+func matchesAny(masks []string, host irc.Host) bool {
+	for _, mask := range masks {
+		if host.Match(irc.Mask(strings.ToLower(mask))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"net"
+	. "testing"
+)
+
+func channelConfig() *Bot {
+	conf := fakeConfig.Clone().
+		GlobalContext().
+		Channel("#global").ChannelKey("gkey").AutoVoice("*!*@voiced").
+		ServerContext(serverID).
+		Channel("#Chan").ChannelKey("key").ChannelPrefix("!").
+		ChannelExtensions("ext").AutoOp("*!*@Opped")
+
+	connProvider := func(srv string) (net.Conn, error) {
+		return nil, nil
+	}
+	b, _ := createBot(conf, connProvider, nil, true, false)
+	return b
+}
+
+func TestBot_ChannelSettings(t *T) {
+	t.Parallel()
+	b := channelConfig()
+
+	if p := b.channelPrefix(serverID, "#CHAN"); p != '!' {
+		t.Errorf("Expected the channel prefix to be !, got: %c", p)
+	}
+	if p := b.channelPrefix(serverID, "#global"); p != '.' {
+		t.Errorf("Expected the server's prefix, got: %c", p)
+	}
+	if p := b.channelPrefix(serverID, "#other"); p != 0 {
+		t.Errorf("Expected no prefix for a channel without settings, got: %c",
+			p)
+	}
+
+	if !b.IsExtensionEnabled("ext", serverID, "#chan") {
+		t.Error("Expected ext to be enabled in #chan.")
+	}
+	if b.IsExtensionEnabled("other", serverID, "#chan") {
+		t.Error("Expected other to be left out of #chan.")
+	}
+	if !b.IsExtensionEnabled("other", serverID, "#global") ||
+		!b.IsExtensionEnabled("other", serverID, "") {
+		t.Error("Expected other to be enabled outside #chan.")
+	}
+	b.EnableExtension("other", serverID, "#chan")
+	if !b.IsExtensionEnabled("other", serverID, "#chan") {
+		t.Error("Expected a toggle to override the channel's extensions.")
+	}
+
+	b.setChannelSettings(serverID, nil)
+	if p := b.channelPrefix(serverID, "#chan"); p != 0 {
+		t.Errorf("Expected the settings to be forgotten, got: %c", p)
+	}
+}
+
+func TestBot_ChannelSettingsRehash(t *T) {
+	t.Parallel()
+	b := channelConfig()
+
+	conf := fakeConfig.Clone().ServerContext(serverID).
+		Channel("#new").ChannelPrefix("@")
+	if !b.ReplaceConfig(conf) {
+		t.Fatal("Expected the config to be replaced.")
+	}
+	if p := b.channelPrefix(serverID, "#new"); p != '@' {
+		t.Errorf("Expected the new channel's prefix, got: %c", p)
+	}
+	if p := b.channelPrefix(serverID, "#chan"); p != 0 {
+		t.Errorf("Expected the old channel to be forgotten, got: %c", p)
+	}
+}
+
+func TestCoreHandler_JoinChannels(t *T) {
+	t.Parallel()
+	b := channelConfig()
+	srv := b.servers[serverID]
+
+	endpoint := makeTestPoint(srv)
+	srv.handler.HandleRaw(&irc.Message{Name: irc.RPL_WELCOME}, endpoint)
+	if got, exp := endpoint.gets(), "JOIN #Chan keyJOIN #global gkey"; got != exp {
+		t.Errorf("Expected: %q, got: %q", exp, got)
+	}
+}
+
+func TestCoreHandler_AutoMode(t *T) {
+	t.Parallel()
+	b := channelConfig()
+	srv := b.servers[serverID]
+
+	tests := []struct {
+		sender  string
+		channel string
+		expect  string
+	}{
+		{"nick!user@opped", "#chan", "MODE #chan +o nick"},
+		{"nick!user@voiced", "#global", "MODE #global +v nick"},
+		{"nick!user@voiced", "#chan", ""},
+		{"nick!user@opped", "#other", ""},
+	}
+
+	for _, test := range tests {
+		endpoint := makeTestPoint(srv)
+		srv.handler.HandleRaw(&irc.Message{
+			Name:   irc.JOIN,
+			Sender: test.sender,
+			Args:   []string{test.channel},
+		}, endpoint)
+		if got := endpoint.gets(); got != test.expect {
+			t.Errorf("%v joining %v: Expected: %q, got: %q",
+				test.sender, test.channel, test.expect, got)
+		}
+	}
+}
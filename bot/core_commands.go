@@ -11,6 +11,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var rgxFlags = regexp.MustCompile(`[A-Za-z]+`)
@@ -31,6 +32,13 @@ const (
 	addmask   = `addmask`
 	delmask   = `delmask`
 
+	linkaccount   = `linkaccount`
+	unlinkaccount = `unlinkaccount`
+
+	seen = `seen`
+
+	resync = `resync`
+
 	resetpasswd = `setpasswd`
 
 	ggive      = `ggive`
@@ -92,6 +100,39 @@ const (
 	delmaskSuccess = `Host [%v] removed successfully.`
 	delmaskFailure = `Host [%v] not found.`
 
+	linkaccountDesc = `Links the services account you're logged in to, ` +
+		`you will be authenticated automatically while logged in to it.`
+	linkaccountSuccess = `Account [%v] linked successfully.`
+	linkaccountFailure = `You are not logged in to a services account.`
+	linkaccountTaken   = `Account [%v] is already linked to another user.`
+	unlinkaccountDesc  = `Unlinks the services account from the current ` +
+		`user.`
+	unlinkaccountSuccess = `Account [%v] unlinked successfully.`
+	unlinkaccountFailure = `No account is linked.`
+
+	seenDesc = `Tells when a nick was last seen and what they were doing. ` +
+		`The nick can have the wildcards * and ?, or be a nick!user@host ` +
+		`mask.`
+	seenFailure = `I haven't seen [%v].`
+	seenMatches = `[%v] matches %v nicks, the %v most recent are:`
+	seenSuccess = `[%v] (%v) was last seen %v ago%v`
+	seenPrivate = `.`
+	seenMessage = ` saying [%v] in %v.`
+	seenJoin    = ` joining %v.`
+	seenPart    = ` leaving %v (%v).`
+	seenKick    = ` being kicked from %v (%v).`
+	seenQuit    = ` quitting (%v).`
+	seenNick    = ` changing nick to [%v].`
+	seenMax     = 5
+
+	resyncDesc = `Asks the server who is in a channel and what its modes are, ` +
+		`and corrects what the bot knows where it's wrong. Without a ` +
+		`channel every channel the bot is in is resynced. The corrections ` +
+		`are logged.`
+	resyncSuccess     = `Resyncing %v channels: %v`
+	resyncFailure     = `I'm not in [%v].`
+	resyncFailureNone = `I'm not in any channels.`
+
 	resetpasswdDesc          = `Resets a user's password.`
 	resetpasswdSuccess       = `Password reset successful.`
 	resetpasswdSuccessTarget = `Your password was reset by %v, it is now: %v`
@@ -179,6 +220,10 @@ var commands = []struct {
 	{masks, masksDesc, true, false, 0, ``, argv{`[*user]`}},
 	{addmask, addmaskDesc, true, false, 0, ``, argv{`mask`, `[*user]`}},
 	{delmask, delmaskDesc, true, false, 0, ``, argv{`mask`, `[*user]`}},
+	{linkaccount, linkaccountDesc, true, false, 0, ``, nil},
+	{unlinkaccount, unlinkaccountDesc, true, false, 0, ``, nil},
+	{seen, seenDesc, false, true, 0, ``, argv{`nick`}},
+	{resync, resyncDesc, true, true, 0, `GS`, argv{`[chan]`}},
 	{resetpasswd, resetpasswdDesc, true, false, 0, ``, argv{`~nick`, `*user`}},
 	{ggive, ggiveDesc, true, true, 0, `G`, argv{`*user`, `levelOrFlags...`}},
 	{sgive, sgiveDesc, true, true, 0, `GS`, argv{`*user`, `levelOrFlags...`}},
@@ -274,6 +319,14 @@ func (c *coreCommands) Command(cmd string, m *irc.Message, d *data.DataEndpoint,
 		internal, external = c.addmask(d, cd)
 	case delmask:
 		internal, external = c.delmask(d, cd)
+	case linkaccount:
+		internal, external = c.linkaccount(d, cd)
+	case unlinkaccount:
+		internal, external = c.unlinkaccount(d, cd)
+	case seen:
+		internal, external = c.seen(d, cd)
+	case resync:
+		internal, external = c.resync(d, cd)
 	case resetpasswd:
 		internal, external = c.resetpasswd(d, cd)
 	case ggive:
@@ -776,6 +829,87 @@ func (c *coreCommands) delmask(d *data.DataEndpoint, cd *cmds.CommandData) (
 	return
 }
 
+// linkaccount links the services account of the current user to them.
+func (c *coreCommands) linkaccount(d *data.DataEndpoint,
+	cd *cmds.CommandData) (internal, external error) {
+
+	nick := cd.User.Nick()
+	account := cd.User.Account()
+	uname := cd.UserAccess.Username
+	if len(account) == 0 {
+		d.Notice(nick, linkaccountFailure)
+		return
+	}
+
+	cd.Close()
+	c.b.protectStore.Lock()
+	defer c.b.protectStore.Unlock()
+	store := c.b.store
+
+	var access *data.UserAccess
+	access, internal = store.FindUserByAccount(account)
+	if internal != nil {
+		return
+	}
+	if access != nil && access.Username != uname {
+		d.Noticef(nick, linkaccountTaken, account)
+		return
+	}
+
+	access, internal = store.FindUser(uname)
+	if internal != nil {
+		return
+	}
+	if access == nil {
+		internal = fmt.Errorf(errFmtExpired, uname)
+		return
+	}
+
+	access.Account = account
+	internal = store.AddUser(access)
+	if internal != nil {
+		return
+	}
+	d.Noticef(nick, linkaccountSuccess, account)
+	return
+}
+
+// unlinkaccount removes the services account from the current user.
+func (c *coreCommands) unlinkaccount(d *data.DataEndpoint,
+	cd *cmds.CommandData) (internal, external error) {
+
+	nick := cd.User.Nick()
+	uname := cd.UserAccess.Username
+
+	cd.Close()
+	c.b.protectStore.Lock()
+	defer c.b.protectStore.Unlock()
+	store := c.b.store
+
+	var access *data.UserAccess
+	access, internal = store.FindUser(uname)
+	if internal != nil {
+		return
+	}
+	if access == nil {
+		internal = fmt.Errorf(errFmtExpired, uname)
+		return
+	}
+	if len(access.Account) == 0 {
+		d.Notice(nick, unlinkaccountFailure)
+		return
+	}
+
+	account := access.Account
+	access.Account = ""
+	internal = store.AddUser(access)
+	if internal != nil {
+		return
+	}
+	d.Noticef(nick, unlinkaccountSuccess, account)
+	return
+}
+
 // resetpasswd resets a user's password
 func (c *coreCommands) resetpasswd(d *data.DataEndpoint, cd *cmds.CommandData) (
 	internal, external error) {
@@ -1137,6 +1271,144 @@ func (c *coreCommands) help(d *data.DataEndpoint, cd *cmds.CommandData) (
 	return
 }
 
+// seen tells when the nicks matching the nick argument were last seen. What
+// they were doing is left out when it was in a private channel, unless it was
+// the channel the command was used in.
+func (c *coreCommands) seen(d *data.DataEndpoint, cd *cmds.CommandData) (
+	internal, external error) {
+
+	mask := cd.GetArg("nick")
+	nick := cd.User.Nick()
+	server := d.GetKey()
+	var here string
+	if cd.Channel != nil {
+		here = cd.Channel.Name()
+	}
+
+	latest := make(map[string]data.Seen)
+	add := func(list []data.Seen) {
+		for _, s := range list {
+			key := strings.ToLower(s.Nick)
+			if old, ok := latest[key]; !ok || s.Time.After(old.Time) {
+				latest[key] = s
+			}
+		}
+	}
+	add(d.MatchSeen(mask))
+	if cd.Store != nil {
+		var stored []data.Seen
+		if stored, internal = cd.Store.MatchSeen(server, mask); internal != nil {
+			return
+		}
+		add(stored)
+	}
+	cd.Close()
+
+	if len(latest) == 0 {
+		return nil, fmt.Errorf(seenFailure, mask)
+	}
+	list := make([]data.Seen, 0, len(latest))
+	for _, s := range latest {
+		list = append(list, s)
+	}
+	sort.Sort(seenByTime(list))
+
+	if len(list) > 1 {
+		shown := len(list)
+		if shown > seenMax {
+			shown = seenMax
+		}
+		d.Noticef(nick, seenMatches, mask, len(list), shown)
+		list = list[:shown]
+	}
+
+	c.b.protectServers.RLock()
+	c.b.protectConfig.RLock()
+	var private map[string]bool
+	if srv, ok := c.b.servers[server]; ok {
+		private = make(map[string]bool)
+		for _, s := range list {
+			if len(s.Channel) > 0 && !strings.EqualFold(s.Channel, here) {
+				private[s.Channel] = srv.conf.GetChannelPrivate(s.Channel)
+			}
+		}
+	}
+	c.b.protectConfig.RUnlock()
+	c.b.protectServers.RUnlock()
+
+	now := time.Now()
+	for _, s := range list {
+		ago := now.Sub(s.Time)
+		ago -= ago % time.Second
+		d.Noticef(nick, seenSuccess, s.Nick, s.Host, ago,
+			seenDoing(s, private[s.Channel]))
+	}
+	return
+}
+
+func (c *coreCommands) resync(d *data.DataEndpoint, cd *cmds.CommandData) (
+	internal, external error) {
+
+	ch := cd.GetArg("chan")
+	nick := cd.User.Nick()
+	cd.Close()
+
+	c.b.protectServers.RLock()
+	srv, ok := c.b.servers[d.GetKey()]
+	c.b.protectServers.RUnlock()
+	if !ok {
+		return
+	}
+
+	var resyncing []string
+	if len(ch) > 0 {
+		resyncing = srv.resync(ch)
+	} else {
+		resyncing = srv.resync()
+	}
+	if len(resyncing) == 0 {
+		if len(ch) > 0 {
+			return nil, fmt.Errorf(resyncFailure, ch)
+		}
+		return nil, fmt.Errorf(resyncFailureNone)
+	}
+
+	d.Noticef(nick, resyncSuccess, len(resyncing),
+		strings.Join(resyncing, " "))
+	return
+}
+
+// seenDoing describes what a user was seen doing, or nothing if it was in a
+// private channel.
+func seenDoing(s data.Seen, private bool) string {
+	if private {
+		return seenPrivate
+	}
+
+	switch s.Action {
+	case data.SeenMessage:
+		return fmt.Sprintf(seenMessage, s.Message, s.Channel)
+	case data.SeenJoin:
+		return fmt.Sprintf(seenJoin, s.Channel)
+	case data.SeenPart:
+		return fmt.Sprintf(seenPart, s.Channel, s.Message)
+	case data.SeenKick:
+		return fmt.Sprintf(seenKick, s.Channel, s.Message)
+	case data.SeenQuit:
+		return fmt.Sprintf(seenQuit, s.Message)
+	case data.SeenNick:
+		return fmt.Sprintf(seenNick, s.Message)
+	}
+	return seenPrivate
+}
+
+// seenByTime sorts seen users from the most recently seen.
+type seenByTime []data.Seen
+
+func (s seenByTime) Len() int           { return len(s) }
+func (s seenByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s seenByTime) Less(i, j int) bool { return s[i].Time.After(s[j].Time) }
+
 // userListWidth calculates the width of the userList's "User" column.
 func userListWidth(users []data.UserAccess) int {
 	minl := len(usersListHeadUser)
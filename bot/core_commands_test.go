@@ -3,6 +3,7 @@ package bot
 import (
 	"bytes"
 	"fmt"
+	"github.com/aarondl/ultimateq/config"
 	"github.com/aarondl/ultimateq/data"
 	"github.com/aarondl/ultimateq/irc"
 	"regexp"
@@ -603,6 +604,217 @@ func TestCoreCommands_Masks(t *T) {
 	}
 }
 
+func TestCoreCommands_LinkAccount(t *T) {
+	ts := commandsSetup(t)
+	defer commandsTeardown(ts, t)
+
+	var err error
+
+	err = rspChk(ts, registerSuccessFirst, u1host, register, password, u1user)
+	if err != nil {
+		t.Error(err)
+	}
+	err = rspChk(ts, registerSuccess, u2host, register, password)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = rspChk(ts, linkaccountFailure, u1host, linkaccount)
+	if err != nil {
+		t.Error(err)
+	}
+	err = rspChk(ts, unlinkaccountFailure, u1host, unlinkaccount)
+	if err != nil {
+		t.Error(err)
+	}
+
+	ts.state.Update(&irc.Message{
+		Name: irc.ACCOUNT, Sender: u1host, Args: []string{"acct"},
+	})
+	ts.state.Update(&irc.Message{
+		Name: irc.ACCOUNT, Sender: u2host, Args: []string{"acct"},
+	})
+
+	err = rspChk(ts, linkaccountSuccess, u1host, linkaccount)
+	if err != nil {
+		t.Error(err)
+	}
+	err = rspChk(ts, linkaccountTaken, u2host, linkaccount)
+	if err != nil {
+		t.Error(err)
+	}
+
+	access, err := ts.store.FindUserByAccount("acct")
+	if err != nil || access == nil || access.Username != u1user {
+		t.Error("Expected the account to be linked, got:", access, err)
+	}
+
+	err = rspChk(ts, unlinkaccountSuccess, u1host, unlinkaccount)
+	if err != nil {
+		t.Error(err)
+	}
+	access, err = ts.store.FindUserByAccount("acct")
+	if err != nil || access != nil {
+		t.Error("Expected the account to be unlinked, got:", access, err)
+	}
+}
+
+func TestCoreCommands_AccountLogout(t *T) {
+	ts := commandsSetup(t)
+	defer commandsTeardown(ts, t)
+	srv := ts.b.servers[serverID]
+
+	var err error
+	err = rspChk(ts, registerSuccessFirst, u1host, register, password, u1user)
+	if err != nil {
+		t.Error(err)
+	}
+	account := func(name string) {
+		msg := &irc.Message{
+			Name: irc.ACCOUNT, Sender: u1host, Args: []string{name},
+		}
+		ts.state.Update(msg)
+		ts.b.accountChanged(srv, msg)
+	}
+	ts.state.Update(&irc.Message{
+		Name: irc.ACCOUNT, Sender: u1host, Args: []string{"acct"},
+	})
+	err = rspChk(ts, linkaccountSuccess, u1host, linkaccount)
+	if err != nil {
+		t.Error(err)
+	}
+
+	account("acct2")
+	if ts.store.GetAuthedUser(serverID, u1host) != nil {
+		t.Error("Expected the login to end with the account change.")
+	}
+	account("acct")
+	err = rspChk(ts, accessSuccess, u1host, access)
+	if err != nil {
+		t.Error(err)
+	}
+	if ts.store.GetAuthedUser(serverID, u1host) == nil {
+		t.Error("Expected the user to be logged in by their account.")
+	}
+
+	account("*")
+	if ts.store.GetAuthedUser(serverID, u1host) != nil {
+		t.Error("Expected the account login to end when it was cleared.")
+	}
+	ts.buffer.Reset()
+	ts.b.commander.Dispatch(serverID, 0, &irc.Message{
+		Name: irc.PRIVMSG, Sender: u1host,
+		Args: []string{botnick, access},
+	}, ts.ep)
+	ts.b.commander.WaitForHandlers()
+	if !strings.Contains(ts.buffer.String(), "not authenticated") {
+		t.Error("Expected the user to be denied, got:", ts.buffer)
+	}
+}
+
+func TestCoreCommands_Seen(t *T) {
+	ts := commandsSetup(t)
+	defer commandsTeardown(ts, t)
+	srv := ts.b.servers[serverID]
+	srv.conf.ChannelSettings = map[string]*config.Channel{
+		"#secret": {Name: "#secret", Private: "true"},
+	}
+
+	var err error
+	joined := `[nick1] (nick1!user1@host1) was last seen %v ago joining #chan.`
+
+	err = rspChk(ts, joined, u2host, seen, u1nick)
+	if err != nil {
+		t.Error(err)
+	}
+	err = rspChk(ts, seenFailure, u2host, seen, "nobody")
+	if err != nil {
+		t.Error(err)
+	}
+
+	ts.b.saveSeen(srv)
+	if ts.state.GetSeen(u1nick) != nil {
+		t.Error("Expected the seen users to be flushed.")
+	}
+	err = rspChk(ts, joined, u2host, seen, "*!*@host1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, sender := range []string{bothost, u1host, u2host} {
+		ts.state.Update(&irc.Message{
+			Name: irc.JOIN, Sender: sender, Args: []string{"#secret"},
+		})
+	}
+	ts.state.Update(&irc.Message{
+		Name: irc.PRIVMSG, Sender: u2host, Args: []string{"#secret", "psst"},
+	})
+
+	err = rspChk(ts, `[nick2] (nick2!user2@host2) was last seen %v ago.`,
+		u1host, seen, u2nick)
+	if err != nil {
+		t.Error(err)
+	}
+	err = prvRspChk(ts,
+		`[nick2] (nick2!user2@host2) was last seen %v ago saying [psst] in `+
+			`#secret.`, "#secret", u1host, prefix+seen, u2nick)
+	if err != nil {
+		t.Error(err)
+	}
+
+	ts.buffer.Reset()
+	ts.b.commander.Dispatch(serverID, 0, &irc.Message{
+		Name: irc.PRIVMSG, Sender: u1host,
+		Args: []string{botnick, seen + " nick?"},
+	}, ts.ep)
+	ts.b.commander.WaitForHandlers()
+	lines := strings.Split(ts.buffer.String(), "NOTICE ")[1:]
+	if len(lines) != 3 || !strings.Contains(lines[0], "matches 2 nicks") ||
+		!strings.Contains(lines[1], "[nick2]") ||
+		!strings.Contains(lines[2], "[nick1]") {
+		t.Errorf("Expected both nicks most recent first, got: %q", lines)
+	}
+}
+
+func TestCoreCommands_Resync(t *T) {
+	ts := commandsSetup(t)
+	defer commandsTeardown(ts, t)
+	var err error
+
+	err = rspChk(ts, registerSuccessFirst, u1host, register, password, u1user)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = rspChk(ts, resyncFailure, u1host, resync, "#other")
+	if err != nil {
+		t.Error(err)
+	}
+	err = rspChk(ts, resyncSuccess, u1host, resync, channel)
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(ts.buffer.String(), "1 channels: "+channel) {
+		t.Error("Expected the channel to be resynced, got:", ts.buffer)
+	}
+
+	events, _ := ts.state.Update(&irc.Message{
+		Name: irc.RPL_ENDOFNAMES, Sender: serverID,
+		Args: []string{botnick, channel, "End of /NAMES list."},
+	})
+	if len(events) != 1 {
+		t.Fatal("Expected the resync to finish, got:", events)
+	}
+	if _, ok := events[0].(data.ChannelResynced); !ok {
+		t.Error("Expected a ChannelResynced event, got:", events[0])
+	}
+
+	err = rspChk(ts, resyncFailureNone, u1host, resync)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestCoreCommands_Resetpasswd(t *T) {
 	ts := commandsSetup(t)
 	defer commandsTeardown(ts, t)
@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"github.com/aarondl/ultimateq/data"
 	"github.com/aarondl/ultimateq/irc"
 	"sync"
 )
@@ -57,15 +58,36 @@ func (c *coreHandler) HandleRaw(msg *irc.Message, endpoint irc.Endpoint) {
 		c.protect.Unlock()
 		endpoint.Send("NICK :" + nick)
 
+	case irc.RPL_WELCOME:
+		c.joinChannels(c.getServer(endpoint), endpoint)
+
 	case irc.JOIN:
 		server := c.getServer(endpoint)
+		lists := "b"
 		server.protectState.RLock()
-		defer server.protectState.RUnlock()
-		if server.state != nil {
-			if msg.Sender == server.state.Self.Host() {
+		self := server.state != nil && server.state.Self.User != nil &&
+			msg.Sender == server.state.Self.Host()
+		if self {
+			if mode := server.state.ExceptMode(); mode != 0 {
+				lists += string(mode)
+			}
+			if mode := server.state.InvexMode(); mode != 0 {
+				lists += string(mode)
+			}
+		}
+		server.protectState.RUnlock()
+		if self {
+			if len(server.caps.Extra("WHOX")) > 0 {
+				endpoint.Send(data.WhoxQuery(msg.Args[0]))
+			} else {
 				endpoint.Send("WHO :", msg.Args[0])
-				endpoint.Send("MODE :", msg.Args[0])
 			}
+			endpoint.Send("MODE :", msg.Args[0])
+			for _, mode := range lists {
+				endpoint.Send("MODE ", msg.Args[0], " :+", string(mode))
+			}
+		} else {
+			c.autoMode(server, msg, endpoint)
 		}
 
 	case irc.RPL_MYINFO:
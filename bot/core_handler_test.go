@@ -135,5 +135,32 @@ func (s *s) TestCoreHandler_Join(c *C) {
 
 	endpoint := makeTestPoint(nil)
 	srv.handler.HandleRaw(msg, endpoint)
-	c.Check(endpoint.gets(), Equals, "WHO :#chanMODE :#chan")
+	c.Check(endpoint.gets(), Equals, "WHO :#chanMODE :#chanMODE #chan :+b")
+}
+
+func (s *s) TestCoreHandler_JoinWhox(c *C) {
+	connProvider := func(srv string) (net.Conn, error) {
+		return nil, nil
+	}
+
+	b, err := createBot(fakeConfig, connProvider, nil, true, false)
+	srv := b.servers[serverID]
+	c.Check(err, IsNil)
+
+	srv.handler.HandleRaw(&irc.Message{
+		Name: irc.RPL_ISUPPORT,
+		Args: []string{"nick", "WHOX", "EXCEPTS", "INVEX=J"},
+	}, &testPoint{})
+
+	srv.state.Self.User = data.CreateUser("nick!user@host")
+	msg := &irc.Message{
+		Name:   irc.JOIN,
+		Sender: srv.state.Self.Host(),
+		Args:   []string{"#chan"},
+	}
+
+	endpoint := makeTestPoint(nil)
+	srv.handler.HandleRaw(msg, endpoint)
+	c.Check(endpoint.gets(), Equals, data.WhoxQuery("#chan")+"MODE :#chan"+
+		"MODE #chan :+bMODE #chan :+eMODE #chan :+J")
 }
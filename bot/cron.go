@@ -0,0 +1,157 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// errFmtCronFields occurs when a cron expression has the wrong number of
+	// fields.
+	errFmtCronFields = "bot: Cron expression needs 5 fields (%v)"
+	// errFmtCronField occurs when a field in a cron expression is invalid.
+	errFmtCronField = "bot: Invalid cron field (%v)"
+	// cronSearchYears is how far ahead a cron schedule is searched for the
+	// next time it should run before giving up.
+	cronSearchYears = 5
+)
+
+// cronShortcuts are the named cron expressions.
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is a parsed cron expression in the usual five field format:
+// minute hour day-of-month month day-of-week. Each field may be a *, a
+// number, a range (a-b), a list (a,b,c) and any of these may have a step
+// (*/5, 1-10/2). Day of week is 0-6 starting on sunday, 7 is also sunday.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// If both day fields are restricted a day matches if either of them do.
+	domStar, dowStar bool
+}
+
+// parseCron parses a cron expression.
+func parseCron(spec string) (*cronSchedule, error) {
+	if shortcut, ok := cronShortcuts[strings.TrimSpace(spec)]; ok {
+		spec = shortcut
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf(errFmtCronFields, spec)
+	}
+
+	c := &cronSchedule{}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField parses a single cron field into a bitset of the values it
+// allows.
+func parseCronField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		low, high, step := min, max, 1
+
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf(errFmtCronField, field)
+			}
+			part = part[:i]
+		}
+
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf(errFmtCronField, field)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf(errFmtCronField, field)
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf(errFmtCronField, field)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matchDay checks if the day of t is allowed by the schedule.
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next finds the first time after t that the schedule allows. If there is no
+// such time in the next few years the zero time is returned.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0,
+				loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,65 @@
+package bot
+
+import (
+	. "testing"
+	"time"
+)
+
+func TestCron_Parse(t *T) {
+	t.Parallel()
+	good := []string{
+		"* * * * *", "*/5 * * * *", "0 9-17 * * 1-5", "0,30 */2 1,15 1-12/3 *",
+		"5 4 * * 7", "@daily", " @hourly ",
+	}
+	bad := []string{
+		"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *",
+		"* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *",
+		"a * * * *", "1-b * * * *", "*/x * * * *", "@sometimes",
+	}
+
+	for _, spec := range good {
+		if _, err := parseCron(spec); err != nil {
+			t.Errorf("Expected %q to parse, got: %v", spec, err)
+		}
+	}
+	for _, spec := range bad {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("Expected %q to fail to parse.", spec)
+		}
+	}
+}
+
+func TestCron_Next(t *T) {
+	t.Parallel()
+	// Sunday the 1st of June 2014.
+	start := time.Date(2014, 6, 1, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2014, 6, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2014, 6, 1, 10, 15, 0, 0, time.UTC)},
+		{"5 * * * *", time.Date(2014, 6, 1, 11, 5, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2014, 6, 2, 9, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2014, 6, 2, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2014, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Restricting both day fields matches either of them.
+		{"0 0 15 * 3", time.Date(2014, 6, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		cron, err := parseCron(test.spec)
+		if err != nil {
+			t.Error("Unexpected error:", err)
+			continue
+		}
+		if next := cron.next(start); !next.Equal(test.next) {
+			t.Errorf("%q: Expected %v, got %v", test.spec, test.next, next)
+		}
+	}
+}
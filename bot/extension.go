@@ -0,0 +1,320 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aarondl/ultimateq/dispatch/commander"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// extensionNamespace is where extension toggles are kept in the store.
+	extensionNamespace = "extensions"
+	// errFmtDuplicateExtension occurs when an extension is registered twice.
+	errFmtDuplicateExtension = "bot: Extension (%v) is already registered."
+	// errFmtUnknownExtension occurs when an extension that is not registered
+	// is unloaded.
+	errFmtUnknownExtension = "bot: Extension (%v) is not registered."
+)
+
+var (
+	// errExtensionName occurs when an extension is given an empty name.
+	errExtensionName = errors.New("bot: Extension name cannot be empty.")
+	// errExtensionChannel occurs when an extension is toggled in a channel
+	// without a server, channels are only toggled on a server.
+	errExtensionChannel = errors.New(
+		"bot: Extension channel toggles require a server.")
+)
+
+// Extension groups the event handlers and commands registered by an extension
+// so they can be enabled and disabled together, and unloaded cleanly. It's
+// created by Bot.RegisterExtension.
+type Extension struct {
+	bot      *Bot
+	name     string
+	handlers []extensionHandler
+	commands []extensionCommand
+
+	protect sync.Mutex
+}
+
+// extensionHandler is an event handler registered by an extension. server is
+// empty for handlers registered to the bot's global dispatcher.
+type extensionHandler struct {
+	server string
+	event  string
+	id     int
+}
+
+// extensionCommand is a command registered by an extension. server is
+// commander.GLOBAL for commands registered to the bot's global commander.
+type extensionCommand struct {
+	server string
+	cmd    string
+}
+
+// GetName returns the name of the extension.
+func (e *Extension) GetName() string {
+	return e.name
+}
+
+// Register adds an event handler to the bot's global dispatcher on behalf of
+// the extension. See Bot.Register.
+func (e *Extension) Register(event string, handler interface{}) int {
+	id := e.bot.dispatcher.RegisterExtension(e.name, event, handler)
+
+	e.protect.Lock()
+	defer e.protect.Unlock()
+	e.handlers = append(e.handlers, extensionHandler{"", event, id})
+	return id
+}
+
+// RegisterServer adds an event handler to a server specific dispatcher on
+// behalf of the extension. See Bot.RegisterServer.
+func (e *Extension) RegisterServer(
+	server string, event string, handler interface{}) (int, error) {
+
+	e.bot.protectServers.RLock()
+	s, ok := e.bot.servers[server]
+	e.bot.protectServers.RUnlock()
+	if !ok {
+		return 0, errUnknownServerID
+	}
+
+	id := s.dispatcher.RegisterExtension(e.name, event, handler)
+
+	e.protect.Lock()
+	defer e.protect.Unlock()
+	e.handlers = append(e.handlers, extensionHandler{server, event, id})
+	return id, nil
+}
+
+// RegisterCommand registers a command with the bot on behalf of the
+// extension, the command's Extension is set to the extension's name. See
+// Bot.RegisterCommand.
+func (e *Extension) RegisterCommand(cmd *commander.Command) error {
+	cmd.Extension = e.name
+	if err := e.bot.commander.Register(commander.GLOBAL, cmd); err != nil {
+		return err
+	}
+
+	e.protect.Lock()
+	defer e.protect.Unlock()
+	e.commands = append(e.commands,
+		extensionCommand{commander.GLOBAL, cmd.Cmd})
+	return nil
+}
+
+// RegisterServerCommand registers a command with a server on behalf of the
+// extension, the command's Extension is set to the extension's name. See
+// Bot.RegisterServerCommand.
+func (e *Extension) RegisterServerCommand(
+	server string, cmd *commander.Command) error {
+
+	e.bot.protectServers.RLock()
+	s, ok := e.bot.servers[server]
+	e.bot.protectServers.RUnlock()
+	if !ok {
+		return errUnknownServerID
+	}
+
+	cmd.Extension = e.name
+	if err := s.commander.Register(server, cmd); err != nil {
+		return err
+	}
+
+	e.protect.Lock()
+	defer e.protect.Unlock()
+	e.commands = append(e.commands, extensionCommand{server, cmd.Cmd})
+	return nil
+}
+
+// unload unregisters everything the extension registered.
+func (e *Extension) unload() {
+	e.protect.Lock()
+	defer e.protect.Unlock()
+
+	for _, h := range e.handlers {
+		if len(h.server) == 0 {
+			e.bot.Unregister(h.event, h.id)
+		} else {
+			e.bot.UnregisterServer(h.server, h.event, h.id)
+		}
+	}
+	for _, c := range e.commands {
+		if c.server == commander.GLOBAL {
+			e.bot.UnregisterCommand(c.cmd)
+		} else {
+			e.bot.UnregisterServerCommand(c.server, c.cmd)
+		}
+	}
+	e.handlers, e.commands = nil, nil
+}
+
+// RegisterExtension creates a registration group for an extension. Handlers
+// and commands registered through it can be toggled with EnableExtension and
+// DisableExtension, and removed with UnloadExtension.
+func (b *Bot) RegisterExtension(name string) (*Extension, error) {
+	if len(name) == 0 {
+		return nil, errExtensionName
+	}
+
+	b.protectExtensions.Lock()
+	defer b.protectExtensions.Unlock()
+
+	if _, ok := b.extensions[name]; ok {
+		return nil, fmt.Errorf(errFmtDuplicateExtension, name)
+	}
+	ext := &Extension{bot: b, name: name}
+	b.extensions[name] = ext
+	return ext, nil
+}
+
+// UnloadExtension unregisters all the handlers and commands of an extension
+// and forgets about it. Whether it's enabled or disabled is remembered for
+// when it's registered again.
+func (b *Bot) UnloadExtension(name string) error {
+	b.protectExtensions.Lock()
+	ext, ok := b.extensions[name]
+	delete(b.extensions, name)
+	b.protectExtensions.Unlock()
+
+	if !ok {
+		return fmt.Errorf(errFmtUnknownExtension, name)
+	}
+	ext.unload()
+	return nil
+}
+
+// GetExtension retrieves a registered extension. Will be nil if the extension
+// is not registered.
+func (b *Bot) GetExtension(name string) *Extension {
+	b.protectExtensions.RLock()
+	defer b.protectExtensions.RUnlock()
+	return b.extensions[name]
+}
+
+// GetExtensions retrieves the names of the registered extensions, sorted.
+func (b *Bot) GetExtensions() (names []string) {
+	b.protectExtensions.RLock()
+	defer b.protectExtensions.RUnlock()
+
+	for name := range b.extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// EnableExtension enables an extension on a server, or only in one of it's
+// channels if channel is not empty. An empty server enables it everywhere
+// that hasn't been toggled more specifically, the channel must be empty then.
+// The toggle is kept in the store.
+func (b *Bot) EnableExtension(name, server, channel string) error {
+	return b.toggleExtension(name, server, channel, true)
+}
+
+// DisableExtension disables an extension on a server, or only in one of it's
+// channels if channel is not empty. An empty server disables it everywhere
+// that hasn't been toggled more specifically, the channel must be empty then.
+// The toggle is kept in the store.
+func (b *Bot) DisableExtension(name, server, channel string) error {
+	return b.toggleExtension(name, server, channel, false)
+}
+
+// ResetExtension removes a toggle set by EnableExtension or DisableExtension
+// so the extension falls back to the toggle for the server, or everywhere.
+func (b *Bot) ResetExtension(name, server, channel string) error {
+	if len(name) == 0 {
+		return errExtensionName
+	}
+	if len(server) == 0 && len(channel) != 0 {
+		return errExtensionChannel
+	}
+
+	key := toggleKey(name, server, channel)
+	b.protectToggles.Lock()
+	delete(b.toggles, key)
+	b.protectToggles.Unlock()
+
+	b.protectStore.Lock()
+	defer b.protectStore.Unlock()
+	if b.store == nil {
+		return nil
+	}
+	return b.store.DeleteData(extensionNamespace, key)
+}
+
+// IsExtensionEnabled checks if an extension is enabled in a channel on a
+// server. The channel's toggle is checked first, then the server's and then
+// the toggle for everywhere. If none are set the extension is enabled unless
+// the channel's config lists Extensions without it. The channel may be empty
+// to check only the server. This is the
+// dispatch.ExtensionFilter used by the bot's dispatchers and commanders.
+func (b *Bot) IsExtensionEnabled(name, server, channel string) bool {
+	b.protectToggles.RLock()
+	defer b.protectToggles.RUnlock()
+
+	if len(channel) != 0 {
+		if on, ok := b.toggles[toggleKey(name, server, channel)]; ok {
+			return on
+		}
+	}
+	if on, ok := b.toggles[toggleKey(name, server, "")]; ok {
+		return on
+	}
+	if on, ok := b.toggles[toggleKey(name, "", "")]; ok {
+		return on
+	}
+	return len(channel) == 0 || b.channelAllows(name, server, channel)
+}
+
+// toggleExtension sets a toggle for an extension and saves it in the store.
+func (b *Bot) toggleExtension(name, server, channel string, on bool) error {
+	if len(name) == 0 {
+		return errExtensionName
+	}
+	if len(server) == 0 && len(channel) != 0 {
+		return errExtensionChannel
+	}
+
+	key := toggleKey(name, server, channel)
+	b.protectToggles.Lock()
+	b.toggles[key] = on
+	b.protectToggles.Unlock()
+
+	b.protectStore.Lock()
+	defer b.protectStore.Unlock()
+	if b.store == nil {
+		return nil
+	}
+	value := []byte{0}
+	if on {
+		value[0] = 1
+	}
+	return b.store.SaveData(extensionNamespace, key, value)
+}
+
+// loadToggles reads the extension toggles from the store.
+func (b *Bot) loadToggles() error {
+	b.protectToggles.Lock()
+	defer b.protectToggles.Unlock()
+
+	b.protectStore.RLock()
+	defer b.protectStore.RUnlock()
+	if b.store == nil {
+		return nil
+	}
+
+	return b.store.EachData(extensionNamespace, func(key string, value []byte) {
+		b.toggles[key] = len(value) > 0 && value[0] != 0
+	})
+}
+
+// toggleKey creates the key for an extension toggle, it's also the key in
+// the store.
+func toggleKey(name, server, channel string) string {
+	return name + " " + server + " " + strings.ToLower(channel)
+}
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/dispatch/commander"
+	"github.com/aarondl/ultimateq/irc"
+	"sync/atomic"
+	. "testing"
+)
+
+func TestBot_Extension(t *T) {
+	// t.Parallel() Cannot be parallel due to the nature of command registration
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	srv := b.servers[serverID]
+
+	if _, err := b.RegisterExtension(""); err != errExtensionName {
+		t.Error("Expected:", errExtensionName, "got:", err)
+	}
+	ext, err := b.RegisterExtension("ext")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, err = b.RegisterExtension("ext"); err == nil {
+		t.Error("Expected an error about duplicates.")
+	}
+	if b.GetExtension("ext") != ext || ext.GetName() != "ext" {
+		t.Error("Expected to get the extension back.")
+	}
+
+	var global, server, commands int32
+	ext.Register(irc.PRIVMSG, testHandler{func(*irc.Message, irc.Endpoint) {
+		atomic.AddInt32(&global, 1)
+	}})
+	_, err = ext.RegisterServer(serverID, irc.PRIVMSG,
+		testHandler{func(*irc.Message, irc.Endpoint) {
+			atomic.AddInt32(&server, 1)
+		}})
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if _, err = ext.RegisterServer("badServer", irc.PRIVMSG,
+		testHandler{}); err != errUnknownServerID {
+		t.Error("Expected:", errUnknownServerID, "got:", err)
+	}
+	cmd := commander.MkCmd("other", "desc", "extcmd", &testCommand{
+		func(string, *irc.Message, *data.DataEndpoint,
+			*commander.CommandData) error {
+			atomic.AddInt32(&commands, 1)
+			return nil
+		}}, commander.ALL, commander.ALL)
+	if err = ext.RegisterCommand(cmd); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if cmd.Extension != "ext" {
+		t.Error("Expected the command to belong to the extension, got:",
+			cmd.Extension)
+	}
+
+	send := func(channel string) (int32, int32, int32) {
+		atomic.StoreInt32(&global, 0)
+		atomic.StoreInt32(&server, 0)
+		atomic.StoreInt32(&commands, 0)
+		b.dispatchMessage(srv, irc.NewMessage(irc.PRIVMSG, "nick!u@h",
+			channel, ".extcmd"))
+		b.dispatcher.WaitForHandlers()
+		srv.dispatcher.WaitForHandlers()
+		return atomic.LoadInt32(&global), atomic.LoadInt32(&server),
+			atomic.LoadInt32(&commands)
+	}
+	check := func(channel string, enabled bool) {
+		var want int32
+		if enabled {
+			want = 1
+		}
+		if g, s, c := send(channel); g != want || s != want || c != want {
+			t.Errorf("Expected %v to get %d events, got: %d %d %d", channel,
+				want, g, s, c)
+		}
+	}
+
+	check("#chan", true)
+	b.DisableExtension("ext", serverID, "#chan")
+	check("#chan", false)
+	check("#other", true)
+
+	b.DisableExtension("ext", serverID, "")
+	b.EnableExtension("ext", serverID, "#CHAN")
+	check("#chan", true)
+	check("#other", false)
+
+	b.ResetExtension("ext", serverID, "")
+	b.DisableExtension("ext", "", "")
+	check("#chan", true)
+	check("#other", false)
+	b.ResetExtension("ext", "", "")
+	check("#other", true)
+
+	if names := b.GetExtensions(); len(names) != 1 || names[0] != "ext" {
+		t.Error("Expected the extension to be registered, got:", names)
+	}
+	if err = b.UnloadExtension("ext"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if err = b.UnloadExtension("ext"); err == nil {
+		t.Error("Expected an error about unknown extensions.")
+	}
+	check("#chan", false)
+	if b.UnregisterCommand("extcmd") {
+		t.Error("Expected the command to have been unregistered.")
+	}
+	if b.GetExtension("ext") != nil || len(b.GetExtensions()) != 0 {
+		t.Error("Expected the extension to be gone.")
+	}
+}
+
+func TestBot_ExtensionToggles(t *T) {
+	t.Parallel()
+	store, err := data.CreateStore(data.MemStoreProvider)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	storeProv := func(string) (*data.Store, error) {
+		return store, nil
+	}
+	conf := fakeConfig.Clone().GlobalContext().NoStore(false)
+	b, err := createBot(conf, nil, storeProv, false, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if err = b.EnableExtension("", serverID, ""); err != errExtensionName {
+		t.Error("Expected:", errExtensionName, "got:", err)
+	}
+	if err = b.DisableExtension("ext", "", ""); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if err = b.EnableExtension("ext", "", "#chan"); err != errExtensionChannel {
+		t.Error("Expected:", errExtensionChannel, "got:", err)
+	}
+	if err = b.ResetExtension("ext", "", "#chan"); err != errExtensionChannel {
+		t.Error("Expected:", errExtensionChannel, "got:", err)
+	}
+	b.EnableExtension("ext", serverID, "")
+	b.DisableExtension("ext", serverID, "#Chan")
+	b.DisableExtension("gone", serverID, "")
+	b.ResetExtension("gone", serverID, "")
+
+	b, err = createBot(conf, nil, storeProv, false, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !b.IsExtensionEnabled("ext", serverID, "") ||
+		!b.IsExtensionEnabled("ext", serverID, "#other") {
+		t.Error("Expected the server toggle to be kept in the store.")
+	}
+	if b.IsExtensionEnabled("ext", serverID, "#chan") {
+		t.Error("Expected the channel toggle to be kept in the store.")
+	}
+	if b.IsExtensionEnabled("ext", "other", "#chan") {
+		t.Error("Expected the global toggle to be kept in the store.")
+	}
+	if !b.IsExtensionEnabled("gone", serverID, "") {
+		t.Error("Expected the reset toggle to be removed from the store.")
+	}
+}
@@ -0,0 +1,457 @@
+package bot
+
+import (
+	"errors"
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errQueryTimeout occurs when the server doesn't finish answering a query
+	// in time.
+	errQueryTimeout = errors.New("bot: Timed out waiting for query replies.")
+	// errQueryAborted occurs when the connection to the server is lost while
+	// waiting on a query.
+	errQueryAborted = errors.New("bot: Disconnected while waiting for query.")
+	// errInvalidQuery occurs when a query has no command or terminators.
+	errInvalidQuery = errors.New("bot: Queries require a command and an end.")
+	// errNoSuchNick occurs when a queried nick does not exist.
+	errNoSuchNick = errors.New("bot: No such nick.")
+)
+
+// Query is a command sent to the server and a description of the replies
+// that answer it.
+type Query struct {
+	// Command is the raw line sent to the server, ie. "WHOIS fish".
+	Command string
+	// Replies are the numerics that make up the answer.
+	Replies []string
+	// Ends are the numerics that finish the answer, they are collected along
+	// with the replies.
+	Ends []string
+	// Key when set must match the argument at KeyIndex of a reply for it to
+	// be collected. This keeps the replies to different queries of the same
+	// kind apart. For numerics the first argument is the bot's own nick.
+	Key      string
+	KeyIndex int
+}
+
+// pendingQuery is a query that has been sent and is waiting on replies.
+type pendingQuery struct {
+	query   Query
+	label   string
+	batch   string
+	replies []*irc.Message
+	waiters int
+	err     error
+	done    chan struct{}
+}
+
+// queries keeps track of a server's pending queries and matches replies from
+// the server to them. When the server supports labeled-response the replies
+// are matched by label, otherwise replies go to the oldest query that
+// accepts them since servers answer commands in order.
+type queries struct {
+	server  *Server
+	pending []*pendingQuery
+	labels  int
+
+	protect sync.Mutex
+}
+
+// WhoisReply is the answer to a WHOIS query.
+type WhoisReply struct {
+	Nick       string
+	Username   string
+	Host       string
+	Realname   string
+	Server     string
+	ServerInfo string
+	Channels   []string
+	Account    string
+	Operator   bool
+	Secure     bool
+	Idle       time.Duration
+	SignOn     time.Time
+}
+
+// WhoReply is a single line of the answer to a WHO query.
+type WhoReply struct {
+	Channel  string
+	Username string
+	Host     string
+	Server   string
+	Nick     string
+	Flags    string
+	Hops     int
+	Realname string
+}
+
+// createQueries creates the query tracking for a server.
+func createQueries(s *Server) *queries {
+	return &queries{server: s}
+}
+
+// Query sends the query's command to the server and waits for the replies to
+// it. Identical queries that are waiting at the same time are only sent to
+// the server once and share the replies.
+func (s *ServerEndpoint) Query(q Query, timeout time.Duration) (
+	[]*irc.Message, error) {
+
+	return s.server.queries.query(q, timeout)
+}
+
+// query sends a query and waits for it to finish.
+func (q *queries) query(query Query, timeout time.Duration) (
+	[]*irc.Message, error) {
+
+	if len(query.Command) == 0 || len(query.Ends) == 0 {
+		return nil, errInvalidQuery
+	}
+
+	q.protect.Lock()
+	p := q.find(query)
+	if p != nil {
+		p.waiters++
+		q.protect.Unlock()
+	} else {
+		p = &pendingQuery{query: query, waiters: 1, done: make(chan struct{})}
+		line := query.Command
+		if q.server.HasCap("labeled-response") && q.server.HasCap("batch") {
+			q.labels++
+			p.label = strconv.Itoa(q.labels)
+			line = "@label=" + p.label + " " + line
+		}
+		q.pending = append(q.pending, p)
+		q.protect.Unlock()
+
+		if _, err := q.server.Write([]byte(line)); err != nil {
+			q.protect.Lock()
+			q.finish(p, err)
+			q.protect.Unlock()
+		}
+	}
+
+	select {
+	case <-p.done:
+		return p.replies, p.err
+	case <-time.After(timeout):
+	}
+
+	q.protect.Lock()
+	defer q.protect.Unlock()
+	select {
+	case <-p.done:
+		return p.replies, p.err
+	default:
+	}
+	p.waiters--
+	if p.waiters == 0 {
+		q.remove(p)
+	}
+	return nil, errQueryTimeout
+}
+
+// find finds a pending query identical to the given one. Not thread safe.
+func (q *queries) find(query Query) *pendingQuery {
+	for _, p := range q.pending {
+		if p.query.Command == query.Command && p.query.Key == query.Key &&
+			p.query.KeyIndex == query.KeyIndex {
+			return p
+		}
+	}
+	return nil
+}
+
+// handle gives a message from the server to the pending queries. It must be
+// called for every message in the order they arrive.
+func (q *queries) handle(msg *irc.Message) {
+	q.protect.Lock()
+	defer q.protect.Unlock()
+
+	if len(q.pending) == 0 {
+		return
+	}
+
+	if label, ok := msg.Tags["label"]; ok {
+		p := q.byLabel(label)
+		if p == nil {
+			return
+		}
+		switch {
+		case msg.Name == irc.BATCH && len(msg.Args) > 0 &&
+			strings.HasPrefix(msg.Args[0], "+"):
+			p.batch = msg.Args[0][1:]
+		case msg.Name == irc.ACK:
+			q.finish(p, nil)
+		default:
+			p.replies = append(p.replies, msg)
+			q.finish(p, nil)
+		}
+		return
+	}
+
+	if batch, ok := msg.Tags["batch"]; ok {
+		if p := q.byBatch(batch); p != nil {
+			p.replies = append(p.replies, msg)
+		}
+		return
+	}
+
+	if msg.Name == irc.BATCH && len(msg.Args) > 0 &&
+		strings.HasPrefix(msg.Args[0], "-") {
+		if p := q.byBatch(msg.Args[0][1:]); p != nil {
+			q.finish(p, nil)
+		}
+		return
+	}
+
+	for _, p := range q.pending {
+		if len(p.label) > 0 || !p.query.accepts(msg) {
+			continue
+		}
+		p.replies = append(p.replies, msg)
+		if inList(p.query.Ends, msg.Name) {
+			q.finish(p, nil)
+		}
+		break
+	}
+}
+
+// abort fails every pending query, used when the connection is lost.
+func (q *queries) abort() {
+	q.protect.Lock()
+	defer q.protect.Unlock()
+
+	for len(q.pending) > 0 {
+		q.finish(q.pending[0], errQueryAborted)
+	}
+}
+
+// byLabel finds the pending query with a label. Not thread safe.
+func (q *queries) byLabel(label string) *pendingQuery {
+	for _, p := range q.pending {
+		if p.label == label {
+			return p
+		}
+	}
+	return nil
+}
+
+// byBatch finds the pending query receiving a batch. Not thread safe.
+func (q *queries) byBatch(batch string) *pendingQuery {
+	for _, p := range q.pending {
+		if len(p.batch) > 0 && p.batch == batch {
+			return p
+		}
+	}
+	return nil
+}
+
+// finish completes a pending query and wakes it's waiters. Not thread safe.
+func (q *queries) finish(p *pendingQuery, err error) {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	p.err = err
+	q.remove(p)
+	close(p.done)
+}
+
+// remove removes a pending query. Not thread safe.
+func (q *queries) remove(p *pendingQuery) {
+	for i, other := range q.pending {
+		if other == p {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// accepts checks if a message is part of the answer to the query.
+func (q *Query) accepts(msg *irc.Message) bool {
+	if !inList(q.Replies, msg.Name) && !inList(q.Ends, msg.Name) {
+		return false
+	}
+	if len(q.Key) == 0 {
+		return true
+	}
+	return q.KeyIndex < len(msg.Args) &&
+		strings.EqualFold(msg.Args[q.KeyIndex], q.Key)
+}
+
+// inList checks if a string is in a list.
+func inList(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Whois queries the server for information about a nick.
+func (s *ServerEndpoint) Whois(nick string, timeout time.Duration) (
+	*WhoisReply, error) {
+
+	replies, err := s.Query(Query{
+		Command: "WHOIS " + nick,
+		Replies: []string{
+			irc.RPL_WHOISUSER, irc.RPL_WHOISSERVER, irc.RPL_WHOISOPERATOR,
+			irc.RPL_WHOISIDLE, irc.RPL_WHOISCHANNELS, irc.RPL_WHOISACCOUNT,
+			irc.RPL_WHOISSECURE, irc.ERR_NOSUCHNICK,
+		},
+		Ends:     []string{irc.RPL_ENDOFWHOIS},
+		Key:      nick,
+		KeyIndex: 1,
+	}, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	whois := &WhoisReply{Nick: nick}
+	for _, r := range replies {
+		args := r.Args
+		switch r.Name {
+		case irc.ERR_NOSUCHNICK:
+			return nil, errNoSuchNick
+		case irc.RPL_WHOISUSER:
+			if len(args) >= 6 {
+				whois.Nick, whois.Username, whois.Host = args[1], args[2],
+					args[3]
+				whois.Realname = args[5]
+			}
+		case irc.RPL_WHOISSERVER:
+			if len(args) >= 4 {
+				whois.Server, whois.ServerInfo = args[2], args[3]
+			}
+		case irc.RPL_WHOISOPERATOR:
+			whois.Operator = true
+		case irc.RPL_WHOISIDLE:
+			if len(args) >= 3 {
+				idle, _ := strconv.Atoi(args[2])
+				whois.Idle = time.Duration(idle) * time.Second
+			}
+			if len(args) >= 5 {
+				if signon, err := strconv.ParseInt(args[3], 10, 64); err == nil {
+					whois.SignOn = time.Unix(signon, 0)
+				}
+			}
+		case irc.RPL_WHOISCHANNELS:
+			if len(args) >= 3 {
+				whois.Channels = append(whois.Channels,
+					strings.Fields(args[len(args)-1])...)
+			}
+		case irc.RPL_WHOISACCOUNT:
+			if len(args) >= 3 {
+				whois.Account = args[2]
+			}
+		case irc.RPL_WHOISSECURE:
+			whois.Secure = true
+		}
+	}
+
+	return whois, nil
+}
+
+// WhoisInfo returns what the state knows about a nick from it's last WHOIS,
+// querying the server with Whois when it knows nothing or it's older than
+// the whois lifetime. The result must not be modified.
+func (s *ServerEndpoint) WhoisInfo(nick string, timeout time.Duration) (
+	*data.WhoisInfo, error) {
+
+	var info *data.WhoisInfo
+	s.UsingState(func(state *data.State) {
+		info = state.GetWhois(nick)
+	})
+	if info != nil {
+		return info, nil
+	}
+
+	whois, err := s.Whois(nick, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.WhoisInfo{
+		Server:     whois.Server,
+		ServerInfo: whois.ServerInfo,
+		Operator:   whois.Operator,
+		Secure:     whois.Secure,
+		Account:    whois.Account,
+		Channels:   whois.Channels,
+		Idle:       whois.Idle,
+		SignOn:     whois.SignOn,
+		Updated:    time.Now(),
+	}, nil
+}
+
+// Who queries the server for the users matching a mask or on a channel.
+func (s *ServerEndpoint) Who(mask string, timeout time.Duration) (
+	[]WhoReply, error) {
+
+	query := Query{
+		Command: "WHO " + mask,
+		Replies: []string{irc.RPL_WHOREPLY},
+		Ends:    []string{irc.RPL_ENDOFWHO},
+	}
+	// Replies to masks that aren't channels don't contain the mask.
+	if s.server.caps.IsChannel(mask) {
+		query.Key, query.KeyIndex = mask, 1
+	}
+
+	replies, err := s.Query(query, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var who []WhoReply
+	for _, r := range replies {
+		args := r.Args
+		if r.Name != irc.RPL_WHOREPLY || len(args) < 8 {
+			continue
+		}
+		reply := WhoReply{
+			Channel: args[1], Username: args[2], Host: args[3],
+			Server: args[4], Nick: args[5], Flags: args[6],
+		}
+		hopsReal := strings.SplitN(args[7], " ", 2)
+		reply.Hops, _ = strconv.Atoi(hopsReal[0])
+		if len(hopsReal) == 2 {
+			reply.Realname = hopsReal[1]
+		}
+		who = append(who, reply)
+	}
+
+	return who, nil
+}
+
+// Ison queries the server for which of the nicks are online.
+func (s *ServerEndpoint) Ison(timeout time.Duration, nicks ...string) (
+	[]string, error) {
+
+	if len(nicks) == 0 {
+		return nil, nil
+	}
+	replies, err := s.Query(Query{
+		Command: "ISON " + strings.Join(nicks, " "),
+		Ends:    []string{irc.RPL_ISON},
+	}, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var online []string
+	for _, r := range replies {
+		if len(r.Args) >= 2 {
+			online = append(online, strings.Fields(r.Args[1])...)
+		}
+	}
+	return online, nil
+}
@@ -0,0 +1,307 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/ircdtest"
+	"github.com/aarondl/ultimateq/parse"
+	"strings"
+	. "testing"
+	"time"
+)
+
+// queryBot starts a bot connected to a fake irc server offering caps.
+func queryBot(t *T, caps ...string) (*Bot, *ircdtest.Server, <-chan error) {
+	ircd := ircdtest.CreateServer(serverID)
+	ircd.Caps("batch", "labeled-response")
+
+	conf := fakeConfig.Clone().GlobalContext().FloodTimeout(100).
+		Caps(caps...)
+	b, err := createBot(conf, ircd.Dial, nil, true, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	end := b.Start()
+
+	if err = ircd.WaitForRegistered(1, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	ircd.Join("fish!fishy@fish.net", "#chan")
+	return b, ircd, end
+}
+
+func stopQueryBot(b *Bot, ircd *ircdtest.Server, end <-chan error) {
+	b.Stop()
+	for _ = range end {
+	}
+	ircd.Close()
+}
+
+// msg parses a line for tests.
+func msg(t *T, line string) *irc.Message {
+	m, err := parse.Parse([]byte(line))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return m
+}
+
+func TestQuery_Handle(t *T) {
+	t.Parallel()
+	q := &queries{}
+	whois := &pendingQuery{
+		query: Query{
+			Replies:  []string{irc.RPL_WHOISUSER},
+			Ends:     []string{irc.RPL_ENDOFWHOIS},
+			Key:      "fish",
+			KeyIndex: 1,
+		},
+		done: make(chan struct{}),
+	}
+	ison := &pendingQuery{
+		query: Query{Ends: []string{irc.RPL_ISON}},
+		done:  make(chan struct{}),
+	}
+	q.pending = []*pendingQuery{whois, ison}
+
+	q.handle(msg(t, ":srv 311 me shark sharky shark.net * :shark"))
+	q.handle(msg(t, ":srv 311 me FISH fishy fish.net * :fish"))
+	q.handle(msg(t, ":srv 303 me :fish"))
+	q.handle(msg(t, ":srv 318 me fish :End of WHOIS list"))
+
+	select {
+	case <-whois.done:
+	default:
+		t.Fatal("Expected the whois to be finished.")
+	}
+	if len(whois.replies) != 2 || whois.replies[0].Args[1] != "FISH" {
+		t.Error("Expected only the replies about fish, got:", whois.replies)
+	}
+	if len(ison.replies) != 1 || len(q.pending) != 0 {
+		t.Error("Expected the ison to be finished, got:", ison.replies)
+	}
+}
+
+func TestQuery_HandleLabeled(t *T) {
+	t.Parallel()
+	q := &queries{}
+	batched := &pendingQuery{
+		query: Query{Ends: []string{irc.RPL_ENDOFWHOIS}},
+		label: "1",
+		done:  make(chan struct{}),
+	}
+	single := &pendingQuery{
+		query: Query{Ends: []string{irc.RPL_ISON}},
+		label: "2",
+		done:  make(chan struct{}),
+	}
+	acked := &pendingQuery{
+		query: Query{Ends: []string{irc.RPL_ISON}},
+		label: "3",
+		done:  make(chan struct{}),
+	}
+	q.pending = []*pendingQuery{batched, single, acked}
+
+	q.handle(msg(t, "@label=1 :srv BATCH +b labeled-response"))
+	q.handle(msg(t, "@label=2 :srv 303 me :"))
+	q.handle(msg(t, ":srv 318 me fish :Not labeled"))
+	q.handle(msg(t, "@batch=b :srv 311 me fish fishy fish.net * :fish"))
+	q.handle(msg(t, "@batch=b :srv 318 me fish :End of WHOIS list"))
+	q.handle(msg(t, "@label=3 :srv ACK"))
+
+	if len(q.pending) != 1 || q.pending[0] != batched {
+		t.Fatal("Expected only the batch to be pending, got:", q.pending)
+	}
+	if len(single.replies) != 1 || len(acked.replies) != 0 {
+		t.Error("Expected the single and ack replies, got:", single.replies,
+			acked.replies)
+	}
+
+	q.handle(msg(t, ":srv BATCH -b"))
+	if len(q.pending) != 0 || len(batched.replies) != 2 {
+		t.Error("Expected the batch to finish, got:", batched.replies)
+	}
+}
+
+func TestQuery_Invalid(t *T) {
+	t.Parallel()
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	ep := b.servers[serverID].endpoint
+
+	if _, err := ep.Query(Query{Command: "ISON"}, time.Second); err !=
+		errInvalidQuery {
+		t.Error("Expected error:", errInvalidQuery, "got:", err)
+	}
+	_, err := ep.Query(Query{Command: "ISON", Ends: []string{irc.RPL_ISON}},
+		time.Second)
+	if err != errNotConnected {
+		t.Error("Expected error:", errNotConnected, "got:", err)
+	}
+}
+
+func TestQuery_Server(t *T) {
+	t.Parallel()
+	b, ircd, end := queryBot(t)
+	defer stopQueryBot(b, ircd, end)
+	ep := b.servers[serverID].endpoint
+	timeout := 2 * time.Second
+
+	whois, err := ep.Whois("FISH", timeout)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if whois.Nick != "fish" || whois.Username != "fishy" ||
+		whois.Host != "fish.net" || whois.Server != serverID ||
+		len(whois.Channels) != 1 || whois.Channels[0] != "#chan" {
+		t.Errorf("Expected fish's whois, got: %#v", whois)
+	}
+
+	if _, err = ep.Whois("nobody1", timeout); err != errNoSuchNick {
+		t.Error("Expected error:", errNoSuchNick, "got:", err)
+	}
+
+	who, err := ep.Who("#chan", timeout)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(who) != 1 || who[0].Nick != "fish" || who[0].Host != "fish.net" ||
+		who[0].Realname != "fish" {
+		t.Errorf("Expected fish to be in the who, got: %#v", who)
+	}
+
+	online, err := ep.Ison(timeout, "fish", "shark", "nobody")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(online) != 2 || online[0] != "fish" || online[1] != "nobody" {
+		t.Error("Expected fish and the bot to be online, got:", online)
+	}
+
+	for _, line := range ircd.Received() {
+		if strings.HasPrefix(line, "CAP") || strings.HasPrefix(line, "@") {
+			t.Error("Expected no caps to be used, got:", line)
+		}
+	}
+}
+
+func TestQuery_WhoisInfo(t *T) {
+	t.Parallel()
+	b, ircd, end := queryBot(t)
+	defer stopQueryBot(b, ircd, end)
+	ep := b.servers[serverID].endpoint
+	timeout := 2 * time.Second
+
+	info, err := ep.WhoisInfo("fish", timeout)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if info.Server != serverID || len(info.Channels) != 1 ||
+		info.Channels[0] != "#chan" {
+		t.Errorf("Expected fish's whois, got: %#v", info)
+	}
+
+	cached, err := ep.WhoisInfo("FISH", timeout)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if cached.Server != serverID {
+		t.Errorf("Expected fish's whois, got: %#v", cached)
+	}
+
+	sent := 0
+	for _, line := range ircd.Received() {
+		if strings.HasPrefix(line, "WHOIS") {
+			sent++
+		}
+	}
+	if sent != 1 {
+		t.Error("Expected the second whois to come from the state, sent:",
+			sent)
+	}
+
+	if _, err = ep.WhoisInfo("nobody1", timeout); err != errNoSuchNick {
+		t.Error("Expected error:", errNoSuchNick, "got:", err)
+	}
+}
+
+func TestQuery_Coalesce(t *T) {
+	t.Parallel()
+	b, ircd, end := queryBot(t)
+	defer stopQueryBot(b, ircd, end)
+	ep := b.servers[serverID].endpoint
+
+	const n = 5
+	results := make(chan *WhoisReply, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			whois, _ := ep.Whois("fish", 2*time.Second)
+			results <- whois
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		if whois := <-results; whois == nil || whois.Username != "fishy" {
+			t.Fatalf("Expected fish's whois, got: %#v", whois)
+		}
+	}
+
+	sent := 0
+	for _, line := range ircd.Received() {
+		if strings.HasPrefix(line, "WHOIS") {
+			sent++
+		}
+	}
+	if sent < 1 || sent >= n {
+		t.Error("Expected concurrent whois to be coalesced, got:", sent)
+	}
+}
+
+func TestQuery_Labeled(t *T) {
+	t.Parallel()
+	b, ircd, end := queryBot(t, "batch", "labeled-response", "sasl")
+	defer stopQueryBot(b, ircd, end)
+	ep := b.servers[serverID].endpoint
+	timeout := 2 * time.Second
+
+	if !ep.HasCap("labeled-response") || !ep.HasCap("batch") ||
+		ep.HasCap("sasl") {
+		t.Fatal("Expected only the offered caps to be enabled.")
+	}
+	if _, err := ircd.WaitFor(`^CAP REQ :batch labeled-response$`,
+		timeout); err != nil {
+		t.Error(err)
+	}
+
+	whois, err := ep.Whois("fish", timeout)
+	if err != nil || whois.Username != "fishy" {
+		t.Error("Expected fish's whois, got:", whois, err)
+	}
+	online, err := ep.Ison(timeout, "fish")
+	if err != nil || len(online) != 1 {
+		t.Error("Expected fish to be online, got:", online, err)
+	}
+
+	if _, err = ircd.WaitFor(`^@label=\S+ WHOIS fish$`, timeout); err != nil {
+		t.Error("Expected the whois to be labeled:", err)
+	}
+}
+
+func TestQuery_Timeout(t *T) {
+	t.Parallel()
+	b, ircd, end := queryBot(t)
+	defer stopQueryBot(b, ircd, end)
+	ep := b.servers[serverID].endpoint
+
+	_, err := ep.Query(Query{
+		Command: "PING :x",
+		Ends:    []string{irc.RPL_ENDOFWHOIS},
+	}, 50*time.Millisecond)
+	if err != errQueryTimeout {
+		t.Error("Expected error:", errQueryTimeout, "got:", err)
+	}
+	q := b.servers[serverID].queries
+	q.protect.Lock()
+	if len(q.pending) != 0 {
+		t.Error("Expected the timed out query to be removed.")
+	}
+	q.protect.Unlock()
+}
@@ -0,0 +1,193 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/irc"
+	"strings"
+)
+
+// relayEvents are the irc events the relay handler is registered for.
+var relayEvents = []string{irc.PRIVMSG, irc.NOTICE, irc.JOIN, irc.PART,
+	irc.NICK, irc.KICK}
+
+// relayHandler repeats what happens in channels linked by the configured
+// relays in the other channels of the relay. It's registered with the bot's
+// dispatcher and writes to the endpoints of the other servers. Relays are
+// read from the config for every event so rehashing changes them.
+type relayHandler struct {
+	bot *Bot
+}
+
+// relayEvent is an event in a channel that should be repeated.
+type relayEvent struct {
+	kind    string
+	server  string
+	channel string
+	nick    string
+	message string
+	target  string
+	newnick string
+}
+
+// HandleRaw implements the dispatch.EventHandler interface so the relay can
+// see the events in linked channels.
+func (r *relayHandler) HandleRaw(msg *irc.Message, endpoint irc.Endpoint) {
+	server := endpoint.GetKey()
+	nick := msg.Nick()
+	if len(msg.Args) == 0 || len(nick) == 0 ||
+		r.bot.isIdentity(server, nick) {
+		return
+	}
+
+	ev := relayEvent{server: server, nick: nick}
+	var channels []string
+	switch msg.Name {
+	case irc.PRIVMSG, irc.NOTICE:
+		if len(msg.Args) < 2 {
+			return
+		}
+		ev.kind, ev.message = config.RelayPrivmsg, msg.Args[1]
+		if msg.Name == irc.NOTICE {
+			ev.kind = config.RelayNotice
+		}
+		if msg.IsCTCP() {
+			tag, data := msg.UnpackCTCP()
+			if msg.Name != irc.PRIVMSG || tag != "ACTION" {
+				return
+			}
+			ev.kind, ev.message = config.RelayAction, data
+		}
+		channels = msg.Args[:1]
+	case irc.JOIN:
+		ev.kind = config.RelayJoin
+		channels = msg.SplitArgs(0)
+	case irc.PART:
+		ev.kind = config.RelayPart
+		if len(msg.Args) > 1 {
+			ev.message = msg.Args[1]
+		}
+		channels = msg.SplitArgs(0)
+	case irc.KICK:
+		if len(msg.Args) < 2 {
+			return
+		}
+		ev.kind, ev.target = config.RelayKick, msg.Args[1]
+		if len(msg.Args) > 2 {
+			ev.message = msg.Args[2]
+		}
+		channels = msg.Args[:1]
+	case irc.NICK:
+		ev.kind, ev.newnick = config.RelayNick, msg.Args[0]
+		channels = msg.Channels
+	default:
+		return
+	}
+
+	for _, channel := range channels {
+		ev.channel = channel
+		r.relay(ev)
+	}
+}
+
+// relay repeats an event in the other channels of every relay that links the
+// channel it happened in.
+func (r *relayHandler) relay(ev relayEvent) {
+	type send struct {
+		link config.RelayLink
+		text string
+	}
+	var sends []send
+
+	r.bot.protectConfig.RLock()
+	for _, relay := range r.bot.conf.Relays {
+		if !relay.HasEvent(ev.kind) {
+			continue
+		}
+		links := r.bot.conf.GetRelayLinks(relay.GetName())
+		if !hasRelayLink(links, ev.server, ev.channel) {
+			continue
+		}
+		text := formatRelay(relay, ev)
+		for _, link := range links {
+			if link.Server == ev.server &&
+				strings.EqualFold(link.Channel, ev.channel) {
+				continue
+			}
+			sends = append(sends, send{link, text})
+		}
+	}
+	r.bot.protectConfig.RUnlock()
+
+	for _, s := range sends {
+		r.bot.protectServers.RLock()
+		srv, ok := r.bot.servers[s.link.Server]
+		r.bot.protectServers.RUnlock()
+		if !ok {
+			continue
+		}
+
+		if ev.kind == config.RelayNotice {
+			srv.endpoint.Notice(s.link.Channel, s.text)
+		} else {
+			srv.endpoint.Privmsg(s.link.Channel, s.text)
+		}
+	}
+}
+
+// hasRelayLink checks if a server's channel is one of the links.
+func hasRelayLink(links []config.RelayLink, server, channel string) bool {
+	for _, link := range links {
+		if link.Server == server && strings.EqualFold(link.Channel, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatRelay creates the text that's sent for an event using the relay's
+// format for it.
+func formatRelay(relay *config.Relay, ev relayEvent) string {
+	return strings.NewReplacer(
+		"{tag}", relay.GetTag(ev.server),
+		"{server}", ev.server,
+		"{nick}", ev.nick,
+		"{channel}", ev.channel,
+		"{message}", ev.message,
+		"{target}", ev.target,
+		"{newnick}", ev.newnick,
+	).Replace(relay.GetFormat(ev.kind))
+}
+
+// isIdentity checks if a nick belongs to one of the bot's identities on the
+// network of a server. Anything they say is ignored by the relay, otherwise
+// what the relay says would be relayed again.
+func (b *Bot) isIdentity(server, nick string) bool {
+	b.protectServers.RLock()
+	defer b.protectServers.RUnlock()
+	b.protectConfig.RLock()
+	defer b.protectConfig.RUnlock()
+
+	srv, ok := b.servers[server]
+	if !ok {
+		return false
+	}
+	network := srv.conf.GetNetwork()
+	for _, other := range b.servers {
+		if other.conf.GetNetwork() == network &&
+			strings.EqualFold(other.currentNick(), nick) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentNick returns the nick the server knows the bot by, or the configured
+// nick when there's no state. The config must be locked.
+func (s *Server) currentNick() string {
+	s.protectState.RLock()
+	defer s.protectState.RUnlock()
+	if s.state != nil && s.state.Self.User != nil {
+		return s.state.Self.Nick()
+	}
+	return s.conf.GetNick()
+}
@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/ircdtest"
+	"net"
+	"regexp"
+	"strings"
+	. "testing"
+	"time"
+)
+
+func TestRelay(t *T) {
+	t.Parallel()
+	timeout := 2 * time.Second
+	ircd1 := ircdtest.CreateServer(serverID)
+	defer ircd1.Close()
+	ircd2 := ircdtest.CreateServer("other")
+	defer ircd2.Close()
+
+	conf := fakeConfig.Clone().GlobalContext().FloodTimeout(100).
+		Server("other").
+		Relay("team", serverID+"/#team", "other/#Team").
+		RelayEvents(config.RelayJoin, config.RelayNick, config.RelayKick).
+		RelayTag(serverID, "one")
+	if !conf.IsValid() {
+		t.Fatal("Expected the config to be valid:", conf.Errors)
+	}
+	connProv := func(addr string) (net.Conn, error) {
+		if strings.HasPrefix(addr, serverID) {
+			return ircd1.Dial(addr)
+		}
+		return ircd2.Dial(addr)
+	}
+
+	b, err := createBot(conf, connProv, nil, true, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	end := b.Start()
+	defer func() {
+		b.Stop()
+		for _ = range end {
+		}
+	}()
+
+	for _, ircd := range []*ircdtest.Server{ircd1, ircd2} {
+		if err = ircd.WaitForRegistered(1, timeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.servers[serverID].endpoint.Join("#team")
+	b.servers["other"].endpoint.Join("#Team")
+	for _, ircd := range []*ircdtest.Server{ircd1, ircd2} {
+		if err = ircd.WaitForJoin("nobody", "#team", timeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := func(ircd *ircdtest.Server, line string) {
+		pattern := "^" + regexp.QuoteMeta(line) + "$"
+		if _, err := ircd.WaitFor(pattern, timeout); err != nil {
+			t.Errorf("Expected %q to be relayed: %v", line, err)
+		}
+	}
+
+	ircd1.Join("fish!fishy@fish.net", "#team")
+	expect(ircd2, "PRIVMSG #Team :* one/fish has joined #team")
+	ircd1.Privmsg("fish", "#team", "hello")
+	expect(ircd2, "PRIVMSG #Team :<one/fish> hello")
+	ircd1.Privmsg("fish", "#team", "\x01ACTION waves\x01")
+	expect(ircd2, "PRIVMSG #Team :* one/fish waves")
+	ircd1.Notice("fish", "#team", "psst")
+	expect(ircd2, "NOTICE #Team :-one/fish- psst")
+
+	ircd1.Join("eel!eel@eel.net", "#team")
+	ircd1.Rename("fish", "trout")
+	expect(ircd2, "PRIVMSG #Team :* one/fish is now known as one/trout")
+	ircd1.Kick("trout", "#team", "eel", "slippery")
+	expect(ircd2,
+		"PRIVMSG #Team :* one/eel was kicked from #team by one/trout (slippery)")
+
+	ircd2.Join("shark!shark@shark.net", "#team")
+	ircd2.Privmsg("shark", "#team", "hi")
+	expect(ircd1, "PRIVMSG #team :<other/shark> hi")
+
+	for _, line := range ircd1.Received() {
+		if strings.Contains(line, "<other/nobody>") ||
+			strings.Contains(line, "one/") {
+			t.Error("Expected the relay not to repeat itself, got:", line)
+		}
+	}
+}
+
+func TestRelay_Identity(t *T) {
+	t.Parallel()
+	conf := fakeConfig.Clone().GlobalContext().
+		ServerContext(serverID).Identity("relay").Nick("relay").
+		Server("other")
+	b, err := createBot(conf, nil, nil, false, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if !b.isIdentity(serverID, "RELAY") || !b.isIdentity("relay", "nobody") {
+		t.Error("Expected the identities on the network to be recognized.")
+	}
+	if b.isIdentity("other", "relay") || b.isIdentity(serverID, "fish") ||
+		b.isIdentity("nope", "nobody") {
+		t.Error("Expected only identities on the network to be recognized.")
+	}
+}
+
+func TestRelay_Format(t *T) {
+	t.Parallel()
+	conf := config.CreateConfig().Relay("r", "a/#a", "b/#b").
+		RelayFormat(config.RelayPart, "{server} {tag} {nick} {channel} "+
+			"{message} {target} {newnick}")
+	relay := conf.GetRelay("r")
+
+	ev := relayEvent{config.RelayPart, "a", "#a", "fish", "bye", "eel", "x"}
+	if s := formatRelay(relay, ev); s != "a a fish #a bye eel x" {
+		t.Error("Expected the placeholders to be replaced, got:", s)
+	}
+}
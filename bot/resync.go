@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/data"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// fmtResyncDrift is logged when a resync finds the state of a channel
+	// was wrong and corrects it.
+	fmtResyncDrift = "bot: Resynced %v on %v (joined: %v, parted: %v, " +
+		"modes: %v)\n"
+)
+
+// setResyncInterval sets how many seconds apart the channels of the server
+// are resynced, 0 never resyncs them. When it changes the first channel is due
+// an interval from now.
+func (s *Server) setResyncInterval(seconds float64) {
+	s.protectState.Lock()
+	defer s.protectState.Unlock()
+	interval := time.Duration(seconds*1000.0) * time.Millisecond
+	if interval == s.resyncInterval && !s.resyncAt.IsZero() {
+		return
+	}
+	s.resyncInterval = interval
+	s.resyncQueue = nil
+	s.resyncAt = time.Now().Add(s.resyncInterval)
+}
+
+// resyncDue returns the next channel due to be resynced, or empty string if
+// none are. The channels are spread evenly across the interval so the server
+// isn't asked about all of them at once.
+func (s *Server) resyncDue(now time.Time) (channel string) {
+	s.protectState.Lock()
+	defer s.protectState.Unlock()
+
+	if s.resyncInterval <= 0 || s.state == nil || now.Before(s.resyncAt) {
+		return ""
+	}
+	if len(s.resyncQueue) == 0 {
+		s.resyncQueue = s.state.GetChannels()
+		if len(s.resyncQueue) == 0 {
+			s.resyncAt = now.Add(s.resyncInterval)
+			return ""
+		}
+		sort.Strings(s.resyncQueue)
+		s.resyncStep = s.resyncInterval / time.Duration(len(s.resyncQueue))
+	}
+
+	channel, s.resyncQueue = s.resyncQueue[0], s.resyncQueue[1:]
+	s.resyncAt = now.Add(s.resyncStep)
+	return channel
+}
+
+// resync starts resyncs of channels, or of every channel the state has if none
+// are given, and asks the server for what they need. The channels being
+// resynced are returned sorted, the ones the state doesn't have are left out.
+func (s *Server) resync(channels ...string) (resyncing []string) {
+	s.protectState.Lock()
+	if s.state != nil {
+		if len(channels) == 0 {
+			channels = s.state.GetChannels()
+		}
+		for _, channel := range channels {
+			if s.state.Resync(channel) {
+				resyncing = append(resyncing,
+					s.state.GetChannel(channel).Name())
+			}
+		}
+	}
+	s.protectState.Unlock()
+	sort.Strings(resyncing)
+
+	whox := len(s.caps.Extra("WHOX")) > 0
+	for _, channel := range resyncing {
+		for _, query := range data.ResyncQueries(channel, whox) {
+			s.Write([]byte(query))
+		}
+	}
+	return resyncing
+}
+
+// logResyncs logs the channels that resyncs found had drifted.
+func logResyncs(srv *Server, events []data.StateEvent) {
+	for _, ev := range events {
+		if resynced, ok := ev.(data.ChannelResynced); ok && resynced.Drifted() {
+			log.Printf(fmtResyncDrift, resynced.Channel, srv.name,
+				resynced.Joined, resynced.Parted, resynced.Modes)
+		}
+	}
+}
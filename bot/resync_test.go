@@ -0,0 +1,80 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	"reflect"
+	. "testing"
+	"time"
+)
+
+func TestServer_ResyncDue(t *T) {
+	srv := &Server{caps: irc.CreateProtoCaps()}
+	if ch := srv.resyncDue(time.Now()); len(ch) > 0 {
+		t.Error("Expected no resyncs without an interval:", ch)
+	}
+
+	var err error
+	if srv.state, err = data.CreateState(srv.caps); err != nil {
+		t.Fatal("Could not create state:", err)
+	}
+	srv.state.Update(&irc.Message{Name: irc.RPL_WELCOME, Sender: serverID,
+		Args: []string{"Welcome", bothost}})
+	for _, ch := range []string{"#chan3", "#chan1", "#chan2", "#chan4"} {
+		srv.state.Update(&irc.Message{Name: irc.JOIN, Sender: bothost,
+			Args: []string{ch}})
+	}
+
+	srv.setResyncInterval(60)
+	start := time.Now()
+	if ch := srv.resyncDue(start); len(ch) > 0 {
+		t.Error("Expected no resync to be due yet:", ch)
+	}
+
+	// The channels are spread 15 seconds apart over the minute.
+	now := start.Add(time.Minute)
+	for i, exp := range []string{"#chan1", "#chan2", "#chan3", "#chan4"} {
+		if ch := srv.resyncDue(now); ch != exp {
+			t.Errorf("%d) Expected %v to be due, got: %q", i, exp, ch)
+		}
+		if ch := srv.resyncDue(now.Add(14 * time.Second)); len(ch) > 0 {
+			t.Errorf("%d) Expected no resync to be due yet: %v", i, ch)
+		}
+		now = now.Add(15 * time.Second)
+	}
+	if ch := srv.resyncDue(now); ch != "#chan1" {
+		t.Error("Expected the next interval to start over, got:", ch)
+	}
+
+	srv.setResyncInterval(60)
+	if ch := srv.resyncDue(now.Add(15 * time.Second)); ch != "#chan2" {
+		t.Error("Expected an unchanged interval to keep its place, got:", ch)
+	}
+}
+
+func TestServer_Resync(t *T) {
+	srv := &Server{caps: irc.CreateProtoCaps()}
+	if resyncing := srv.resync(); resyncing != nil {
+		t.Error("Expected nothing to resync without a state:", resyncing)
+	}
+
+	var err error
+	if srv.state, err = data.CreateState(srv.caps); err != nil {
+		t.Fatal("Could not create state:", err)
+	}
+	srv.state.Update(&irc.Message{Name: irc.RPL_WELCOME, Sender: serverID,
+		Args: []string{"Welcome", bothost}})
+	for _, ch := range []string{"#Chan2", "#chan1"} {
+		srv.state.Update(&irc.Message{Name: irc.JOIN, Sender: bothost,
+			Args: []string{ch}})
+	}
+
+	resyncing := srv.resync()
+	if exp := []string{"#Chan2", "#chan1"}; !reflect.DeepEqual(resyncing, exp) {
+		t.Errorf("Expected %v to be resyncing, got: %v", exp, resyncing)
+	}
+	resyncing = srv.resync("#chan2", "#nochan")
+	if exp := []string{"#Chan2"}; !reflect.DeepEqual(resyncing, exp) {
+		t.Errorf("Expected %v to be resyncing, got: %v", exp, resyncing)
+	}
+}
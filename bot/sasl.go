@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"encoding/base64"
+	"github.com/aarondl/ultimateq/irc"
+	"log"
+)
+
+const (
+	// saslChunk is the most base64 that can be sent in one AUTHENTICATE.
+	saslChunk = 400
+	// errFmtSaslFailed occurs when the server refuses the SASL credentials,
+	// registration carries on without being logged in.
+	errFmtSaslFailed = "bot: %v failed SASL authentication (%v)\n"
+)
+
+// saslCredentials are the account and password used for SASL PLAIN.
+type saslCredentials struct {
+	user string
+	pass string
+}
+
+// setSasl sets the credentials to authenticate with the next time capability
+// negotiation starts. The sasl capability is requested if user is not empty.
+func (s *Server) setSasl(user, pass string) {
+	s.protectCaps.Lock()
+	defer s.protectCaps.Unlock()
+	s.sasl = saslCredentials{user, pass}
+}
+
+// handleSasl handles the server's side of SASL authentication. It's started
+// by handleCap once the server has acknowledged the sasl capability, and
+// capability negotiation is finished once the server gives a result.
+func (s *Server) handleSasl(msg *irc.Message) {
+	var writes []string
+	s.protectCaps.Lock()
+	switch msg.Name {
+	case irc.AUTHENTICATE:
+		if !s.capNeg.authenticating || len(msg.Args) == 0 ||
+			msg.Args[0] != "+" {
+			break
+		}
+		writes = saslPlain(s.sasl.user, s.sasl.pass)
+	case irc.RPL_LOGGEDIN:
+		if len(msg.Args) >= 3 {
+			s.capNeg.account = msg.Args[2]
+		}
+	case irc.RPL_LOGGEDOUT:
+		s.capNeg.account = ""
+	case irc.RPL_SASLSUCCESS, irc.ERR_SASLFAIL, irc.ERR_SASLTOOLONG,
+		irc.ERR_SASLABORTED, irc.ERR_SASLALREADY, irc.ERR_NICKLOCKED:
+
+		if !s.capNeg.authenticating {
+			break
+		}
+		s.capNeg.authenticating = false
+		if msg.Name != irc.RPL_SASLSUCCESS {
+			log.Printf(errFmtSaslFailed, s.name, msg.Name)
+		}
+		if s.capNeg.negotiating {
+			s.capNeg.negotiating = false
+			writes = append(writes, "CAP END")
+		}
+	}
+	s.protectCaps.Unlock()
+
+	for _, line := range writes {
+		s.Write([]byte(line))
+	}
+}
+
+// saslPlain creates the AUTHENTICATE lines that carry the PLAIN credentials.
+// They're split into chunks and an empty chunk ends a message that fits
+// exactly.
+func saslPlain(user, pass string) (lines []string) {
+	payload := base64.StdEncoding.EncodeToString(
+		[]byte(user + "\x00" + user + "\x00" + pass))
+
+	for len(payload) >= saslChunk {
+		lines = append(lines, irc.AUTHENTICATE+" "+payload[:saslChunk])
+		payload = payload[saslChunk:]
+	}
+	if len(payload) == 0 {
+		payload = "+"
+	}
+	return append(lines, irc.AUTHENTICATE+" "+payload)
+}
+
+// GetAccount returns the account the server says the bot is logged in to, or
+// an empty string if it's not logged in.
+func (s *Server) GetAccount() string {
+	s.protectCaps.RLock()
+	defer s.protectCaps.RUnlock()
+	return s.capNeg.account
+}
+
+// GetAccount returns the account the server says the bot is logged in to, or
+// an empty string if it's not logged in.
+func (s *ServerEndpoint) GetAccount() string {
+	return s.server.GetAccount()
+}
@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"encoding/base64"
+	"github.com/aarondl/ultimateq/irc"
+	"strings"
+	. "testing"
+)
+
+func TestSasl_Plain(t *T) {
+	t.Parallel()
+	lines := saslPlain("user", "pass")
+	if len(lines) != 1 || lines[0] != "AUTHENTICATE dXNlcgB1c2VyAHBhc3M=" {
+		t.Error("Expected one line with the credentials, got:", lines)
+	}
+
+	// 3 bytes of credentials make 4 bytes of base64, so 300 make exactly one
+	// full chunk which has to be followed by an empty one.
+	lines = saslPlain("u", strings.Repeat("p", 300-4))
+	if len(lines) != 2 || len(lines[0]) != len(irc.AUTHENTICATE)+1+saslChunk ||
+		lines[1] != "AUTHENTICATE +" {
+		t.Error("Expected a full chunk and an empty one, got:", lines)
+	}
+
+	lines = saslPlain("u", strings.Repeat("p", 400))
+	payload := ""
+	for _, line := range lines {
+		payload += strings.TrimPrefix(line, irc.AUTHENTICATE+" ")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(payload)
+	if len(lines) != 2 || err != nil ||
+		string(decoded) != "u\x00u\x00"+strings.Repeat("p", 400) {
+		t.Error("Expected the chunks to make up the credentials, got:", lines)
+	}
+}
+
+func TestSasl_Handle(t *T) {
+	t.Parallel()
+	conf := fakeConfig.Clone().GlobalContext().Caps("batch").
+		Sasl("account", "secret")
+	b, _ := createBot(conf, nil, nil, false, false)
+	srv := b.servers[serverID]
+	srv.startCaps()
+
+	if !inList(srv.capNeg.wanted, "sasl") {
+		t.Error("Expected sasl to be wanted, got:", srv.capNeg.wanted)
+	}
+	srv.handleCap(msg(t, ":srv CAP * LS :batch sasl"))
+	srv.handleCap(msg(t, ":srv CAP * ACK :batch sasl"))
+	if !srv.capNeg.authenticating || !srv.capNeg.negotiating {
+		t.Fatal("Expected authentication to hold up negotiation.")
+	}
+
+	srv.handleSasl(msg(t, ":srv 900 nobody nobody!nobody@host account :In"))
+	srv.handleSasl(msg(t, ":srv 903 nobody :SASL authentication successful"))
+	if srv.capNeg.authenticating || srv.capNeg.negotiating {
+		t.Error("Expected authentication and negotiation to be finished.")
+	}
+	if account := srv.endpoint.GetAccount(); account != "account" {
+		t.Error("Expected to be logged in to account, got:", account)
+	}
+
+	srv.handleSasl(msg(t, ":srv 901 nobody nobody!nobody@host :Out"))
+	if account := srv.GetAccount(); len(account) != 0 {
+		t.Error("Expected to be logged out, got:", account)
+	}
+}
+
+func TestSasl_Failed(t *T) {
+	t.Parallel()
+	conf := fakeConfig.Clone().GlobalContext().Sasl("account", "secret")
+	b, _ := createBot(conf, nil, nil, false, false)
+	srv := b.servers[serverID]
+	srv.startCaps()
+
+	srv.handleCap(msg(t, ":srv CAP * LS :sasl"))
+	srv.handleCap(msg(t, ":srv CAP * ACK :sasl"))
+	srv.handleSasl(msg(t, ":srv 904 nobody :SASL authentication failed"))
+	if srv.capNeg.authenticating || srv.capNeg.negotiating {
+		t.Error("Expected negotiation to finish when authentication fails.")
+	}
+	if account := srv.GetAccount(); len(account) != 0 {
+		t.Error("Expected not to be logged in, got:", account)
+	}
+}
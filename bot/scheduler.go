@@ -0,0 +1,408 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/aarondl/ultimateq/irc"
+	"log"
+	"sync"
+	"time"
+)
+
+// JobKind is the kind of schedule a job runs on.
+type JobKind byte
+
+// Job kinds
+const (
+	JOB_ONCE JobKind = iota
+	JOB_INTERVAL
+	JOB_CRON
+)
+
+const (
+	// schedulerNamespace is the store namespace jobs are persisted in.
+	schedulerNamespace = "scheduler"
+	// errFmtPersistJob occurs when a job can't be written to the store.
+	errFmtPersistJob = "bot: Failed to persist job %v (%v)\n"
+	// errFmtLoadJob occurs when a job in the store can't be read.
+	errFmtLoadJob = "bot: Failed to load job %v (%v)\n"
+)
+
+var (
+	// errInvalidJob occurs when a job is scheduled without a name or handler.
+	errInvalidJob = errors.New("bot: Jobs require a name and a handler.")
+	// errInvalidInterval occurs when an interval job is scheduled with an
+	// interval that isn't positive.
+	errInvalidInterval = errors.New("bot: Job interval must be positive.")
+	// errCronNeverRuns occurs when a cron expression never allows a job to
+	// run, such as 31st of February.
+	errCronNeverRuns = errors.New("bot: Cron expression never runs.")
+)
+
+// Job is a piece of work the scheduler runs at some point in the future.
+// Jobs are persisted in the store so they survive restarts, this is why they
+// refer to their handler by name instead of holding on to it, a job waits
+// until a handler with it's name has been registered with the bot before
+// it will run.
+type Job struct {
+	// Name uniquely identifies the job, scheduling another job with the same
+	// name replaces it.
+	Name string
+	// Handler is the name of the JobHandler that runs the job.
+	Handler string
+	// Server is the name of the server the job is bound to. Jobs bound to a
+	// server pause while that server is not connected and run as soon as it
+	// connects again if they were due in the meantime. If empty the job runs
+	// regardless of server connections.
+	Server string
+	// Data is anything the handler needs to know to run the job.
+	Data string
+
+	Kind     JobKind
+	Interval time.Duration
+	Cron     string
+
+	// Next is when the job runs next.
+	Next time.Time
+}
+
+// JobHandler runs jobs when they come due. The endpoint is the endpoint of
+// the server the job is bound to or nil if it's not bound to a server.
+type JobHandler interface {
+	HandleJob(job Job, endpoint irc.Endpoint)
+}
+
+// scheduler keeps track of jobs and runs them when they are due.
+type scheduler struct {
+	bot      *Bot
+	jobs     map[string]*Job
+	handlers map[string]JobHandler
+	running  bool
+	wake     chan struct{}
+	quit     chan struct{}
+	now      func() time.Time
+
+	protect sync.Mutex
+}
+
+// createScheduler creates a scheduler for the bot.
+func createScheduler(b *Bot) *scheduler {
+	return &scheduler{
+		bot:      b,
+		jobs:     make(map[string]*Job),
+		handlers: make(map[string]JobHandler),
+		wake:     make(chan struct{}, 1),
+		now:      time.Now,
+	}
+}
+
+// RegisterJobHandler adds a handler that runs jobs scheduled with it's name.
+// Registering a handler under an existing name replaces it.
+func (b *Bot) RegisterJobHandler(name string, handler JobHandler) {
+	s := b.scheduler
+	s.protect.Lock()
+	s.handlers[name] = handler
+	s.protect.Unlock()
+	s.wakeup()
+}
+
+// UnregisterJobHandler removes a job handler. Jobs that use it stay scheduled
+// but will not run until a handler is registered with the same name.
+func (b *Bot) UnregisterJobHandler(name string) bool {
+	s := b.scheduler
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	_, ok := s.handlers[name]
+	delete(s.handlers, name)
+	return ok
+}
+
+// ScheduleOnce schedules a job to run one time at the given time. If the time
+// has already passed the job is run right away.
+func (b *Bot) ScheduleOnce(name, handler, server string, at time.Time,
+	data string) error {
+
+	return b.schedule(&Job{
+		Name: name, Handler: handler, Server: server, Data: data,
+		Kind: JOB_ONCE, Next: at,
+	})
+}
+
+// ScheduleInterval schedules a job to run every interval.
+func (b *Bot) ScheduleInterval(name, handler, server string,
+	interval time.Duration, data string) error {
+
+	if interval <= 0 {
+		return errInvalidInterval
+	}
+	return b.schedule(&Job{
+		Name: name, Handler: handler, Server: server, Data: data,
+		Kind: JOB_INTERVAL, Interval: interval,
+		Next: b.scheduler.now().Add(interval),
+	})
+}
+
+// ScheduleCron schedules a job to run on a cron expression such as
+// "*/15 9-17 * * 1-5". The usual shortcuts like @hourly and @daily can also
+// be used.
+func (b *Bot) ScheduleCron(name, handler, server, spec, data string) error {
+	cron, err := parseCron(spec)
+	if err != nil {
+		return err
+	}
+	next := cron.next(b.scheduler.now())
+	if next.IsZero() {
+		return errCronNeverRuns
+	}
+	return b.schedule(&Job{
+		Name: name, Handler: handler, Server: server, Data: data,
+		Kind: JOB_CRON, Cron: spec, Next: next,
+	})
+}
+
+// Unschedule removes a job.
+func (b *Bot) Unschedule(name string) bool {
+	s := b.scheduler
+	s.protect.Lock()
+	_, ok := s.jobs[name]
+	if ok {
+		delete(s.jobs, name)
+		s.unpersist(name)
+	}
+	s.protect.Unlock()
+
+	if ok {
+		s.wakeup()
+	}
+	return ok
+}
+
+// GetJobs returns a copy of all the scheduled jobs.
+func (b *Bot) GetJobs() []Job {
+	s := b.scheduler
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// schedule validates and adds a job to the scheduler.
+func (b *Bot) schedule(job *Job) error {
+	if len(job.Name) == 0 || len(job.Handler) == 0 {
+		return errInvalidJob
+	}
+	if len(job.Server) > 0 {
+		b.protectServers.RLock()
+		_, ok := b.servers[job.Server]
+		b.protectServers.RUnlock()
+		if !ok {
+			return errUnknownServerID
+		}
+	}
+
+	s := b.scheduler
+	s.protect.Lock()
+	s.jobs[job.Name] = job
+	s.persist(job)
+	s.protect.Unlock()
+
+	s.wakeup()
+	return nil
+}
+
+// start begins running jobs.
+func (s *scheduler) start() {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+	s.quit = make(chan struct{})
+	go s.run(s.quit)
+}
+
+// stop stops running jobs. Jobs that are already running are not
+// interrupted.
+func (s *scheduler) stop() {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	if !s.running {
+		return
+	}
+	s.running = false
+	close(s.quit)
+}
+
+// wakeup makes the scheduler re-examine it's jobs, it should be called
+// whenever something happens that may allow a job to run.
+func (s *scheduler) wakeup() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run runs due jobs until quit is closed.
+func (s *scheduler) run(quit chan struct{}) {
+	for {
+		s.protect.Lock()
+		wait := s.runDue()
+		s.protect.Unlock()
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+
+		select {
+		case <-fire:
+		case <-s.wake:
+		case <-quit:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+
+		select {
+		case <-quit:
+			return
+		default:
+		}
+	}
+}
+
+// runDue starts every job that is due and able to run and reschedules it.
+// It returns how long until the next job is due, or -1 if no jobs are
+// waiting on time alone. Not thread safe.
+func (s *scheduler) runDue() time.Duration {
+	now := s.now()
+	wait := time.Duration(-1)
+
+	for name, job := range s.jobs {
+		if job.Next.After(now) {
+			if until := job.Next.Sub(now); wait < 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		handler, ok := s.handlers[job.Handler]
+		if !ok {
+			continue
+		}
+		endpoint, ok := s.endpoint(job.Server)
+		if !ok {
+			continue
+		}
+
+		go handler.HandleJob(*job, endpoint)
+
+		switch job.Kind {
+		case JOB_ONCE:
+			delete(s.jobs, name)
+			s.unpersist(name)
+			continue
+		case JOB_INTERVAL:
+			job.Next = now.Add(job.Interval)
+		case JOB_CRON:
+			if cron, err := parseCron(job.Cron); err == nil {
+				job.Next = cron.next(now)
+			}
+			if job.Next.IsZero() || !job.Next.After(now) {
+				delete(s.jobs, name)
+				s.unpersist(name)
+				continue
+			}
+		}
+		s.persist(job)
+
+		if until := job.Next.Sub(now); wait < 0 || until < wait {
+			wait = until
+		}
+	}
+
+	return wait
+}
+
+// endpoint finds the endpoint for a job's server. If the server is not
+// connected it returns false.
+func (s *scheduler) endpoint(server string) (irc.Endpoint, bool) {
+	if len(server) == 0 {
+		return nil, true
+	}
+
+	s.bot.protectServers.RLock()
+	defer s.bot.protectServers.RUnlock()
+
+	srv, ok := s.bot.servers[server]
+	if !ok || srv.GetStatus() != STATUS_STARTED {
+		return nil, false
+	}
+	return srv.endpoint, true
+}
+
+// persist writes a job to the store if there is one. Not thread safe.
+func (s *scheduler) persist(job *Job) {
+	s.bot.protectStore.Lock()
+	defer s.bot.protectStore.Unlock()
+	if s.bot.store == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(job)
+	if err == nil {
+		err = s.bot.store.SaveData(schedulerNamespace, job.Name, buf.Bytes())
+	}
+	if err != nil {
+		log.Printf(errFmtPersistJob, job.Name, err)
+	}
+}
+
+// unpersist removes a job from the store if there is one. Not thread safe.
+func (s *scheduler) unpersist(name string) {
+	s.bot.protectStore.Lock()
+	defer s.bot.protectStore.Unlock()
+	if s.bot.store == nil {
+		return
+	}
+
+	if err := s.bot.store.DeleteData(schedulerNamespace, name); err != nil {
+		log.Printf(errFmtPersistJob, name, err)
+	}
+}
+
+// load reads the persisted jobs from the store.
+func (s *scheduler) load() error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	s.bot.protectStore.RLock()
+	defer s.bot.protectStore.RUnlock()
+	if s.bot.store == nil {
+		return nil
+	}
+
+	return s.bot.store.EachData(schedulerNamespace,
+		func(name string, value []byte) {
+			job := &Job{}
+			err := gob.NewDecoder(bytes.NewReader(value)).Decode(job)
+			if err != nil {
+				log.Printf(errFmtLoadJob, name, err)
+				return
+			}
+			s.jobs[job.Name] = job
+		},
+	)
+}
@@ -0,0 +1,258 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+	. "testing"
+	"time"
+)
+
+// jobRun is a record of a job being run.
+type jobRun struct {
+	job      Job
+	endpoint irc.Endpoint
+}
+
+// testJobHandler sends every job it runs on a channel.
+type testJobHandler chan jobRun
+
+func (h testJobHandler) HandleJob(job Job, endpoint irc.Endpoint) {
+	h <- jobRun{job, endpoint}
+}
+
+// waitJob waits for a job to be run.
+func waitJob(t *T, h testJobHandler) (run jobRun, ok bool) {
+	select {
+	case run = <-h:
+		return run, true
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for a job to run.")
+	}
+	return
+}
+
+// expectNoJob makes sure no job is run for a little while.
+func expectNoJob(t *T, h testJobHandler) {
+	select {
+	case run := <-h:
+		t.Error("Expected no job to run, got:", run.job.Name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestScheduler_Validation(t *T) {
+	t.Parallel()
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	now := time.Now()
+
+	if err := b.ScheduleOnce("", "h", "", now, ""); err != errInvalidJob {
+		t.Error("Expected error:", errInvalidJob, "got:", err)
+	}
+	if err := b.ScheduleOnce("job", "", "", now, ""); err != errInvalidJob {
+		t.Error("Expected error:", errInvalidJob, "got:", err)
+	}
+	err := b.ScheduleOnce("job", "h", "nosuchserver", now, "")
+	if err != errUnknownServerID {
+		t.Error("Expected error:", errUnknownServerID, "got:", err)
+	}
+	err = b.ScheduleInterval("job", "h", "", 0, "")
+	if err != errInvalidInterval {
+		t.Error("Expected error:", errInvalidInterval, "got:", err)
+	}
+	if err = b.ScheduleCron("job", "h", "", "* * *", ""); err == nil {
+		t.Error("Expected a cron parse error.")
+	}
+	err = b.ScheduleCron("job", "h", "", "0 0 31 2 *", "")
+	if err != errCronNeverRuns {
+		t.Error("Expected error:", errCronNeverRuns, "got:", err)
+	}
+	if len(b.GetJobs()) != 0 {
+		t.Error("Expected no jobs to be scheduled.")
+	}
+}
+
+func TestScheduler_Once(t *T) {
+	t.Parallel()
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	h := make(testJobHandler)
+	b.RegisterJobHandler("handler", h)
+	b.scheduler.start()
+	defer b.scheduler.stop()
+
+	err := b.ScheduleOnce("job", "handler", "", time.Now().Add(-time.Hour),
+		"data")
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	run, ok := waitJob(t, h)
+	if ok && (run.job.Name != "job" || run.job.Data != "data" ||
+		run.endpoint != nil) {
+		t.Error("Unexpected job run:", run.job, run.endpoint)
+	}
+	expectNoJob(t, h)
+	if len(b.GetJobs()) != 0 {
+		t.Error("Expected the job to be removed after running.")
+	}
+}
+
+func TestScheduler_Interval(t *T) {
+	t.Parallel()
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	h := make(testJobHandler)
+	b.RegisterJobHandler("handler", h)
+	b.scheduler.start()
+
+	err := b.ScheduleInterval("job", "handler", "", 10*time.Millisecond, "")
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	for i := 0; i < 3; i++ {
+		waitJob(t, h)
+	}
+
+	jobs := b.GetJobs()
+	if len(jobs) != 1 || jobs[0].Kind != JOB_INTERVAL {
+		t.Error("Expected the interval job to stay scheduled, got:", jobs)
+	}
+
+	if !b.Unschedule("job") {
+		t.Error("Expected the job to be unscheduled.")
+	}
+	if b.Unschedule("job") {
+		t.Error("Expected the job to be gone.")
+	}
+	select {
+	case <-h:
+	case <-time.After(20 * time.Millisecond):
+	}
+	expectNoJob(t, h)
+	b.scheduler.stop()
+}
+
+func TestScheduler_Cron(t *T) {
+	t.Parallel()
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	h := make(testJobHandler)
+	b.RegisterJobHandler("handler", h)
+
+	now := time.Date(2014, 6, 1, 10, 7, 30, 0, time.UTC)
+	b.scheduler.now = func() time.Time { return now }
+
+	err := b.ScheduleCron("job", "handler", "", "*/15 * * * *", "")
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	jobs := b.GetJobs()
+	if len(jobs) != 1 ||
+		!jobs[0].Next.Equal(time.Date(2014, 6, 1, 10, 15, 0, 0, time.UTC)) {
+		t.Error("Expected the job to be due at quarter past, got:", jobs)
+	}
+
+	b.scheduler.protect.Lock()
+	now = now.Add(10 * time.Minute)
+	wait := b.scheduler.runDue()
+	b.scheduler.protect.Unlock()
+
+	waitJob(t, h)
+	if wait != 12*time.Minute+30*time.Second {
+		t.Error("Expected to wait until half past, got:", wait)
+	}
+}
+
+func TestScheduler_WaitsForHandler(t *T) {
+	t.Parallel()
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	h := make(testJobHandler)
+	b.scheduler.start()
+	defer b.scheduler.stop()
+
+	b.ScheduleOnce("job", "handler", "", time.Now(), "")
+	expectNoJob(t, h)
+
+	b.RegisterJobHandler("handler", h)
+	waitJob(t, h)
+
+	if !b.UnregisterJobHandler("handler") {
+		t.Error("Expected the handler to be unregistered.")
+	}
+	if b.UnregisterJobHandler("handler") {
+		t.Error("Expected the handler to be gone.")
+	}
+}
+
+func TestScheduler_ServerBound(t *T) {
+	t.Parallel()
+	b, _ := createBot(fakeConfig, nil, nil, false, false)
+	h := make(testJobHandler)
+	b.RegisterJobHandler("handler", h)
+	b.scheduler.start()
+	defer b.scheduler.stop()
+
+	err := b.ScheduleInterval("job", "handler", serverID, time.Millisecond, "")
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	expectNoJob(t, h)
+
+	srv := b.servers[serverID]
+	srv.setStatus(STATUS_STARTED)
+	b.scheduler.wakeup()
+	run, ok := waitJob(t, h)
+	if ok && run.endpoint != srv.endpoint {
+		t.Error("Expected the server's endpoint, got:", run.endpoint)
+	}
+
+	srv.setStatus(STATUS_RECONNECTING)
+	select {
+	case <-h:
+	case <-time.After(20 * time.Millisecond):
+	}
+	expectNoJob(t, h)
+}
+
+func TestScheduler_Persist(t *T) {
+	t.Parallel()
+	store, err := data.CreateStore(data.MemStoreProvider)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	storeProv := func(string) (*data.Store, error) {
+		return store, nil
+	}
+
+	conf := fakeConfig.Clone().GlobalContext().NoStore(false)
+	b, err := createBot(conf, nil, storeProv, false, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	at := time.Now().Add(time.Hour)
+	b.ScheduleOnce("once", "handler", serverID, at, "data")
+	b.ScheduleCron("cron", "handler", "", "@daily", "")
+	b.ScheduleInterval("gone", "handler", "", time.Hour, "")
+	b.Unschedule("gone")
+
+	b2, err := createBot(conf, nil, storeProv, false, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	jobs := make(map[string]Job)
+	for _, job := range b2.GetJobs() {
+		jobs[job.Name] = job
+	}
+	if len(jobs) != 2 {
+		t.Error("Expected two jobs to be loaded, got:", jobs)
+	}
+	if job := jobs["once"]; job.Server != serverID || job.Data != "data" ||
+		!job.Next.Equal(at) || job.Kind != JOB_ONCE {
+		t.Error("Job was not loaded correctly:", job)
+	}
+	if job := jobs["cron"]; job.Cron != "@daily" || job.Kind != JOB_CRON {
+		t.Error("Job was not loaded correctly:", job)
+	}
+
+	if list, _ := store.GlobalUsers(); len(list) != 0 {
+		t.Error("Expected jobs not to be mistaken for users.")
+	}
+}
@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// seenSaveInterval is how often the users seen on a server are saved to
+	// the store while messages are coming in.
+	seenSaveInterval = time.Minute
+	// seenPruneInterval is how often the users seen on a server that are
+	// older than seenRetention are removed from the store.
+	seenPruneInterval = 24 * time.Hour
+	// seenRetention is how long the store remembers when a user was seen.
+	seenRetention = 365 * 24 * time.Hour
+	// errFmtSaveSeen occurs when the users seen on a server can't be saved
+	// to the store, they're lost.
+	errFmtSaveSeen = "bot: Failed to save seen users on %v (%v)\n"
+	// errFmtPruneSeen occurs when the old seen users on a server can't be
+	// removed from the store, it's tried again the next interval.
+	errFmtPruneSeen = "bot: Failed to prune seen users on %v (%v)\n"
+)
+
+// saveSeen saves the users seen on a server since the last time to the store.
+// Without a store they're forgotten.
+func (b *Bot) saveSeen(srv *Server) {
+	srv.protectState.Lock()
+	if srv.state == nil {
+		srv.protectState.Unlock()
+		return
+	}
+	list := srv.state.FlushSeen()
+	srv.protectState.Unlock()
+	if len(list) == 0 {
+		return
+	}
+
+	b.protectStore.Lock()
+	defer b.protectStore.Unlock()
+	if b.store == nil {
+		return
+	}
+	if err := b.store.SaveSeen(srv.name, list...); err != nil {
+		log.Printf(errFmtSaveSeen, srv.name, err)
+	}
+}
+
+// pruneSeen removes the users seen on a server longer than seenRetention ago
+// from the store.
+func (b *Bot) pruneSeen(srv *Server) {
+	b.protectStore.Lock()
+	defer b.protectStore.Unlock()
+	if b.store == nil {
+		return
+	}
+	_, err := b.store.PruneSeen(srv.name, time.Now().Add(-seenRetention))
+	if err != nil {
+		log.Printf(errFmtPruneSeen, srv.name, err)
+	}
+}
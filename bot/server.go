@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -12,6 +13,7 @@ import (
 	"github.com/aarondl/ultimateq/inet"
 	"github.com/aarondl/ultimateq/irc"
 	"io/ioutil"
+	"log"
 	"net"
 	"os"
 	"strconv"
@@ -33,6 +35,9 @@ const (
 	// errServerAlreadyConnected occurs if a server has not been shutdown
 	// before another attempt to connect to it is made.
 	errFmtAlreadyConnected = "bot: %v already connected.\n"
+	// errFmtRecordFile occurs when the record file can't be opened, the
+	// server carries on without recording.
+	errFmtRecordFile = "bot: %v failed to open record file (%v)\n"
 )
 
 var (
@@ -73,22 +78,44 @@ type Server struct {
 	dispatcher   *dispatch.Dispatcher
 	commander    *commander.Commander
 	endpoint     *ServerEndpoint
+	queries      *queries
+	bouncer      *bouncer
 
 	handlerID int
 	handler   *coreHandler
 
 	// State and Connection
 	client      *inet.IrcClient
+	recorder    *inet.Recorder
 	started     bool
 	state       *data.State
 	reconnScale time.Duration
 	killable    chan int
+	// resyncInterval is how often the channels are resynced, resyncQueue are
+	// the channels left to resync this interval, resyncStep is the time
+	// between them and resyncAt is when the next one is due, protected by
+	// protectState.
+	resyncInterval time.Duration
+	resyncQueue    []string
+	resyncStep     time.Duration
+	resyncAt       time.Time
+	// quitting is set once a QUIT is written so that the server closing the
+	// link isn't reconnected, protected by protect.
+	quitting bool
+
+	// IRCv3 capabilities and SASL
+	wantCaps []string
+	capNeg   capNegotiation
+	sasl     saslCredentials
 
 	// protects client reading/writing
 	protect sync.RWMutex
 
 	// protects the state from reading and writing.
 	protectState sync.RWMutex
+
+	// protects the capability negotiation.
+	protectCaps sync.RWMutex
 }
 
 // ServerEndpoint implements the Endpoint interface.
@@ -102,16 +129,50 @@ func (s *Server) Write(buf []byte) (int, error) {
 	if len(buf) == 0 {
 		return 0, nil
 	}
+	if isQuit(buf) {
+		s.setQuitting(true)
+	}
 	s.protect.RLock()
 	defer s.protect.RUnlock()
 
-	if s.GetStatus() != STATUS_STOPPED {
+	if s.status != STATUS_STOPPED {
 		return s.client.Write(buf)
 	}
 
 	return 0, errNotConnected
 }
 
+// isQuit checks if a line written to the server is a QUIT.
+func isQuit(buf []byte) bool {
+	name := buf
+	if i := bytes.IndexAny(buf, " \r\n"); i >= 0 {
+		name = buf[:i]
+	}
+	return bytes.EqualFold(name, []byte(irc.QUIT))
+}
+
+// setQuitting sets if the bot has quit the server.
+func (s *Server) setQuitting(quitting bool) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	s.quitting = quitting
+}
+
+// isQuitting checks if the bot has quit the server, in which case it's not
+// reconnected when the server closes the link.
+func (s *Server) isQuitting() bool {
+	s.protect.RLock()
+	defer s.protect.RUnlock()
+	return s.quitting
+}
+
+// GetNetwork returns the network the server is an identity on.
+func (s *ServerEndpoint) GetNetwork() string {
+	s.server.bot.protectConfig.RLock()
+	defer s.server.bot.protectConfig.RUnlock()
+	return s.server.conf.GetNetwork()
+}
+
 // createEndpoint creates a ServerEndpoint with an embedded DataEndpoint.
 func (s *Server) createEndpoint(store *data.Store, mutex *sync.RWMutex) {
 	s.endpoint = &ServerEndpoint{
@@ -137,9 +198,23 @@ func (s *Server) createDispatching(prefix rune, channels []string) {
 // createState uses the server's current ProtoCaps to create a state.
 func (s *Server) createState() (err error) {
 	s.state, err = data.CreateState(s.caps)
+	if err == nil {
+		s.setWhoisLifetime(s.conf.GetWhoisLifetime())
+		s.setResyncInterval(s.conf.GetResyncInterval())
+	}
 	return err
 }
 
+// setWhoisLifetime sets how many seconds the state keeps WHOIS results for.
+func (s *Server) setWhoisLifetime(seconds float64) {
+	s.protectState.Lock()
+	defer s.protectState.Unlock()
+	if s.state != nil {
+		s.state.SetWhoisLifetime(
+			time.Duration(seconds*1000.0) * time.Millisecond)
+	}
+}
+
 // createIrcClient connects to the configured server, and creates an IrcClient
 // for use with that connection.
 func (s *Server) createIrcClient() error {
@@ -147,6 +222,7 @@ func (s *Server) createIrcClient() error {
 		return fmt.Errorf(errFmtAlreadyConnected, s.name)
 	}
 
+	var err error
 	var result *connResult
 	resultService := make(chan chan *connResult)
 	resultChan := make(chan *connResult)
@@ -171,10 +247,28 @@ func (s *Server) createIrcClient() error {
 		time.Duration(s.conf.GetFloodStep()*1000.0)*time.Millisecond,
 		time.Duration(s.conf.GetKeepAlive())*time.Second,
 		time.Second)
+	if filename := s.conf.GetRecordFile(); len(filename) > 0 {
+		s.recorder, err = openRecorder(filename)
+		if err != nil {
+			log.Printf(errFmtRecordFile, s.name, err)
+		} else {
+			s.client.SetRecorder(s.recorder)
+		}
+	}
 	s.protect.Unlock()
 	return nil
 }
 
+// openRecorder opens a file for appending and creates a recorder with it.
+func openRecorder(filename string) (*inet.Recorder, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0644)
+	if err != nil {
+		return nil, err
+	}
+	return inet.CreateRecorder(file), nil
+}
+
 // createConnection creates a connection based off the server receiver's
 // config variables. It takes a chan of channels to return the result on.
 // If the channel is closed before it can send it's result, it will close the
@@ -230,7 +324,11 @@ func (s *Server) Close() (err error) {
 	if s.client != nil {
 		err = s.client.Close()
 	}
+	if s.recorder != nil {
+		s.recorder.Close()
+	}
 	s.client = nil
+	s.recorder = nil
 	return
 }
 
@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"fmt"
+	"github.com/aarondl/ultimateq/config"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultWatchInterval is how often the config file is checked for
+	// changes if no interval is given.
+	defaultWatchInterval = 2 * time.Second
+	// errFmtConfigNotApplied is the message of a ConfigError.
+	errFmtConfigNotApplied = "bot: Config file (%v) was not applied: %v"
+)
+
+// ConfigError is given to the WatchConfig callback when the config file
+// changed but could not be applied. The running config is left as it was.
+// Values read from the environment or files are redacted from the errors.
+type ConfigError struct {
+	Filename string
+	Errors   []string
+}
+
+// Error implements error.
+func (c ConfigError) Error() string {
+	return fmt.Sprintf(errFmtConfigNotApplied, c.Filename,
+		strings.Join(c.Errors, "; "))
+}
+
+// WatchCallback is called by the config watcher after it tried to apply a
+// changed config file. err is nil if the new config is now running, or a
+// ConfigError if it was not. It must not start or stop watching the config.
+type WatchCallback func(err error)
+
+// configWatcher polls a config file and rehashes the bot when it changes.
+type configWatcher struct {
+	bot      *Bot
+	filename string
+	interval time.Duration
+	fn       WatchCallback
+
+	modTime time.Time
+	size    int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// WatchConfig watches the file the config was read from, or the default
+// file name, and rehashes the bot whenever it changes. The new config is
+// validated and applied with ReplaceConfig, if it can't be the running config
+// is kept and the errors are given to fn. If fn is nil the errors are logged
+// instead. The file is checked every interval, or every couple of seconds if
+// the interval is 0. Calling WatchConfig again replaces the previous watch.
+func (b *Bot) WatchConfig(interval time.Duration, fn WatchCallback) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	b.protectConfig.RLock()
+	filename := b.conf.GetFilename()
+	b.protectConfig.RUnlock()
+
+	w := &configWatcher{
+		bot:      b,
+		filename: filename,
+		interval: interval,
+		fn:       fn,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	w.changed()
+
+	b.protectWatcher.Lock()
+	defer b.protectWatcher.Unlock()
+	if b.watcher != nil {
+		b.watcher.close()
+	}
+	b.watcher = w
+	go w.watch()
+}
+
+// StopWatchingConfig stops watching the config file.
+func (b *Bot) StopWatchingConfig() {
+	b.protectWatcher.Lock()
+	defer b.protectWatcher.Unlock()
+	if b.watcher != nil {
+		b.watcher.close()
+		b.watcher = nil
+	}
+}
+
+// watch polls the file until the watcher is closed.
+func (w *configWatcher) watch() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if w.changed() {
+				w.rehash()
+			}
+		}
+	}
+}
+
+// changed checks if the file was modified since it was last checked. A file
+// that can't be read is not considered changed until it can be again.
+func (w *configWatcher) changed() bool {
+	info, err := os.Stat(w.filename)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+	return true
+}
+
+// rehash reads the config file and tries to apply it.
+func (w *configWatcher) rehash() {
+	conf := config.CreateConfigFromFile(w.filename)
+	if len(conf.Errors) == 0 && w.bot.ReplaceConfig(conf) {
+		if w.fn != nil {
+			w.fn(nil)
+		}
+		return
+	}
+
+	if w.fn == nil {
+		conf.DisplayErrors()
+		return
+	}
+	err := ConfigError{Filename: w.filename}
+	for _, e := range conf.Errors {
+		err.Errors = append(err.Errors, conf.Redact(e.Error()))
+	}
+	w.fn(err)
+}
+
+// close stops the watcher and waits for it to finish.
+func (w *configWatcher) close() {
+	close(w.stop)
+	<-w.done
+}
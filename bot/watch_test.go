@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"github.com/aarondl/ultimateq/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	. "testing"
+	"time"
+)
+
+var watchConfig = `global:
+    nick: nobody
+    username: nobody
+    userhost: bitforge.ca
+    realname: %v
+    nostore: true
+    noreconnect: true
+servers:
+    irc.test.net:
+        port: 6667
+`
+
+func TestBot_WatchConfig(t *T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "ultimateq")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "config.yaml")
+	write := func(realname string) {
+		err := ioutil.WriteFile(filename,
+			[]byte(strings.Replace(watchConfig, "%v", realname, 1)), 0644)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	realname := func(b *Bot) (name string) {
+		b.ReadConfig(func(conf *config.Config) {
+			name = conf.Global.GetRealname()
+		})
+		return
+	}
+
+	write("first")
+	conf := config.CreateConfigFromFile(filename)
+	b, err := createBot(conf, nil, nil, false, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	errs := make(chan error, 100)
+	b.WatchConfig(5*time.Millisecond, func(err error) {
+		errs <- err
+	})
+	defer b.StopWatchingConfig()
+
+	// The watcher may see the file half written, so wait for the outcome
+	// of the complete file.
+	wait := func(what string, expected func(error) bool) {
+		for {
+			select {
+			case err := <-errs:
+				if expected(err) {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Expected", what)
+			}
+		}
+	}
+
+	write("second one")
+	wait("the changed config to be applied.", func(err error) bool {
+		return err == nil && realname(b) == "second one"
+	})
+
+	write("not valid!")
+	wait("the invalid config to be reported.", func(err error) bool {
+		cerr, ok := err.(ConfigError)
+		return ok && cerr.Filename == filename && len(cerr.Errors) > 0 &&
+			strings.Contains(cerr.Error(), "realname")
+	})
+	if name := realname(b); name != "second one" {
+		t.Error("Expected the running config to be kept, got:", name)
+	}
+
+	b.StopWatchingConfig()
+	for len(errs) > 0 {
+		<-errs
+	}
+	write("third")
+	select {
+	case err = <-errs:
+		t.Error("Expected no rehash after stopping, got:", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/aarondl/ultimateq/config"
+	"github.com/aarondl/ultimateq/data"
+	"strings"
+)
+
+const (
+	// errFmtUserExists occurs when adduser is given a user that's in the
+	// store already.
+	errFmtUserExists = "User [%v] already exists."
+)
+
+// cmdAddUser creates a user in the store of a config file, the store must not
+// be in use by a running bot. The user is given all access everywhere unless
+// -level or -flags are given, like the first user registered with the bot.
+// The password is asked for if it's not given.
+func cmdAddUser(args []string, env *environment) int {
+	flags := flagSet("adduser", env)
+	file := flags.String("config", defaultConfigFile,
+		"the config file naming the store")
+	store := flags.String("store", "",
+		"the store database file, overrides the config")
+	level := flags.Uint("level", 0, "the global access level")
+	access := flags.String("flags", "", "the global access flags")
+	masks := flags.String("masks", "",
+		"comma separated host masks the user must match")
+	account := flags.String("account", "",
+		"the services account that authenticates the user")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 || *level > 255 {
+		flags.Usage()
+		return 2
+	}
+	granted := false
+	flags.Visit(func(f *flag.Flag) {
+		granted = granted || f.Name == "level" || f.Name == "flags"
+	})
+
+	username, password := flags.Arg(0), flags.Arg(1)
+	if len(password) == 0 {
+		var err error
+		if password, err = prompt(env, "Password", ""); err != nil {
+			fmt.Fprintln(env.stderr, "ultimateq:", err)
+			return 1
+		}
+	}
+
+	if len(*store) == 0 {
+		conf := config.CreateConfigFromFile(*file)
+		if len(conf.Errors) > 0 {
+			for _, err := range conf.Errors {
+				fmt.Fprintln(env.stderr, conf.Redact(err.Error()))
+			}
+			return 1
+		}
+		*store = conf.GetStoreFile()
+	}
+
+	var userMasks []string
+	if len(*masks) > 0 {
+		userMasks = strings.Split(*masks, ",")
+	}
+	user, err := data.CreateUserAccess(username, password, userMasks...)
+	if err != nil {
+		fmt.Fprintln(env.stderr, "ultimateq:", err)
+		return 1
+	}
+	user.Account = *account
+	if granted {
+		user.GrantGlobal(uint8(*level), *access)
+	} else {
+		user.Global = &data.Access{Level: ^uint8(0), Flags: ^uint64(0)}
+	}
+
+	if err = addUser(*store, user); err != nil {
+		fmt.Fprintln(env.stderr, "ultimateq:", err)
+		return 1
+	}
+	fmt.Fprintf(env.stdout, "Added %v to %v\n", user.Username, *store)
+	return 0
+}
+
+// addUser adds a user to a store if it's not there already. The store is
+// marked as having it's first user so the bot doesn't give all access to the
+// next person to register.
+func addUser(filename string, user *data.UserAccess) error {
+	store, err := data.CreateStore(data.MakeFileStoreProvider(filename))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	existing, err := store.FindUser(user.Username)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf(errFmtUserExists, user.Username)
+	}
+
+	if _, err = store.IsFirst(); err != nil {
+		return err
+	}
+	return store.AddUser(user)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/aarondl/ultimateq/data"
+	"os"
+	"strings"
+	. "testing"
+)
+
+func TestAddUser(t *T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	store := dir + "/store.db"
+
+	env, stdout, stderr := testEnv("secret\n")
+	if code := cmdAddUser([]string{"-store", store, "Admin"}, env); code != 0 {
+		t.Fatal("Expected the user to be added, got:", stderr)
+	}
+	if !strings.Contains(stdout.String(), "Password") ||
+		!strings.Contains(stdout.String(), "Added admin") {
+		t.Error("Expected to be asked for a password, got:", stdout)
+	}
+
+	env, _, stderr = testEnv("")
+	if code := cmdAddUser([]string{"-store", store, "-level", "50",
+		"-flags", "ab", "-masks", "*!*@host,*!*@other", "-account", "acct",
+		"helper", "pass"}, env); code != 0 {
+		t.Fatal("Expected the user to be added, got:", stderr)
+	}
+
+	env, _, stderr = testEnv("")
+	if code := cmdAddUser([]string{"-store", store, "admin", "pass"},
+		env); code != 1 {
+		t.Error("Expected a duplicate user to fail.")
+	}
+	if !strings.Contains(stderr.String(), "already exists") {
+		t.Error("Expected a duplicate error, got:", stderr)
+	}
+
+	s, err := data.CreateStore(data.MakeFileStoreProvider(store))
+	if err != nil {
+		t.Fatal("Could not open the store:", err)
+	}
+	defer s.Close()
+
+	admin, err := s.FindUser("admin")
+	if err != nil || admin == nil {
+		t.Fatal("Expected the admin in the store:", err)
+	}
+	if !admin.VerifyPassword("secret") || !admin.HasGlobalLevel(255) ||
+		!admin.HasGlobalFlags("abcXYZ") {
+		t.Error("Expected the admin to have all access.")
+	}
+
+	helper, err := s.FindUser("helper")
+	if err != nil || helper == nil {
+		t.Fatal("Expected the helper in the store:", err)
+	}
+	if !helper.HasGlobalLevel(50) || helper.HasGlobalLevel(51) ||
+		!helper.HasGlobalFlags("ab") || helper.HasGlobalFlag('c') ||
+		len(helper.Masks) != 2 || helper.Account != "acct" {
+		t.Error("Expected the helper to have the access given.")
+	}
+
+	if first, err := s.IsFirst(); err != nil || first {
+		t.Error("Expected the store to have it's first user.")
+	}
+}
+
+func TestAddUser_Usage(t *T) {
+	t.Parallel()
+
+	for _, args := range [][]string{
+		nil,
+		{"a", "b", "c"},
+		{"-level", "256", "user", "pass"},
+	} {
+		env, _, _ := testEnv("")
+		if code := cmdAddUser(args, env); code != 2 {
+			t.Errorf("Expected exit code 2 for %v, got: %v", args, code)
+		}
+	}
+
+	env, _, stderr := testEnv("")
+	if code := cmdAddUser([]string{"-config", "/none/config.yaml", "user",
+		"pass"}, env); code != 1 {
+		t.Error("Expected a missing config to fail.")
+	}
+	if len(stderr.String()) == 0 {
+		t.Error("Expected an error about the config.")
+	}
+}
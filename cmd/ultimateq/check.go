@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aarondl/ultimateq/config"
+)
+
+// cmdCheck validates a config file. The problems are written one per line,
+// or as a JSON array of config.ValidationErrors with -json. Problems reading
+// the file have only a message. The exit code is 1 if there are any.
+func cmdCheck(args []string, env *environment) int {
+	flags := flagSet("check", env)
+	file := flags.String("config", defaultConfigFile, "the file to check")
+	asJSON := flags.Bool("json", false, "write the problems out as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	conf := config.CreateConfigFromFile(*file)
+	var errs []config.ValidationError
+	for _, err := range conf.Errors {
+		errs = append(errs, config.ValidationError{
+			Message: conf.Redact(err.Error()),
+		})
+	}
+	if len(errs) == 0 {
+		errs = conf.Validate()
+	}
+
+	if *asJSON {
+		if errs == nil {
+			errs = []config.ValidationError{}
+		}
+		buf, err := json.MarshalIndent(errs, "", "    ")
+		if err != nil {
+			fmt.Fprintln(env.stderr, "ultimateq:", err)
+			return 1
+		}
+		fmt.Fprintln(env.stdout, string(buf))
+	} else if len(errs) == 0 {
+		fmt.Fprintln(env.stdout, *file, "is valid.")
+	} else {
+		for _, err := range errs {
+			fmt.Fprintln(env.stdout, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
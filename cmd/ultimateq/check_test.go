@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aarondl/ultimateq/config"
+	"io/ioutil"
+	"os"
+	"strings"
+	. "testing"
+)
+
+func TestCheck(t *T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	valid, invalid, broken := dir+"/valid.yaml", dir+"/invalid.yaml",
+		dir+"/broken.json"
+	ioutil.WriteFile(valid, []byte("global:\n    nick: bot\n"+
+		"    username: bot\n    userhost: bot.net\n    realname: Bot\n"+
+		"servers:\n    irc.net:\n"), 0600)
+	ioutil.WriteFile(invalid, []byte("servers:\n    irc.net:\n"+
+		"        ssl: maybe\n"), 0600)
+	ioutil.WriteFile(broken, []byte(`{"servers": `), 0600)
+
+	env, stdout, _ := testEnv("")
+	if code := cmdCheck([]string{"-config", valid}, env); code != 0 {
+		t.Error("Expected the config to be valid, got:", stdout)
+	}
+	if !strings.Contains(stdout.String(), "is valid") {
+		t.Error("Expected to be told the config is valid, got:", stdout)
+	}
+
+	env, stdout, _ = testEnv("")
+	if code := cmdCheck([]string{"-config", invalid}, env); code != 1 {
+		t.Error("Expected the config to be invalid.")
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[0], "maybe") {
+		t.Error("Expected a line for each problem, got:", stdout)
+	}
+
+	env, stdout, _ = testEnv("")
+	if code := cmdCheck([]string{"-json", "-config", invalid},
+		env); code != 1 {
+		t.Error("Expected the config to be invalid.")
+	}
+	var errs []config.ValidationError
+	if err := json.Unmarshal(stdout.Bytes(), &errs); err != nil {
+		t.Fatal("Expected JSON, got:", err, stdout)
+	}
+	if len(errs) != 5 || errs[0].Rule != config.RuleInvalid ||
+		strings.Join(errs[0].Path, " ") != "servers irc.net ssl" {
+		t.Errorf("Expected the validation errors, got: %#v", errs)
+	}
+
+	env, stdout, _ = testEnv("")
+	if code := cmdCheck([]string{"-json", "-config", valid}, env); code != 0 {
+		t.Error("Expected the config to be valid, got:", stdout)
+	}
+	if strings.TrimSpace(stdout.String()) != "[]" {
+		t.Error("Expected an empty array, got:", stdout)
+	}
+
+	env, stdout, _ = testEnv("")
+	if code := cmdCheck([]string{"-json", "-config", broken},
+		env); code != 1 {
+		t.Error("Expected the config to be invalid.")
+	}
+	errs = nil
+	if err := json.Unmarshal(stdout.Bytes(), &errs); err != nil {
+		t.Fatal("Expected JSON, got:", err, stdout)
+	}
+	if len(errs) != 1 || len(errs[0].Message) == 0 || len(errs[0].Rule) != 0 {
+		t.Errorf("Expected the read error, got: %#v", errs)
+	}
+
+	env, stdout, _ = testEnv("")
+	if code := cmdCheck([]string{"-config", dir + "/none.yaml"},
+		env); code != 1 {
+		t.Error("Expected a missing config to fail.")
+	}
+}
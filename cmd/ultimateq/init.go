@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aarondl/ultimateq/config"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// errFmtConfigExists occurs when init would overwrite a config file.
+	errFmtConfigExists = "ultimateq: Config file (%v) exists, use -force " +
+		"to overwrite it."
+	// errFmtWriteConfig occurs when the config file could not be written.
+	errFmtWriteConfig = "ultimateq: Failed to write config file (%v): %v"
+)
+
+// initOptions are the values init puts in the config.
+type initOptions struct {
+	nick     string
+	altnick  string
+	username string
+	userhost string
+	realname string
+	server   string
+	port     string
+	ssl      bool
+	channels string
+	prefix   string
+	store    string
+}
+
+// cmdInit creates a config file from flags, or by asking for each value when
+// -i is given. The flags are the defaults of the questions.
+func cmdInit(args []string, env *environment) int {
+	var opts initOptions
+	flags := flagSet("init", env)
+	file := flags.String("config", defaultConfigFile,
+		"the file to create, .yaml, .json or .toml")
+	interactive := flags.Bool("i", false, "ask for each value")
+	force := flags.Bool("force", false, "overwrite an existing config file")
+	flags.StringVar(&opts.nick, "nick", "", "the bot's nickname")
+	flags.StringVar(&opts.altnick, "altnick", "",
+		"the nickname to use if the nickname is taken")
+	flags.StringVar(&opts.username, "username", "", "the bot's username")
+	flags.StringVar(&opts.userhost, "userhost", "", "the bot's host")
+	flags.StringVar(&opts.realname, "realname", "", "the bot's real name")
+	flags.StringVar(&opts.server, "server", "", "the irc server to connect to")
+	flags.StringVar(&opts.port, "port", "", "the port of the irc server")
+	flags.BoolVar(&opts.ssl, "ssl", false, "connect to the server with ssl")
+	flags.StringVar(&opts.channels, "channels", "",
+		"comma separated channels to join")
+	flags.StringVar(&opts.prefix, "prefix", "", "the command prefix")
+	flags.StringVar(&opts.store, "store", "", "the store database file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if _, err := os.Stat(*file); err == nil && !*force {
+		fmt.Fprintf(env.stderr, errFmtConfigExists+"\n", *file)
+		return 1
+	}
+
+	if *interactive {
+		if err := opts.ask(env); err != nil {
+			fmt.Fprintln(env.stderr, "ultimateq:", err)
+			return 1
+		}
+	}
+
+	conf, ok := opts.config(env)
+	if !ok {
+		return 1
+	}
+
+	if err := config.FlushConfigToFile(conf, *file); err != nil {
+		fmt.Fprintf(env.stderr, errFmtWriteConfig+"\n", *file, err)
+		return 1
+	}
+	fmt.Fprintln(env.stdout, "Wrote", *file)
+	return 0
+}
+
+// ask asks for each value, using what's already set as the default.
+func (o *initOptions) ask(env *environment) (err error) {
+	questions := []struct {
+		question string
+		value    *string
+	}{
+		{"Nickname", &o.nick},
+		{"Alternate nickname", &o.altnick},
+		{"Username", &o.username},
+		{"Host", &o.userhost},
+		{"Real name", &o.realname},
+		{"Server", &o.server},
+		{"Port", &o.port},
+		{"Channels (comma separated)", &o.channels},
+		{"Command prefix", &o.prefix},
+	}
+	for _, q := range questions {
+		if *q.value, err = prompt(env, q.question, *q.value); err != nil {
+			return
+		}
+	}
+
+	ssl, err := prompt(env, "Use ssl (y/n)", map[bool]string{
+		true: "y", false: "n"}[o.ssl])
+	if err != nil {
+		return
+	}
+	o.ssl = strings.HasPrefix(strings.ToLower(ssl), "y")
+	return
+}
+
+// config creates the config from the options and validates it, writing the
+// errors out if it's not valid.
+func (o *initOptions) config(env *environment) (*config.Config, bool) {
+	conf := config.CreateConfig().
+		Nick(o.nick).
+		Altnick(o.altnick).
+		Username(o.username).
+		Userhost(o.userhost).
+		Realname(o.realname).
+		Prefix(o.prefix)
+	if len(o.store) > 0 {
+		conf.StoreFile(o.store)
+	}
+
+	if len(o.server) > 0 {
+		conf.Server(o.server).Ssl(o.ssl)
+		if len(o.port) > 0 {
+			port, err := strconv.ParseUint(o.port, 10, 16)
+			if err != nil {
+				fmt.Fprintln(env.stderr, "ultimateq: Invalid port:", o.port)
+				return nil, false
+			}
+			conf.Port(uint16(port))
+		}
+		if len(o.channels) > 0 {
+			conf.Channels(strings.Split(o.channels, ",")...)
+		}
+	}
+
+	ok := len(conf.Errors) == 0
+	for _, err := range conf.Errors {
+		fmt.Fprintln(env.stderr, err)
+	}
+	for _, err := range conf.Validate() {
+		fmt.Fprintln(env.stderr, err)
+		ok = false
+	}
+	return conf, ok
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/aarondl/ultimateq/config"
+	"os"
+	"strings"
+	. "testing"
+)
+
+func TestInit_Flags(t *T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := dir + "/config.json"
+
+	args := []string{"-config", file, "-nick", "bot", "-altnick", "bot_",
+		"-username", "bot", "-userhost", "bot.net", "-realname", "A Bot",
+		"-server", "irc.test.net", "-port", "6697", "-ssl",
+		"-channels", "#one,#two", "-prefix", "!"}
+	env, stdout, stderr := testEnv("")
+	if code := cmdInit(args, env); code != 0 {
+		t.Fatal("Expected init to succeed, got:", stderr)
+	}
+	if !strings.Contains(stdout.String(), file) {
+		t.Error("Expected the file to be named, got:", stdout)
+	}
+
+	conf := config.CreateConfigFromFile(file)
+	if len(conf.Errors) != 0 || !conf.IsValid() {
+		t.Fatal("Expected a valid config, got:", conf.Errors)
+	}
+	if conf.GetFormat() != config.FormatJSON {
+		t.Error("Expected the config to be JSON, got:", conf.GetFormat())
+	}
+	srv := conf.GetServer("irc.test.net")
+	if srv == nil {
+		t.Fatal("Expected the server to be configured.")
+	}
+	if srv.GetNick() != "bot" || srv.GetAltnick() != "bot_" ||
+		srv.GetRealname() != "A Bot" || srv.GetPort() != 6697 ||
+		!srv.GetSsl() || srv.GetPrefix() != '!' ||
+		strings.Join(srv.GetChannels(), ",") != "#one,#two" {
+		t.Errorf("Expected the flags in the config, got: %#v", srv)
+	}
+
+	env, _, stderr = testEnv("")
+	if code := cmdInit(args, env); code != 1 {
+		t.Error("Expected init to refuse to overwrite the config.")
+	}
+	if !strings.Contains(stderr.String(), "-force") {
+		t.Error("Expected to be told about -force, got:", stderr)
+	}
+	env, _, stderr = testEnv("")
+	if code := cmdInit(append(args, "-force"), env); code != 0 {
+		t.Error("Expected init to overwrite the config, got:", stderr)
+	}
+}
+
+func TestInit_Interactive(t *T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := dir + "/config.yaml"
+
+	answers := strings.Join([]string{
+		"bot", "", "bot", "bot.net", "A Bot", "irc.test.net", "", "#chan",
+		"", "y",
+	}, "\n") + "\n"
+	env, _, stderr := testEnv(answers)
+	if code := cmdInit([]string{"-i", "-config", file, "-nick", "x",
+		"-altnick", "bot_"}, env); code != 0 {
+		t.Fatal("Expected init to succeed, got:", stderr)
+	}
+
+	conf := config.CreateConfigFromFile(file)
+	srv := conf.GetServer("irc.test.net")
+	if srv == nil {
+		t.Fatal("Expected the server to be configured, got:", conf.Errors)
+	}
+	if srv.GetNick() != "bot" || srv.GetAltnick() != "bot_" || !srv.GetSsl() ||
+		strings.Join(srv.GetChannels(), ",") != "#chan" {
+		t.Errorf("Expected the answers in the config, got: %#v", srv)
+	}
+}
+
+func TestInit_Invalid(t *T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := dir + "/config.yaml"
+
+	env, _, stderr := testEnv("")
+	if code := cmdInit([]string{"-config", file, "-nick", "bot"},
+		env); code != 1 {
+		t.Error("Expected init to fail without a server.")
+	}
+	if !strings.Contains(stderr.String(), "server is required") {
+		t.Error("Expected the validation errors, got:", stderr)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("Expected no config to be written.")
+	}
+
+	env, _, stderr = testEnv("")
+	if code := cmdInit([]string{"-config", file, "-server", "irc.net",
+		"-port", "huge"}, env); code != 1 {
+		t.Error("Expected init to fail with a bad port.")
+	}
+	if !strings.Contains(stderr.String(), "Invalid port") {
+		t.Error("Expected a port error, got:", stderr)
+	}
+
+	env, _, _ = testEnv("")
+	if code := cmdInit([]string{"-i", "-config", file}, env); code != 1 {
+		t.Error("Expected init to fail when the answers run out.")
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Command ultimateq is a front end for the bot that needs no code to be written.
+It creates, checks and runs the bot from a config file, and manages the users
+in it's store.
+
+Usage:
+
+	ultimateq <command> [flags] [args]
+
+The commands are:
+
+	init     create a config file, from flags or by asking for each value
+	check    validate a config file
+	run      connect the bot to it's servers
+	adduser  create a user in the store while the bot is not running
+
+Run ultimateq <command> -h for the flags of a command.
+*/
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// defaultConfigFile is the config file used when -config is not given.
+	defaultConfigFile = "config.yaml"
+	// errFmtUnknownCommand occurs when the command given doesn't exist.
+	errFmtUnknownCommand = "ultimateq: Unknown command (%v)"
+)
+
+// environment is what a command reads from and writes to, it's the standard
+// streams outside of tests.
+type environment struct {
+	stdin  *bufio.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command is a subcommand of the binary.
+type command struct {
+	name  string
+	usage string
+	desc  string
+	run   func(args []string, env *environment) int
+}
+
+// commands are the subcommands in the order they're shown in the usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"init", "[flags]", "create a config file", cmdInit},
+		{"check", "[flags]", "validate a config file", cmdCheck},
+		{"run", "[flags]", "connect the bot to it's servers", cmdRun},
+		{"adduser", "[flags] username [password]",
+			"create a user in the store", cmdAddUser},
+	}
+}
+
+func main() {
+	env := &environment{
+		stdin:  bufio.NewReader(os.Stdin),
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	os.Exit(run(os.Args[1:], env))
+}
+
+// run runs the command named by the first argument and returns the exit code.
+func run(args []string, env *environment) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" ||
+		args[0] == "--help" {
+
+		usage(env.stderr)
+		return 2
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:], env)
+		}
+	}
+
+	fmt.Fprintf(env.stderr, errFmtUnknownCommand+"\n", args[0])
+	usage(env.stderr)
+	return 2
+}
+
+// usage writes out the commands.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: ultimateq <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %-8v %v\n", cmd.name, cmd.desc)
+	}
+}
+
+// flagSet creates the flags of a command, they write their errors and usage
+// to stderr.
+func flagSet(name string, env *environment) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	flags.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(env.stderr, "usage: ultimateq %v %v\n",
+					cmd.name, cmd.usage)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// prompt asks a question and reads the answer, the answer is def if nothing
+// is entered.
+func prompt(env *environment, question, def string) (string, error) {
+	if len(def) > 0 {
+		fmt.Fprintf(env.stdout, "%v [%v]: ", question, def)
+	} else {
+		fmt.Fprintf(env.stdout, "%v: ", question)
+	}
+
+	line, err := env.stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); len(line) == 0 {
+		line = def
+	}
+	return line, nil
+}
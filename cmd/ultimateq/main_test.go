@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/aarondl/ultimateq/data"
+	"io/ioutil"
+	"os"
+	"strings"
+	. "testing"
+)
+
+func init() {
+	data.UserAccessPwdCost = 4 // See bcrypt.MinCost
+}
+
+// testEnv creates an environment reading input and writing to buffers.
+func testEnv(input string) (env *environment, stdout, stderr *bytes.Buffer) {
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	env = &environment{
+		stdin:  bufio.NewReader(strings.NewReader(input)),
+		stdout: stdout,
+		stderr: stderr,
+	}
+	return
+}
+
+// tempDir creates a directory for a test's files, remove it when done.
+func tempDir(t *T) string {
+	dir, err := ioutil.TempDir("", "ultimateq")
+	if err != nil {
+		t.Fatal("Could not create temp dir:", err)
+	}
+	return dir
+}
+
+func TestMain_Run(t *T) {
+	t.Parallel()
+
+	env, _, stderr := testEnv("")
+	if code := run(nil, env); code != 2 {
+		t.Error("Expected exit code 2, got:", code)
+	}
+	for _, cmd := range commands {
+		if !strings.Contains(stderr.String(), cmd.name) {
+			t.Error("Expected the usage to name", cmd.name)
+		}
+	}
+
+	env, _, stderr = testEnv("")
+	if code := run([]string{"dance"}, env); code != 2 {
+		t.Error("Expected exit code 2, got:", code)
+	}
+	if !strings.Contains(stderr.String(), "Unknown command (dance)") {
+		t.Error("Expected an unknown command error, got:", stderr)
+	}
+
+	env, _, stderr = testEnv("")
+	if code := run([]string{"check", "-h"}, env); code != 2 {
+		t.Error("Expected exit code 2, got:", code)
+	}
+	if !strings.Contains(stderr.String(), "usage: ultimateq check") {
+		t.Error("Expected the command's usage, got:", stderr)
+	}
+}
+
+func TestMain_Prompt(t *T) {
+	t.Parallel()
+
+	env, stdout, _ := testEnv("answer\n\nlast")
+	if s, err := prompt(env, "Question", "def"); err != nil || s != "answer" {
+		t.Error("Expected answer, got:", s, err)
+	}
+	if s, err := prompt(env, "Question", "def"); err != nil || s != "def" {
+		t.Error("Expected the default, got:", s, err)
+	}
+	if s, err := prompt(env, "Question", ""); err != nil || s != "last" {
+		t.Error("Expected an answer without a newline, got:", s, err)
+	}
+	if _, err := prompt(env, "Question", ""); err == nil {
+		t.Error("Expected an error at the end of the input.")
+	}
+	exp := "Question [def]: Question [def]: Question: Question: "
+	if stdout.String() != exp {
+		t.Errorf("Expected: %q, got: %q", exp, stdout)
+	}
+}
+
+func TestMain_Commands(t *T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := dir + "/bot.toml"
+
+	env, _, stderr := testEnv("")
+	code := run([]string{"init", "-config", file, "-nick", "bot",
+		"-username", "bot", "-userhost", "bot.net", "-realname", "Bot",
+		"-server", "irc.test.net", "-store", dir + "/store.db"}, env)
+	if code != 0 {
+		t.Fatal("Expected init to succeed, got:", stderr)
+	}
+
+	env, _, stderr = testEnv("")
+	if code = run([]string{"check", "-config", file}, env); code != 0 {
+		t.Error("Expected check to succeed, got:", stderr)
+	}
+
+	env, _, stderr = testEnv("")
+	if code = run([]string{"adduser", "-config", file, "admin", "pass"},
+		env); code != 0 {
+		t.Error("Expected adduser to succeed, got:", stderr)
+	}
+}
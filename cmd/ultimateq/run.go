@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aarondl/ultimateq/bot"
+	"github.com/aarondl/ultimateq/config"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultQuitMessage is sent to the servers when the bot is stopped.
+	defaultQuitMessage = "Shutting down."
+	// defaultQuitTimeout is how long the servers have to close the link after
+	// the quit message before they're stopped.
+	defaultQuitTimeout = 10 * time.Second
+)
+
+// runner is the part of the bot that serve drives.
+type runner interface {
+	Start() <-chan error
+	Stop()
+	Rehash() error
+	Close() error
+	Quit(msg string)
+}
+
+// botRunner is the runner for a bot.
+type botRunner struct {
+	*bot.Bot
+}
+
+// Quit sends a quit message to every server.
+func (b botRunner) Quit(msg string) {
+	var servers []string
+	b.ReadConfig(func(conf *config.Config) {
+		for name := range conf.Servers {
+			servers = append(servers, name)
+		}
+	})
+	sort.Strings(servers)
+
+	for _, server := range servers {
+		if endpoint := b.GetEndpoint(server); endpoint != nil {
+			endpoint.Quit(msg)
+		}
+	}
+}
+
+// cmdRun creates the bot from a config file and runs it until it's stopped by
+// SIGTERM or an interrupt. SIGHUP rehashes the config file.
+func cmdRun(args []string, env *environment) int {
+	flags := flagSet("run", env)
+	file := flags.String("config", defaultConfigFile, "the config file")
+	watch := flags.Duration("watch", 0,
+		"how often to check the config file for changes, 0 to not watch it")
+	quit := flags.String("quit", defaultQuitMessage,
+		"the quit message sent when stopping")
+	quitTimeout := flags.Duration("quit-timeout", defaultQuitTimeout,
+		"how long the servers have to close the link after the quit message")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	conf := config.CreateConfigFromFile(*file)
+	if len(conf.Errors) > 0 {
+		for _, err := range conf.Errors {
+			fmt.Fprintln(env.stderr, conf.Redact(err.Error()))
+		}
+		return 1
+	}
+	b, err := bot.CreateBot(conf)
+	if err != nil {
+		fmt.Fprintln(env.stderr, err)
+		return 1
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	if *watch > 0 {
+		b.WatchConfig(*watch, nil)
+	}
+	return serve(botRunner{b}, signals, *quit, *quitTimeout, env)
+}
+
+// serve starts the runner and handles signals until all of it's servers have
+// stopped. SIGHUP rehashes, any other signal sends the quit message to the
+// servers and waits for them to close the link, stopping them if they haven't
+// within the timeout. A second one gives up on stopping gracefully. The exit
+// code is 0 if the servers stopped because of a signal.
+func serve(r runner, signals <-chan os.Signal, quit string,
+	timeout time.Duration, env *environment) int {
+
+	end := r.Start()
+	defer r.Close()
+
+	stopping := false
+	var expired <-chan time.Time
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if err := r.Rehash(); err != nil {
+					fmt.Fprintln(env.stderr, "Rehash failed:", err)
+				} else {
+					fmt.Fprintln(env.stderr, "Rehashed.")
+				}
+				continue
+			}
+
+			if stopping {
+				fmt.Fprintln(env.stderr, "Stopping immediately.")
+				return 1
+			}
+			stopping = true
+			fmt.Fprintln(env.stderr, "Shutting down...")
+			r.Quit(quit)
+			expired = time.After(timeout)
+
+		case <-expired:
+			expired = nil
+			fmt.Fprintln(env.stderr, "Servers did not quit, stopping them.")
+			go r.Stop()
+
+		case err, ok := <-end:
+			if !ok {
+				if stopping {
+					return 0
+				}
+				return 1
+			}
+			fmt.Fprintln(env.stderr, "Server death:", err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	. "testing"
+	"time"
+)
+
+// testRunner records what serve does with it.
+type testRunner struct {
+	end    chan error
+	calls  []string
+	rehash error
+	// quit and stop are when Quit and Stop were called.
+	quit time.Time
+	stop time.Time
+
+	protect sync.Mutex
+}
+
+func (r *testRunner) call(name string) {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+	r.calls = append(r.calls, name)
+}
+
+func (r *testRunner) getCalls() string {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+	return strings.Join(r.calls, " ")
+}
+
+func (r *testRunner) Start() <-chan error {
+	r.call("start")
+	return r.end
+}
+
+func (r *testRunner) Stop() {
+	r.call("stop")
+	r.protect.Lock()
+	r.stop = time.Now()
+	r.protect.Unlock()
+	close(r.end)
+}
+
+func (r *testRunner) Rehash() error {
+	r.call("rehash")
+	return r.rehash
+}
+
+func (r *testRunner) Close() error {
+	r.call("close")
+	return nil
+}
+
+func (r *testRunner) Quit(msg string) {
+	r.call("quit " + msg)
+	r.protect.Lock()
+	r.quit = time.Now()
+	r.protect.Unlock()
+}
+
+func TestRun_Serve(t *T) {
+	t.Parallel()
+
+	r := &testRunner{end: make(chan error)}
+	signals := make(chan os.Signal)
+	env, _, stderr := testEnv("")
+	done := make(chan int)
+	go func() {
+		done <- serve(r, signals, "bye", 50*time.Millisecond, env)
+	}()
+
+	signals <- syscall.SIGHUP
+	r.end <- errors.New("dead")
+	signals <- syscall.SIGTERM
+
+	if code := <-done; code != 0 {
+		t.Error("Expected exit code 0, got:", code)
+	}
+	if calls := r.getCalls(); calls != "start rehash quit bye stop close" {
+		t.Error("Expected the bot to be stopped gracefully, got:", calls)
+	}
+	if wait := r.stop.Sub(r.quit); wait < 50*time.Millisecond {
+		t.Error("Expected the bot to wait for the servers to quit, waited:",
+			wait)
+	}
+	for _, expect := range []string{"Rehashed", "Server death: dead",
+		"Shutting down", "Servers did not quit"} {
+		if !strings.Contains(stderr.String(), expect) {
+			t.Errorf("Expected %q to be logged, got: %v", expect, stderr)
+		}
+	}
+}
+
+func TestRun_ServeQuit(t *T) {
+	t.Parallel()
+
+	r := &testRunner{end: make(chan error)}
+	signals := make(chan os.Signal)
+	env, _, stderr := testEnv("")
+	done := make(chan int)
+	go func() {
+		done <- serve(r, signals, "bye", time.Hour, env)
+	}()
+
+	signals <- syscall.SIGTERM
+	// The servers close the link after the quit message.
+	close(r.end)
+
+	if code := <-done; code != 0 {
+		t.Error("Expected exit code 0, got:", code)
+	}
+	if calls := r.getCalls(); calls != "start quit bye close" {
+		t.Error("Expected the bot not to be stopped, got:", calls)
+	}
+	if strings.Contains(stderr.String(), "Servers did not quit") {
+		t.Error("Expected the servers to have quit, got:", stderr)
+	}
+}
+
+func TestRun_ServeRehashFailure(t *T) {
+	t.Parallel()
+
+	r := &testRunner{end: make(chan error), rehash: errors.New("bad")}
+	signals := make(chan os.Signal)
+	env, _, stderr := testEnv("")
+	done := make(chan int)
+	go func() {
+		done <- serve(r, signals, "bye", time.Millisecond, env)
+	}()
+
+	signals <- syscall.SIGHUP
+	signals <- os.Interrupt
+	<-done
+	if !strings.Contains(stderr.String(), "Rehash failed: bad") {
+		t.Error("Expected the rehash error to be logged, got:", stderr)
+	}
+}
+
+func TestRun_ServeEnded(t *T) {
+	t.Parallel()
+
+	r := &testRunner{end: make(chan error)}
+	env, _, _ := testEnv("")
+	done := make(chan int)
+	go func() {
+		done <- serve(r, nil, "bye", time.Hour, env)
+	}()
+
+	close(r.end)
+	if code := <-done; code != 1 {
+		t.Error("Expected exit code 1 when the servers die, got:", code)
+	}
+	if calls := r.getCalls(); calls != "start close" {
+		t.Error("Expected the bot to be closed, got:", calls)
+	}
+}
+
+func TestRun_Invalid(t *T) {
+	t.Parallel()
+
+	env, _, stderr := testEnv("")
+	if code := cmdRun([]string{"-config", "/none/config.yaml"},
+		env); code != 1 {
+		t.Error("Expected a missing config to fail.")
+	}
+	if len(stderr.String()) == 0 {
+		t.Error("Expected an error about the config.")
+	}
+}
@@ -0,0 +1,381 @@
+package config
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Channel errors.
+const (
+	errMsgChannelContext = "config: Channel settings require a channel, " +
+		"use .Channel()"
+	errChannelPrefix  = "channel prefix"
+	errChannelNoLog   = "channel nolog"
+	errChannelPrivate = "channel private"
+	errChannelRelay   = "channel relay"
+)
+
+// Channel holds the settings of a channel. Channels are configured on a server
+// or globally, the settings of a server's channel fall back to the settings
+// of the same channel in the global context and then to the server's settings.
+type Channel struct {
+	// Name of the channel.
+	Name string
+	// Key is used to join the channel.
+	Key string
+	// Prefix is the command prefix in this channel, the server's prefix is
+	// still accepted by the commands registered with the bot.
+	Prefix string
+	// Extensions are the only extensions enabled in this channel, all of them
+	// are if this is empty.
+	Extensions []string
+	// AutoOp and AutoVoice are masks of people given ops or voice when they
+	// join the channel.
+	AutoOp    []string
+	AutoVoice []string
+	// NoLog turns channel logging off for this channel.
+	NoLog string
+	// Private keeps what people do in this channel from being told outside
+	// of it, such as by the seen command.
+	Private string
+	// Relays are names of relays this channel is a link of.
+	Relays []string
+}
+
+// Channel fluently creates the settings of a channel in the current config
+// context and sets the channel context to it. The channel context is used by
+// ChannelKey, ChannelPrefix, ChannelExtensions, AutoOp, AutoVoice,
+// ChannelNoLog, ChannelPrivate and ChannelRelays.
+func (c *Config) Channel(name string) *Config {
+	if len(name) == 0 {
+		c.addError(fmtErrMissing, c.GetContext().GetName(), errChannel)
+		return c
+	}
+	context := c.GetContext()
+	if context.ChannelSettings == nil {
+		context.ChannelSettings = make(map[string]*Channel)
+	}
+	key := strings.ToLower(name)
+	if ch, ok := context.ChannelSettings[key]; ok {
+		c.channel = ch
+	} else {
+		c.channel = &Channel{Name: name}
+		context.ChannelSettings[key] = c.channel
+	}
+	return c
+}
+
+// ChannelKey fluently sets the key of the current channel.
+func (c *Config) ChannelKey(key string) *Config {
+	if c.channel == nil {
+		c.addError(errMsgChannelContext)
+		return c
+	}
+	c.channel.Key = key
+	return c
+}
+
+// ChannelPrefix fluently sets the command prefix of the current channel.
+func (c *Config) ChannelPrefix(prefix string) *Config {
+	if c.channel == nil {
+		c.addError(errMsgChannelContext)
+		return c
+	}
+	c.channel.Prefix = prefix
+	return c
+}
+
+// ChannelExtensions fluently sets the only extensions enabled in the current
+// channel.
+func (c *Config) ChannelExtensions(extensions ...string) *Config {
+	if c.channel == nil {
+		c.addError(errMsgChannelContext)
+		return c
+	}
+	c.channel.Extensions = make([]string, len(extensions))
+	copy(c.channel.Extensions, extensions)
+	return c
+}
+
+// AutoOp fluently sets the masks of people given ops when they join the
+// current channel.
+func (c *Config) AutoOp(masks ...string) *Config {
+	if c.channel == nil {
+		c.addError(errMsgChannelContext)
+		return c
+	}
+	c.channel.AutoOp = make([]string, len(masks))
+	copy(c.channel.AutoOp, masks)
+	return c
+}
+
+// AutoVoice fluently sets the masks of people given voice when they join the
+// current channel.
+func (c *Config) AutoVoice(masks ...string) *Config {
+	if c.channel == nil {
+		c.addError(errMsgChannelContext)
+		return c
+	}
+	c.channel.AutoVoice = make([]string, len(masks))
+	copy(c.channel.AutoVoice, masks)
+	return c
+}
+
+// ChannelNoLog fluently turns channel logging off for the current channel.
+func (c *Config) ChannelNoLog(nolog bool) *Config {
+	if c.channel == nil {
+		c.addError(errMsgChannelContext)
+		return c
+	}
+	c.channel.NoLog = strconv.FormatBool(nolog)
+	return c
+}
+
+// ChannelPrivate fluently makes the current channel private.
+func (c *Config) ChannelPrivate(private bool) *Config {
+	if c.channel == nil {
+		c.addError(errMsgChannelContext)
+		return c
+	}
+	c.channel.Private = strconv.FormatBool(private)
+	return c
+}
+
+// ChannelRelays fluently makes the current channel a link of the relays.
+func (c *Config) ChannelRelays(relays ...string) *Config {
+	if c.channel == nil {
+		c.addError(errMsgChannelContext)
+		return c
+	}
+	c.channel.Relays = make([]string, len(relays))
+	copy(c.channel.Relays, relays)
+	return c
+}
+
+// fixChannels names the channel settings after their keys and lowercases the
+// keys.
+func (s *Server) fixChannels() {
+	if len(s.ChannelSettings) == 0 {
+		return
+	}
+	settings := make(map[string]*Channel, len(s.ChannelSettings))
+	for key, ch := range s.ChannelSettings {
+		if ch == nil {
+			ch = &Channel{}
+		}
+		if len(ch.Name) == 0 {
+			ch.Name = key
+		}
+		settings[strings.ToLower(key)] = ch
+	}
+	s.ChannelSettings = settings
+}
+
+// cloneChannels deep copies channel settings.
+func cloneChannels(settings map[string]*Channel) map[string]*Channel {
+	if settings == nil {
+		return nil
+	}
+	clone := make(map[string]*Channel, len(settings))
+	for key, ch := range settings {
+		newch := *ch
+		newch.Extensions = append([]string(nil), ch.Extensions...)
+		newch.AutoOp = append([]string(nil), ch.AutoOp...)
+		newch.AutoVoice = append([]string(nil), ch.AutoVoice...)
+		newch.Relays = append([]string(nil), ch.Relays...)
+		clone[key] = &newch
+	}
+	return clone
+}
+
+// validateChannels checks the channel settings of a server for errors and adds
+// them to the validator.
+func (c *Config) validateChannels(v *validator, s *Server) {
+	keys := make([]string, 0, len(s.ChannelSettings))
+	for key := range s.ChannelSettings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		ch := s.ChannelSettings[key]
+		path := func(field string) []string {
+			return c.serverPath(s, "channelsettings", key, field)
+		}
+		if !rgxChannel.MatchString(ch.Name) {
+			v.invalid(path("name"), errChannel, ch.Name)
+		}
+		if len(ch.Prefix) > 1 {
+			v.invalid(path("prefix"), errChannelPrefix, ch.Prefix)
+		}
+		if len(ch.NoLog) != 0 {
+			if _, err := strconv.ParseBool(ch.NoLog); err != nil {
+				v.invalid(path("nolog"), errChannelNoLog, ch.NoLog)
+			}
+		}
+		if len(ch.Private) != 0 {
+			if _, err := strconv.ParseBool(ch.Private); err != nil {
+				v.invalid(path("private"), errChannelPrivate, ch.Private)
+			}
+		}
+		for _, relay := range ch.Relays {
+			if c.Relays[relay] == nil {
+				v.invalid(path("relays"), errChannelRelay, relay)
+			}
+		}
+	}
+}
+
+// GetRelayLinks gets the links of a relay, those it was given and the channels
+// that name it in their Relays. Channels in the global context are linked on
+// every server.
+func (c *Config) GetRelayLinks(name string) (links []RelayLink) {
+	if r := c.Relays[name]; r != nil {
+		links = r.GetLinks()
+	}
+
+	servers := make([]string, 0, len(c.Servers))
+	for server := range c.Servers {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+
+	for _, server := range servers {
+		for _, channel := range c.Servers[server].GetChannelNames() {
+			for _, relay := range c.Servers[server].GetChannelRelays(channel) {
+				if relay == name {
+					links = append(links, RelayLink{server, channel})
+				}
+			}
+		}
+	}
+	return
+}
+
+// getChannel gets the settings of a channel on the server and in the global
+// context, either may be nil.
+func (s *Server) getChannel(channel string) (srv, global *Channel) {
+	key := strings.ToLower(channel)
+	srv = s.ChannelSettings[key]
+	if s.parent != nil && s.parent.Global != s {
+		global = s.parent.Global.ChannelSettings[key]
+	}
+	return
+}
+
+// GetChannelNames gets the names of the channels with settings on the server
+// or in the global context, sorted.
+func (s *Server) GetChannelNames() (channels []string) {
+	seen := make(map[string]bool)
+	add := func(settings map[string]*Channel) {
+		for key, ch := range settings {
+			if !seen[key] {
+				seen[key] = true
+				channels = append(channels, ch.Name)
+			}
+		}
+	}
+	add(s.ChannelSettings)
+	if s.parent != nil {
+		add(s.parent.Global.ChannelSettings)
+	}
+	sort.Strings(channels)
+	return
+}
+
+// GetChannelKey gets the key of the channel, or the key of the channel in the
+// global context, or empty string.
+func (s *Server) GetChannelKey(channel string) (key string) {
+	srv, global := s.getChannel(channel)
+	if srv != nil && len(srv.Key) > 0 {
+		key = srv.Key
+	} else if global != nil && len(global.Key) > 0 {
+		key = global.Key
+	}
+	return
+}
+
+// GetChannelPrefix gets the prefix of the channel, or the prefix of the
+// channel in the global context, or the server's prefix.
+func (s *Server) GetChannelPrefix(channel string) rune {
+	srv, global := s.getChannel(channel)
+	if srv != nil && len(srv.Prefix) > 0 {
+		return rune(srv.Prefix[0])
+	} else if global != nil && len(global.Prefix) > 0 {
+		return rune(global.Prefix[0])
+	}
+	return s.GetPrefix()
+}
+
+// GetChannelExtensions gets the extensions enabled in the channel, or in the
+// channel in the global context, or a nil slice of string when they all are.
+func (s *Server) GetChannelExtensions(channel string) (extensions []string) {
+	srv, global := s.getChannel(channel)
+	if srv != nil && len(srv.Extensions) > 0 {
+		extensions = srv.Extensions
+	} else if global != nil && len(global.Extensions) > 0 {
+		extensions = global.Extensions
+	}
+	return
+}
+
+// GetAutoOp gets the auto-op masks of the channel, or of the channel in the
+// global context, or a nil slice of string.
+func (s *Server) GetAutoOp(channel string) (masks []string) {
+	srv, global := s.getChannel(channel)
+	if srv != nil && len(srv.AutoOp) > 0 {
+		masks = srv.AutoOp
+	} else if global != nil && len(global.AutoOp) > 0 {
+		masks = global.AutoOp
+	}
+	return
+}
+
+// GetAutoVoice gets the auto-voice masks of the channel, or of the channel in
+// the global context, or a nil slice of string.
+func (s *Server) GetAutoVoice(channel string) (masks []string) {
+	srv, global := s.getChannel(channel)
+	if srv != nil && len(srv.AutoVoice) > 0 {
+		masks = srv.AutoVoice
+	} else if global != nil && len(global.AutoVoice) > 0 {
+		masks = global.AutoVoice
+	}
+	return
+}
+
+// GetChannelNoLog gets NoLog of the channel, or of the channel in the global
+// context, or false.
+func (s *Server) GetChannelNoLog(channel string) (nolog bool) {
+	srv, global := s.getChannel(channel)
+	if srv != nil && len(srv.NoLog) > 0 {
+		nolog, _ = strconv.ParseBool(srv.NoLog)
+	} else if global != nil && len(global.NoLog) > 0 {
+		nolog, _ = strconv.ParseBool(global.NoLog)
+	}
+	return
+}
+
+// GetChannelPrivate gets Private of the channel, or of the channel in the
+// global context, or false.
+func (s *Server) GetChannelPrivate(channel string) (private bool) {
+	srv, global := s.getChannel(channel)
+	if srv != nil && len(srv.Private) > 0 {
+		private, _ = strconv.ParseBool(srv.Private)
+	} else if global != nil && len(global.Private) > 0 {
+		private, _ = strconv.ParseBool(global.Private)
+	}
+	return
+}
+
+// GetChannelRelays gets the relays the channel is a link of, or those of the
+// channel in the global context, or a nil slice of string.
+func (s *Server) GetChannelRelays(channel string) (relays []string) {
+	srv, global := s.getChannel(channel)
+	if srv != nil && len(srv.Relays) > 0 {
+		relays = srv.Relays
+	} else if global != nil && len(global.Relays) > 0 {
+		relays = global.Relays
+	}
+	return
+}
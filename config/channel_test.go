@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	. "gopkg.in/check.v1"
+	"os"
+)
+
+func channelConfig() *Config {
+	return relayConfig().
+		GlobalContext().
+		Prefix("!").
+		Channel("#both").
+		ChannelKey("globalkey").
+		ChannelPrefix("@").
+		ChannelExtensions("quotes").
+		AutoOp("*!*@ops.net").
+		AutoVoice("*!*@voice.net").
+		ChannelNoLog(true).
+		ChannelPrivate(true).
+		ServerContext(srv1.GetName()).
+		Channel("#Both").
+		ChannelKey("serverkey").
+		Channel("#one").
+		ChannelRelays("team").
+		Relay("team", srv2.GetName()+"/#team")
+}
+
+func (s *s) TestChannel_Fallbacks(c *C) {
+	conf := channelConfig()
+	c.Check(len(conf.Errors), Equals, 0)
+	c.Check(conf.IsValid(), Equals, true)
+
+	s1, s2 := conf.GetServer(srv1.GetName()), conf.GetServer(srv2.GetName())
+	c.Check(s1.GetChannelNames(), DeepEquals, []string{"#Both", "#one"})
+	c.Check(s2.GetChannelNames(), DeepEquals, []string{"#both"})
+
+	c.Check(s1.GetChannelKey("#BOTH"), Equals, "serverkey")
+	c.Check(s2.GetChannelKey("#both"), Equals, "globalkey")
+	c.Check(s1.GetChannelKey("#one"), Equals, "")
+
+	c.Check(s1.GetChannelPrefix("#both"), Equals, '@')
+	c.Check(s1.GetChannelPrefix("#one"), Equals, '!')
+	c.Check(s1.GetChannelPrefix("#none"), Equals, '!')
+
+	c.Check(s1.GetChannelExtensions("#both"), DeepEquals, []string{"quotes"})
+	c.Check(s1.GetChannelExtensions("#one"), IsNil)
+	c.Check(s2.GetAutoOp("#both"), DeepEquals, []string{"*!*@ops.net"})
+	c.Check(s2.GetAutoVoice("#both"), DeepEquals, []string{"*!*@voice.net"})
+	c.Check(s1.GetAutoOp("#one"), IsNil)
+	c.Check(s1.GetChannelNoLog("#both"), Equals, true)
+	c.Check(s1.GetChannelNoLog("#one"), Equals, false)
+	c.Check(s1.GetChannelPrivate("#both"), Equals, true)
+	c.Check(s1.GetChannelPrivate("#one"), Equals, false)
+	c.Check(s1.GetChannelRelays("#one"), DeepEquals, []string{"team"})
+
+	c.Check(conf.GetRelayLinks("team"), DeepEquals, []RelayLink{
+		{srv2.GetName(), "#team"}, {srv1.GetName(), "#one"}})
+}
+
+func (s *s) TestChannel_NoContext(c *C) {
+	conf := CreateConfig().
+		ChannelKey("key").
+		ChannelPrefix("!").
+		ChannelExtensions("a").
+		AutoOp("*").
+		AutoVoice("*").
+		ChannelNoLog(true).
+		ChannelPrivate(true).
+		ChannelRelays("a").
+		Channel("")
+	c.Check(len(conf.Errors), Equals, 9)
+	for _, err := range conf.Errors[:8] {
+		c.Check(err.Error(), Equals, errMsgChannelContext)
+	}
+	c.Check(conf.Errors[8].Error(), Matches, reqErr(errChannel))
+}
+
+func (s *s) TestChannel_Validation(c *C) {
+	conf := relayConfig().
+		GlobalContext().
+		Channel("nochan").
+		Channel("#chan").
+		ChannelPrefix("!!").
+		ChannelRelays("nope")
+	conf.Global.ChannelSettings["#chan"].NoLog = "maybe"
+	conf.Global.ChannelSettings["#chan"].Private = "perhaps"
+
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 5)
+	c.Check(conf.Errors[0].Error(), Matches, invErr(errChannelPrefix))
+	c.Check(conf.Errors[1].Error(), Matches, invErr(errChannelNoLog))
+	c.Check(conf.Errors[2].Error(), Matches, invErr(errChannelPrivate))
+	c.Check(conf.Errors[3].Error(), Matches, invErr(errChannelRelay))
+	c.Check(conf.Errors[4].Error(), Matches, invErr(errChannel)+"nochan")
+}
+
+func (s *s) TestChannel_Clone(c *C) {
+	conf := channelConfig()
+	newconf := conf.Clone()
+	newconf.Global.ChannelSettings["#both"].Key = "changed"
+	newconf.GetServer(srv1.GetName()).ChannelSettings["#one"].Relays[0] = "x"
+	both := newconf.Global.ChannelSettings["#both"]
+	both.Extensions[0] = "changed"
+	both.AutoOp[0] = "changed"
+	both.AutoVoice[0] = "changed"
+
+	orig := conf.Global.ChannelSettings["#both"]
+	c.Check(orig.Key, Equals, "globalkey")
+	c.Check(orig.Extensions, DeepEquals, []string{"quotes"})
+	c.Check(orig.AutoOp, DeepEquals, []string{"*!*@ops.net"})
+	c.Check(orig.AutoVoice, DeepEquals, []string{"*!*@voice.net"})
+	c.Check(conf.GetServer(srv1.GetName()).GetChannelRelays("#one"),
+		DeepEquals, []string{"team"})
+}
+
+func (s *s) TestChannel_File(c *C) {
+	buf := &bytes.Buffer{}
+	c.Check(FlushConfigToWriter(channelConfig(), buf), IsNil)
+	read := CreateConfigFromReader(buf)
+	c.Check(read.IsValid(), Equals, true)
+
+	s1 := read.GetServer(srv1.GetName())
+	c.Check(s1.GetChannelNames(), DeepEquals, []string{"#Both", "#one"})
+	c.Check(s1.GetChannelKey("#both"), Equals, "serverkey")
+	c.Check(s1.GetChannelPrefix("#both"), Equals, '@')
+	c.Check(s1.GetChannelNoLog("#both"), Equals, true)
+	c.Check(s1.GetChannelPrivate("#both"), Equals, true)
+	c.Check(s1.GetChannelRelays("#one"), DeepEquals, []string{"team"})
+
+	os.Setenv("UQ_TEST_KEY", "secretkey")
+	defer os.Unsetenv("UQ_TEST_KEY")
+	raw := `servers:
+    irc:
+        channelsettings:
+            "#Chan":
+                key: ${UQ_TEST_KEY}
+`
+	read = CreateConfigFromReader(bytes.NewBufferString(raw))
+	c.Check(len(read.Errors), Equals, 0)
+	srv := read.GetServer("irc")
+	c.Check(srv.GetChannelNames(), DeepEquals, []string{"#Chan"})
+	c.Check(srv.GetChannelKey("#chan"), Equals, "secretkey")
+
+	buf.Reset()
+	c.Check(FlushConfigToWriter(read, buf), IsNil)
+	c.Check(bytes.Contains(buf.Bytes(), []byte("${UQ_TEST_KEY}")), Equals, true)
+	c.Check(bytes.Contains(buf.Bytes(), []byte("secretkey")), Equals, false)
+	c.Check(srv.GetChannelKey("#chan"), Equals, "secretkey")
+}
@@ -1,15 +1,17 @@
 /*
 Package config provides several ways to configure an irc bot. Some methods are
-inline fluent configuration, yaml reading from any io.Reader. It also provides
-config validation.
+inline fluent configuration, yaml, json or toml reading from any io.Reader. It
+also provides config validation.
 */
 package config
 
 import (
 	"fmt"
 	"log"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -32,14 +34,30 @@ const (
 	// defaultKeepAlive is the default number of seconds to wait on an idle
 	// connection before sending a ping.
 	defaultKeepAlive = 60.0
+	// defaultWhoisLifetime is the default number of seconds the results of
+	// a WHOIS are kept by the state.
+	defaultWhoisLifetime = 300.0
 	// defaultReconnectTimeout is how many seconds to wait between reconns.
 	defaultReconnectTimeout = uint(20)
+	// defaultBouncerBuffer is how many recent lines are replayed to irc
+	// clients attaching to the bouncer.
+	defaultBouncerBuffer = uint(100)
+	// defaultLogFormat is the format channel logs are written in.
+	defaultLogFormat = LogText
 	// botDefaultPrefix is the command prefix by default
 	defaultPrefix = '.'
 	// maxHostSize is the biggest hostname possible
 	maxHostSize = 255
 )
 
+// Channel log formats, see LogFormat.
+const (
+	// LogText writes channel logs as text similar to irssi's.
+	LogText = "text"
+	// LogJSON writes channel logs with a JSON object on each line.
+	LogJSON = "json"
+)
+
 // The following format strings are for formatting various config errors.
 const (
 	fmtErrInvalid         = "config(%v): Invalid %v, given: %v"
@@ -47,6 +65,11 @@ const (
 	fmtErrServerNotFound  = "config: Server not found, given: %v"
 	errMsgServersRequired = "config: At least one server is required."
 	errMsgDuplicateServer = "config: Server names must be unique, use .Host()"
+	errMsgIdentityContext = "config: Identity requires a server context."
+	fmtErrDuplicateNick   = "config(%v): Nickname %v is already used by %v " +
+		"on network %v"
+	fmtErrDuplicateBouncer = "config(%v): Bouncer address %v is already " +
+		"used by %v, each server needs its own bouncerlisten"
 )
 
 // The following is for mapping config setting names to strings
@@ -62,8 +85,13 @@ const (
 	errFloodTimeout     = "floodprotecttimeout"
 	errFloodStep        = "floodprotectstep"
 	errKeepAlive        = "keepalive"
+	errWhoisLifetime    = "whoislifetime"
+	errResyncInterval   = "resyncinterval"
 	errNoReconnect      = "noreconnect"
 	errReconnectTimeout = "reconnecttimeout"
+	errBouncerBuffer    = "bouncerbuffer"
+	errSaslPass         = "sasl password"
+	errLogFormat        = "logformat"
 	errNick             = "nickname"
 	errAltnick          = "alternate nickname"
 	errRealname         = "realname"
@@ -119,8 +147,12 @@ var (
 type Config struct {
 	Servers   map[string]*Server
 	Global    *Server
+	Relays    map[string]*Relay
 	context   *Server
+	relay     *Relay
+	channel   *Channel
 	filename  string
+	format    string
 	Storefile string
 	Errors    []error "-"
 }
@@ -130,6 +162,7 @@ func CreateConfig() *Config {
 	return &Config{
 		Global:  &Server{},
 		Servers: make(map[string]*Server, nAssumedServers),
+		Relays:  make(map[string]*Relay),
 		Errors:  make([]error, 0),
 	}
 }
@@ -138,16 +171,24 @@ func CreateConfig() *Config {
 func (c *Config) Clone() *Config {
 	global := *c.Global
 	newconf := &Config{
-		Global:   &global,
-		Servers:  make(map[string]*Server, len(c.Servers)),
-		Errors:   make([]error, 0),
-		filename: c.filename,
+		Global:    &global,
+		Servers:   make(map[string]*Server, len(c.Servers)),
+		Relays:    make(map[string]*Relay, len(c.Relays)),
+		Errors:    make([]error, 0),
+		filename:  c.filename,
+		format:    c.format,
+		Storefile: c.Storefile,
 	}
+	global.ChannelSettings = cloneChannels(c.Global.ChannelSettings)
 	for name, srv := range c.Servers {
 		newsrv := *srv
 		newsrv.parent = newconf
+		newsrv.ChannelSettings = cloneChannels(srv.ChannelSettings)
 		newconf.Servers[name] = &newsrv
 	}
+	for name, relay := range c.Relays {
+		newconf.Relays[name] = relay.clone()
+	}
 	return newconf
 }
 
@@ -157,146 +198,220 @@ func (c *Config) addError(format string, args ...interface{}) {
 }
 
 // IsValid checks to see if the configuration is valid. If errors are found in
-// the config the Config.Errors property is filled with the validation errors.
-// These can be used to display to the user. See DisplayErrors for a display
-// helper.
+// the config the Config.Errors property is filled with the validation errors,
+// which are ValidationErrors. These can be used to display to the user. See
+// DisplayErrors for a display helper, and Validate for the errors alone.
 func (c *Config) IsValid() bool {
-	if len(c.Servers) == 0 {
-		c.addError(errMsgServersRequired)
-		return false
+	for _, err := range c.Validate() {
+		c.Errors = append(c.Errors, err)
 	}
+	return len(c.Errors) == 0
+}
 
-	c.validateServer(c.Global, false)
-	for _, s := range c.Servers {
-		c.validateServer(s, true)
+// validateIdentities checks that no two servers connecting to the same network
+// try to use the same nickname.
+func (c *Config) validateIdentities(v *validator) {
+	nicks := make(map[string]string)
+	for _, name := range c.serverNames() {
+		s := c.Servers[name]
+		nick := s.GetNick()
+		if len(nick) == 0 {
+			continue
+		}
+		key := s.GetNetwork() + " " + nick
+		if other, ok := nicks[key]; ok {
+			v.add(ValidationError{
+				Path:  c.serverPath(s, "nick"),
+				Value: nick,
+				Rule:  RuleDuplicate,
+				Message: fmt.Sprintf(fmtErrDuplicateNick, name, nick, other,
+					s.GetNetwork()),
+			})
+		} else {
+			nicks[key] = name
+		}
 	}
+}
 
-	return len(c.Errors) == 0
+// validateBouncers checks that no two servers try to listen for bouncer
+// clients on the same address, which happens when several servers inherit the
+// global bouncer address.
+func (c *Config) validateBouncers(v *validator) {
+	addresses := make(map[string]string)
+	for _, name := range c.serverNames() {
+		s := c.Servers[name]
+		address := s.GetBouncerListen()
+		if len(address) == 0 {
+			continue
+		}
+		if other, ok := addresses[address]; ok {
+			v.add(ValidationError{
+				Path:  c.serverPath(s, "bouncerlisten"),
+				Value: address,
+				Rule:  RuleDuplicate,
+				Message: fmt.Sprintf(fmtErrDuplicateBouncer, name, address,
+					other),
+			})
+		} else {
+			addresses[address] = name
+		}
+	}
 }
 
-// validateServer checks a server for errors and adds to the error collection
-// if any are found.
-func (c *Config) validateServer(s *Server, missingIsError bool) {
-	name := s.GetName()
+// validateServer checks a server for errors and adds them to the validator.
+func (c *Config) validateServer(v *validator, s *Server,
+	missingIsError bool) {
+
 	if len(s.Ssl) != 0 {
 		if _, err := strconv.ParseBool(s.Ssl); err != nil {
-			c.addError(fmtErrInvalid, name, errSsl, s.Ssl)
+			v.invalid(c.serverPath(s, "ssl"), errSsl, s.Ssl)
 		}
 	}
 
 	if len(s.NoVerifyCert) != 0 {
 		if _, err := strconv.ParseBool(s.NoVerifyCert); err != nil {
-			c.addError(fmtErrInvalid, name, errNoVerifyCert, s.NoVerifyCert)
+			v.invalid(c.serverPath(s, "noverifycert"), errNoVerifyCert,
+				s.NoVerifyCert)
 		}
 	}
 
 	if len(s.NoState) != 0 {
 		if _, err := strconv.ParseBool(s.NoState); err != nil {
-			c.addError(fmtErrInvalid, name, errNoState, s.NoState)
+			v.invalid(c.serverPath(s, "nostate"), errNoState, s.NoState)
 		}
 	}
 
 	if len(s.NoStore) != 0 {
 		if _, err := strconv.ParseBool(s.NoStore); err != nil {
-			c.addError(fmtErrInvalid, name, errNoStore, s.NoStore)
+			v.invalid(c.serverPath(s, "nostore"), errNoStore, s.NoStore)
 		}
 	}
 
 	if len(s.FloodLenPenalty) != 0 {
 		if _, err :=
 			strconv.ParseUint(s.FloodLenPenalty, 10, 32); err != nil {
-			c.addError(fmtErrInvalid, name, errFloodLenPenalty,
+			v.invalid(c.serverPath(s, "floodlenpenalty"), errFloodLenPenalty,
 				s.FloodLenPenalty)
 		}
 	}
 
 	if len(s.FloodTimeout) != 0 {
 		if _, err := strconv.ParseFloat(s.FloodTimeout, 32); err != nil {
-			c.addError(fmtErrInvalid, name, errFloodTimeout,
+			v.invalid(c.serverPath(s, "floodtimeout"), errFloodTimeout,
 				s.FloodTimeout)
 		}
 	}
 
 	if len(s.FloodStep) != 0 {
 		if _, err := strconv.ParseFloat(s.FloodStep, 32); err != nil {
-			c.addError(fmtErrInvalid, name, errFloodStep,
+			v.invalid(c.serverPath(s, "floodstep"), errFloodStep,
 				s.FloodStep)
 		}
 	}
 
 	if len(s.KeepAlive) != 0 {
 		if _, err := strconv.ParseFloat(s.KeepAlive, 32); err != nil {
-			c.addError(fmtErrInvalid, name, errKeepAlive,
+			v.invalid(c.serverPath(s, "keepalive"), errKeepAlive,
 				s.KeepAlive)
 		}
 	}
 
+	if len(s.WhoisLifetime) != 0 {
+		if _, err := strconv.ParseFloat(s.WhoisLifetime, 32); err != nil {
+			v.invalid(c.serverPath(s, "whoislifetime"), errWhoisLifetime,
+				s.WhoisLifetime)
+		}
+	}
+
+	if len(s.ResyncInterval) != 0 {
+		if _, err := strconv.ParseFloat(s.ResyncInterval, 32); err != nil {
+			v.invalid(c.serverPath(s, "resyncinterval"), errResyncInterval,
+				s.ResyncInterval)
+		}
+	}
+
 	if len(s.NoReconnect) != 0 {
 		if _, err := strconv.ParseBool(s.NoReconnect); err != nil {
-			c.addError(fmtErrInvalid, name, errNoReconnect,
+			v.invalid(c.serverPath(s, "noreconnect"), errNoReconnect,
 				s.NoReconnect)
 		}
 	}
 
 	if len(s.ReconnectTimeout) != 0 {
 		if _, err := strconv.ParseUint(s.ReconnectTimeout, 10, 32); err != nil {
-			c.addError(fmtErrInvalid, name, errReconnectTimeout,
-				s.ReconnectTimeout)
+			v.invalid(c.serverPath(s, "reconnecttimeout"),
+				errReconnectTimeout, s.ReconnectTimeout)
+		}
+	}
+
+	if len(s.BouncerBuffer) != 0 {
+		if _, err := strconv.ParseUint(s.BouncerBuffer, 10, 32); err != nil {
+			v.invalid(c.serverPath(s, "bouncerbuffer"), errBouncerBuffer,
+				s.BouncerBuffer)
 		}
 	}
 
+	if len(s.LogFormat) != 0 && s.LogFormat != LogText &&
+		s.LogFormat != LogJSON {
+		v.invalid(c.serverPath(s, "logformat"), errLogFormat, s.LogFormat)
+	}
+
+	if len(s.GetSaslUser()) != 0 && len(s.GetSaslPass()) == 0 {
+		v.missing(c.serverPath(s, "saslpass"), errSaslPass)
+	}
+
 	if host := s.GetHost(); len(host) == 0 {
 		if missingIsError {
-			c.addError(fmtErrMissing, name, errHost)
+			v.missing(c.serverPath(s, "host"), errHost)
 		}
 	} else if !rgxHost.MatchString(host) || len(host) > maxHostSize {
-		c.addError(fmtErrInvalid, name, errHost, host)
+		v.invalid(c.serverPath(s, "host"), errHost, host)
 	}
 
 	if nick := s.GetNick(); len(nick) == 0 {
 		if missingIsError {
-			c.addError(fmtErrMissing, name, errNick)
+			v.missing(c.serverPath(s, "nick"), errNick)
 		}
 	} else if !rgxNickname.MatchString(nick) {
-		c.addError(fmtErrInvalid, name, errNick, nick)
+		v.invalid(c.serverPath(s, "nick"), errNick, nick)
 	}
 
 	if username := s.GetUsername(); len(username) == 0 {
 		if missingIsError {
-			c.addError(fmtErrMissing, name, errUsername)
+			v.missing(c.serverPath(s, "username"), errUsername)
 		}
 	} else if !rgxUsername.MatchString(username) {
-		c.addError(fmtErrInvalid, name, errUsername, username)
+		v.invalid(c.serverPath(s, "username"), errUsername, username)
 	}
 
 	if userhost := s.GetUserhost(); len(userhost) == 0 {
 		if missingIsError {
-			c.addError(fmtErrMissing, name, errUserhost)
+			v.missing(c.serverPath(s, "userhost"), errUserhost)
 		}
 	} else if !rgxHost.MatchString(userhost) {
-		c.addError(fmtErrInvalid, name, errUserhost, userhost)
+		v.invalid(c.serverPath(s, "userhost"), errUserhost, userhost)
 	}
 
 	if realname := s.GetRealname(); len(realname) == 0 {
 		if missingIsError {
-			c.addError(fmtErrMissing, name, errRealname)
+			v.missing(c.serverPath(s, "realname"), errRealname)
 		}
 	} else if !rgxRealname.MatchString(realname) {
-		c.addError(fmtErrInvalid, name, errRealname, realname)
+		v.invalid(c.serverPath(s, "realname"), errRealname, realname)
 	}
 
 	for _, channel := range s.GetChannels() {
 		if !rgxChannel.MatchString(channel) {
-			c.addError(fmtErrInvalid, name, errChannel, channel)
+			v.invalid(c.serverPath(s, "channels"), errChannel, channel)
 		}
 	}
 }
 
 // DisplayErrors is a helper function to log the output of all config to the
-// standard logger.
+// standard logger. Values read from the environment or files are redacted.
 func (c *Config) DisplayErrors() {
 	for _, e := range c.Errors {
-		log.Println(e.Error())
+		log.Println(c.Redact(e.Error()))
 	}
 }
 
@@ -349,6 +464,40 @@ func (c *Config) Server(name string) *Config {
 	return c
 }
 
+// Identity fluently creates another server connecting to the same network as
+// the current server context and sets the context to it. The connection
+// details are copied from the current context so only the irc user data and
+// other settings that should differ have to be given. Each identity is it's
+// own server in the bot with it's own connection and state.
+func (c *Config) Identity(name string) *Config {
+	if c.context == nil {
+		c.addError(errMsgIdentityContext)
+		return c
+	}
+	if len(name) == 0 {
+		c.addError(fmtErrMissing, "<NONE>", errHost)
+		return c
+	}
+	if _, ok := c.Servers[name]; ok {
+		c.addError(errMsgDuplicateServer)
+		return c
+	}
+
+	from := c.context
+	c.context = &Server{
+		parent:       c,
+		Name:         name,
+		Network:      from.GetNetwork(),
+		Host:         from.Host,
+		Port:         from.Port,
+		Ssl:          from.Ssl,
+		SslCert:      from.SslCert,
+		NoVerifyCert: from.NoVerifyCert,
+	}
+	c.Servers[name] = c.context
+	return c
+}
+
 // RemoveServer removes a server by name. Note that this does not work on
 // host if a name has been set on the server.
 func (c *Config) RemoveServer(name string) (deleted bool) {
@@ -367,6 +516,15 @@ func (c *Config) Host(host string) *Config {
 	return c
 }
 
+// Network fluently sets the network for the current config context. Servers
+// with the same network are identities of the bot on that network.
+func (c *Config) Network(network string) *Config {
+	if c.context != nil {
+		c.context.Network = network
+	}
+	return c
+}
+
 // Port fluently sets the port for the current config context
 func (c *Config) Port(port uint16) *Config {
 	c.GetContext().Port = port
@@ -441,6 +599,98 @@ func (c *Config) KeepAlive(floodstep float64) *Config {
 	return c
 }
 
+// WhoisLifetime fluently sets the whois lifetime for the current config
+// context, this is how many seconds the results of a WHOIS are kept by the
+// state before they have to be asked for again.
+func (c *Config) WhoisLifetime(seconds float64) *Config {
+	c.GetContext().WhoisLifetime = strconv.FormatFloat(seconds, 'e', -1, 64)
+	return c
+}
+
+// ResyncInterval fluently sets the resync interval for the current config
+// context, this is how many seconds apart the state of every channel is asked
+// for again and corrected where it's wrong. 0 never does.
+func (c *Config) ResyncInterval(seconds float64) *Config {
+	c.GetContext().ResyncInterval = strconv.FormatFloat(seconds, 'e', -1, 64)
+	return c
+}
+
+// RecordFile fluently sets the record file for the current config context,
+// every line read from and written to the server is appended to this file
+// along with the time it happened. When it's set globally each server records
+// to a file named after it, see Server.GetRecordFile. See inet.Recorder.
+func (c *Config) RecordFile(filename string) *Config {
+	c.GetContext().RecordFile = filename
+	return c
+}
+
+// Caps fluently sets the IRCv3 capabilities to request from the server for
+// the current config context. Capability negotiation only happens if at least
+// one capability is requested. The server may not support them all. The state
+// tracks accounts, away messages and host changes when account-notify,
+// extended-join, away-notify, chghost and setname are requested.
+func (c *Config) Caps(caps ...string) *Config {
+	if len(caps) > 0 {
+		context := c.GetContext()
+		context.Caps = make([]string, len(caps))
+		copy(context.Caps, caps)
+	}
+	return c
+}
+
+// Sasl fluently sets the account and password used to authenticate with
+// SASL PLAIN for the current config context. The sasl capability is requested
+// automatically when an account is set.
+func (c *Config) Sasl(user, password string) *Config {
+	context := c.GetContext()
+	context.SaslUser = user
+	context.SaslPass = password
+	return c
+}
+
+// BouncerListen fluently sets the address the bouncer listens on for the
+// current config context. Irc clients that connect to it are attached to the
+// bot's connection to the server. The bouncer is off if this is empty. No two
+// servers may listen on the same address.
+func (c *Config) BouncerListen(address string) *Config {
+	c.GetContext().BouncerListen = address
+	return c
+}
+
+// BouncerBuffer fluently sets how many recent lines are replayed to irc
+// clients attaching to the bouncer for the current config context.
+func (c *Config) BouncerBuffer(lines uint) *Config {
+	c.GetContext().BouncerBuffer = strconv.FormatUint(uint64(lines), 10)
+	return c
+}
+
+// LogDir fluently sets the directory channel logs are written to for the
+// current config context. Logs are written to a directory for each network
+// and a file for each channel and day. Logging is off if this is empty.
+func (c *Config) LogDir(dir string) *Config {
+	c.GetContext().LogDir = dir
+	return c
+}
+
+// LogFormat fluently sets the format of the channel logs for the current
+// config context, either LogText or LogJSON.
+func (c *Config) LogFormat(format string) *Config {
+	c.GetContext().LogFormat = format
+	return c
+}
+
+// LogIgnore fluently sets the masks of the users whose events are not written
+// to channel logs for the current config context. A mask without a ! or @ is
+// taken to be a nick.
+func (c *Config) LogIgnore(masks ...string) *Config {
+	if len(masks) > 0 {
+		context := c.GetContext()
+		context.LogIgnore = make([]string, len(masks))
+		copy(context.LogIgnore, masks)
+	}
+	return c
+}
+
 // NoReconnect fluently sets reconnection for the current config context
 func (c *Config) NoReconnect(noreconnect bool) *Config {
 	c.GetContext().NoReconnect = strconv.FormatBool(noreconnect)
@@ -505,9 +755,13 @@ func (c *Config) Channels(channels ...string) *Config {
 // be used to preserve correct global-value resolution.
 type Server struct {
 	parent *Config
+	// interpolated holds the values resolved while loading by field name.
+	interpolated map[string]interpolation
 
 	// Name of this connection
 	Name string
+	// Network this connection is an identity on, defaults to the host.
+	Network string
 
 	// Irc Server connection info
 	Host string
@@ -530,6 +784,31 @@ type Server struct {
 	// Keep alive
 	KeepAlive string
 
+	// Seconds to keep WHOIS results for
+	WhoisLifetime string
+
+	// Seconds between resyncs of the channels' state
+	ResyncInterval string
+
+	// Session recording
+	RecordFile string
+
+	// IRCv3 capabilities to request
+	Caps []string
+
+	// SASL authentication
+	SaslUser string
+	SaslPass string
+
+	// Bouncer
+	BouncerListen string
+	BouncerBuffer string
+
+	// Channel logging
+	LogDir    string
+	LogFormat string
+	LogIgnore []string
+
 	// Auto reconnection
 	NoReconnect      string
 	ReconnectTimeout string
@@ -544,6 +823,9 @@ type Server struct {
 	// Dispatching options
 	Prefix   string
 	Channels []string
+
+	// Per channel settings keyed by lowercased channel name, see Channel.
+	ChannelSettings map[string]*Channel
 }
 
 // GetFilename returns fileName of the configuration, or the default.
@@ -555,6 +837,15 @@ func (c *Config) GetFilename() (filename string) {
 	return
 }
 
+// GetFormat returns the format the configuration was read in, or yaml.
+func (c *Config) GetFormat() (format string) {
+	format = FormatYAML
+	if len(c.format) > 0 {
+		format = c.format
+	}
+	return
+}
+
 // StoreFile fluently sets the storefile for the global context.
 func (c *Config) StoreFile(storefile string) *Config {
 	c.Storefile = storefile
@@ -580,6 +871,14 @@ func (s *Server) GetName() string {
 	return s.Name
 }
 
+// GetNetwork gets s.network, or the host if it's not set.
+func (s *Server) GetNetwork() string {
+	if len(s.Network) > 0 {
+		return s.Network
+	}
+	return s.Host
+}
+
 // GetPort returns gets Port of the server, or the global port, or
 // ircDefaultPort
 func (s *Server) GetPort() (port uint16) {
@@ -755,6 +1054,156 @@ func (s *Server) GetKeepAlive() (keepAlive float64) {
 	return
 }
 
+// GetResyncInterval gets ResyncInterval of the server, or the global
+// resyncInterval, or 0.
+func (s *Server) GetResyncInterval() (resyncInterval float64) {
+	var err error
+	if len(s.ResyncInterval) != 0 {
+		resyncInterval, err = strconv.ParseFloat(s.ResyncInterval, 32)
+	} else if s.parent != nil && len(s.parent.Global.ResyncInterval) != 0 {
+		resyncInterval, err = strconv.ParseFloat(
+			s.parent.Global.ResyncInterval, 32)
+	}
+
+	if err != nil {
+		resyncInterval = 0
+	}
+	return
+}
+
+// GetWhoisLifetime gets WhoisLifetime of the server, or the global
+// whoisLifetime, or defaultWhoisLifetime.
+func (s *Server) GetWhoisLifetime() (whoisLifetime float64) {
+	var err error
+	whoisLifetime = defaultWhoisLifetime
+	if len(s.WhoisLifetime) != 0 {
+		whoisLifetime, err = strconv.ParseFloat(s.WhoisLifetime, 32)
+	} else if s.parent != nil && len(s.parent.Global.WhoisLifetime) != 0 {
+		whoisLifetime, err = strconv.ParseFloat(
+			s.parent.Global.WhoisLifetime, 32)
+	}
+
+	if err != nil {
+		whoisLifetime = defaultWhoisLifetime
+	}
+	return
+}
+
+// GetRecordFile gets RecordFile of the server, or the global recordFile with
+// the server's name before its extension so each server records to its own
+// file, or empty string.
+func (s *Server) GetRecordFile() (recordFile string) {
+	if len(s.RecordFile) > 0 {
+		recordFile = s.RecordFile
+	} else if s.parent != nil && len(s.parent.Global.RecordFile) > 0 {
+		recordFile = s.parent.Global.RecordFile
+		ext := filepath.Ext(recordFile)
+		recordFile = strings.TrimSuffix(recordFile, ext) + "." + s.Name + ext
+	}
+	return
+}
+
+// GetCaps gets Caps of the server, or the global caps, or nil.
+func (s *Server) GetCaps() (caps []string) {
+	if len(s.Caps) > 0 {
+		caps = s.Caps
+	} else if s.parent != nil && len(s.parent.Global.Caps) > 0 {
+		caps = s.parent.Global.Caps
+	}
+	return
+}
+
+// GetSaslUser gets SaslUser of the server, or the global saslUser, or empty
+// string.
+func (s *Server) GetSaslUser() (user string) {
+	if len(s.SaslUser) > 0 {
+		user = s.SaslUser
+	} else if s.parent != nil && len(s.parent.Global.SaslUser) > 0 {
+		user = s.parent.Global.SaslUser
+	}
+	return
+}
+
+// GetSaslPass gets SaslPass of the server, or the global saslPass, or empty
+// string.
+func (s *Server) GetSaslPass() (password string) {
+	if len(s.SaslPass) > 0 {
+		password = s.SaslPass
+	} else if s.parent != nil && len(s.parent.Global.SaslPass) > 0 {
+		password = s.parent.Global.SaslPass
+	}
+	return
+}
+
+// GetBouncerListen gets BouncerListen of the server, or the global
+// bouncerListen, or empty string.
+func (s *Server) GetBouncerListen() (address string) {
+	if len(s.BouncerListen) > 0 {
+		address = s.BouncerListen
+	} else if s.parent != nil && len(s.parent.Global.BouncerListen) > 0 {
+		address = s.parent.Global.BouncerListen
+	}
+	return
+}
+
+// GetBouncerBuffer gets BouncerBuffer of the server, or the global
+// bouncerBuffer, or defaultBouncerBuffer
+func (s *Server) GetBouncerBuffer() (lines uint) {
+	var notset bool
+	var err error
+	var u uint64
+	lines = defaultBouncerBuffer
+	if len(s.BouncerBuffer) != 0 {
+		u, err = strconv.ParseUint(s.BouncerBuffer, 10, 32)
+	} else if s.parent != nil && len(s.parent.Global.BouncerBuffer) != 0 {
+		u, err = strconv.ParseUint(s.parent.Global.BouncerBuffer, 10, 32)
+	} else {
+		notset = true
+	}
+
+	if err != nil {
+		lines = defaultBouncerBuffer
+	} else if !notset {
+		lines = uint(u)
+	}
+	return
+}
+
+// GetLogDir gets LogDir of the server, or the global logDir, or empty string.
+func (s *Server) GetLogDir() (dir string) {
+	if len(s.LogDir) > 0 {
+		dir = s.LogDir
+	} else if s.parent != nil && len(s.parent.Global.LogDir) > 0 {
+		dir = s.parent.Global.LogDir
+	}
+	return
+}
+
+// GetLogFormat gets LogFormat of the server, or the global logFormat, or
+// defaultLogFormat.
+func (s *Server) GetLogFormat() (format string) {
+	format = defaultLogFormat
+	if len(s.LogFormat) > 0 {
+		format = s.LogFormat
+	} else if s.parent != nil && len(s.parent.Global.LogFormat) > 0 {
+		format = s.parent.Global.LogFormat
+	}
+	if format != LogText && format != LogJSON {
+		format = defaultLogFormat
+	}
+	return
+}
+
+// GetLogIgnore gets LogIgnore of the server, or the global logIgnore, or nil.
+func (s *Server) GetLogIgnore() (masks []string) {
+	if len(s.LogIgnore) > 0 {
+		masks = s.LogIgnore
+	} else if s.parent != nil && len(s.parent.Global.LogIgnore) > 0 {
+		masks = s.parent.Global.LogIgnore
+	}
+	return
+}
+
 // GetReconnectTimeout gets ReconnectTimeout of the server, or the global
 // reconnectTimeout, or defaultReconnectTimeout
 func (s *Server) GetReconnectTimeout() (reconnTimeout uint) {
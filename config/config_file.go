@@ -1,7 +1,6 @@
 package config
 
 import (
-	"gopkg.in/yaml.v1"
 	"io"
 	"io/ioutil"
 	"os"
@@ -11,7 +10,7 @@ const (
 	// defaultConfigFileName specifies a config file name in the event that
 	// none was given, but a write to the file is requested with no name given.
 	defaultConfigFileName = "config.yaml"
-	// errMsgInvalidConfigFile is when the yaml does not successfully parse
+	// errMsgInvalidConfigFile is when the file does not successfully parse
 	errMsgInvalidConfigFile = "config: Failed to load config file (%v)"
 	// errMsgFileError occurs if the file could not be opened.
 	errMsgFileError = "config: Failed to open config file (%v)"
@@ -22,7 +21,9 @@ type (
 	roFileCallback func(string) (io.ReadCloser, error)
 )
 
-// CreateConfigFromFile initializes a Config object from a file.
+// CreateConfigFromFile initializes a Config object from a file. The format of
+// the file is taken from it's extension: .yaml, .yml, .json or .toml, and
+// guessed from it's contents if it has none of those.
 func CreateConfigFromFile(filename string) *Config {
 	provider := func(name string) (io.ReadCloser, error) {
 		return os.Open(name)
@@ -38,15 +39,37 @@ func createConfigFromFile(filename string, fn roFileCallback) (conf *Config) {
 		conf = CreateConfig()
 		conf.addError(errMsgInvalidConfigFile, err)
 	} else {
-		conf = CreateConfigFromReader(file)
+		conf = createConfigFromReader(file, formatFromFilename(filename))
 		conf.filename = filename
 		file.Close()
 	}
 	return
 }
 
-// CreateConfigFromReader initializes a Config object from a reader.
+// CreateConfigFromReader initializes a Config object from a reader. The format
+// is guessed from the contents, see CreateConfigFromReaderFormat. Values may
+// reference environment variables with ${NAME}, or be read from a file with
+// file:/path, which is handy for passwords. The references are what gets
+// written back out by FlushConfigToWriter.
 func CreateConfigFromReader(reader io.Reader) *Config {
+	return createConfigFromReader(reader, "")
+}
+
+// CreateConfigFromReaderFormat initializes a Config object from a reader in
+// one of the formats: FormatYAML, FormatJSON or FormatTOML. The config is
+// written back out in the same format by FlushConfigToWriter.
+func CreateConfigFromReaderFormat(reader io.Reader, format string) *Config {
+	if !isFormat(format) {
+		c := CreateConfig()
+		c.addError(errFmtUnknownFormat, format)
+		return c
+	}
+	return createConfigFromReader(reader, format)
+}
+
+// createConfigFromReader reads a config in a format, or in the format guessed
+// from the contents if format is empty.
+func createConfigFromReader(reader io.Reader, format string) *Config {
 	c := &Config{
 		Errors: make([]error, 0),
 	}
@@ -55,12 +78,17 @@ func CreateConfigFromReader(reader io.Reader) *Config {
 		c.addError(errMsgInvalidConfigFile, err)
 		return c
 	}
-	err = yaml.Unmarshal(buf, c)
+	if len(format) == 0 {
+		format = formatFromContent(buf)
+	}
+	c.format = format
+	err = unmarshal(buf, format, c)
 	if err != nil {
 		c.addError(errMsgInvalidConfigFile, err)
 	}
 
 	c.fixReferencesAndNames()
+	c.interpolate()
 
 	return c
 }
@@ -72,6 +100,7 @@ func (c *Config) fixReferencesAndNames() {
 	if c.Global == nil {
 		c.Global = &Server{}
 	}
+	c.Global.fixChannels()
 	for s, v := range c.Servers {
 		if v == nil {
 			v = &Server{}
@@ -82,12 +111,21 @@ func (c *Config) fixReferencesAndNames() {
 		if len(v.Host) == 0 {
 			v.Host = s
 		}
+		v.fixChannels()
+	}
+	for r, v := range c.Relays {
+		if v == nil {
+			v = &Relay{}
+			c.Relays[r] = v
+		}
+		v.Name = r
 	}
 }
 
-// FlushConfigToFile writes a config out to a writer. If the filename is empty
+// FlushConfigToFile writes a config out to a file. If the filename is empty
 // it will write to the file that this config was loaded from, or it will
-// write to the defaultConfigFileName.
+// write to the defaultConfigFileName. The format is taken from the file's
+// extension, or is the format the config was read in.
 func FlushConfigToFile(conf *Config, filename string) (err error) {
 	provider := func(f string) (io.WriteCloser, error) {
 		return os.Create(filename)
@@ -118,13 +156,29 @@ func flushConfigToFile(conf *Config, filename string,
 	}
 	defer writer.Close()
 
-	err = FlushConfigToWriter(conf, writer)
+	format := formatFromFilename(filename)
+	if len(format) == 0 {
+		format = conf.GetFormat()
+	}
+	err = FlushConfigToWriterFormat(conf, writer, format)
 	return
 }
 
-// FlushConfigToWriter writes a config out to a writer
+// FlushConfigToWriter writes a config out to a writer in the format it was
+// read in, or yaml. Values that were read from the environment or files are
+// written as the references they came from.
 func FlushConfigToWriter(conf *Config, writer io.Writer) (err error) {
-	marshalled, err := yaml.Marshal(conf)
+	return FlushConfigToWriterFormat(conf, writer, conf.GetFormat())
+}
+
+// FlushConfigToWriterFormat writes a config out to a writer in one of the
+// formats: FormatYAML, FormatJSON or FormatTOML.
+func FlushConfigToWriterFormat(conf *Config, writer io.Writer,
+	format string) (err error) {
+
+	conf = conf.Clone()
+	conf.uninterpolate()
+	marshalled, err := marshal(conf, format)
 	if err != nil {
 		return
 	}
@@ -36,6 +36,7 @@ func invErr(name string) string {
 
 var srv1 = &Server{
 	Name:             "irc1",
+	Network:          "gamesurge",
 	Host:             "irc.gamesurge.net",
 	Port:             5555,
 	Ssl:              "true",
@@ -47,6 +48,15 @@ var srv1 = &Server{
 	FloodTimeout:     "3.5",
 	FloodStep:        "5.5",
 	KeepAlive:        "7.5",
+	RecordFile:       "rec1",
+	Caps:             []string{"batch", "labeled-response"},
+	SaslUser:         "s1",
+	SaslPass:         "p1",
+	BouncerListen:    "localhost:7001",
+	BouncerBuffer:    "50",
+	LogDir:           "logs1",
+	LogFormat:        LogJSON,
+	LogIgnore:        []string{"*!*@bots.net"},
 	NoReconnect:      "false",
 	ReconnectTimeout: "10",
 	Nick:             "n1",
@@ -60,6 +70,7 @@ var srv1 = &Server{
 
 var srv2 = &Server{
 	Name:             "irc2",
+	Network:          "gamesurge2",
 	Host:             "irc.gamesurge.com",
 	Port:             6666,
 	Ssl:              "false",
@@ -71,6 +82,15 @@ var srv2 = &Server{
 	FloodTimeout:     "4.5",
 	FloodStep:        "6.5",
 	KeepAlive:        "8.5",
+	RecordFile:       "rec2",
+	Caps:             []string{"away-notify"},
+	SaslUser:         "s2",
+	SaslPass:         "p2",
+	BouncerListen:    "localhost:7002",
+	BouncerBuffer:    "60",
+	LogDir:           "logs2",
+	LogFormat:        LogText,
+	LogIgnore:        []string{"spambot"},
 	NoReconnect:      "true",
 	ReconnectTimeout: "100",
 	Nick:             "n2",
@@ -101,6 +121,7 @@ func (s *s) TestConfig_Fallbacks(c *C) {
 
 	c.Check(server.GetHost(), Equals, host)
 	c.Check(server.GetName(), Equals, name)
+	c.Check(server.GetNetwork(), Equals, host)
 	c.Check(server.GetPort(), Equals, config.Global.GetPort())
 	c.Check(server.GetSsl(), Equals, config.Global.GetSsl())
 	c.Check(server.GetSslCert(), Equals, config.Global.GetSslCert())
@@ -112,6 +133,18 @@ func (s *s) TestConfig_Fallbacks(c *C) {
 	c.Check(server.GetFloodTimeout(), Equals, config.Global.GetFloodTimeout())
 	c.Check(server.GetFloodStep(), Equals, config.Global.GetFloodStep())
 	c.Check(server.GetKeepAlive(), Equals, config.Global.GetKeepAlive())
+	c.Check(server.GetRecordFile(), Equals,
+		config.Global.GetRecordFile()+"."+name)
+	c.Check(server.GetCaps(), DeepEquals, config.Global.GetCaps())
+	c.Check(server.GetSaslUser(), Equals, config.Global.GetSaslUser())
+	c.Check(server.GetSaslPass(), Equals, config.Global.GetSaslPass())
+	c.Check(server.GetBouncerListen(), Equals,
+		config.Global.GetBouncerListen())
+	c.Check(server.GetBouncerBuffer(), Equals,
+		config.Global.GetBouncerBuffer())
+	c.Check(server.GetLogDir(), Equals, config.Global.GetLogDir())
+	c.Check(server.GetLogFormat(), Equals, config.Global.GetLogFormat())
+	c.Check(server.GetLogIgnore(), DeepEquals, config.Global.GetLogIgnore())
 	c.Check(server.GetNoReconnect(), Equals, config.Global.GetNoReconnect())
 	c.Check(server.GetReconnectTimeout(), Equals,
 		config.Global.GetReconnectTimeout())
@@ -143,6 +176,14 @@ func (s *s) TestConfig_Fluent(c *C) {
 		FloodTimeout(srv2.GetFloodTimeout()).
 		FloodStep(srv2.GetFloodStep()).
 		KeepAlive(srv2.GetKeepAlive()).
+		RecordFile(srv2.GetRecordFile()).
+		Caps(srv2.GetCaps()...).
+		Sasl(srv2.GetSaslUser(), srv2.GetSaslPass()).
+		BouncerListen(srv2.GetBouncerListen()).
+		BouncerBuffer(srv2.GetBouncerBuffer()).
+		LogDir(srv2.GetLogDir()).
+		LogFormat(srv2.GetLogFormat()).
+		LogIgnore(srv2.GetLogIgnore()...).
 		NoReconnect(srv2.GetNoReconnect()).
 		ReconnectTimeout(srv2.GetReconnectTimeout()).
 		Nick(srv2.GetNick()).
@@ -155,6 +196,7 @@ func (s *s) TestConfig_Fluent(c *C) {
 		// Server 1
 		Server(srv1.GetName()).
 		Host(srv1.GetHost()).
+		Network(srv1.GetNetwork()).
 		Port(srv1.GetPort()).
 		Ssl(srv1.GetSsl()).
 		SslCert(srv1.GetSslCert()).
@@ -165,6 +207,14 @@ func (s *s) TestConfig_Fluent(c *C) {
 		FloodTimeout(srv1.GetFloodTimeout()).
 		FloodStep(srv1.GetFloodStep()).
 		KeepAlive(srv1.GetKeepAlive()).
+		RecordFile(srv1.GetRecordFile()).
+		Caps(srv1.GetCaps()...).
+		Sasl(srv1.GetSaslUser(), srv1.GetSaslPass()).
+		BouncerListen(srv1.GetBouncerListen()).
+		BouncerBuffer(srv1.GetBouncerBuffer()).
+		LogDir(srv1.GetLogDir()).
+		LogFormat(srv1.GetLogFormat()).
+		LogIgnore(srv1.GetLogIgnore()...).
 		NoReconnect(srv1.GetNoReconnect()).
 		ReconnectTimeout(srv1.GetReconnectTimeout()).
 		Nick(srv1.GetNick()).
@@ -181,6 +231,7 @@ func (s *s) TestConfig_Fluent(c *C) {
 	server2 := conf.GetServer(srv2host)
 	c.Check(server.GetHost(), Equals, srv1.GetHost())
 	c.Check(server.GetName(), Equals, srv1.GetName())
+	c.Check(server.GetNetwork(), Equals, srv1.GetNetwork())
 	c.Check(server.GetPort(), Equals, srv1.GetPort())
 	c.Check(server.GetSsl(), Equals, srv1.GetSsl())
 	c.Check(server.GetSslCert(), Equals, srv1.GetSslCert())
@@ -191,6 +242,15 @@ func (s *s) TestConfig_Fluent(c *C) {
 	c.Check(server.GetFloodTimeout(), Equals, srv1.GetFloodTimeout())
 	c.Check(server.GetFloodStep(), Equals, srv1.GetFloodStep())
 	c.Check(server.GetKeepAlive(), Equals, srv1.GetKeepAlive())
+	c.Check(server.GetRecordFile(), Equals, srv1.GetRecordFile())
+	c.Check(server.GetCaps(), DeepEquals, srv1.GetCaps())
+	c.Check(server.GetSaslUser(), Equals, srv1.GetSaslUser())
+	c.Check(server.GetSaslPass(), Equals, srv1.GetSaslPass())
+	c.Check(server.GetBouncerListen(), Equals, srv1.GetBouncerListen())
+	c.Check(server.GetBouncerBuffer(), Equals, srv1.GetBouncerBuffer())
+	c.Check(server.GetLogDir(), Equals, srv1.GetLogDir())
+	c.Check(server.GetLogFormat(), Equals, srv1.GetLogFormat())
+	c.Check(server.GetLogIgnore(), DeepEquals, srv1.GetLogIgnore())
 	c.Check(server.GetNoReconnect(), Equals, srv1.GetNoReconnect())
 	c.Check(server.GetReconnectTimeout(), Equals, srv1.GetReconnectTimeout())
 	c.Check(server.GetNick(), Equals, srv1.GetNick())
@@ -205,6 +265,7 @@ func (s *s) TestConfig_Fluent(c *C) {
 	}
 
 	c.Check(server2.GetHost(), Equals, srv2host)
+	c.Check(server2.GetNetwork(), Equals, srv2host)
 	c.Check(server2.GetPort(), Equals, srv2.GetPort())
 	c.Check(server2.GetSsl(), Equals, srv2.GetSsl())
 	c.Check(server2.GetSslCert(), Equals, srv2.GetSslCert())
@@ -215,6 +276,16 @@ func (s *s) TestConfig_Fluent(c *C) {
 	c.Check(server2.GetFloodTimeout(), Equals, srv2.GetFloodTimeout())
 	c.Check(server2.GetFloodStep(), Equals, srv2.GetFloodStep())
 	c.Check(server2.GetKeepAlive(), Equals, srv2.GetKeepAlive())
+	c.Check(server2.GetRecordFile(), Equals,
+		srv2.GetRecordFile()+"."+srv2host)
+	c.Check(server2.GetCaps(), DeepEquals, srv2.GetCaps())
+	c.Check(server2.GetSaslUser(), Equals, srv2.GetSaslUser())
+	c.Check(server2.GetSaslPass(), Equals, srv2.GetSaslPass())
+	c.Check(server2.GetBouncerListen(), Equals, srv2.GetBouncerListen())
+	c.Check(server2.GetBouncerBuffer(), Equals, srv2.GetBouncerBuffer())
+	c.Check(server2.GetLogDir(), Equals, srv2.GetLogDir())
+	c.Check(server2.GetLogFormat(), Equals, srv2.GetLogFormat())
+	c.Check(server2.GetLogIgnore(), DeepEquals, srv2.GetLogIgnore())
 	c.Check(server2.GetNoReconnect(), Equals, srv2.GetNoReconnect())
 	c.Check(server2.GetReconnectTimeout(), Equals, srv2.GetReconnectTimeout())
 	c.Check(server2.GetNick(), Equals, srv2.GetNick())
@@ -263,6 +334,13 @@ func (s *s) TestConfig_Defaults(c *C) {
 	c.Check(srv.GetKeepAlive(), Equals, defaultKeepAlive)
 	c.Check(srv.GetNoReconnect(), Equals, false)
 	c.Check(srv.GetReconnectTimeout(), Equals, defaultReconnectTimeout)
+	c.Check(srv.GetBouncerListen(), Equals, "")
+	c.Check(srv.GetSaslUser(), Equals, "")
+	c.Check(srv.GetSaslPass(), Equals, "")
+	c.Check(srv.GetLogDir(), Equals, "")
+	c.Check(srv.GetLogFormat(), Equals, defaultLogFormat)
+	c.Check(srv.GetLogIgnore(), IsNil)
+	c.Check(srv.GetBouncerBuffer(), Equals, defaultBouncerBuffer)
 	c.Check(srv.GetPrefix(), Equals, defaultPrefix)
 }
 
@@ -284,6 +362,8 @@ func (s *s) TestConfig_InvalidValues(c *C) {
 	srv.NoStore = "x"
 	srv.NoReconnect = "x"
 	srv.ReconnectTimeout = "x"
+	srv.BouncerBuffer = "x"
+	srv.LogFormat = "x"
 	srv.Prefix = "xx"
 
 	c.Check(srv.GetSsl(), Equals, false)
@@ -296,9 +376,11 @@ func (s *s) TestConfig_InvalidValues(c *C) {
 	c.Check(srv.GetKeepAlive(), Equals, defaultKeepAlive)
 	c.Check(srv.GetNoReconnect(), Equals, false)
 	c.Check(srv.GetReconnectTimeout(), Equals, defaultReconnectTimeout)
+	c.Check(srv.GetBouncerBuffer(), Equals, defaultBouncerBuffer)
+	c.Check(srv.GetLogFormat(), Equals, defaultLogFormat)
 
 	c.Check(conf.IsValid(), Equals, false)
-	c.Check(len(conf.Errors), Equals, 10)
+	c.Check(len(conf.Errors), Equals, 12)
 	c.Check(conf.Errors[0].Error(), Matches, invErr(errSsl))
 	c.Check(conf.Errors[1].Error(), Matches, invErr(errNoVerifyCert))
 	c.Check(conf.Errors[2].Error(), Matches, invErr(errNoState))
@@ -309,6 +391,58 @@ func (s *s) TestConfig_InvalidValues(c *C) {
 	c.Check(conf.Errors[7].Error(), Matches, invErr(errKeepAlive))
 	c.Check(conf.Errors[8].Error(), Matches, invErr(errNoReconnect))
 	c.Check(conf.Errors[9].Error(), Matches, invErr(errReconnectTimeout))
+	c.Check(conf.Errors[10].Error(), Matches, invErr(errBouncerBuffer))
+	c.Check(conf.Errors[11].Error(), Matches, invErr(errLogFormat))
+}
+
+func (s *s) TestConfig_WhoisLifetime(c *C) {
+	conf := CreateConfig().
+		WhoisLifetime(30).
+		Server(srv1.GetName()).
+		Server(srv2.GetName()).
+		WhoisLifetime(10.5)
+	c.Check(conf.GetServer(srv1.GetName()).GetWhoisLifetime(), Equals, 30.0)
+	c.Check(conf.GetServer(srv2.GetName()).GetWhoisLifetime(), Equals, 10.5)
+
+	conf = CreateConfig().
+		Nick(srv1.Nick).
+		Realname(srv1.Realname).
+		Username(srv1.Username).
+		Userhost(srv1.Userhost).
+		Server(srv1.GetName())
+	srv := conf.GetServer(srv1.GetName())
+	c.Check(srv.GetWhoisLifetime(), Equals, defaultWhoisLifetime)
+
+	srv.WhoisLifetime = "x"
+	c.Check(srv.GetWhoisLifetime(), Equals, defaultWhoisLifetime)
+	c.Check(conf.IsValid(), Equals, false)
+	c.Assert(len(conf.Errors), Equals, 1)
+	c.Check(conf.Errors[0].Error(), Matches, invErr(errWhoisLifetime))
+}
+
+func (s *s) TestConfig_ResyncInterval(c *C) {
+	conf := CreateConfig().
+		ResyncInterval(600).
+		Server(srv1.GetName()).
+		Server(srv2.GetName()).
+		ResyncInterval(90.5)
+	c.Check(conf.GetServer(srv1.GetName()).GetResyncInterval(), Equals, 600.0)
+	c.Check(conf.GetServer(srv2.GetName()).GetResyncInterval(), Equals, 90.5)
+
+	conf = CreateConfig().
+		Nick(srv1.Nick).
+		Realname(srv1.Realname).
+		Username(srv1.Username).
+		Userhost(srv1.Userhost).
+		Server(srv1.GetName())
+	srv := conf.GetServer(srv1.GetName())
+	c.Check(srv.GetResyncInterval(), Equals, 0.0)
+
+	srv.ResyncInterval = "x"
+	c.Check(srv.GetResyncInterval(), Equals, 0.0)
+	c.Check(conf.IsValid(), Equals, false)
+	c.Assert(len(conf.Errors), Equals, 1)
+	c.Check(conf.Errors[0].Error(), Matches, invErr(errResyncInterval))
 }
 
 func (s *s) TestConfig_ValidationEmpty(c *C) {
@@ -370,6 +504,93 @@ func (s *s) TestConfig_ValidationDuplicateName(c *C) {
 	c.Check(conf.Errors[0].Error(), Equals, errMsgDuplicateServer)
 }
 
+func (s *s) TestConfig_ValidationSasl(c *C) {
+	conf := CreateConfig().
+		Nick(srv1.Nick).
+		Realname(srv1.Realname).
+		Username(srv1.Username).
+		Userhost(srv1.Userhost).
+		Server(srv1.GetName()).
+		Sasl("account", "")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 1)
+	c.Check(conf.Errors[0].Error(), Matches, reqErr(errSaslPass))
+}
+
+func (s *s) TestConfig_Identity(c *C) {
+	conf := CreateConfig().
+		Nick(srv1.Nick).
+		Realname(srv1.Realname).
+		Username(srv1.Username).
+		Userhost(srv1.Userhost).
+		Server(srv1.GetName()).
+		Host(srv1.GetHost()).
+		Port(srv1.GetPort()).
+		Ssl(true).
+		Identity("relay").
+		Nick("relay").
+		Sasl("relay", "secret")
+
+	c.Check(len(conf.Errors), Equals, 0)
+	server := conf.GetServer(srv1.GetName())
+	relay := conf.GetServer("relay")
+	c.Check(relay, NotNil)
+	c.Check(relay.GetHost(), Equals, srv1.GetHost())
+	c.Check(relay.GetPort(), Equals, srv1.GetPort())
+	c.Check(relay.GetSsl(), Equals, true)
+	c.Check(relay.GetNetwork(), Equals, server.GetNetwork())
+	c.Check(relay.GetNick(), Equals, "relay")
+	c.Check(relay.GetSaslUser(), Equals, "relay")
+	c.Check(server.GetNick(), Equals, srv1.Nick)
+	c.Check(server.GetSaslUser(), Equals, "")
+	c.Check(conf.IsValid(), Equals, true)
+
+	conf.Identity("relay")
+	c.Check(len(conf.Errors), Equals, 1)
+	c.Check(conf.Errors[0].Error(), Equals, errMsgDuplicateServer)
+
+	conf = CreateConfig().Identity("relay")
+	c.Check(len(conf.Errors), Equals, 1)
+	c.Check(conf.Errors[0].Error(), Equals, errMsgIdentityContext)
+}
+
+func (s *s) TestConfig_ValidationDuplicateNick(c *C) {
+	conf := CreateConfig().
+		Nick(srv1.Nick).
+		Realname(srv1.Realname).
+		Username(srv1.Username).
+		Userhost(srv1.Userhost).
+		Server("a").Host(srv1.GetHost()).
+		Server("b").Host(srv1.GetHost()).
+		Server("c").Host(srv2.GetHost())
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 1)
+	c.Check(conf.Errors[0].Error(), Matches,
+		`.*\(b\).*`+srv1.Nick+`.*by a.*`+srv1.GetHost())
+
+	conf.ServerContext("b").Nick("other")
+	conf.Errors = nil
+	c.Check(conf.IsValid(), Equals, true)
+}
+
+func (s *s) TestConfig_ValidationDuplicateBouncer(c *C) {
+	conf := CreateConfig().
+		Nick(srv1.Nick).
+		Realname(srv1.Realname).
+		Username(srv1.Username).
+		Userhost(srv1.Userhost).
+		BouncerListen("localhost:7000").
+		Server("a").Host(srv1.GetHost()).Nick("a").
+		Server("b").Host(srv2.GetHost()).Nick("b")
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 1)
+	c.Check(conf.Errors[0].Error(), Matches, `.*\(b\).*localhost:7000.*by a.*`)
+
+	conf.ServerContext("b").BouncerListen("localhost:7001")
+	conf.Errors = nil
+	c.Check(conf.IsValid(), Equals, true)
+}
+
 func (s *s) TestConfig_ValidationMissing(c *C) {
 	conf := CreateConfig().
 		Server(srv1.Host)
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v1"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Config file formats. Every format uses the same keys, the lowercased names
+// of the config's fields as they appear in yaml.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+const (
+	// errFmtUnknownFormat occurs when a config is read or written in a format
+	// that doesn't exist.
+	errFmtUnknownFormat = "config: Unknown config format (%v)"
+)
+
+var (
+	// rgxTomlKey matches the start of a toml key/value pair.
+	rgxTomlKey = regexp.MustCompile(`^("[^"]*"|[A-Za-z0-9_.-]+)\s*=`)
+)
+
+// isFormat checks that format is one of the config file formats.
+func isFormat(format string) bool {
+	return format == FormatYAML || format == FormatJSON || format == FormatTOML
+}
+
+// formatFromFilename gets the format of a file from it's extension, or empty
+// string if the extension isn't one of the formats.
+func formatFromFilename(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	}
+	return ""
+}
+
+// formatFromContent guesses the format of a config from the first line that
+// isn't blank or a comment. JSON starts with a brace, toml with a table header
+// or a key = value pair and anything else is assumed to be yaml.
+func formatFromContent(buf []byte) string {
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case len(line) == 0 || line[0] == '#':
+			continue
+		case line[0] == '{':
+			return FormatJSON
+		case line[0] == '[' || rgxTomlKey.MatchString(line):
+			return FormatTOML
+		default:
+			return FormatYAML
+		}
+	}
+	return FormatYAML
+}
+
+// unmarshal reads a config in a format. JSON and toml are read into a tree
+// that's handed to yaml so every format maps onto the config the same way.
+func unmarshal(buf []byte, format string, conf *Config) (err error) {
+	var tree interface{}
+	switch format {
+	case FormatYAML:
+		return yaml.Unmarshal(buf, conf)
+	case FormatJSON:
+		err = json.Unmarshal(buf, &tree)
+	case FormatTOML:
+		table := make(map[string]interface{})
+		_, err = toml.Decode(string(buf), &table)
+		tree = table
+	default:
+		return fmt.Errorf(errFmtUnknownFormat, format)
+	}
+	if err != nil {
+		return
+	}
+
+	if buf, err = yaml.Marshal(tree); err != nil {
+		return
+	}
+	return yaml.Unmarshal(buf, conf)
+}
+
+// marshal writes a config in a format. The config is turned into yaml first
+// and from there into the other formats so the keys are the same in all.
+func marshal(conf *Config, format string) ([]byte, error) {
+	if !isFormat(format) {
+		return nil, fmt.Errorf(errFmtUnknownFormat, format)
+	}
+
+	buf, err := yaml.Marshal(conf)
+	if err != nil || format == FormatYAML {
+		return buf, err
+	}
+
+	var tree interface{}
+	if err = yaml.Unmarshal(buf, &tree); err != nil {
+		return nil, err
+	}
+	tree = stringKeys(tree)
+
+	if format == FormatJSON {
+		return json.MarshalIndent(tree, "", "    ")
+	}
+	out := &bytes.Buffer{}
+	err = toml.NewEncoder(out).Encode(tree)
+	return out.Bytes(), err
+}
+
+// stringKeys converts the maps of a tree read by yaml to have string keys and
+// drops nil values, neither JSON nor toml can hold anything else.
+func stringKeys(tree interface{}) interface{} {
+	switch t := tree.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for key, value := range t {
+			if value != nil {
+				m[fmt.Sprint(key)] = stringKeys(value)
+			}
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, 0, len(t))
+		for _, value := range t {
+			if value != nil {
+				s = append(s, stringKeys(value))
+			}
+		}
+		return s
+	}
+	return tree
+}
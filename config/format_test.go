@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bytes"
+	. "gopkg.in/check.v1"
+	"io"
+	"strings"
+)
+
+var jsonConfiguration = `{
+    "global": {
+        "port": 5555,
+        "nick": "nick",
+        "username": "username",
+        "userhost": "userhost.com",
+        "realname": "realname"
+    },
+    "servers": {
+        "myserver": {
+            "host": "irc.gamesurge.net",
+            "nick": "nickoverride",
+            "ssl": true
+        },
+        "irc.gamesurge.net": {
+            "port": 3333
+        }
+    }
+}
+`
+
+var tomlConfiguration = `# A comment before anything else.
+[global]
+port = 5555
+nick = "nick"
+username = "username"
+userhost = "userhost.com"
+realname = "realname"
+
+[servers.myserver]
+host = "irc.gamesurge.net"
+nick = "nickoverride"
+ssl = true
+
+[servers."irc.gamesurge.net"]
+port = 3333
+`
+
+// fullConfiguration has a little of everything to check that nothing is lost
+// going between the formats.
+var fullConfiguration = `storefile: /tmp/store.db
+global:
+    port: 5555
+    nick: nick
+    username: username
+    userhost: userhost.com
+    realname: realname
+    floodtimeout: "3.5"
+    caps: [sasl, multi-prefix]
+    channelsettings:
+        '#global':
+            key: gkey
+            autovoice: ['*!*@voiced']
+servers:
+    myserver:
+        host: irc.gamesurge.net
+        nick: nickoverride
+        ssl: "true"
+        channels: ['#one', '#two']
+        channelsettings:
+            '#Chan':
+                prefix: '!'
+                extensions: [ext]
+                relays: [bridge]
+    irc.gamesurge.net:
+        port: 3333
+relays:
+    bridge:
+        links: [myserver/#one, irc.gamesurge.net/#one]
+        tags:
+            myserver: my
+`
+
+func (s *s) TestFormat_FromFilename(c *C) {
+	c.Check(formatFromFilename("config.yaml"), Equals, FormatYAML)
+	c.Check(formatFromFilename("config.YML"), Equals, FormatYAML)
+	c.Check(formatFromFilename("/etc/bot/config.json"), Equals, FormatJSON)
+	c.Check(formatFromFilename("config.toml"), Equals, FormatTOML)
+	c.Check(formatFromFilename("config"), Equals, "")
+	c.Check(formatFromFilename("config.conf"), Equals, "")
+}
+
+func (s *s) TestFormat_FromContent(c *C) {
+	c.Check(formatFromContent([]byte(configuration)), Equals, FormatYAML)
+	c.Check(formatFromContent([]byte(fullConfiguration)), Equals, FormatYAML)
+	c.Check(formatFromContent([]byte(jsonConfiguration)), Equals, FormatJSON)
+	c.Check(formatFromContent([]byte(tomlConfiguration)), Equals, FormatTOML)
+	c.Check(formatFromContent([]byte("\n  port = 5\n")), Equals, FormatTOML)
+	c.Check(formatFromContent([]byte(`"a.b" = 5`)), Equals, FormatTOML)
+	c.Check(formatFromContent([]byte("# port = 5\nport: 5")), Equals,
+		FormatYAML)
+	c.Check(formatFromContent([]byte("nick: a=b")), Equals, FormatYAML)
+	c.Check(formatFromContent(nil), Equals, FormatYAML)
+}
+
+func (s *s) TestFormat_FromReader(c *C) {
+	for format, str := range map[string]string{
+		FormatYAML: configuration,
+		FormatJSON: jsonConfiguration,
+		FormatTOML: tomlConfiguration,
+	} {
+		conf := CreateConfigFromReader(bytes.NewBufferString(str))
+		c.Check(conf.Errors, HasLen, 0, Commentf("format: %v", format))
+		c.Check(conf.GetFormat(), Equals, format)
+		verifyFakeConfig(c, conf)
+		c.Check(conf.IsValid(), Equals, true, Commentf("format: %v", format))
+
+		conf = CreateConfigFromReaderFormat(bytes.NewBufferString(str), format)
+		c.Check(conf.Errors, HasLen, 0, Commentf("format: %v", format))
+		verifyFakeConfig(c, conf)
+	}
+
+	conf := CreateConfigFromReader(bytes.NewBufferString(jsonConfiguration))
+	c.Check(conf.Servers["myserver"].GetSsl(), Equals, true)
+	conf = CreateConfigFromReader(bytes.NewBufferString(tomlConfiguration))
+	c.Check(conf.Servers["myserver"].GetSsl(), Equals, true)
+}
+
+func (s *s) TestFormat_FromReaderErrors(c *C) {
+	conf := CreateConfigFromReaderFormat(
+		bytes.NewBufferString(configuration), "xml")
+	c.Check(conf.Errors, HasLen, 1)
+	c.Check(conf.Errors[0].Error(), Matches, `.*xml.*`)
+
+	conf = CreateConfigFromReader(bytes.NewBufferString(`{"global": `))
+	c.Check(conf.Errors, HasLen, 1)
+	c.Check(conf.Errors[0].Error(), Matches,
+		errMsgInvalidConfigFile[:len(errMsgInvalidConfigFile)-4]+`.*`)
+
+	conf = CreateConfigFromReader(bytes.NewBufferString("[global\nport = "))
+	c.Check(conf.Errors, HasLen, 1)
+	c.Check(conf.Errors[0].Error(), Matches,
+		errMsgInvalidConfigFile[:len(errMsgInvalidConfigFile)-4]+`.*`)
+
+	conf = CreateConfigFromReaderFormat(
+		bytes.NewBufferString(configuration), FormatJSON)
+	c.Check(conf.Errors, HasLen, 1)
+}
+
+func (s *s) TestFormat_RoundTrip(c *C) {
+	original := CreateConfigFromReader(bytes.NewBufferString(fullConfiguration))
+	c.Check(original.Errors, HasLen, 0)
+	c.Check(original.IsValid(), Equals, true)
+
+	expect := &bytes.Buffer{}
+	c.Check(FlushConfigToWriterFormat(original, expect, FormatYAML), IsNil)
+
+	for _, format := range []string{FormatYAML, FormatJSON, FormatTOML} {
+		comment := Commentf("format: %v", format)
+		out := &bytes.Buffer{}
+		c.Check(FlushConfigToWriterFormat(original, out, format), IsNil,
+			comment)
+		c.Check(formatFromContent(out.Bytes()), Equals, format, comment)
+
+		conf := CreateConfigFromReader(out)
+		c.Check(conf.Errors, HasLen, 0, comment)
+		c.Check(conf.GetFormat(), Equals, format, comment)
+		c.Check(conf.IsValid(), Equals, true, comment)
+
+		srv := conf.Servers["myserver"]
+		c.Check(srv.GetChannelPrefix("#chan"), Equals, '!', comment)
+		c.Check(srv.GetChannelKey("#global"), Equals, "gkey", comment)
+		c.Check(srv.GetFloodTimeout(), Equals, 3.5, comment)
+		c.Check(srv.GetCaps(), DeepEquals,
+			[]string{"sasl", "multi-prefix"}, comment)
+		c.Check(conf.GetStoreFile(), Equals, "/tmp/store.db", comment)
+
+		again := &bytes.Buffer{}
+		c.Check(FlushConfigToWriter(conf, again), IsNil, comment)
+		c.Check(formatFromContent(again.Bytes()), Equals, format, comment)
+
+		yaml := &bytes.Buffer{}
+		c.Check(FlushConfigToWriterFormat(conf, yaml, FormatYAML), IsNil,
+			comment)
+		c.Check(yaml.String(), Equals, expect.String(), comment)
+	}
+
+	err := FlushConfigToWriterFormat(original, &bytes.Buffer{}, "xml")
+	c.Check(err, NotNil)
+}
+
+func (s *s) TestFormat_File(c *C) {
+	buf := &testBuffer{bytes.NewBufferString(jsonConfiguration), false}
+	conf := createConfigFromFile("check.json",
+		func(string) (io.ReadCloser, error) {
+			return buf, nil
+		})
+	c.Check(conf.Errors, HasLen, 0)
+	c.Check(conf.GetFormat(), Equals, FormatJSON)
+	verifyFakeConfig(c, conf)
+
+	// The extension wins over the contents.
+	buf = &testBuffer{bytes.NewBufferString(jsonConfiguration), false}
+	conf = createConfigFromFile("check.toml",
+		func(string) (io.ReadCloser, error) {
+			return buf, nil
+		})
+	c.Check(conf.Errors, HasLen, 1)
+
+	conf = CreateConfigFromReader(bytes.NewBufferString(configuration))
+	out := &testBuffer{&bytes.Buffer{}, false}
+	err := flushConfigToFile(conf, "out.toml",
+		func(string) (io.WriteCloser, error) {
+			return out, nil
+		})
+	c.Check(err, IsNil)
+	c.Check(out.closed, Equals, true)
+	c.Check(strings.Contains(out.ReadWriter.(*bytes.Buffer).String(),
+		"[servers.myserver]"), Equals, true)
+
+	// Without a known extension the format it was read in is kept.
+	buf = &testBuffer{bytes.NewBufferString(tomlConfiguration), false}
+	conf = createConfigFromFile("bot.conf",
+		func(string) (io.ReadCloser, error) {
+			return buf, nil
+		})
+	c.Check(conf.Errors, HasLen, 0)
+	var name string
+	out = &testBuffer{&bytes.Buffer{}, false}
+	err = flushConfigToFile(conf, "", func(f string) (io.WriteCloser, error) {
+		name = f
+		return out, nil
+	})
+	c.Check(err, IsNil)
+	c.Check(name, Equals, "bot.conf")
+	c.Check(formatFromContent(out.ReadWriter.(*bytes.Buffer).Bytes()),
+		Equals, FormatTOML)
+}
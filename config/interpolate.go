@@ -0,0 +1,148 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	// filePrefix marks a config value that should be read from a file.
+	filePrefix = "file:"
+	// redacted replaces interpolated values in displayed errors.
+	redacted = "[redacted]"
+
+	fmtErrUnsetEnv   = "config(%v): Environment variable %v for %v is not set."
+	fmtErrSecretFile = "config(%v): Failed to read %v for %v (%v)"
+)
+
+var (
+	// rgxEnv matches environment variable references: ${NAME}
+	rgxEnv = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// interpolation is a config value that was resolved while loading, ref is
+// what was written in the config and value what it resolved to.
+type interpolation struct {
+	ref   string
+	value string
+}
+
+// interpolate resolves the references in the string fields of every server.
+// ${NAME} anywhere in a value is replaced by the environment variable NAME,
+// and a value of file:/path is replaced by the contents of the file without
+// the trailing newline. The references are remembered so they can be written
+// back out instead of the values they resolved to.
+func (c *Config) interpolate() {
+	c.interpolateServer(c.Global)
+	for _, s := range c.Servers {
+		c.interpolateServer(s)
+	}
+}
+
+// interpolateServer resolves the references in the string fields of a server
+// and it's channel settings.
+func (c *Config) interpolateServer(s *Server) {
+	c.interpolateFields(s, "", reflect.ValueOf(s).Elem())
+	for key, ch := range s.ChannelSettings {
+		c.interpolateFields(s, key+" ", reflect.ValueOf(ch).Elem())
+	}
+}
+
+// interpolateFields resolves the references in the string fields of a struct
+// and records them on the server with the prefix in front of the field names.
+func (c *Config) interpolateFields(s *Server, prefix string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() != reflect.String || !field.CanSet() {
+			continue
+		}
+
+		name := prefix + t.Field(i).Name
+		ref := field.String()
+		value, ok := c.resolve(s.GetName(), name, ref)
+		if !ok || value == ref {
+			continue
+		}
+		if s.interpolated == nil {
+			s.interpolated = make(map[string]interpolation)
+		}
+		s.interpolated[name] = interpolation{ref, value}
+		field.SetString(value)
+	}
+}
+
+// resolve resolves the references in a single value, adding an error and
+// returning false if it can't be.
+func (c *Config) resolve(name, field, ref string) (string, bool) {
+	if strings.HasPrefix(ref, filePrefix) {
+		path := ref[len(filePrefix):]
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			c.addError(fmtErrSecretFile, name, path, field, err)
+			return "", false
+		}
+		return strings.TrimRight(string(buf), "\r\n"), true
+	}
+
+	ok := true
+	value := rgxEnv.ReplaceAllStringFunc(ref, func(match string) string {
+		env := match[2 : len(match)-1]
+		value, set := os.LookupEnv(env)
+		if !set {
+			c.addError(fmtErrUnsetEnv, name, env, field)
+			ok = false
+		}
+		return value
+	})
+	return value, ok
+}
+
+// uninterpolate puts the references back in place of the values they
+// resolved to so they're never written out. Values changed since the config
+// was loaded are left alone.
+func (c *Config) uninterpolate() {
+	for _, s := range append([]*Server{c.Global}, c.serverList()...) {
+		for field, in := range s.interpolated {
+			v := reflect.ValueOf(s).Elem()
+			if i := strings.LastIndex(field, " "); i >= 0 {
+				ch := s.ChannelSettings[field[:i]]
+				if ch == nil {
+					continue
+				}
+				v, field = reflect.ValueOf(ch).Elem(), field[i+1:]
+			}
+			if f := v.FieldByName(field); f.String() == in.value {
+				f.SetString(in.ref)
+			}
+		}
+	}
+}
+
+// Redact hides the values that were read from the environment or files in a
+// string, such as one of the config's errors.
+func (c *Config) Redact(str string) string {
+	for _, s := range append([]*Server{c.Global}, c.serverList()...) {
+		if s == nil {
+			continue
+		}
+		for _, in := range s.interpolated {
+			if len(in.value) != 0 {
+				str = strings.Replace(str, in.value, redacted, -1)
+			}
+		}
+	}
+	return str
+}
+
+// serverList returns the servers of the config.
+func (c *Config) serverList() []*Server {
+	servers := make([]*Server, 0, len(c.Servers))
+	for _, s := range c.Servers {
+		servers = append(servers, s)
+	}
+	return servers
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"bytes"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var interpolated = `global:
+    nick: ${UQ_TEST_NICK}
+    username: user${UQ_TEST_NICK}
+    realname: realname
+    ssl: ${UQ_TEST_SSL}
+servers:
+    myserver:
+        host: irc.gamesurge.net
+        saslpass: file:%v
+        sasluser: ${UQ_TEST_NICK}
+`
+
+func (s *s) TestConfig_Interpolate(c *C) {
+	dir, err := ioutil.TempDir("", "ultimateq")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "secret")
+	c.Assert(ioutil.WriteFile(secret, []byte("hunter2\n"), 0600), IsNil)
+	os.Setenv("UQ_TEST_NICK", "bob")
+	os.Setenv("UQ_TEST_SSL", "notabool")
+	defer os.Unsetenv("UQ_TEST_NICK")
+	defer os.Unsetenv("UQ_TEST_SSL")
+
+	raw := strings.Replace(interpolated, "%v", secret, 1)
+	conf := CreateConfigFromReader(bytes.NewBufferString(raw))
+	c.Check(len(conf.Errors), Equals, 0)
+	srv := conf.Servers["myserver"]
+	c.Check(srv.GetNick(), Equals, "bob")
+	c.Check(srv.GetUsername(), Equals, "userbob")
+	c.Check(srv.GetSaslUser(), Equals, "bob")
+	c.Check(srv.GetSaslPass(), Equals, "hunter2")
+	c.Check(srv.GetRealname(), Equals, "realname")
+
+	conf.GlobalContext().Realname("changed")
+	conf.ServerContext("myserver").Sasl("alice", "newpass")
+	out := &bytes.Buffer{}
+	c.Check(FlushConfigToWriter(conf, out), IsNil)
+	written := out.String()
+	c.Check(strings.Contains(written, "${UQ_TEST_NICK}"), Equals, true)
+	c.Check(strings.Contains(written, "user${UQ_TEST_NICK}"), Equals, true)
+	c.Check(strings.Contains(written, "changed"), Equals, true)
+	c.Check(strings.Contains(written, "newpass"), Equals, true)
+	c.Check(strings.Contains(written, "bob"), Equals, false)
+	c.Check(strings.Contains(written, "hunter2"), Equals, false)
+	c.Check(srv.GetSaslPass(), Equals, "newpass")
+
+	conf = CreateConfigFromReader(bytes.NewBufferString(raw))
+	out.Reset()
+	FlushConfigToWriter(conf, out)
+	c.Check(strings.Contains(out.String(), "file:"+secret), Equals, true)
+	c.Check(strings.Contains(out.String(), "hunter2"), Equals, false)
+
+	c.Check(conf.IsValid(), Equals, false)
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	conf.DisplayErrors()
+	setLogger()
+	c.Check(strings.Contains(buf.String(), redacted), Equals, true)
+	c.Check(strings.Contains(buf.String(), "notabool"), Equals, false)
+}
+
+func (s *s) TestConfig_InterpolateErrors(c *C) {
+	os.Unsetenv("UQ_TEST_UNSET")
+	raw := `servers:
+    myserver:
+        nick: ${UQ_TEST_UNSET}
+        saslpass: file:/nonexistent/ultimateq/secret
+`
+	conf := CreateConfigFromReader(bytes.NewBufferString(raw))
+	c.Assert(len(conf.Errors), Equals, 2)
+	c.Check(conf.Errors[0].Error(), Matches, `.*Failed to read.*SaslPass.*`)
+	c.Check(conf.Errors[1].Error(), Matches, `.*UQ_TEST_UNSET.*not set.*`)
+	c.Check(conf.Servers["myserver"].Nick, Equals, "${UQ_TEST_UNSET}")
+}
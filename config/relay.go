@@ -0,0 +1,250 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// Relay events, these are the names used to turn relaying of an event on with
+// RelayEvents and to change how it's shown with RelayFormat. Messages, actions
+// and notices are always relayed.
+const (
+	RelayPrivmsg = "privmsg"
+	RelayAction  = "action"
+	RelayNotice  = "notice"
+	RelayJoin    = "join"
+	RelayPart    = "part"
+	RelayNick    = "nick"
+	RelayKick    = "kick"
+)
+
+// The following are for relay config errors.
+const (
+	errMsgRelayContext   = "config: Relay settings require a relay, use .Relay()"
+	errMsgDuplicateRelay = "config: Relay names must be unique."
+	errRelayLinks        = "number of links"
+	errRelayLink         = "link"
+	errRelayEvent        = "relay event"
+	errRelayFormat       = "relay format"
+)
+
+var (
+	// defaultRelayFormats are how relayed events are shown if the relay does
+	// not override them. See Relay.GetFormat for the placeholders.
+	defaultRelayFormats = map[string]string{
+		RelayPrivmsg: "<{tag}/{nick}> {message}",
+		RelayAction:  "* {tag}/{nick} {message}",
+		RelayNotice:  "-{tag}/{nick}- {message}",
+		RelayJoin:    "* {tag}/{nick} has joined {channel}",
+		RelayPart:    "* {tag}/{nick} has left {channel} ({message})",
+		RelayNick:    "* {tag}/{nick} is now known as {tag}/{newnick}",
+		RelayKick: "* {tag}/{target} was kicked from {channel} by " +
+			"{tag}/{nick} ({message})",
+	}
+)
+
+// Relay links channels on different servers together, what is said in one
+// of them is repeated in all the others. Links are given as server/#channel
+// where server is the name of a configured server.
+type Relay struct {
+	Name string
+
+	// The channels being linked.
+	Links []string
+
+	// The events to relay on top of messages, actions and notices.
+	Events []string
+
+	// Formats overrides the way events are shown by event name, and tags
+	// overrides the name shown in front of nicks by server name.
+	Formats map[string]string
+	Tags    map[string]string
+}
+
+// RelayLink is a channel on a server that is part of a relay.
+type RelayLink struct {
+	Server  string
+	Channel string
+}
+
+// ParseRelayLink splits a link in the form server/#channel, ok is false if
+// the link is not in that form.
+func ParseRelayLink(link string) (l RelayLink, ok bool) {
+	i := strings.IndexByte(link, '/')
+	if i <= 0 || i == len(link)-1 {
+		return
+	}
+	return RelayLink{link[:i], link[i+1:]}, true
+}
+
+// Relay fluently creates a relay linking the channels given in the form
+// server/#channel and sets the relay context to it. The relay context is used
+// by RelayEvents, RelayFormat and RelayTag.
+func (c *Config) Relay(name string, links ...string) *Config {
+	if len(name) == 0 {
+		c.addError(fmtErrMissing, "<NONE>", "relay name")
+		return c
+	}
+	if c.Relays == nil {
+		c.Relays = make(map[string]*Relay)
+	}
+	if _, ok := c.Relays[name]; ok {
+		c.addError(errMsgDuplicateRelay)
+		return c
+	}
+
+	c.relay = &Relay{Name: name, Links: make([]string, len(links))}
+	copy(c.relay.Links, links)
+	c.Relays[name] = c.relay
+	return c
+}
+
+// RelayEvents fluently sets the events the current relay repeats on top of
+// messages, actions and notices. See the Relay constants.
+func (c *Config) RelayEvents(events ...string) *Config {
+	if c.relay == nil {
+		c.addError(errMsgRelayContext)
+		return c
+	}
+	c.relay.Events = make([]string, len(events))
+	copy(c.relay.Events, events)
+	return c
+}
+
+// RelayFormat fluently sets how an event is shown by the current relay.
+func (c *Config) RelayFormat(event, format string) *Config {
+	if c.relay == nil {
+		c.addError(errMsgRelayContext)
+		return c
+	}
+	if c.relay.Formats == nil {
+		c.relay.Formats = make(map[string]string)
+	}
+	c.relay.Formats[event] = format
+	return c
+}
+
+// RelayTag fluently sets the name shown in front of the nicks of people on a
+// server by the current relay. It tells apart people using the same nick on
+// different networks.
+func (c *Config) RelayTag(server, tag string) *Config {
+	if c.relay == nil {
+		c.addError(errMsgRelayContext)
+		return c
+	}
+	if c.relay.Tags == nil {
+		c.relay.Tags = make(map[string]string)
+	}
+	c.relay.Tags[server] = tag
+	return c
+}
+
+// GetRelay retrieves the relay by name if it exists, nil if not.
+func (c *Config) GetRelay(name string) *Relay {
+	return c.Relays[name]
+}
+
+// validateRelays checks the relays for errors and adds them to the
+// validator.
+func (c *Config) validateRelays(v *validator) {
+	names := make([]string, 0, len(c.Relays))
+	for name := range c.Relays {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		r := c.Relays[name]
+		if links := c.GetRelayLinks(name); len(links) < 2 {
+			v.invalid(relayPath(name, "links"), errRelayLinks, len(links))
+		}
+		for _, link := range r.Links {
+			l, ok := ParseRelayLink(link)
+			if !ok || c.Servers[l.Server] == nil ||
+				!rgxChannel.MatchString(l.Channel) {
+				v.invalid(relayPath(name, "links"), errRelayLink, link)
+			}
+		}
+		for _, event := range r.Events {
+			if _, ok := defaultRelayFormats[event]; !ok {
+				v.invalid(relayPath(name, "events"), errRelayEvent, event)
+			}
+		}
+		for event := range r.Formats {
+			if _, ok := defaultRelayFormats[event]; !ok {
+				v.invalid(relayPath(name, "formats", event), errRelayFormat,
+					event)
+			}
+		}
+	}
+}
+
+// clone deep copies a relay.
+func (r *Relay) clone() *Relay {
+	newrelay := *r
+	newrelay.Links = append([]string(nil), r.Links...)
+	newrelay.Events = append([]string(nil), r.Events...)
+	newrelay.Formats = cloneStrings(r.Formats)
+	newrelay.Tags = cloneStrings(r.Tags)
+	return &newrelay
+}
+
+// cloneStrings copies a map of strings.
+func cloneStrings(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	clone := make(map[string]string, len(m))
+	for k, v := range m {
+		clone[k] = v
+	}
+	return clone
+}
+
+// GetName gets r.name
+func (r *Relay) GetName() string {
+	return r.Name
+}
+
+// GetLinks gets the links of the relay that are in the form server/#channel.
+func (r *Relay) GetLinks() (links []RelayLink) {
+	for _, link := range r.Links {
+		if l, ok := ParseRelayLink(link); ok {
+			links = append(links, l)
+		}
+	}
+	return
+}
+
+// HasEvent checks if the relay repeats an event. Messages, actions and
+// notices are always repeated.
+func (r *Relay) HasEvent(event string) bool {
+	switch event {
+	case RelayPrivmsg, RelayAction, RelayNotice:
+		return true
+	}
+	for _, e := range r.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFormat gets the format of an event, or the default format. The
+// placeholders {tag}, {server}, {nick}, {channel}, {message}, {target} and
+// {newnick} are replaced when the event is relayed.
+func (r *Relay) GetFormat(event string) string {
+	if format, ok := r.Formats[event]; ok && len(format) > 0 {
+		return format
+	}
+	return defaultRelayFormats[event]
+}
+
+// GetTag gets the tag of a server, or the server name.
+func (r *Relay) GetTag(server string) string {
+	if tag, ok := r.Tags[server]; ok && len(tag) > 0 {
+		return tag
+	}
+	return server
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"bytes"
+	. "gopkg.in/check.v1"
+)
+
+func relayConfig() *Config {
+	return CreateConfig().
+		Nick(srv1.Nick).
+		Realname(srv1.Realname).
+		Username(srv1.Username).
+		Userhost(srv1.Userhost).
+		Server(srv1.GetName()).
+		Server(srv2.GetName())
+}
+
+func (s *s) TestRelay_ParseLink(c *C) {
+	l, ok := ParseRelayLink("irc1/#chan/x")
+	c.Check(ok, Equals, true)
+	c.Check(l, Equals, RelayLink{"irc1", "#chan/x"})
+
+	for _, link := range []string{"", "irc1", "/#chan", "irc1/"} {
+		_, ok = ParseRelayLink(link)
+		c.Check(ok, Equals, false, Commentf("link: %q", link))
+	}
+}
+
+func (s *s) TestRelay_Fluent(c *C) {
+	conf := relayConfig().
+		Relay("team", "irc1/#team", "irc2/#team").
+		RelayEvents(RelayJoin, RelayNick).
+		RelayFormat(RelayPrivmsg, "[{tag}] {nick}: {message}").
+		RelayTag("irc1", "gs")
+
+	c.Check(len(conf.Errors), Equals, 0)
+	c.Check(conf.IsValid(), Equals, true)
+
+	r := conf.GetRelay("team")
+	c.Check(r.GetName(), Equals, "team")
+	c.Check(r.GetLinks(), DeepEquals,
+		[]RelayLink{{"irc1", "#team"}, {"irc2", "#team"}})
+	c.Check(r.HasEvent(RelayPrivmsg), Equals, true)
+	c.Check(r.HasEvent(RelayAction), Equals, true)
+	c.Check(r.HasEvent(RelayNotice), Equals, true)
+	c.Check(r.HasEvent(RelayJoin), Equals, true)
+	c.Check(r.HasEvent(RelayNick), Equals, true)
+	c.Check(r.HasEvent(RelayPart), Equals, false)
+	c.Check(r.GetFormat(RelayPrivmsg), Equals, "[{tag}] {nick}: {message}")
+	c.Check(r.GetFormat(RelayJoin), Equals, defaultRelayFormats[RelayJoin])
+	c.Check(r.GetTag("irc1"), Equals, "gs")
+	c.Check(r.GetTag("irc2"), Equals, "irc2")
+
+	conf.Relay("team")
+	c.Check(len(conf.Errors), Equals, 1)
+	c.Check(conf.Errors[0].Error(), Equals, errMsgDuplicateRelay)
+}
+
+func (s *s) TestRelay_NoContext(c *C) {
+	conf := CreateConfig().
+		RelayEvents(RelayJoin).
+		RelayFormat(RelayJoin, "").
+		RelayTag("irc1", "gs").
+		Relay("")
+	c.Check(len(conf.Errors), Equals, 4)
+	for _, err := range conf.Errors[:3] {
+		c.Check(err.Error(), Equals, errMsgRelayContext)
+	}
+	c.Check(conf.Errors[3].Error(), Matches, reqErr("relay name"))
+}
+
+func (s *s) TestRelay_Validation(c *C) {
+	conf := relayConfig().
+		Relay("a", "irc1/#team").
+		Relay("b", "irc1/#team", "nope/#team", "irc2/team").
+		RelayEvents("quit").
+		RelayFormat("topic", "{message}")
+
+	c.Check(conf.IsValid(), Equals, false)
+	c.Check(len(conf.Errors), Equals, 5)
+	c.Check(conf.Errors[0].Error(), Matches, invErr(errRelayLinks))
+	c.Check(conf.Errors[1].Error(), Matches, invErr(errRelayLink)+"nope.*")
+	c.Check(conf.Errors[2].Error(), Matches, invErr(errRelayLink)+"team")
+	c.Check(conf.Errors[3].Error(), Matches, invErr(errRelayEvent))
+	c.Check(conf.Errors[4].Error(), Matches, invErr(errRelayFormat))
+}
+
+func (s *s) TestRelay_Clone(c *C) {
+	conf := relayConfig().Relay("team", "irc1/#team", "irc2/#team").
+		RelayEvents(RelayKick).
+		RelayFormat(RelayKick, "kicked").
+		RelayTag("irc2", "gs2")
+	newconf := conf.Clone()
+	newrelay := newconf.GetRelay("team")
+	newrelay.Links[0] = "irc1/#other"
+	newrelay.Events[0] = RelayJoin
+	newrelay.Formats[RelayKick] = "booted"
+	newrelay.Tags["irc2"] = "other"
+	newconf.Relay("other", "irc1/#other", "irc2/#other")
+
+	r := conf.GetRelay("team")
+	c.Check(r.Links, DeepEquals, []string{"irc1/#team", "irc2/#team"})
+	c.Check(r.Events, DeepEquals, []string{RelayKick})
+	c.Check(r.Formats, DeepEquals, map[string]string{RelayKick: "kicked"})
+	c.Check(r.Tags, DeepEquals, map[string]string{"irc2": "gs2"})
+	c.Check(conf.GetRelay("other"), IsNil)
+}
+
+func (s *s) TestRelay_File(c *C) {
+	conf := relayConfig().
+		Relay("team", "irc1/#team", "irc2/#team").
+		RelayEvents(RelayKick).
+		RelayTag("irc2", "gs2")
+
+	buf := &bytes.Buffer{}
+	c.Check(FlushConfigToWriter(conf, buf), IsNil)
+	read := CreateConfigFromReader(buf)
+	c.Check(read.IsValid(), Equals, true)
+
+	r := read.GetRelay("team")
+	c.Check(r, NotNil)
+	c.Check(r.GetName(), Equals, "team")
+	c.Check(r.Links, DeepEquals, conf.GetRelay("team").Links)
+	c.Check(r.HasEvent(RelayKick), Equals, true)
+	c.Check(r.GetTag("irc2"), Equals, "gs2")
+}
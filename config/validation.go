@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Validation rules, these say what a ValidationError's value broke.
+const (
+	// RuleRequired is broken when a value is missing.
+	RuleRequired = "required"
+	// RuleInvalid is broken when a value can't be parsed or is malformed.
+	RuleInvalid = "invalid"
+	// RuleDuplicate is broken when a value has to be unique but isn't.
+	RuleDuplicate = "duplicate"
+)
+
+// ValidationError is a problem found in a config by Validate. Path is where
+// the bad key is in the config file, for example: servers, irc.net, nick. It's
+// empty for problems with the whole config. Values read from the environment
+// or files are redacted.
+type ValidationError struct {
+	// Server is the name of the server the problem is in, empty if it's not
+	// in a server.
+	Server string `json:"server"`
+	// Path is the key of the bad value from the top of the config file.
+	Path []string `json:"path"`
+	// Value is the value that was given, empty if it was missing.
+	Value string `json:"value"`
+	// Rule is what the value broke, one of the Rule constants.
+	Rule string `json:"rule"`
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (v ValidationError) Error() string {
+	return v.Message
+}
+
+// Validate checks the configuration for errors and returns them. Unlike
+// IsValid the config's Errors are left alone.
+func (c *Config) Validate() []ValidationError {
+	v := &validator{conf: c}
+	if len(c.Servers) == 0 {
+		v.add(ValidationError{
+			Path:    []string{"servers"},
+			Rule:    RuleRequired,
+			Message: errMsgServersRequired,
+		})
+		return v.errs
+	}
+
+	c.validateServer(v, c.Global, false)
+	c.validateChannels(v, c.Global)
+	for _, name := range c.serverNames() {
+		s := c.Servers[name]
+		c.validateServer(v, s, true)
+		c.validateChannels(v, s)
+	}
+	c.validateIdentities(v)
+	c.validateBouncers(v)
+	c.validateRelays(v)
+
+	return v.errs
+}
+
+// validator collects the errors found by Validate.
+type validator struct {
+	conf *Config
+	errs []ValidationError
+}
+
+// add adds an error, redacting interpolated values from it.
+func (v *validator) add(err ValidationError) {
+	if len(err.Path) > 1 && err.Path[0] == "servers" {
+		err.Server = err.Path[1]
+	}
+	err.Value = v.conf.Redact(err.Value)
+	err.Message = v.conf.Redact(err.Message)
+	v.errs = append(v.errs, err)
+}
+
+// invalid adds an error for a value at path that's invalid, what is the name
+// of the value in the message.
+func (v *validator) invalid(path []string, what string, value interface{}) {
+	v.add(ValidationError{
+		Path:    path,
+		Value:   fmt.Sprint(value),
+		Rule:    RuleInvalid,
+		Message: fmt.Sprintf(fmtErrInvalid, v.name(path), what, value),
+	})
+}
+
+// missing adds an error for a value at path that's required, what is the name
+// of the value in the message.
+func (v *validator) missing(path []string, what string) {
+	v.add(ValidationError{
+		Path:    path,
+		Rule:    RuleRequired,
+		Message: fmt.Sprintf(fmtErrMissing, v.name(path), what),
+	})
+}
+
+// name gets the name of the server or relay a path is in.
+func (v *validator) name(path []string) string {
+	if len(path) > 1 && path[0] != "global" {
+		return path[1]
+	}
+	return v.conf.Global.GetName()
+}
+
+// serverPath makes a path to a key in a server, or in the global context.
+func (c *Config) serverPath(s *Server, keys ...string) []string {
+	var path []string
+	if s == c.Global {
+		path = []string{"global"}
+	} else {
+		path = []string{"servers", s.GetName()}
+	}
+	return append(path, keys...)
+}
+
+// relayPath makes a path to a key in a relay.
+func relayPath(name string, keys ...string) []string {
+	return append([]string{"relays", name}, keys...)
+}
+
+// serverNames gets the names of the servers, sorted.
+func (c *Config) serverNames() []string {
+	names := make([]string, 0, len(c.Servers))
+	for name := range c.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	. "gopkg.in/check.v1"
+	"os"
+)
+
+var invalidConfiguration = `global:
+    nick: nick
+    username: username
+    userhost: userhost.com
+    realname: realname
+    keepalive: often
+servers:
+    b.net:
+        ssl: maybe
+        channelsettings:
+            '#chan':
+                prefix: '!!'
+    a.net:
+        network: net
+    c.net:
+        network: net
+relays:
+    bridge:
+        links: [a.net/#chan]
+        events: [dance]
+`
+
+func (s *s) TestValidation_Validate(c *C) {
+	conf := CreateConfigFromReader(bytes.NewBufferString(invalidConfiguration))
+	c.Check(conf.Errors, HasLen, 0)
+
+	errs := conf.Validate()
+	c.Check(conf.Errors, HasLen, 0)
+
+	expect := []ValidationError{
+		{"", []string{"global", "keepalive"}, "often", RuleInvalid, ""},
+		{"b.net", []string{"servers", "b.net", "ssl"}, "maybe",
+			RuleInvalid, ""},
+		{"b.net", []string{"servers", "b.net", "channelsettings", "#chan",
+			"prefix"}, "!!", RuleInvalid, ""},
+		{"c.net", []string{"servers", "c.net", "nick"}, "nick",
+			RuleDuplicate, ""},
+		{"", []string{"relays", "bridge", "links"}, "1", RuleInvalid, ""},
+		{"", []string{"relays", "bridge", "events"}, "dance", RuleInvalid,
+			""},
+	}
+	c.Assert(errs, HasLen, len(expect))
+	for i, err := range errs {
+		c.Check(err.Message, Not(Equals), "")
+		c.Check(err.Error(), Equals, err.Message)
+		err.Message = ""
+		c.Check(err, DeepEquals, expect[i])
+	}
+	c.Check(errs[1].Error(), Matches, invErr(errSsl)+"maybe")
+	c.Check(errs[2].Error(), Matches, invErr(errChannelPrefix))
+	c.Check(errs[3].Error(), Matches, `.*nick.*a\.net.*`)
+
+	c.Check(conf.IsValid(), Equals, false)
+	c.Assert(conf.Errors, HasLen, len(expect))
+	for i, err := range conf.Errors {
+		verr, ok := err.(ValidationError)
+		c.Check(ok, Equals, true)
+		c.Check(verr.Path, DeepEquals, expect[i].Path)
+	}
+}
+
+func (s *s) TestValidation_Missing(c *C) {
+	errs := CreateConfig().Validate()
+	c.Assert(errs, HasLen, 1)
+	c.Check(errs[0].Path, DeepEquals, []string{"servers"})
+	c.Check(errs[0].Rule, Equals, RuleRequired)
+	c.Check(errs[0].Error(), Equals, errMsgServersRequired)
+
+	errs = CreateConfig().Server("irc.net").Sasl("user", "").Validate()
+	c.Assert(errs, HasLen, 5)
+	c.Check(errs[0], DeepEquals, ValidationError{
+		Server:  "irc.net",
+		Path:    []string{"servers", "irc.net", "saslpass"},
+		Rule:    RuleRequired,
+		Message: errs[0].Message,
+	})
+	c.Check(errs[0].Error(), Matches, reqErr(errSaslPass))
+	c.Check(errs[1].Path, DeepEquals, []string{"servers", "irc.net", "nick"})
+}
+
+func (s *s) TestValidation_Redacted(c *C) {
+	os.Setenv("UQ_TEST_NICK", "bad nick")
+	defer os.Unsetenv("UQ_TEST_NICK")
+
+	conf := CreateConfigFromReader(bytes.NewBufferString(`global:
+    nick: ${UQ_TEST_NICK}
+    username: username
+    userhost: userhost.com
+    realname: realname
+servers:
+    irc.net:
+`))
+	c.Check(conf.Errors, HasLen, 0)
+
+	errs := conf.Validate()
+	c.Assert(errs, HasLen, 2)
+	for _, err := range errs {
+		c.Check(err.Value, Equals, redacted)
+		c.Check(err.Error(), Not(Matches), ".*bad nick.*")
+	}
+}
+
+func (s *s) TestValidation_JSON(c *C) {
+	err := ValidationError{
+		Server:  "irc.net",
+		Path:    []string{"servers", "irc.net", "ssl"},
+		Value:   "maybe",
+		Rule:    RuleInvalid,
+		Message: "message",
+	}
+	buf, e := json.Marshal(err)
+	c.Check(e, IsNil)
+	c.Check(string(buf), Equals, `{"server":"irc.net",`+
+		`"path":["servers","irc.net","ssl"],"value":"maybe",`+
+		`"rule":"invalid","message":"message"}`)
+}
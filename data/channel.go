@@ -3,6 +3,7 @@ package data
 import (
 	"github.com/aarondl/ultimateq/irc"
 	"strings"
+	"time"
 )
 
 const (
@@ -10,10 +11,25 @@ const (
 	banMode = 'b'
 )
 
+// ListEntry is an entry in one of a channel's lists: the bans, ban exceptions
+// or invite exceptions. Setter and Time are empty when the server didn't say
+// who set the entry or when.
+type ListEntry struct {
+	Mask   string
+	Setter string
+	Time   time.Time
+}
+
 // Channel encapsulates all the data associated with a channel.
 type Channel struct {
-	name  string
-	topic string
+	name        string
+	topic       string
+	topicSetter string
+	topicTime   time.Time
+	created     time.Time
+	// lists holds who set the entries of the address modes, and when, by
+	// mode and mask.
+	lists map[rune]map[string]ListEntry
 	*ChannelModes
 }
 
@@ -31,6 +47,25 @@ func CreateChannel(name string,
 	}
 }
 
+// clone makes a copy of the channel that uses the given kinds.
+func (c *Channel) clone(
+	kinds *ChannelModeKinds, userKinds *UserModeKinds) *Channel {
+
+	ch := *c
+	ch.ChannelModes = c.ChannelModes.clone(kinds, userKinds)
+	if c.lists != nil {
+		ch.lists = make(map[rune]map[string]ListEntry, len(c.lists))
+		for mode, entries := range c.lists {
+			clone := make(map[string]ListEntry, len(entries))
+			for mask, entry := range entries {
+				clone[mask] = entry
+			}
+			ch.lists[mode] = clone
+		}
+	}
+	return &ch
+}
+
 // Name gets the name of the channel.
 func (c *Channel) Name() string {
 	return c.name
@@ -46,6 +81,88 @@ func (c *Channel) Topic() string {
 	return c.topic
 }
 
+// SetTopicInfo sets who set the topic of the channel and when.
+func (c *Channel) SetTopicInfo(setter string, when time.Time) {
+	c.topicSetter = setter
+	c.topicTime = when
+}
+
+// TopicSetter gets who set the topic of the channel, this is a nick or a
+// fullhost depending on the server.
+func (c *Channel) TopicSetter() string {
+	return c.topicSetter
+}
+
+// TopicTime gets when the topic of the channel was set.
+func (c *Channel) TopicTime() time.Time {
+	return c.topicTime
+}
+
+// SetCreated sets when the channel was created.
+func (c *Channel) SetCreated(when time.Time) {
+	c.created = when
+}
+
+// Created gets when the channel was created, zero if it's not known.
+func (c *Channel) Created() time.Time {
+	return c.created
+}
+
+// AddListEntry adds an entry to the list of an address mode, replacing who
+// set it and when if the mask is in the list already.
+func (c *Channel) AddListEntry(mode rune, entry ListEntry) {
+	c.setAddress(mode, entry.Mask)
+	if c.lists == nil {
+		c.lists = make(map[rune]map[string]ListEntry)
+	}
+	entries, ok := c.lists[mode]
+	if !ok {
+		entries = make(map[string]ListEntry)
+		c.lists[mode] = entries
+	}
+	entries[entry.Mask] = entry
+}
+
+// DeleteListEntry deletes an entry from the list of an address mode.
+func (c *Channel) DeleteListEntry(mode rune, mask string) {
+	c.unsetAddress(mode, mask)
+	c.forgetListEntry(mode, mask)
+}
+
+// ListEntries gets the entries in the list of an address mode. Nil if the
+// list is empty.
+func (c *Channel) ListEntries(mode rune) []ListEntry {
+	masks := c.GetAddresses(mode)
+	if len(masks) == 0 {
+		return nil
+	}
+
+	entries := make([]ListEntry, len(masks))
+	for i := 0; i < len(masks); i++ {
+		if entry, ok := c.lists[mode][masks[i]]; ok {
+			entries[i] = entry
+		} else {
+			entries[i] = ListEntry{Mask: masks[i]}
+		}
+	}
+	return entries
+}
+
+// BanEntries gets the bans of the channel along with who set them and when.
+func (c *Channel) BanEntries() []ListEntry {
+	return c.ListEntries(banMode)
+}
+
+// forgetListEntry removes who set an entry and when.
+func (c *Channel) forgetListEntry(mode rune, mask string) {
+	if entries, ok := c.lists[mode]; ok {
+		delete(entries, mask)
+		if len(entries) == 0 {
+			delete(c.lists, mode)
+		}
+	}
+}
+
 // IsBanned checks a host to see if it's banned.
 func (c *Channel) IsBanned(host irc.Host) bool {
 	if !strings.ContainsAny(string(host), "!@") {
@@ -64,6 +181,7 @@ func (c *Channel) IsBanned(host irc.Host) bool {
 // SetBans sets the bans of the channel.
 func (c *Channel) SetBans(bans []string) {
 	delete(c.modes, banMode)
+	delete(c.lists, banMode)
 	for i := 0; i < len(bans); i++ {
 		c.setAddress(banMode, bans[i])
 	}
@@ -72,6 +190,7 @@ func (c *Channel) SetBans(bans []string) {
 // AddBan adds to the channel's bans.
 func (c *Channel) AddBan(ban string) {
 	c.setAddress(banMode, ban)
+	c.forgetListEntry(banMode, ban)
 }
 
 // Bans gets the bans of the channel.
@@ -92,7 +211,7 @@ func (c *Channel) HasBan(ban string) bool {
 
 // DeleteBan deletes a ban from the list.
 func (c *Channel) DeleteBan(ban string) {
-	c.unsetAddress(banMode, ban)
+	c.DeleteListEntry(banMode, ban)
 }
 
 // String returns the name of the channel.
@@ -119,6 +238,6 @@ func (c *Channel) DeleteBans(mask irc.Host) {
 	}
 
 	for i := 0; i < len(toRemove); i++ {
-		c.unsetAddress(banMode, toRemove[i])
+		c.DeleteListEntry(banMode, toRemove[i])
 	}
 }
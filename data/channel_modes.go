@@ -34,6 +34,24 @@ func CreateChannelModes(
 	}
 }
 
+// clone makes a copy of the modes that uses the given kinds.
+func (m *ChannelModes) clone(
+	kinds *ChannelModeKinds, userKinds *UserModeKinds) *ChannelModes {
+
+	clone := CreateChannelModes(kinds, userKinds)
+	for mode, set := range m.modes {
+		clone.modes[mode] = set
+	}
+	for mode, arg := range m.argModes {
+		clone.argModes[mode] = arg
+	}
+	for mode, addresses := range m.addressModes {
+		clone.addressModes[mode] = append([]string(nil), addresses...)
+	}
+	clone.addresses = m.addresses
+	return clone
+}
+
 // Apply takes a complex modestring and applies it to a an existing modeset.
 // Assumes any modes not declared as part of ChannelModeKinds were not intended
 // for channel and are user-targeted (therefore taking an argument)
@@ -2,6 +2,7 @@ package data
 
 import (
 	. "gopkg.in/check.v1"
+	"time"
 )
 
 func (s *s) TestChannel_Create(c *C) {
@@ -26,6 +27,48 @@ func (s *s) TestChannel_GettersSetters(c *C) {
 	c.Check(ch.Topic(), Equals, topic)
 }
 
+func (s *s) TestChannel_TopicInfo(c *C) {
+	ch := CreateChannel("#chan", testChannelKinds, testUserKinds)
+	c.Check(ch.TopicSetter(), Equals, "")
+	c.Check(ch.TopicTime().IsZero(), Equals, true)
+	c.Check(ch.Created().IsZero(), Equals, true)
+
+	when := time.Unix(1367197165, 0)
+	ch.SetTopicInfo("nick", when)
+	ch.SetCreated(when)
+	c.Check(ch.TopicSetter(), Equals, "nick")
+	c.Check(ch.TopicTime().Equal(when), Equals, true)
+	c.Check(ch.Created().Equal(when), Equals, true)
+}
+
+func (s *s) TestChannel_ListEntries(c *C) {
+	ch := CreateChannel("#chan", testChannelKinds, testUserKinds)
+	c.Check(ch.ListEntries('b'), IsNil)
+	c.Check(ch.BanEntries(), IsNil)
+
+	when := time.Unix(1367197165, 0)
+	ch.AddListEntry('b', ListEntry{Mask: "a!*@*", Setter: "nick", Time: when})
+	ch.AddBan("b!*@*")
+	ch.AddListEntry('e', ListEntry{Mask: "c!*@*"})
+
+	c.Check(ch.BanEntries(), DeepEquals, []ListEntry{
+		{Mask: "a!*@*", Setter: "nick", Time: when},
+		{Mask: "b!*@*"},
+	})
+	c.Check(ch.ListEntries('e'), DeepEquals, []ListEntry{{Mask: "c!*@*"}})
+	c.Check(ch.HasBan("a!*@*"), Equals, true)
+
+	ch.AddBan("a!*@*")
+	c.Check(ch.BanEntries()[0], DeepEquals, ListEntry{Mask: "a!*@*"})
+
+	ch.DeleteListEntry('e', "c!*@*")
+	c.Check(ch.ListEntries('e'), IsNil)
+	ch.DeleteBan("b!*@*")
+	ch.DeleteBans("a")
+	c.Check(ch.BanEntries(), IsNil)
+	c.Check(ch.lists, HasLen, 0)
+}
+
 func (s *s) TestChannel_Bans(c *C) {
 	bans := []string{"ban1", "ban2"}
 	ch := CreateChannel("name", testChannelKinds, testUserKinds)
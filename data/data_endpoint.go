@@ -15,6 +15,10 @@ type DataEndpoint struct {
 	store        *Store
 	protectState *sync.RWMutex
 	protectStore *sync.RWMutex
+
+	// stateCopy is the last copy made by CopyState.
+	stateCopy        *State
+	protectStateCopy sync.Mutex
 }
 
 // CreateDataEndpoint creates a data endpoint for use.
@@ -22,9 +26,12 @@ func CreateDataEndpoint(key string, write io.Writer, state *State, store *Store,
 	stateMutex, storeMutex *sync.RWMutex) *DataEndpoint {
 
 	return &DataEndpoint{
-		key, &irc.Helper{write},
-		state, store,
-		stateMutex, storeMutex,
+		key:          key,
+		Helper:       &irc.Helper{write},
+		state:        state,
+		store:        store,
+		protectState: stateMutex,
+		protectStore: storeMutex,
 	}
 }
 
@@ -34,28 +41,57 @@ func (d *DataEndpoint) GetKey() string {
 }
 
 // UsingState calls a callback if this DataEndpoint can present a data state
-// object. The returned boolean is whether or not the function was called.
+// object. The returned boolean is whether or not the function was called. The
+// state given to the callback is the shared copy from CopyState.
 func (d *DataEndpoint) UsingState(fn func(*State)) (called bool) {
-	d.protectState.RLock()
-	defer d.protectState.RUnlock()
-	if d.state != nil {
-		fn(d.state)
+	if state := d.CopyState(); state != nil {
+		fn(state)
 		called = true
 	}
 	return
 }
 
-// OpenState locks the data state, and returns it. CloseState must be called or
-// the lock will never be released and the bot will sieze up. The state must
-// be checked for nil.
+// OpenState returns the shared copy of the data state from CopyState, it no
+// longer holds a lock on the state so updates aren't blocked while it's used.
+// CloseState is kept for compatibility and does nothing. The state must be
+// checked for nil.
 func (d *DataEndpoint) OpenState() *State {
-	d.protectState.RLock()
-	return d.state
+	return d.CopyState()
 }
 
-// CloseState unlocks the data state after use by OpenState.
+// CloseState does nothing, the state from OpenState doesn't hold a lock.
 func (d *DataEndpoint) CloseState() {
-	d.protectState.RUnlock()
+}
+
+// CopyState returns a copy of the data state that can be kept for as long as
+// it's needed without blocking updates to the state. Copies are shared between
+// callers until the state changes so they must not be modified. See
+// State.Clone. The state must be checked for nil.
+func (d *DataEndpoint) CopyState() *State {
+	d.protectState.RLock()
+	defer d.protectState.RUnlock()
+	if d.state == nil {
+		return nil
+	}
+
+	d.protectStateCopy.Lock()
+	defer d.protectStateCopy.Unlock()
+	if d.stateCopy == nil || d.stateCopy.version != d.state.version {
+		d.stateCopy = d.state.Clone()
+	}
+	return d.stateCopy
+}
+
+// MatchSeen looks up the seen records of the data state that haven't been
+// saved to the store yet. They're read from the state itself since they're
+// not part of its copies. See State.MatchSeen.
+func (d *DataEndpoint) MatchSeen(mask string) []Seen {
+	d.protectState.RLock()
+	defer d.protectState.RUnlock()
+	if d.state == nil {
+		return nil
+	}
+	return d.state.MatchSeen(mask)
 }
 
 // UsingStore calls a callback if this DataEndpoint can present a data store
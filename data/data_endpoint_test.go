@@ -2,9 +2,11 @@ package data
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/aarondl/ultimateq/irc"
 	"sync"
 	. "testing"
+	"time"
 )
 
 func TestDataEndpoint(t *T) {
@@ -42,7 +44,7 @@ func TestDataEndpoint(t *T) {
 	}
 
 	ostate := ep.OpenState()
-	if ostate != state {
+	if ostate == nil || ostate == state || ostate != ep.CopyState() {
 		t.Error("Wrong object came back:", ostate)
 	}
 	ep.CloseState()
@@ -53,3 +55,147 @@ func TestDataEndpoint(t *T) {
 	}
 	ep.CloseStore()
 }
+
+func TestDataEndpoint_CopyState(t *T) {
+	var stateMutex, storeMutex sync.RWMutex
+	ep := CreateDataEndpoint("key", &bytes.Buffer{}, nil, nil,
+		&stateMutex, &storeMutex)
+	if st := ep.CopyState(); st != nil {
+		t.Error("Expected no state to copy:", st)
+	}
+
+	state, err := CreateState(irc.CreateProtoCaps())
+	if err != nil {
+		t.Fatal("Could not create state:", err)
+	}
+	ep = CreateDataEndpoint("key", &bytes.Buffer{}, state, nil,
+		&stateMutex, &storeMutex)
+	state.Self.User = self.User
+	state.addChannel(channel)
+	state.Update(&irc.Message{Name: irc.JOIN, Sender: users[0],
+		Args: []string{channel}})
+
+	copied := ep.CopyState()
+	if copied == nil || copied == state {
+		t.Fatal("Expected a copy of the state:", copied)
+	}
+	if !copied.IsOn(nicks[0], channel) {
+		t.Error("Expected the copy to have the user joined.")
+	}
+	if again := ep.CopyState(); again != copied {
+		t.Error("Expected the copy to be reused when nothing changed.")
+	}
+
+	// Holding a copy doesn't stop updates.
+	done := make(chan struct{})
+	go func() {
+		stateMutex.Lock()
+		state.Update(&irc.Message{Name: irc.PART, Sender: users[0],
+			Args: []string{channel}})
+		stateMutex.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The update was blocked by a copy of the state.")
+	}
+
+	if !copied.IsOn(nicks[0], channel) {
+		t.Error("Expected the copy to be unchanged by the update.")
+	}
+	again := ep.CopyState()
+	if again == copied || again.IsOn(nicks[0], channel) {
+		t.Error("Expected a new copy after the update.")
+	}
+}
+
+// benchmarkDispatch measures how long the dispatch loop takes to update the
+// state while handlers read it, each handler taking a while to finish with the
+// state as given by read. The timer starts once every handler has had the state
+// and the latency of each update is reported as ns/update.
+func benchmarkDispatch(b *B,
+	read func(*DataEndpoint, *sync.RWMutex) func()) {
+
+	var stateMutex, storeMutex sync.RWMutex
+	state, err := CreateState(irc.CreateProtoCaps())
+	if err != nil {
+		b.Fatal("Could not create state:", err)
+	}
+	ep := CreateDataEndpoint("key", &bytes.Buffer{}, state, nil,
+		&stateMutex, &storeMutex)
+	state.Self.User = self.User
+	state.addChannel(channel)
+	for i := 0; i < 200; i++ {
+		state.Update(&irc.Message{Name: irc.JOIN,
+			Sender: fmt.Sprintf("nick%v!user@host", i), Args: []string{channel}})
+	}
+
+	const readers = 16
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	ready := sync.WaitGroup{}
+	ready.Add(readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first := true
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				done := read(ep, &stateMutex)
+				if first {
+					ready.Done()
+					first = false
+				}
+				time.Sleep(100 * time.Microsecond)
+				done()
+			}
+		}()
+	}
+	ready.Wait()
+
+	joins := []*irc.Message{
+		{Name: irc.JOIN, Sender: "bench!user@host", Args: []string{channel}},
+		{Name: irc.PART, Sender: "bench!user@host", Args: []string{channel}},
+	}
+	var total, worst time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		stateMutex.Lock()
+		state.Update(joins[i%2])
+		stateMutex.Unlock()
+		took := time.Since(start)
+		total += took
+		if took > worst {
+			worst = took
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(total.Nanoseconds())/float64(b.N), "ns/update")
+	b.ReportMetric(float64(worst.Nanoseconds()), "max-ns/update")
+
+	close(stop)
+	wg.Wait()
+}
+
+func BenchmarkDispatch_LockState(b *B) {
+	benchmarkDispatch(b, func(ep *DataEndpoint, m *sync.RWMutex) func() {
+		m.RLock()
+		state := ep.state
+		state.GetNChanUsers(channel)
+		return m.RUnlock
+	})
+}
+
+func BenchmarkDispatch_CopyState(b *B) {
+	benchmarkDispatch(b, func(ep *DataEndpoint, m *sync.RWMutex) func() {
+		ep.CopyState().GetNChanUsers(channel)
+		return func() {}
+	})
+}
@@ -0,0 +1,182 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// netsplitLifetime is how long the users that quit in a netsplit are
+	// remembered, joining again within it makes them part of a NetJoin.
+	netsplitLifetime = 30 * time.Minute
+)
+
+// splitUser is a user that quit in a netsplit.
+type splitUser struct {
+	host string
+	hub  string
+	leaf string
+	when time.Time
+}
+
+// parseNetsplit checks if a quit message is the one servers give users that
+// quit in a netsplit: the names of the two servers that split, and returns
+// them if it is.
+func parseNetsplit(message string) (hub, leaf string, ok bool) {
+	servers := strings.Split(message, " ")
+	if len(servers) != 2 {
+		return "", "", false
+	}
+	for _, server := range servers {
+		if !isServerName(server) {
+			return "", "", false
+		}
+	}
+	return servers[0], servers[1], true
+}
+
+// isServerName checks if a name looks like a server name, servers that hide
+// their names in netsplits use wildcards like *.net and *.split.
+func isServerName(name string) bool {
+	if len(name) == 0 || name[0] == '.' || name[len(name)-1] == '.' ||
+		!strings.ContainsRune(name, '.') {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '.', c == '-', c == '_', c == '*':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// splitQuit adds a user that quit to the netsplit being gathered and removes
+// them from the database.
+func (s *State) splitQuit(fullhost, hub, leaf string) {
+	now := time.Now()
+	if s.split == nil {
+		s.split = &NetSplit{
+			Hub: hub, Leaf: leaf, Users: make(map[string][]string),
+		}
+		for nick, user := range s.splitUsers {
+			if now.Sub(user.when) >= netsplitLifetime {
+				delete(s.splitUsers, nick)
+			}
+		}
+	}
+
+	for _, channel := range s.GetUserChans(fullhost) {
+		s.split.Users[channel] = append(s.split.Users[channel], fullhost)
+	}
+	nick := strings.ToLower(irc.Nick(fullhost))
+	s.splitUsers[nick] = splitUser{host: fullhost, hub: hub, leaf: leaf,
+		when: now}
+	s.netUpdated = now
+	s.removeUser(fullhost)
+}
+
+// splitJoin adds a user joining a channel to the netjoin being gathered if
+// they quit in a netsplit, and returns true if they did.
+func (s *State) splitJoin(fullhost, channel string) bool {
+	user, ok := s.rejoined(fullhost)
+	if !ok {
+		return false
+	}
+
+	if s.netjoin == nil {
+		s.netjoin = &NetJoin{
+			Hub: user.hub, Leaf: user.leaf, Users: make(map[string][]string),
+		}
+	}
+	s.netjoin.Users[channel] = append(s.netjoin.Users[channel], fullhost)
+	s.netUpdated = time.Now()
+	return true
+}
+
+// rejoined looks up a user that quit in a netsplit that's still remembered.
+func (s *State) rejoined(fullhost string) (splitUser, bool) {
+	user, ok := s.splitUsers[strings.ToLower(irc.Nick(fullhost))]
+	if !ok || user.host != fullhost ||
+		time.Since(user.when) >= netsplitLifetime {
+
+		return splitUser{}, false
+	}
+	return user, true
+}
+
+// FlushNetEvents returns the netsplit and netjoin being gathered once no
+// message has been part of them for the quiet duration. Without it they're
+// only returned when the next message that's not part of them arrives, which
+// could be a long time on a quiet server.
+func (s *State) FlushNetEvents(quiet time.Duration) []StateEvent {
+	if (s.split == nil && s.netjoin == nil) ||
+		time.Since(s.netUpdated) < quiet {
+
+		return nil
+	}
+	return s.netEvents(nil)
+}
+
+// netEvents returns the netsplit and netjoin being gathered if the message
+// is not part of them, or if the message is nil. The users in a netjoin
+// that's returned are forgotten as having quit in a netsplit.
+func (s *State) netEvents(m *irc.Message) (events []StateEvent) {
+	if s.split != nil && !s.continuesSplit(m) {
+		sortUsers(s.split.Users)
+		events = append(events, *s.split)
+		s.split = nil
+	}
+
+	if s.netjoin != nil && !s.continuesNetjoin(m) {
+		for _, users := range s.netjoin.Users {
+			for _, user := range users {
+				delete(s.splitUsers, strings.ToLower(irc.Nick(user)))
+			}
+		}
+		sortUsers(s.netjoin.Users)
+		events = append(events, *s.netjoin)
+		s.netjoin = nil
+	} else if s.netjoin != nil {
+		s.netUpdated = time.Now()
+	}
+	return events
+}
+
+// continuesSplit checks if a message is a QUIT of the netsplit being
+// gathered.
+func (s *State) continuesSplit(m *irc.Message) bool {
+	if m == nil || m.Name != irc.QUIT || len(m.Args) == 0 {
+		return false
+	}
+	hub, leaf, ok := parseNetsplit(m.Args[0])
+	return ok && hub == s.split.Hub && leaf == s.split.Leaf
+}
+
+// continuesNetjoin checks if a message is part of the netjoin being
+// gathered: a JOIN of a user that quit in the same netsplit, or the modes the
+// server gives them back.
+func (s *State) continuesNetjoin(m *irc.Message) bool {
+	if m == nil {
+		return false
+	}
+	switch m.Name {
+	case irc.JOIN:
+		user, ok := s.rejoined(m.Sender)
+		return ok && user.hub == s.netjoin.Hub && user.leaf == s.netjoin.Leaf
+	case irc.MODE:
+		return !strings.ContainsAny(m.Sender, "!@")
+	}
+	return false
+}
+
+// sortUsers sorts the users of each channel.
+func sortUsers(users map[string][]string) {
+	for _, list := range users {
+		sort.Strings(list)
+	}
+}
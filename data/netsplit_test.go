@@ -0,0 +1,187 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+func (s *s) TestNetsplit_Parse(c *C) {
+	tests := []struct {
+		Message   string
+		Hub, Leaf string
+		Ok        bool
+	}{
+		{"hub.net leaf.net", "hub.net", "leaf.net", true},
+		{"*.net *.split", "*.net", "*.split", true},
+		{"irc-1.a.org irc_2.b.org", "irc-1.a.org", "irc_2.b.org", true},
+		{"", "", "", false},
+		{"hub.net", "", "", false},
+		{"hub.net  leaf.net", "", "", false},
+		{"hub.net leaf.net now", "", "", false},
+		{"Quit: hub.net", "", "", false},
+		{"hub leaf", "", "", false},
+		{".net leaf.", "", "", false},
+		{"hub.net le@f.net", "", "", false},
+	}
+
+	for _, test := range tests {
+		hub, leaf, ok := parseNetsplit(test.Message)
+		c.Check(ok, Equals, test.Ok, Commentf("%q", test.Message))
+		c.Check(hub, Equals, test.Hub)
+		c.Check(leaf, Equals, test.Leaf)
+	}
+}
+
+func (s *s) TestNetsplit_SplitAndJoin(c *C) {
+	st := eventState(c)
+	split := func(sender string) []StateEvent {
+		events, err := st.Update(&irc.Message{
+			Name: irc.QUIT, Sender: sender, Args: []string{"hub.net leaf.net"},
+		})
+		c.Check(err, IsNil)
+		return events
+	}
+	join := func(sender, channel string) []StateEvent {
+		events, err := st.Update(&irc.Message{
+			Name: irc.JOIN, Sender: sender, Args: []string{channel},
+		})
+		c.Check(err, IsNil)
+		return events
+	}
+	ping := func() []StateEvent {
+		events, err := st.Update(&irc.Message{
+			Name: irc.PING, Sender: server, Args: []string{server},
+		})
+		c.Check(err, IsNil)
+		return events
+	}
+
+	c.Check(split(users[1]), HasLen, 0)
+	c.Check(split(users[0]), HasLen, 0)
+	c.Check(st.GetUser(users[0]), IsNil)
+	c.Check(st.GetUser(users[1]), IsNil)
+	c.Check(st.GetNChanUsers(channels[0]), Equals, 1)
+
+	c.Check(ping(), DeepEquals, []StateEvent{
+		NetSplit{Hub: "hub.net", Leaf: "leaf.net", Users: map[string][]string{
+			channels[0]: {users[0], users[1]},
+			channels[1]: {users[0]},
+		}},
+	})
+	c.Check(ping(), HasLen, 0)
+
+	c.Check(join(users[0], channels[0]), HasLen, 0)
+	c.Check(join(users[0], channels[1]), HasLen, 0)
+	_, err := st.Update(&irc.Message{
+		Name: irc.MODE, Sender: server, Args: []string{channels[0], "+o",
+			nicks[0]},
+	})
+	c.Check(err, IsNil)
+	c.Check(join(users[1], channels[0]), HasLen, 0)
+	c.Check(st.IsOn(users[0], channels[1]), Equals, true)
+	c.Check(st.GetUsersChannelModes(users[0], channels[0]).HasMode('o'),
+		Equals, true)
+
+	joiner := "nick3!user3@host3"
+	c.Check(join(joiner, channels[0]), DeepEquals, []StateEvent{
+		NetJoin{Hub: "hub.net", Leaf: "leaf.net", Users: map[string][]string{
+			channels[0]: {users[0], users[1]},
+			channels[1]: {users[0]},
+		}},
+		UserJoined{Channel: channels[0], User: joiner},
+	})
+	c.Check(st.splitUsers, HasLen, 0)
+
+	events, _ := st.Update(&irc.Message{
+		Name: irc.PART, Sender: users[0], Args: []string{channels[1]},
+	})
+	c.Check(events, HasLen, 1)
+	c.Check(join(users[0], channels[1]), DeepEquals, []StateEvent{
+		UserJoined{Channel: channels[1], User: users[0]},
+	})
+}
+
+func (s *s) TestNetsplit_NotRejoined(c *C) {
+	st := eventState(c)
+	st.Update(&irc.Message{
+		Name: irc.QUIT, Sender: users[0], Args: []string{"*.net *.split"},
+	})
+	events, _ := st.Update(&irc.Message{
+		Name: irc.QUIT, Sender: users[1], Args: []string{"Quit: bye"},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		NetSplit{Hub: "*.net", Leaf: "*.split", Users: map[string][]string{
+			channels[0]: {users[0]},
+			channels[1]: {users[0]},
+		}},
+		UserQuit{User: users[1], Message: "Quit: bye",
+			Channels: []string{channels[0]}},
+	})
+
+	// A different host under the same nick is someone else.
+	impostor := nicks[0] + "!other@host"
+	events, _ = st.Update(&irc.Message{
+		Name: irc.JOIN, Sender: impostor, Args: []string{channels[0]},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		UserJoined{Channel: channels[0], User: impostor},
+	})
+	st.Update(&irc.Message{
+		Name: irc.PART, Sender: impostor, Args: []string{channels[0]},
+	})
+
+	// Rejoining after the split is forgotten is a normal join.
+	user := st.splitUsers[nicks[0]]
+	user.when = user.when.Add(-netsplitLifetime)
+	st.splitUsers[nicks[0]] = user
+	events, _ = st.Update(&irc.Message{
+		Name: irc.JOIN, Sender: users[0], Args: []string{channels[0]},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		UserJoined{Channel: channels[0], User: users[0]},
+	})
+
+	// Old split users are dropped when the next split starts.
+	st.Update(&irc.Message{
+		Name: irc.QUIT, Sender: users[0], Args: []string{"a.net b.net"},
+	})
+	c.Check(st.splitUsers, HasLen, 1)
+	c.Check(st.splitUsers[nicks[0]].hub, Equals, "a.net")
+	c.Check(time.Since(st.splitUsers[nicks[0]].when) < time.Minute, Equals,
+		true)
+}
+
+func (s *s) TestNetsplit_Flush(c *C) {
+	st := eventState(c)
+	c.Check(st.FlushNetEvents(0), HasLen, 0)
+
+	st.Update(&irc.Message{
+		Name: irc.QUIT, Sender: users[0], Args: []string{"hub.net leaf.net"},
+	})
+	c.Check(st.FlushNetEvents(time.Minute), HasLen, 0)
+	st.netUpdated = st.netUpdated.Add(-time.Minute)
+	c.Check(st.FlushNetEvents(time.Minute), DeepEquals, []StateEvent{
+		NetSplit{Hub: "hub.net", Leaf: "leaf.net", Users: map[string][]string{
+			channels[0]: {users[0]},
+			channels[1]: {users[0]},
+		}},
+	})
+	c.Check(st.FlushNetEvents(0), HasLen, 0)
+
+	st.Update(&irc.Message{
+		Name: irc.JOIN, Sender: users[0], Args: []string{channels[0]},
+	})
+	c.Check(st.FlushNetEvents(time.Minute), HasLen, 0)
+	c.Check(st.FlushNetEvents(0), DeepEquals, []StateEvent{
+		NetJoin{Hub: "hub.net", Leaf: "leaf.net", Users: map[string][]string{
+			channels[0]: {users[0]},
+		}},
+	})
+	c.Check(st.splitUsers, HasLen, 0)
+}
+
+func (s *s) TestStateEvents_NetGetChannel(c *C) {
+	c.Check(NetSplit{}.GetChannel(), Equals, "")
+	c.Check(NetJoin{}.GetChannel(), Equals, "")
+}
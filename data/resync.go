@@ -0,0 +1,224 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// resyncLifetime is how long a resync waits for its replies, after that
+	// it's dropped so it doesn't take over a NAMES asked for by someone else.
+	resyncLifetime = 2 * time.Minute
+)
+
+// resync is the resync of a channel being gathered.
+type resync struct {
+	// names are the nicks in the channel's NAMES reply.
+	names map[string]bool
+	// pos and neg are the corrections made to the modes.
+	pos []UserMode
+	neg []UserMode
+	ev  ChannelResynced
+	// started is when the resync began.
+	started time.Time
+}
+
+// ResyncQueries creates the queries that make the server send what a resync
+// of a channel needs, in the order they should be sent. The WHO refreshes the
+// users after NAMES has corrected who's in the channel.
+func ResyncQueries(channel string, whox bool) []string {
+	who := "WHO " + channel
+	if whox {
+		who = WhoxQuery(channel)
+	}
+	return []string{"MODE " + channel, "NAMES " + channel, who}
+}
+
+// Resync starts a resync of a channel. The RPL_CHANNELMODEIS and NAMES
+// replies for the channel that follow replace what the state knows rather
+// than add to it, and a ChannelResynced event is returned once the NAMES
+// reply ends. See ResyncQueries. Returns false if the channel is not known.
+func (s *State) Resync(channel string) bool {
+	key := strings.ToLower(channel)
+	ch, ok := s.channels[key]
+	if !ok {
+		return false
+	}
+
+	s.resyncs[key] = &resync{
+		names:   make(map[string]bool),
+		ev:      ChannelResynced{Channel: ch.Name()},
+		started: time.Now(),
+	}
+	return true
+}
+
+// getResync gets the resync of a channel, nil if there is none. Resyncs that
+// have waited longer than resyncLifetime for their replies are dropped.
+func (s *State) getResync(channel string) *resync {
+	key := strings.ToLower(channel)
+	r, ok := s.resyncs[key]
+	if !ok {
+		return nil
+	}
+	if time.Since(r.started) >= resyncLifetime {
+		delete(s.resyncs, key)
+		return nil
+	}
+	return r
+}
+
+// resyncModes replaces the modes of a channel being resynced with the ones
+// in its RPL_CHANNELMODEIS. The lists of the address modes are left alone.
+func (s *State) resyncModes(r *resync, ch *Channel, m *irc.Message) {
+	modes := CreateChannelModes(&s.kinds, &s.umodes)
+	modes.Apply(strings.Join(m.Args[2:], " "))
+
+	for mode := range ch.modes {
+		if !modes.modes[mode] {
+			r.neg = append(r.neg, UserMode{Mode: mode})
+		}
+	}
+	for mode := range modes.modes {
+		if !ch.modes[mode] {
+			r.pos = append(r.pos, UserMode{Mode: mode})
+		}
+	}
+	for mode, arg := range ch.argModes {
+		if _, ok := modes.argModes[mode]; !ok {
+			unset := UserMode{Mode: mode}
+			if ch.getKind(mode) == ARGS_ALWAYS {
+				unset.Arg = arg
+			}
+			r.neg = append(r.neg, unset)
+		}
+	}
+	for mode, arg := range modes.argModes {
+		if old, ok := ch.argModes[mode]; !ok || old != arg {
+			r.pos = append(r.pos, UserMode{Mode: mode, Arg: arg})
+		}
+	}
+
+	ch.modes = modes.modes
+	ch.argModes = modes.argModes
+	s.version++
+}
+
+// resyncNames adds the users in the NAMES reply of a channel being resynced
+// that the state didn't have, and corrects their modes.
+func (s *State) resyncNames(r *resync, m *irc.Message) {
+	channel := m.Args[2]
+	for _, name := range strings.Fields(m.Args[3]) {
+		nick, mode := s.parseName(name)
+		if s.addUser(nick) == nil {
+			continue
+		}
+		r.names[strings.ToLower(nick)] = true
+		if !s.IsOn(nick, channel) {
+			s.addToChannel(nick, channel)
+			r.ev.Joined = append(r.ev.Joined, nick)
+		}
+
+		modes := s.GetUsersChannelModes(nick, channel)
+		if modes == nil {
+			continue
+		}
+		// NAMES only shows the highest mode a user has, the ones above it
+		// are wrong and the ones below it can't be known.
+		for _, info := range s.umodes.modeInfo {
+			if info[0] == mode {
+				if !modes.HasMode(mode) {
+					modes.SetMode(mode)
+					s.version++
+					r.pos = append(r.pos, UserMode{Mode: mode, Arg: nick})
+				}
+				break
+			}
+			if modes.HasMode(info[0]) {
+				modes.UnsetMode(info[0])
+				s.version++
+				r.neg = append(r.neg, UserMode{Mode: info[0], Arg: nick})
+			}
+		}
+	}
+}
+
+// rplEndOfNames finishes the resync of a channel, removing the users the
+// NAMES reply didn't have. If the bot wasn't in it the channel is forgotten.
+func (s *State) rplEndOfNames(m *irc.Message) StateEvent {
+	key := strings.ToLower(m.Args[1])
+	r := s.getResync(key)
+	if r == nil {
+		return nil
+	}
+	delete(s.resyncs, key)
+	if _, ok := s.channels[key]; !ok {
+		return nil
+	}
+
+	selfGone := s.Self.User != nil &&
+		!r.names[strings.ToLower(s.Self.Nick())]
+	for nick, cu := range s.channelUsers[key] {
+		if selfGone || !r.names[nick] {
+			r.ev.Parted = append(r.ev.Parted, cu.User.Nick())
+			s.removeFromChannel(nick, key)
+		}
+	}
+	if selfGone {
+		s.removeChannel(key)
+	}
+
+	sort.Strings(r.ev.Joined)
+	sort.Strings(r.ev.Parted)
+	r.ev.Modes = modeString(r.pos, r.neg)
+	return r.ev
+}
+
+// parseName splits a name in a RPL_NAMREPLY into the nick and the mode of
+// its prefix, 0 if it has none.
+func (s *State) parseName(name string) (nick string, mode rune) {
+	for _, info := range s.umodes.modeInfo {
+		if info[1] == rune(name[0]) {
+			return name[1:], info[0]
+		}
+	}
+	return name, 0
+}
+
+// modeString creates a modestring from modes set and unset, in mode order.
+func modeString(pos, neg []UserMode) string {
+	var modes string
+	var args []string
+	for i, list := range [][]UserMode{pos, neg} {
+		if len(list) == 0 {
+			continue
+		}
+		sort.Sort(userModes(list))
+		modes += string("+-"[i])
+		for _, mode := range list {
+			modes += string(mode.Mode)
+			if len(mode.Arg) > 0 {
+				args = append(args, mode.Arg)
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		return modes
+	}
+	return modes + " " + strings.Join(args, " ")
+}
+
+// userModes sorts user modes by mode, then argument.
+type userModes []UserMode
+
+func (u userModes) Len() int      { return len(u) }
+func (u userModes) Swap(i, j int) { u[i], u[j] = u[j], u[i] }
+func (u userModes) Less(i, j int) bool {
+	if u[i].Mode != u[j].Mode {
+		return u[i].Mode < u[j].Mode
+	}
+	return u[i].Arg < u[j].Arg
+}
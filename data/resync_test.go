@@ -0,0 +1,121 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "gopkg.in/check.v1"
+	"strings"
+	"time"
+)
+
+func (s *s) TestResyncQueries(c *C) {
+	c.Check(ResyncQueries(channel, false), DeepEquals, []string{
+		"MODE " + channel, "NAMES " + channel, "WHO " + channel,
+	})
+	c.Check(ResyncQueries(channel, true)[2], Equals, WhoxQuery(channel))
+}
+
+func (s *s) TestState_Resync(c *C) {
+	st := eventState(c)
+	var events []StateEvent
+	update := func(name string, args ...string) {
+		var err error
+		events, err = st.Update(&irc.Message{
+			Name: name, Sender: server, Args: args,
+		})
+		c.Assert(err, IsNil)
+	}
+	update(irc.MODE, channels[0], "+ntl", "10")
+	update(irc.MODE, channels[0], "+ob", nicks[0], "*!*@ban")
+	update(irc.MODE, channels[0], "+v", nicks[1])
+
+	c.Check(st.Resync("#nochannel"), Equals, false)
+	c.Check(st.Resync(channels[0]), Equals, true)
+
+	update(irc.RPL_CHANNELMODEIS, self.Nick(), channels[0], "+nsk", "key")
+	ch := st.GetChannel(channels[0])
+	c.Check(ch.IsSet("n", "s", "k key"), Equals, true)
+	c.Check(ch.IsSet("t"), Equals, false)
+	c.Check(ch.IsSet("l"), Equals, false)
+	c.Check(ch.HasBan("*!*@ban"), Equals, true)
+
+	update(irc.RPL_NAMREPLY, self.Nick(), "=", channels[0],
+		"@"+self.Nick()+" "+nicks[0]+" +nick3")
+	c.Check(events, HasLen, 0)
+	c.Check(st.IsOn("nick3", channels[0]), Equals, true)
+	c.Check(st.GetUsersChannelModes("nick3", channels[0]).HasMode('v'),
+		Equals, true)
+	c.Check(st.GetUsersChannelModes(nicks[0], channels[0]).HasMode('o'),
+		Equals, false)
+
+	update(irc.RPL_ENDOFNAMES, self.Nick(), channels[0], "End of /NAMES list.")
+	c.Assert(events, HasLen, 1)
+	c.Check(events[0], DeepEquals, ChannelResynced{
+		Channel: channels[0],
+		Joined:  []string{"nick3"},
+		Parted:  []string{nicks[1]},
+		Modes:   "+kosv-lot key me nick3 " + nicks[0],
+	})
+	c.Check(events[0].(ChannelResynced).Drifted(), Equals, true)
+	c.Check(st.IsOn(nicks[1], channels[0]), Equals, false)
+
+	// Once it's done replies add to the state again.
+	update(irc.RPL_NAMREPLY, self.Nick(), "=", channels[0], nicks[1])
+	update(irc.RPL_ENDOFNAMES, self.Nick(), channels[0], "End of /NAMES list.")
+	c.Check(events, HasLen, 0)
+	c.Check(st.IsOn(nicks[1], channels[0]), Equals, true)
+}
+
+func (s *s) TestState_ResyncInSync(c *C) {
+	st := eventState(c)
+	var events []StateEvent
+	update := func(name string, args ...string) {
+		var err error
+		events, err = st.Update(&irc.Message{
+			Name: name, Sender: server, Args: args,
+		})
+		c.Assert(err, IsNil)
+	}
+	update(irc.MODE, channels[1], "+n")
+
+	c.Check(st.Resync(channels[1]), Equals, true)
+	update(irc.RPL_CHANNELMODEIS, self.Nick(), channels[1], "+n")
+	update(irc.RPL_NAMREPLY, self.Nick(), "=", channels[1],
+		self.Nick()+" "+nicks[0])
+	update(irc.RPL_ENDOFNAMES, self.Nick(), channels[1], "End of /NAMES list.")
+	c.Assert(events, HasLen, 1)
+	c.Check(events[0], DeepEquals, ChannelResynced{Channel: channels[1]})
+	c.Check(events[0].(ChannelResynced).Drifted(), Equals, false)
+}
+
+func (s *s) TestState_ResyncSelfGone(c *C) {
+	st := eventState(c)
+	var events []StateEvent
+	c.Check(st.Resync(channels[1]), Equals, true)
+	events, err := st.Update(&irc.Message{Name: irc.RPL_ENDOFNAMES,
+		Sender: server, Args: []string{self.Nick(), channels[1], "End"}})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+	c.Check(events[0].(ChannelResynced).Parted, DeepEquals,
+		[]string{self.Nick(), nicks[0]})
+	c.Check(st.GetChannel(channels[1]), IsNil)
+	c.Check(st.GetNUserChans(nicks[0]), Equals, 1)
+}
+
+func (s *s) TestState_ResyncExpired(c *C) {
+	st := eventState(c)
+	c.Check(st.Resync(channels[1]), Equals, true)
+	st.resyncs[strings.ToLower(channels[1])].started =
+		time.Now().Add(-resyncLifetime)
+
+	// A NAMES that comes after the resync expired adds to the channel.
+	_, err := st.Update(&irc.Message{Name: irc.RPL_NAMREPLY,
+		Sender: server, Args: []string{self.Nick(), "=", channels[1], "other"}})
+	c.Assert(err, IsNil)
+	events, err := st.Update(&irc.Message{Name: irc.RPL_ENDOFNAMES,
+		Sender: server, Args: []string{self.Nick(), channels[1], "End"}})
+	c.Assert(err, IsNil)
+	c.Check(events, HasLen, 0)
+	c.Check(st.IsOn(self.Nick(), channels[1]), Equals, true)
+	c.Check(st.IsOn("other", channels[1]), Equals, true)
+	c.Check(st.resyncs, HasLen, 0)
+}
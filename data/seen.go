@@ -0,0 +1,135 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	"strings"
+	"time"
+)
+
+// Seen actions, the last thing a user was seen doing.
+const (
+	SeenMessage = "message"
+	SeenJoin    = "join"
+	SeenPart    = "part"
+	SeenKick    = "kick"
+	SeenQuit    = "quit"
+	SeenNick    = "nick"
+)
+
+// Seen is the last time a user was seen, and what they were doing.
+type Seen struct {
+	// Nick is the nick the user was seen as.
+	Nick string
+	// Host is the fullhost of the user.
+	Host string
+	// Action is one of the Seen actions.
+	Action string
+	// Channel is where the user was seen, empty for quits and nick changes.
+	Channel string
+	// Message is the message they said, the part or quit message, the kick
+	// reason or the nick they changed to.
+	Message string
+	Time    time.Time
+}
+
+// Matches checks if the seen user matches a nick or a fullhost mask, the
+// wildcards * and ? can be used in both.
+func (s Seen) Matches(mask string) bool {
+	mask = strings.ToLower(mask)
+	if strings.ContainsAny(mask, "!@") {
+		return irc.Mask(mask).Match(irc.Host(strings.ToLower(s.Host)))
+	}
+	return irc.Mask(mask).Match(irc.Host(strings.ToLower(s.Nick)))
+}
+
+// GetSeen gets when a nick was last seen, nil if it has not been seen since
+// the last FlushSeen.
+func (s *State) GetSeen(nick string) *Seen {
+	seen, ok := s.seen[strings.ToLower(nick)]
+	if !ok {
+		return nil
+	}
+	return &seen
+}
+
+// MatchSeen gets when the nicks that match a mask were last seen, for those
+// that have been seen since the last FlushSeen. See Seen.Matches.
+func (s *State) MatchSeen(mask string) (list []Seen) {
+	for _, seen := range s.seen {
+		if seen.Matches(mask) {
+			list = append(list, seen)
+		}
+	}
+	return list
+}
+
+// FlushSeen returns the users that have been seen since the last time it was
+// called, and forgets them. The bot saves them to the store with SaveSeen.
+func (s *State) FlushSeen() (list []Seen) {
+	if len(s.seen) == 0 {
+		return nil
+	}
+	list = make([]Seen, 0, len(s.seen))
+	for _, seen := range s.seen {
+		list = append(list, seen)
+	}
+	s.seen = make(map[string]Seen)
+	return list
+}
+
+// see records the last thing the sender of a message was seen doing.
+func (s *State) see(m *irc.Message) {
+	if m.Name != irc.KICK && !strings.ContainsAny(m.Sender, "!@") {
+		return
+	}
+	seen := Seen{Nick: irc.Nick(m.Sender), Host: m.Sender, Time: m.Time}
+	if seen.Time.IsZero() {
+		seen.Time = time.Now()
+	}
+
+	switch m.Name {
+	case irc.PRIVMSG, irc.NOTICE:
+		if !s.caps.IsChannel(m.Args[0]) {
+			return
+		}
+		seen.Action, seen.Channel = SeenMessage, m.Args[0]
+		if len(m.Args) > 1 {
+			seen.Message = m.Args[1]
+		}
+	case irc.JOIN:
+		seen.Action, seen.Channel = SeenJoin, m.Args[0]
+	case irc.PART:
+		seen.Action, seen.Channel = SeenPart, m.Args[0]
+		if len(m.Args) > 1 {
+			seen.Message = m.Args[1]
+		}
+	case irc.KICK:
+		seen.Action, seen.Channel = SeenKick, m.Args[0]
+		if len(m.Args) > 2 {
+			seen.Message = m.Args[2]
+		}
+		// The user kicked is seen being kicked, not the kicker.
+		seen.Nick, seen.Host = m.Args[1], m.Args[1]
+		if user := s.GetUser(m.Args[1]); user != nil {
+			seen.Host = user.Host()
+		}
+	case irc.QUIT:
+		seen.Action = SeenQuit
+		if len(m.Args) > 0 {
+			seen.Message = m.Args[0]
+		}
+	case irc.NICK:
+		seen.Action, seen.Message = SeenNick, m.Args[0]
+		// The user is seen under the new nick too, with it's new host.
+		renamed := seen
+		renamed.Nick, renamed.Host = m.Args[0], m.Args[0]
+		if i := strings.IndexAny(m.Sender, "!@"); i >= 0 {
+			renamed.Host += m.Sender[i:]
+		}
+		s.seen[strings.ToLower(renamed.Nick)] = renamed
+	default:
+		return
+	}
+
+	s.seen[strings.ToLower(seen.Nick)] = seen
+}
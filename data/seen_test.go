@@ -0,0 +1,100 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+func (s *s) TestSeen_Matches(c *C) {
+	seen := Seen{Nick: "Nick1", Host: "Nick1!user1@Host1"}
+	c.Check(seen.Matches("nick1"), Equals, true)
+	c.Check(seen.Matches("NICK?"), Equals, true)
+	c.Check(seen.Matches("n*"), Equals, true)
+	c.Check(seen.Matches("nick2"), Equals, false)
+	c.Check(seen.Matches("*!*@host1"), Equals, true)
+	c.Check(seen.Matches("*!*@host2"), Equals, false)
+	c.Check(seen.Matches("user1"), Equals, false)
+}
+
+func (s *s) TestSeen_Update(c *C) {
+	st := eventState(c)
+	when := time.Unix(1401620000, 0)
+	update := func(name, sender string, args ...string) {
+		_, err := st.Update(&irc.Message{
+			Name: name, Sender: sender, Args: args, Time: when,
+		})
+		c.Check(err, IsNil)
+	}
+
+	update(irc.PRIVMSG, users[0], channels[0], "hello")
+	c.Check(st.GetSeen(nicks[0]), DeepEquals, &Seen{Nick: nicks[0],
+		Host: users[0], Action: SeenMessage, Channel: channels[0],
+		Message: "hello", Time: when})
+
+	update(irc.PRIVMSG, users[1], self.Nick(), "private")
+	update(irc.NOTICE, server, channels[0], "from a server")
+	c.Check(st.GetSeen(nicks[1]), IsNil)
+	c.Check(st.GetSeen(server), IsNil)
+
+	update(irc.JOIN, users[1], channels[1])
+	c.Check(st.GetSeen(nicks[1]).Action, Equals, SeenJoin)
+	update(irc.PART, users[1], channels[1], "later")
+	c.Check(st.GetSeen(nicks[1]).Message, Equals, "later")
+
+	update(irc.KICK, users[0], channels[0], nicks[1], "out")
+	c.Check(st.GetSeen(nicks[1]), DeepEquals, &Seen{Nick: nicks[1],
+		Host: users[1], Action: SeenKick, Channel: channels[0],
+		Message: "out", Time: when})
+
+	update(irc.NICK, users[0], "newnick")
+	c.Check(st.GetSeen(nicks[0]).Action, Equals, SeenNick)
+	c.Check(st.GetSeen("NewNick").Message, Equals, "newnick")
+	c.Check(st.MatchSeen("newnick"), DeepEquals, []Seen{{Nick: "newnick",
+		Host: "newnick!user1@host1", Action: SeenNick, Message: "newnick",
+		Time: when}})
+	c.Check(st.GetSeen(nicks[0]).Host, Equals, users[0])
+
+	update(irc.QUIT, "newnick!user1@host1", "gone")
+	c.Check(st.GetSeen("newnick").Action, Equals, SeenQuit)
+	c.Check(st.GetSeen("newnick").Channel, Equals, "")
+
+	c.Check(st.MatchSeen("nick*"), HasLen, 2)
+	c.Check(st.MatchSeen("*!*@host2"), HasLen, 1)
+
+	c.Check(st.FlushSeen(), HasLen, 3)
+	c.Check(st.GetSeen(nicks[0]), IsNil)
+	c.Check(st.FlushSeen(), IsNil)
+
+	_, err := st.Update(&irc.Message{
+		Name: irc.JOIN, Sender: users[1], Args: []string{channels[0]},
+	})
+	c.Check(err, IsNil)
+	c.Check(time.Since(st.GetSeen(nicks[1]).Time) < time.Minute, Equals, true)
+}
+
+func (s *s) TestSeen_NickStored(c *C) {
+	st := eventState(c)
+	_, err := st.Update(&irc.Message{
+		Name: irc.NICK, Sender: users[0], Args: []string{"newnick"},
+	})
+	c.Assert(err, IsNil)
+
+	store, err := CreateStore(MemStoreProvider)
+	c.Assert(err, IsNil)
+	defer store.Close()
+	c.Check(store.SaveSeen(server, st.FlushSeen()...), IsNil)
+
+	seen, err := store.FindSeen(server, "NewNick")
+	c.Check(err, IsNil)
+	c.Assert(seen, NotNil)
+	c.Check(seen.Nick, Equals, "newnick")
+	c.Check(seen.Host, Equals, "newnick!user1@host1")
+	c.Check(seen.Action, Equals, SeenNick)
+
+	seen, err = store.FindSeen(server, nicks[0])
+	c.Check(err, IsNil)
+	c.Assert(seen, NotNil)
+	c.Check(seen.Host, Equals, users[0])
+	c.Check(seen.Message, Equals, "newnick")
+}
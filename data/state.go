@@ -5,14 +5,98 @@ package data
 
 import (
 	"errors"
+	"fmt"
 	"github.com/aarondl/ultimateq/irc"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	errProtoCapsMissing = errors.New("data: Protocaps missing.")
 )
 
+var (
+	// updateArity is the number of arguments each message that's handled by
+	// Update needs, messages with fewer are returned as UpdateErrors.
+	updateArity = map[string]int{
+		irc.NICK:              1,
+		irc.JOIN:              1,
+		irc.PART:              1,
+		irc.KICK:              2,
+		irc.MODE:              2,
+		irc.TOPIC:             2,
+		irc.RPL_TOPIC:         3,
+		irc.RPL_TOPICWHOTIME:  4,
+		irc.RPL_CREATIONTIME:  3,
+		irc.PRIVMSG:           1,
+		irc.NOTICE:            1,
+		irc.ACCOUNT:           1,
+		irc.CHGHOST:           2,
+		irc.SETNAME:           1,
+		irc.RPL_AWAY:          3,
+		irc.RPL_WELCOME:       2,
+		irc.RPL_NAMREPLY:      4,
+		irc.RPL_ENDOFNAMES:    2,
+		irc.RPL_WHOREPLY:      8,
+		irc.RPL_WHOSPCRPL:     2,
+		irc.RPL_CHANNELMODEIS: 3,
+		irc.RPL_BANLIST:       3,
+		irc.RPL_EXCEPTLIST:    3,
+		irc.RPL_INVITELIST:    3,
+		irc.RPL_WHOISUSER:     6,
+		irc.RPL_WHOISSERVER:   4,
+		irc.RPL_WHOISOPERATOR: 2,
+		irc.RPL_WHOISIDLE:     3,
+		irc.RPL_WHOISCHANNELS: 3,
+		irc.RPL_WHOISACCOUNT:  3,
+		irc.RPL_WHOISSECURE:   2,
+		irc.RPL_ENDOFWHOIS:    2,
+		irc.ERR_NOSUCHNICK:    2,
+	}
+)
+
+const (
+	// whoxToken is the token of the queries made with WhoxQuery, it tells
+	// their replies apart from those of other WHOX queries.
+	whoxToken = "152"
+	// whoxFields are the fields asked for by WhoxQuery: the token, channel,
+	// username, host, nick, flags, account and realname in that order.
+	whoxFields = "tcuhnfar"
+	// DefaultWhoisLifetime is how long the result of a WHOIS is returned by
+	// GetWhois unless it's changed with SetWhoisLifetime.
+	DefaultWhoisLifetime = 5 * time.Minute
+
+	// errFmtUpdateArity occurs when Update is given a message with too few
+	// arguments.
+	errFmtUpdateArity = "data: %v message has %v arguments, it needs %v."
+)
+
+// UpdateError is returned by Update when it's given a message that's missing
+// arguments, such as a numeric from a broken server. The state is not changed
+// by the message.
+type UpdateError struct {
+	// Name is the name of the message.
+	Name string
+	// Args are the arguments the message had.
+	Args []string
+	// Need is the number of arguments the message needs.
+	Need int
+}
+
+// Error builds the error string for an UpdateError.
+func (u UpdateError) Error() string {
+	return fmt.Sprintf(errFmtUpdateArity, u.Name, len(u.Args), u.Need)
+}
+
+// WhoxQuery returns a WHOX query for a channel, the replies fill in the
+// services account of every user in it. It should only be sent to servers
+// with WHOX in their ISUPPORT.
+func WhoxQuery(channel string) string {
+	return "WHO " + channel + " %" + whoxFields + "," + whoxToken
+}
+
 // Self is the bot's user, he's a special case since he has to hold a Modeset.
 type Self struct {
 	*User
@@ -33,6 +117,31 @@ type State struct {
 	kinds  ChannelModeKinds
 	umodes UserModeKinds
 	caps   *irc.ProtoCaps
+
+	// whois are the WHOIS replies that have not ended yet by nick.
+	whois map[string]*WhoisInfo
+	// whoisUntracked are the WHOIS results of users the state doesn't have
+	// by nick, they're pruned once they're older than the whois lifetime.
+	whoisUntracked map[string]*WhoisInfo
+	whoisLifetime  time.Duration
+
+	// split and netjoin are the netsplit and netjoin being gathered, they're
+	// returned once a message that's not part of them is received, or by
+	// FlushNetEvents. netUpdated is when a message was last part of them.
+	split      *NetSplit
+	netjoin    *NetJoin
+	netUpdated time.Time
+	// splitUsers are the users that quit in netsplits by nick.
+	splitUsers map[string]splitUser
+
+	// seen are the users seen since the last FlushSeen by nick.
+	seen map[string]Seen
+
+	// resyncs are the resyncs being gathered by channel.
+	resyncs map[string]*resync
+
+	// version changes whenever something a Clone copies may have changed.
+	version uint64
 }
 
 // CreateState creates a state from an irc protocaps instance.
@@ -47,6 +156,12 @@ func CreateState(caps *irc.ProtoCaps) (*State, error) {
 	state.users = make(map[string]*User)
 	state.channelUsers = make(map[string]map[string]*ChannelUser)
 	state.userChannels = make(map[string]map[string]*UserChannel)
+	state.whois = make(map[string]*WhoisInfo)
+	state.whoisUntracked = make(map[string]*WhoisInfo)
+	state.whoisLifetime = DefaultWhoisLifetime
+	state.splitUsers = make(map[string]splitUser)
+	state.seen = make(map[string]Seen)
+	state.resyncs = make(map[string]*resync)
 
 	return state, nil
 }
@@ -68,9 +183,82 @@ func (s *State) Protocaps(caps *irc.ProtoCaps) error {
 
 	s.kinds = *kinds
 	s.umodes = *modes
+	s.version++
 	return nil
 }
 
+// Clone makes a copy of the users, channels and self of the state that shares
+// nothing with it, so it can be read while the state is updated. WHOIS replies
+// and resyncs being gathered, netsplits and seen users are not copied.
+func (s *State) Clone() *State {
+	st := &State{
+		kinds:          s.kinds,
+		umodes:         s.umodes,
+		caps:           s.caps.Clone(),
+		channels:       make(map[string]*Channel, len(s.channels)),
+		users:          make(map[string]*User, len(s.users)),
+		channelUsers:   make(map[string]map[string]*ChannelUser),
+		userChannels:   make(map[string]map[string]*UserChannel),
+		whois:          make(map[string]*WhoisInfo),
+		whoisUntracked: make(map[string]*WhoisInfo, len(s.whoisUntracked)),
+		whoisLifetime:  s.whoisLifetime,
+		splitUsers:     make(map[string]splitUser),
+		seen:           make(map[string]Seen),
+		resyncs:        make(map[string]*resync),
+		version:        s.version,
+	}
+
+	for nick, info := range s.whoisUntracked {
+		st.whoisUntracked[nick] = info
+	}
+
+	for key, user := range s.users {
+		clone := *user
+		st.users[key] = &clone
+	}
+	for key, ch := range s.channels {
+		st.channels[key] = ch.clone(&st.kinds, &st.umodes)
+	}
+	for chankey, cus := range s.channelUsers {
+		ch, ok := st.channels[chankey]
+		if !ok {
+			continue
+		}
+		cusClone := make(map[string]*ChannelUser, len(cus))
+		for nick, cu := range cus {
+			user, ok := st.users[nick]
+			if !ok {
+				continue
+			}
+			modes := CreateUserModes(&st.umodes)
+			modes.modes = cu.modes
+			cusClone[nick] = CreateChannelUser(user, modes)
+
+			ucs, ok := st.userChannels[nick]
+			if !ok {
+				ucs = make(map[string]*UserChannel, 1)
+				st.userChannels[nick] = ucs
+			}
+			ucs[chankey] = CreateUserChannel(ch, modes)
+		}
+		st.channelUsers[chankey] = cusClone
+	}
+
+	if s.Self.User != nil {
+		st.Self.User = st.users[strings.ToLower(s.Self.Nick())]
+		if st.Self.User == nil {
+			clone := *s.Self.User
+			st.Self.User = &clone
+		}
+	}
+	if s.Self.ChannelModes != nil {
+		st.Self.ChannelModes = s.Self.ChannelModes.clone(
+			s.Self.ChannelModeKinds, nil)
+	}
+
+	return st
+}
+
 // GetUser returns the user if he exists.
 func (s *State) GetUser(nickorhost string) *User {
 	nick := strings.ToLower(irc.Nick(nickorhost))
@@ -217,6 +405,46 @@ func (s *State) IsOn(nickorhost, channel string) bool {
 	return false
 }
 
+// SetWhoisLifetime sets how long the result of a WHOIS is returned by
+// GetWhois, 0 returns them however old they are. The results of users the
+// state doesn't have are kept for DefaultWhoisLifetime when it's 0.
+func (s *State) SetWhoisLifetime(lifetime time.Duration) {
+	s.whoisLifetime = lifetime
+	s.version++
+}
+
+// GetWhois gets the result of the last WHOIS of a user, or nil if there has
+// not been one or it's older than the whois lifetime.
+func (s *State) GetWhois(nickorhost string) *WhoisInfo {
+	var info *WhoisInfo
+	if user := s.GetUser(nickorhost); user != nil && user.whois != nil {
+		info = user.whois
+	} else {
+		nick := strings.ToLower(irc.Nick(nickorhost))
+		if info = s.whoisUntracked[nick]; info == nil {
+			return nil
+		}
+	}
+	if s.whoisLifetime > 0 && time.Since(info.Updated) > s.whoisLifetime {
+		return nil
+	}
+	return info
+}
+
+// pruneWhois forgets the WHOIS results of users the state doesn't have that
+// are older than the whois lifetime.
+func (s *State) pruneWhois() {
+	lifetime := s.whoisLifetime
+	if lifetime <= 0 {
+		lifetime = DefaultWhoisLifetime
+	}
+	for nick, info := range s.whoisUntracked {
+		if time.Since(info.Updated) > lifetime {
+			delete(s.whoisUntracked, nick)
+		}
+	}
+}
+
 // addUser adds a user to the database.
 func (s *State) addUser(nickorhost string) *User {
 	excl, at, per := false, false, false
@@ -236,15 +464,19 @@ func (s *State) addUser(nickorhost string) *User {
 	}
 
 	nick := strings.ToLower(irc.Nick(nickorhost))
+	if len(nick) == 0 {
+		return nil
+	}
 	var user *User
 	var ok bool
 	if user, ok = s.users[nick]; ok {
 		if excl && at && user.Host() != nickorhost {
 			user.host = irc.Host(nickorhost)
+			s.version++
 		}
-	} else {
-		user = CreateUser(nickorhost)
+	} else if user = CreateUser(nickorhost); user != nil {
 		s.users[nick] = user
+		s.version++
 	}
 	return user
 }
@@ -252,21 +484,27 @@ func (s *State) addUser(nickorhost string) *User {
 // removeUser deletes a user from the database.
 func (s *State) removeUser(nickorhost string) {
 	nick := strings.ToLower(irc.Nick(nickorhost))
+	if _, ok := s.users[nick]; !ok {
+		return
+	}
 	for _, cus := range s.channelUsers {
 		delete(cus, nick)
 	}
 
 	delete(s.userChannels, nick)
 	delete(s.users, nick)
+	s.version++
 }
 
 // addChannel adds a channel to the database.
 func (s *State) addChannel(channel string) *Channel {
 	chankey := strings.ToLower(channel)
-	var ch *Channel
-	if ch, ok := s.channels[chankey]; !ok {
-		ch = CreateChannel(channel, &s.kinds, &s.umodes)
-		s.channels[chankey] = ch
+	ch, ok := s.channels[chankey]
+	if !ok {
+		if ch = CreateChannel(channel, &s.kinds, &s.umodes); ch != nil {
+			s.channels[chankey] = ch
+			s.version++
+		}
 	}
 	return ch
 }
@@ -274,12 +512,16 @@ func (s *State) addChannel(channel string) *Channel {
 // removeChannel deletes a channel from the database.
 func (s *State) removeChannel(channel string) {
 	channel = strings.ToLower(channel)
+	if _, ok := s.channels[channel]; !ok {
+		return
+	}
 	for _, cus := range s.userChannels {
 		delete(cus, channel)
 	}
 
 	delete(s.channelUsers, channel)
 	delete(s.channels, channel)
+	s.version++
 }
 
 // addToChannel adds a user by nick or fullhost to the channel
@@ -322,6 +564,7 @@ func (s *State) addToChannel(nickorhost, channel string) {
 	uc[channel] = CreateUserChannel(ch, modes)
 	s.channelUsers[channel] = cu
 	s.userChannels[nick] = uc
+	s.version++
 }
 
 // removeFromChannel removes a user by nick or fullhost from the channel
@@ -334,7 +577,10 @@ func (s *State) removeFromChannel(nickorhost, channel string) {
 	channel = strings.ToLower(channel)
 
 	if cu, ok = s.channelUsers[channel]; ok {
-		delete(cu, nick)
+		if _, has := cu[nick]; has {
+			delete(cu, nick)
+			s.version++
+		}
 	}
 
 	if uc, ok = s.userChannels[nick]; ok {
@@ -342,139 +588,319 @@ func (s *State) removeFromChannel(nickorhost, channel string) {
 	}
 }
 
-// Update uses the irc.IrcMessage to modify the database accordingly.
-func (s *State) Update(m *irc.Message) {
+// Update uses the irc.IrcMessage to modify the database accordingly. The
+// changes made to users and channels are returned as StateEvents, in the
+// order they happened. Messages missing arguments are not applied and an
+// UpdateError is returned instead.
+func (s *State) Update(m *irc.Message) (events []StateEvent, err error) {
+	if need, ok := updateArity[m.Name]; ok && len(m.Args) < need {
+		return nil, UpdateError{Name: m.Name, Args: m.Args, Need: need}
+	}
+	events = s.netEvents(m)
+	s.see(m)
+
 	if len(m.Sender) > 0 {
 		s.addUser(m.Sender)
 	}
+
+	var ev StateEvent
 	switch m.Name {
 	case irc.NICK:
-		s.nick(m)
+		ev = s.nick(m)
 	case irc.JOIN:
-		s.join(m)
+		ev = s.join(m)
 	case irc.PART:
-		s.part(m)
+		ev = s.part(m)
 	case irc.QUIT:
-		s.quit(m)
+		ev = s.quit(m)
 	case irc.KICK:
-		s.kick(m)
+		ev = s.kick(m)
 	case irc.MODE:
-		s.mode(m)
+		ev = s.mode(m)
 	case irc.TOPIC:
-		s.topic(m)
+		ev = s.topic(m)
 	case irc.RPL_TOPIC:
-		s.rplTopic(m)
+		ev = s.rplTopic(m)
+	case irc.RPL_TOPICWHOTIME:
+		s.rplTopicWhoTime(m)
+	case irc.RPL_CREATIONTIME:
+		s.rplCreationTime(m)
 	case irc.PRIVMSG, irc.NOTICE:
 		s.msg(m)
+	case irc.ACCOUNT:
+		s.account(m)
+	case irc.AWAY:
+		s.away(m)
+	case irc.CHGHOST:
+		s.chghost(m)
+	case irc.SETNAME:
+		s.setname(m)
+	case irc.RPL_AWAY:
+		s.rplAway(m)
+	case irc.RPL_UNAWAY, irc.RPL_NOWAWAY:
+		s.rplSelfAway(m)
 	case irc.RPL_WELCOME:
 		s.rplWelcome(m)
 	case irc.RPL_NAMREPLY:
 		s.rplNameReply(m)
+	case irc.RPL_ENDOFNAMES:
+		ev = s.rplEndOfNames(m)
 	case irc.RPL_WHOREPLY:
 		s.rplWhoReply(m)
+	case irc.RPL_WHOSPCRPL:
+		s.rplWhoxReply(m)
 	case irc.RPL_CHANNELMODEIS:
 		s.rplChannelModeIs(m)
 	case irc.RPL_BANLIST:
-		s.rplBanList(m)
+		s.rplList(banMode, m)
+	case irc.RPL_EXCEPTLIST:
+		if mode := s.ExceptMode(); mode != 0 {
+			s.rplList(mode, m)
+		}
+	case irc.RPL_INVITELIST:
+		if mode := s.InvexMode(); mode != 0 {
+			s.rplList(mode, m)
+		}
+	case irc.RPL_WHOISUSER, irc.RPL_WHOISSERVER, irc.RPL_WHOISOPERATOR,
+		irc.RPL_WHOISIDLE, irc.RPL_WHOISCHANNELS, irc.RPL_WHOISACCOUNT,
+		irc.RPL_WHOISSECURE, irc.RPL_ENDOFWHOIS, irc.ERR_NOSUCHNICK:
+		s.rplWhois(m)
+	}
 
-		// TODO: Handle Whois
+	if ev != nil {
+		events = append(events, ev)
 	}
+	return
 }
 
 // nick alters the state of the database when a NICK message is received.
-func (s *State) nick(m *irc.Message) {
+func (s *State) nick(m *irc.Message) StateEvent {
 	nick, username, host := m.Split()
 	newnick := m.Args[0]
 	newuser := irc.Host(newnick + "!" + username + "@" + host)
 
+	oldnick := nick
 	nick = strings.ToLower(nick)
 	newnick = strings.ToLower(newnick)
 
-	if user, ok := s.users[nick]; ok {
-		user.host = newuser
-		for _, cus := range s.channelUsers {
-			if _, ok := cus[nick]; ok {
-				cus[newnick] = cus[nick]
-				delete(cus, nick)
-			}
-		}
-		if _, ok := s.userChannels[nick]; ok {
-			s.userChannels[newnick] = s.userChannels[nick]
-			delete(s.userChannels, nick)
+	user, ok := s.users[nick]
+	if !ok {
+		return nil
+	}
+	s.version++
+
+	ev := NickChanged{
+		Old:      oldnick,
+		New:      m.Args[0],
+		User:     string(newuser),
+		Channels: s.GetUserChans(nick),
+	}
+	sort.Strings(ev.Channels)
+
+	user.host = newuser
+	for _, cus := range s.channelUsers {
+		if _, ok := cus[nick]; ok {
+			cus[newnick] = cus[nick]
+			delete(cus, nick)
 		}
-		s.users[newnick] = s.users[nick]
-		delete(s.users, nick)
 	}
+	if _, ok := s.userChannels[nick]; ok {
+		s.userChannels[newnick] = s.userChannels[nick]
+		delete(s.userChannels, nick)
+	}
+	s.users[newnick] = s.users[nick]
+	delete(s.users, nick)
+	return ev
 }
 
 // join alters the state of the database when a JOIN message is received.
-func (s *State) join(m *irc.Message) {
+func (s *State) join(m *irc.Message) StateEvent {
 	if m.Sender == s.Self.Host() {
 		s.addChannel(m.Args[0])
 	}
 	s.addToChannel(m.Sender, m.Args[0])
+
+	// extended-join adds the account and realname of the user.
+	if len(m.Args) >= 3 {
+		if user := s.GetUser(m.Sender); user != nil {
+			user.SetAccount(m.Args[1])
+			user.SetRealname(m.Args[2])
+			s.version++
+		}
+	}
+
+	if !s.IsOn(m.Sender, m.Args[0]) || s.splitJoin(m.Sender, m.Args[0]) {
+		return nil
+	}
+	return UserJoined{Channel: m.Args[0], User: m.Sender}
 }
 
 // part alters the state of the database when a PART message is received.
-func (s *State) part(m *irc.Message) {
+func (s *State) part(m *irc.Message) StateEvent {
+	if s.GetChannel(m.Args[0]) == nil {
+		return nil
+	}
+
 	if m.Sender == s.Self.Host() {
 		s.removeChannel(m.Args[0])
 	} else {
 		s.removeFromChannel(m.Sender, m.Args[0])
 	}
+
+	ev := UserParted{Channel: m.Args[0], User: m.Sender}
+	if len(m.Args) > 1 {
+		ev.Message = m.Args[1]
+	}
+	return ev
 }
 
 // quit alters the state of the database when a QUIT message is received.
-func (s *State) quit(m *irc.Message) {
-	if m.Sender != s.Self.Host() {
-		s.removeUser(m.Sender)
+func (s *State) quit(m *irc.Message) StateEvent {
+	if m.Sender == s.Self.Host() {
+		return nil
+	}
+	if len(m.Args) > 0 {
+		if hub, leaf, ok := parseNetsplit(m.Args[0]); ok {
+			s.splitQuit(m.Sender, hub, leaf)
+			return nil
+		}
+	}
+
+	ev := UserQuit{User: m.Sender, Channels: s.GetUserChans(m.Sender)}
+	sort.Strings(ev.Channels)
+	if len(m.Args) > 0 {
+		ev.Message = m.Args[0]
 	}
+	s.removeUser(m.Sender)
+	return ev
 }
 
 // kick alters the state of the database when a KICK message is received.
-func (s *State) kick(m *irc.Message) {
+func (s *State) kick(m *irc.Message) StateEvent {
+	if s.GetChannel(m.Args[0]) == nil {
+		return nil
+	}
+
+	var message string
+	if len(m.Args) > 2 {
+		message = m.Args[2]
+	}
+
 	if m.Args[1] == s.Self.Nick() {
 		s.removeChannel(m.Args[0])
-	} else {
-		s.removeFromChannel(m.Args[1], m.Args[0])
+		return SelfKicked{
+			Channel: m.Args[0],
+			Kicker:  m.Sender,
+			Message: message,
+		}
+	}
+
+	user := m.Args[1]
+	if u := s.GetUser(user); u != nil {
+		user = u.Host()
+	}
+	s.removeFromChannel(m.Args[1], m.Args[0])
+	return UserParted{
+		Channel: m.Args[0],
+		User:    user,
+		Kicker:  m.Sender,
+		Message: message,
 	}
 }
 
 // mode alters the state of the database when a MODE message is received.
-func (s *State) mode(m *irc.Message) {
+func (s *State) mode(m *irc.Message) StateEvent {
 	target := strings.ToLower(m.Args[0])
+	modes := strings.Join(m.Args[1:], " ")
 	if s.caps.IsChannel(target) {
-		if ch, ok := s.channels[target]; ok {
-			pos, neg := ch.Apply(strings.Join(m.Args[1:], " "))
-			for i := 0; i < len(pos); i++ {
-				nick := strings.ToLower(pos[i].Arg)
-				s.channelUsers[target][nick].SetMode(pos[i].Mode)
+		ch, ok := s.channels[target]
+		if !ok {
+			return nil
+		}
+		s.version++
+
+		pos, neg := ch.Apply(modes)
+		for i := 0; i < len(pos); i++ {
+			nick := strings.ToLower(pos[i].Arg)
+			if cu, ok := s.channelUsers[target][nick]; ok {
+				cu.SetMode(pos[i].Mode)
 			}
-			for i := 0; i < len(neg); i++ {
-				nick := strings.ToLower(neg[i].Arg)
-				s.channelUsers[target][nick].UnsetMode(neg[i].Mode)
+		}
+		for i := 0; i < len(neg); i++ {
+			nick := strings.ToLower(neg[i].Arg)
+			if cu, ok := s.channelUsers[target][nick]; ok {
+				cu.UnsetMode(neg[i].Mode)
 			}
 		}
-	} else if target == s.Self.Nick() {
+
+		diff := CreateModeDiff(&s.kinds, &s.umodes)
+		set, unset := diff.Apply(modes)
+		now := time.Now()
+		for mode, masks := range diff.pos.addressModes {
+			for i := 0; i < len(masks); i++ {
+				ch.AddListEntry(mode, ListEntry{
+					Mask: masks[i], Setter: m.Sender, Time: now,
+				})
+			}
+		}
+		for mode, masks := range diff.neg.addressModes {
+			for i := 0; i < len(masks); i++ {
+				ch.forgetListEntry(mode, masks[i])
+			}
+		}
+		return ModeChanged{
+			Channel: m.Args[0],
+			Sender:  m.Sender,
+			Diff:    diff,
+			Set:     set,
+			Unset:   unset,
+		}
+	} else if s.Self.User != nil &&
+		target == strings.ToLower(s.Self.Nick()) {
+
 		s.Self.Apply(m.Args[1])
+		s.version++
+
+		diff := CreateModeDiff(s.Self.ChannelModeKinds, nil)
+		diff.Apply(m.Args[1])
+		return ModeChanged{Sender: m.Sender, Diff: diff}
 	}
+	return nil
 }
 
 // topic alters the state of the database when a TOPIC message is received.
-func (s *State) topic(m *irc.Message) {
+func (s *State) topic(m *irc.Message) StateEvent {
 	chname := strings.ToLower(m.Args[0])
-	if ch, ok := s.channels[chname]; ok {
-		ch.SetTopic(m.Args[1])
+	ch, ok := s.channels[chname]
+	if !ok {
+		return nil
 	}
+
+	ev := TopicChanged{
+		Channel: m.Args[0],
+		Sender:  m.Sender,
+		Old:     ch.Topic(),
+		New:     m.Args[1],
+	}
+	ch.SetTopic(m.Args[1])
+	ch.SetTopicInfo(m.Sender, time.Now())
+	s.version++
+	return ev
 }
 
 // rplTopic alters the state of the database when a RPL_TOPIC message is
 // received.
-func (s *State) rplTopic(m *irc.Message) {
+func (s *State) rplTopic(m *irc.Message) StateEvent {
 	chname := strings.ToLower(m.Args[1])
-	if ch, ok := s.channels[chname]; ok {
-		ch.SetTopic(m.Args[2])
+	ch, ok := s.channels[chname]
+	if !ok || ch.Topic() == m.Args[2] {
+		return nil
 	}
+
+	ev := TopicChanged{Channel: m.Args[1], Old: ch.Topic(), New: m.Args[2]}
+	ch.SetTopic(m.Args[2])
+	s.version++
+	return ev
 }
 
 // msg alters the state of the database when a PRIVMSG or NOTICE message is
@@ -485,42 +911,164 @@ func (s *State) msg(m *irc.Message) {
 	}
 }
 
+// account alters the state of the database when an ACCOUNT message is
+// received.
+func (s *State) account(m *irc.Message) {
+	if user := s.GetUser(m.Sender); user != nil {
+		user.SetAccount(m.Args[0])
+		s.version++
+	}
+}
+
+// away alters the state of the database when an AWAY message is received.
+func (s *State) away(m *irc.Message) {
+	if user := s.GetUser(m.Sender); user != nil {
+		if len(m.Args) > 0 && len(m.Args[0]) > 0 {
+			user.SetAway(true, m.Args[0])
+		} else {
+			user.SetAway(false, "")
+		}
+		s.version++
+	}
+}
+
+// chghost alters the state of the database when a CHGHOST message is
+// received.
+func (s *State) chghost(m *irc.Message) {
+	if user := s.GetUser(m.Sender); user != nil {
+		user.host = irc.Host(user.Nick() + "!" + m.Args[0] + "@" + m.Args[1])
+		s.version++
+	}
+}
+
+// setname alters the state of the database when a SETNAME message is
+// received.
+func (s *State) setname(m *irc.Message) {
+	if user := s.GetUser(m.Sender); user != nil {
+		user.SetRealname(m.Args[0])
+		s.version++
+	}
+}
+
+// rplAway alters the state of the database when a RPL_AWAY message is
+// received.
+func (s *State) rplAway(m *irc.Message) {
+	if user := s.GetUser(m.Args[1]); user != nil {
+		user.SetAway(true, m.Args[2])
+		s.version++
+	}
+}
+
+// rplSelfAway alters the state of the database when a RPL_NOWAWAY or
+// RPL_UNAWAY message is received.
+func (s *State) rplSelfAway(m *irc.Message) {
+	if s.Self.User != nil {
+		s.Self.SetAway(m.Name == irc.RPL_NOWAWAY, s.Self.AwayMessage())
+		s.version++
+	}
+}
+
+// rplWhois alters the state of the database when a reply to a WHOIS is
+// received. The reply is gathered until RPL_ENDOFWHOIS and then given to the
+// user, or kept by nick if the state doesn't have the user.
+func (s *State) rplWhois(m *irc.Message) {
+	nick := strings.ToLower(m.Args[1])
+
+	if m.Name == irc.RPL_WHOISUSER {
+		s.whois[nick] = &WhoisInfo{}
+		if s.GetUser(m.Args[1]) == nil {
+			return
+		}
+		fullhost := m.Args[1] + "!" + m.Args[2] + "@" + m.Args[3]
+		if user := s.addUser(fullhost); user != nil {
+			user.SetRealname(m.Args[5])
+			s.version++
+		}
+		return
+	}
+
+	info, ok := s.whois[nick]
+	if !ok {
+		return
+	}
+
+	args := m.Args
+	switch m.Name {
+	case irc.RPL_WHOISSERVER:
+		info.Server, info.ServerInfo = args[2], args[3]
+	case irc.RPL_WHOISOPERATOR:
+		info.Operator = true
+	case irc.RPL_WHOISIDLE:
+		idle, _ := strconv.Atoi(args[2])
+		info.Idle = time.Duration(idle) * time.Second
+		if len(args) >= 5 {
+			if signon, err := strconv.ParseInt(args[3], 10, 64); err == nil {
+				info.SignOn = time.Unix(signon, 0)
+			}
+		}
+	case irc.RPL_WHOISCHANNELS:
+		info.Channels = append(info.Channels,
+			strings.Fields(args[len(args)-1])...)
+	case irc.RPL_WHOISACCOUNT:
+		info.Account = args[2]
+	case irc.RPL_WHOISSECURE:
+		info.Secure = true
+	case irc.ERR_NOSUCHNICK:
+		delete(s.whois, nick)
+	case irc.RPL_ENDOFWHOIS:
+		delete(s.whois, nick)
+		info.Updated = time.Now()
+		s.pruneWhois()
+		s.version++
+		if user := s.GetUser(m.Args[1]); user != nil {
+			delete(s.whoisUntracked, nick)
+			user.SetWhois(info)
+			if len(info.Account) > 0 {
+				user.SetAccount(info.Account)
+			}
+		} else {
+			s.whoisUntracked[nick] = info
+		}
+	}
+}
+
 // rplWelcome alters the state of the database when a RPL_WELCOME message is
 // received.
 func (s *State) rplWelcome(m *irc.Message) {
-	splits := strings.Fields(m.Args[1])
-	host := splits[len(splits)-1]
+	var host string
+	if splits := strings.Fields(m.Args[1]); len(splits) > 0 {
+		host = splits[len(splits)-1]
+	}
 
 	if !strings.ContainsRune(host, '!') || !strings.ContainsRune(host, '@') {
 		host = m.Args[0]
 	}
 	user := CreateUser(host)
+	if user == nil {
+		return
+	}
 	s.Self.User = user
 	s.users[strings.ToLower(user.Nick())] = user
+	s.version++
 }
 
 // rplNameReply alters the state of the database when a RPL_NAMEREPLY
 // message is received.
 func (s *State) rplNameReply(m *irc.Message) {
 	channel := m.Args[2]
-	users := strings.Fields(m.Args[3])
-	for i := 0; i < len(users); i++ {
-		j := 0
-		mode := rune(0)
-		for ; j < len(s.umodes.modeInfo); j++ {
-			if s.umodes.modeInfo[j][1] == rune(users[i][0]) {
-				mode = s.umodes.modeInfo[j][0]
-				break
-			}
-		}
-		if j < len(s.umodes.modeInfo) {
-			nick := users[i][1:]
-			s.addUser(nick)
-			s.addToChannel(nick, channel)
-			s.GetUsersChannelModes(nick, channel).SetMode(mode)
-		} else {
-			s.addUser(users[i])
-			s.addToChannel(users[i], channel)
+	if r := s.getResync(channel); r != nil {
+		s.resyncNames(r, m)
+		return
+	}
+
+	for _, name := range strings.Fields(m.Args[3]) {
+		nick, mode := s.parseName(name)
+		s.addUser(nick)
+		s.addToChannel(nick, channel)
+		modes := s.GetUsersChannelModes(nick, channel)
+		if modes != nil && mode != 0 && !modes.HasMode(mode) {
+			modes.SetMode(mode)
+			s.version++
 		}
 	}
 }
@@ -531,14 +1079,62 @@ func (s *State) rplWhoReply(m *irc.Message) {
 	channel := m.Args[1]
 	fullhost := m.Args[5] + "!" + m.Args[2] + "@" + m.Args[3]
 	modes := m.Args[6]
-	realname := strings.SplitN(m.Args[7], " ", 2)[1]
+	var realname string
+	if hopsname := strings.SplitN(m.Args[7], " ", 2); len(hopsname) > 1 {
+		realname = hopsname[1]
+	}
 
-	s.addUser(fullhost)
+	user := s.addUser(fullhost)
+	if user == nil {
+		return
+	}
 	s.addToChannel(fullhost, channel)
-	s.GetUser(fullhost).SetRealname(realname)
-	for _, modechar := range modes {
-		if mode := s.umodes.GetMode(modechar); mode != 0 {
-			s.GetUsersChannelModes(fullhost, channel).SetMode(mode)
+	user.SetRealname(realname)
+	s.whoFlags(fullhost, channel, modes)
+	s.version++
+}
+
+// rplWhoxReply alters the state of the database when a RPL_WHOSPCRPL message
+// is received in reply to a query made with WhoxQuery.
+func (s *State) rplWhoxReply(m *irc.Message) {
+	if len(m.Args) != 1+len(whoxFields) || m.Args[1] != whoxToken {
+		return
+	}
+
+	channel := m.Args[2]
+	fullhost := m.Args[5] + "!" + m.Args[3] + "@" + m.Args[4]
+	account := m.Args[7]
+	if account == "0" {
+		account = ""
+	}
+
+	user := s.addUser(fullhost)
+	if user == nil {
+		return
+	}
+	s.addToChannel(fullhost, channel)
+	user.SetAccount(account)
+	user.SetRealname(m.Args[8])
+	s.whoFlags(fullhost, channel, m.Args[6])
+	s.version++
+}
+
+// whoFlags sets the away status and channel modes of a user from the flags
+// of a WHO reply.
+func (s *State) whoFlags(fullhost, channel, flags string) {
+	user := s.GetUser(fullhost)
+	modes := s.GetUsersChannelModes(fullhost, channel)
+	for _, flag := range flags {
+		switch flag {
+		case 'H':
+			user.SetAway(false, "")
+		case 'G':
+			if !user.IsAway() {
+				user.SetAway(true, "")
+			}
+		}
+		if mode := s.umodes.GetMode(flag); mode != 0 && modes != nil {
+			modes.SetMode(mode)
 		}
 	}
 }
@@ -546,14 +1142,86 @@ func (s *State) rplWhoReply(m *irc.Message) {
 // rplChannelModeIs alters the state of the database when a RPL_CHANNELMODEIS
 // message is received.
 func (s *State) rplChannelModeIs(m *irc.Message) {
-	channel := m.Args[1]
-	modes := strings.Join(m.Args[2:], " ")
-	s.GetChannel(channel).Apply(modes)
+	ch := s.GetChannel(m.Args[1])
+	if ch == nil {
+		return
+	}
+	if r := s.getResync(m.Args[1]); r != nil {
+		s.resyncModes(r, ch, m)
+		return
+	}
+	ch.Apply(strings.Join(m.Args[2:], " "))
+	s.version++
 }
 
-// rplBanList alters the state of the database when a RPL_BANLIST message is
-// received.
-func (s *State) rplBanList(m *irc.Message) {
-	channel := m.Args[1]
-	s.GetChannel(channel).AddBan(m.Args[2])
+// rplTopicWhoTime alters the state of the database when a RPL_TOPICWHOTIME
+// message is received.
+func (s *State) rplTopicWhoTime(m *irc.Message) {
+	if ch := s.GetChannel(m.Args[1]); ch != nil {
+		ch.SetTopicInfo(m.Args[2], parseUnix(m.Args[3]))
+		s.version++
+	}
+}
+
+// rplCreationTime alters the state of the database when a RPL_CREATIONTIME
+// message is received.
+func (s *State) rplCreationTime(m *irc.Message) {
+	if ch := s.GetChannel(m.Args[1]); ch != nil {
+		ch.SetCreated(parseUnix(m.Args[2]))
+		s.version++
+	}
+}
+
+// rplList alters the state of the database when a RPL_BANLIST,
+// RPL_EXCEPTLIST or RPL_INVITELIST message is received. The setter and time
+// are optional in all of them.
+func (s *State) rplList(mode rune, m *irc.Message) {
+	ch := s.GetChannel(m.Args[1])
+	if ch == nil {
+		return
+	}
+
+	entry := ListEntry{Mask: m.Args[2]}
+	if len(m.Args) > 3 {
+		entry.Setter = m.Args[3]
+	}
+	if len(m.Args) > 4 {
+		entry.Time = parseUnix(m.Args[4])
+	}
+	ch.AddListEntry(mode, entry)
+	s.version++
+}
+
+// ExceptMode returns the channel mode of ban exceptions, 0 if the server
+// doesn't have EXCEPTS in it's ISUPPORT.
+func (s *State) ExceptMode() rune {
+	return listMode(s.caps.Extra("EXCEPTS"), 'e')
+}
+
+// InvexMode returns the channel mode of invite exceptions, 0 if the server
+// doesn't have INVEX in it's ISUPPORT.
+func (s *State) InvexMode() rune {
+	return listMode(s.caps.Extra("INVEX"), 'I')
+}
+
+// listMode returns the mode of an ISUPPORT list token, or the default mode if
+// the token doesn't name one.
+func listMode(value string, def rune) rune {
+	switch value {
+	case "":
+		return 0
+	case "true":
+		return def
+	}
+	return []rune(value)[0]
+}
+
+// parseUnix parses a unix timestamp, returning the zero time if it's not
+// valid.
+func parseUnix(timestamp string) time.Time {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || secs <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(secs, 0)
 }
@@ -0,0 +1,165 @@
+package data
+
+// StateEvent is a change made to the state by State.Update. It's one of:
+// UserJoined, UserParted, SelfKicked, NickChanged, ModeChanged, TopicChanged,
+// UserQuit, NetSplit, NetJoin or ChannelResynced.
+type StateEvent interface {
+	// GetChannel returns the channel the change was made in, or empty string
+	// if it was not made in a single channel.
+	GetChannel() string
+}
+
+// UserJoined is a user, or the bot, joining a channel.
+type UserJoined struct {
+	Channel string
+	// User is the fullhost of the user.
+	User string
+}
+
+// GetChannel implements StateEvent.
+func (e UserJoined) GetChannel() string {
+	return e.Channel
+}
+
+// UserParted is a user, or the bot, leaving a channel. Kicker is the fullhost
+// of who kicked them if they were kicked, and Message the part message or
+// kick reason.
+type UserParted struct {
+	Channel string
+	// User is the fullhost of the user.
+	User    string
+	Kicker  string
+	Message string
+}
+
+// GetChannel implements StateEvent.
+func (e UserParted) GetChannel() string {
+	return e.Channel
+}
+
+// SelfKicked is the bot being kicked from a channel, the channel is no longer
+// tracked.
+type SelfKicked struct {
+	Channel string
+	// Kicker is the fullhost of who kicked the bot.
+	Kicker  string
+	Message string
+}
+
+// GetChannel implements StateEvent.
+func (e SelfKicked) GetChannel() string {
+	return e.Channel
+}
+
+// NickChanged is a user changing their nickname. Channels are the channels
+// they're in, sorted.
+type NickChanged struct {
+	Old string
+	New string
+	// User is the new fullhost of the user.
+	User     string
+	Channels []string
+}
+
+// GetChannel implements StateEvent.
+func (e NickChanged) GetChannel() string {
+	return ""
+}
+
+// ModeChanged is a change to the modes of a channel, or to the bot's own
+// modes when Channel is empty. Diff holds the modes that changed and Set and
+// Unset the modes given to and taken from users in the channel.
+type ModeChanged struct {
+	Channel string
+	// Sender is the fullhost, or server, that changed the modes.
+	Sender string
+	Diff   *ModeDiff
+	Set    []UserMode
+	Unset  []UserMode
+}
+
+// GetChannel implements StateEvent.
+func (e ModeChanged) GetChannel() string {
+	return e.Channel
+}
+
+// TopicChanged is a change to the topic of a channel. Sender is who changed
+// it, and is empty when the topic was learned on joining the channel.
+type TopicChanged struct {
+	Channel string
+	Sender  string
+	Old     string
+	New     string
+}
+
+// GetChannel implements StateEvent.
+func (e TopicChanged) GetChannel() string {
+	return e.Channel
+}
+
+// UserQuit is a user quitting irc. Channels are the channels they were in,
+// sorted.
+type UserQuit struct {
+	// User is the fullhost of the user.
+	User     string
+	Message  string
+	Channels []string
+}
+
+// GetChannel implements StateEvent.
+func (e UserQuit) GetChannel() string {
+	return ""
+}
+
+// NetSplit is users quitting because their server split from the network,
+// it's returned instead of a UserQuit for each of them. Hub and Leaf are the
+// servers that split, and Users the fullhosts of the users that quit by
+// channel, sorted. Since the QUITs of a netsplit arrive one at a time, it's
+// returned by Update along with the first message that's not one of them.
+type NetSplit struct {
+	Hub   string
+	Leaf  string
+	Users map[string][]string
+}
+
+// GetChannel implements StateEvent.
+func (e NetSplit) GetChannel() string {
+	return ""
+}
+
+// NetJoin is users that quit in a NetSplit joining their channels again when
+// the servers reconnect, it's returned instead of a UserJoined for each of
+// them. Hub, Leaf and Users are as in NetSplit. Like a NetSplit, it's
+// returned along with the first message that's not part of it.
+type NetJoin struct {
+	Hub   string
+	Leaf  string
+	Users map[string][]string
+}
+
+// GetChannel implements StateEvent.
+func (e NetJoin) GetChannel() string {
+	return ""
+}
+
+// ChannelResynced is a resync of a channel finishing, see State.Resync.
+// Joined are the nicks the state was missing from the channel, Parted the
+// nicks it had that weren't in it, both sorted. Modes is a modestring of the
+// corrections made to the modes of the channel and its users.
+type ChannelResynced struct {
+	Channel string
+	Joined  []string
+	Parted  []string
+	Modes   string
+}
+
+// GetChannel implements StateEvent.
+func (e ChannelResynced) GetChannel() string {
+	return e.Channel
+}
+
+// Drifted checks if the state of the channel was wrong and had to be
+// corrected.
+func (e ChannelResynced) Drifted() bool {
+	return len(e.Joined) > 0 || len(e.Parted) > 0 || len(e.Modes) > 0
+}
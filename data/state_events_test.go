@@ -0,0 +1,201 @@
+package data
+
+import (
+	"github.com/aarondl/ultimateq/irc"
+	. "gopkg.in/check.v1"
+)
+
+// eventState creates a state where self and users[0] are in channels[0] and
+// channels[1], and users[1] is in channels[0].
+func eventState(c *C) *State {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.Self.User = self.User
+
+	st.addUser(self.Host())
+	st.addUser(users[0])
+	st.addUser(users[1])
+	for _, ch := range channels {
+		st.addChannel(ch)
+		st.addToChannel(self.Host(), ch)
+		st.addToChannel(users[0], ch)
+	}
+	st.addToChannel(users[1], channels[0])
+	return st
+}
+
+func (s *s) TestStateEvents_GetChannel(c *C) {
+	c.Check(UserJoined{Channel: channel}.GetChannel(), Equals, channel)
+	c.Check(UserParted{Channel: channel}.GetChannel(), Equals, channel)
+	c.Check(SelfKicked{Channel: channel}.GetChannel(), Equals, channel)
+	c.Check(ModeChanged{Channel: channel}.GetChannel(), Equals, channel)
+	c.Check(ModeChanged{}.GetChannel(), Equals, "")
+	c.Check(TopicChanged{Channel: channel}.GetChannel(), Equals, channel)
+	c.Check(NickChanged{Channels: channels}.GetChannel(), Equals, "")
+	c.Check(UserQuit{Channels: channels}.GetChannel(), Equals, "")
+	c.Check(ChannelResynced{Channel: channel}.GetChannel(), Equals, channel)
+}
+
+func (s *s) TestStateEvents_Join(c *C) {
+	st := eventState(c)
+	joiner := "nick3!user3@host3"
+
+	events, _ := st.Update(&irc.Message{
+		Name: irc.JOIN, Sender: joiner, Args: []string{channels[0]},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		UserJoined{Channel: channels[0], User: joiner},
+	})
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.JOIN, Sender: joiner, Args: []string{"#untracked"},
+	})
+	c.Check(events, HasLen, 0)
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.JOIN, Sender: self.Host(), Args: []string{"#new"},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		UserJoined{Channel: "#new", User: self.Host()},
+	})
+}
+
+func (s *s) TestStateEvents_Part(c *C) {
+	st := eventState(c)
+
+	events, _ := st.Update(&irc.Message{
+		Name: irc.PART, Sender: users[1], Args: []string{channels[0], "bye"},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		UserParted{Channel: channels[0], User: users[1], Message: "bye"},
+	})
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.PART, Sender: users[1], Args: []string{"#untracked"},
+	})
+	c.Check(events, HasLen, 0)
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.PART, Sender: self.Host(), Args: []string{channels[1]},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		UserParted{Channel: channels[1], User: self.Host()},
+	})
+	c.Check(st.GetChannel(channels[1]), IsNil)
+}
+
+func (s *s) TestStateEvents_Kick(c *C) {
+	st := eventState(c)
+
+	events, _ := st.Update(&irc.Message{
+		Name: irc.KICK, Sender: users[0],
+		Args: []string{channels[0], nicks[1], "out"},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		UserParted{Channel: channels[0], User: users[1], Kicker: users[0],
+			Message: "out"},
+	})
+	c.Check(st.IsOn(users[1], channels[0]), Equals, false)
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.KICK, Sender: users[0],
+		Args: []string{channels[1], self.Nick()},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		SelfKicked{Channel: channels[1], Kicker: users[0]},
+	})
+	c.Check(st.GetChannel(channels[1]), IsNil)
+}
+
+func (s *s) TestStateEvents_Nick(c *C) {
+	st := eventState(c)
+
+	events, _ := st.Update(&irc.Message{
+		Name: irc.NICK, Sender: users[0], Args: []string{"newnick"},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		NickChanged{Old: nicks[0], New: "newnick",
+			User: "newnick!user1@host1", Channels: channels},
+	})
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.NICK, Sender: "", Args: []string{"other"},
+	})
+	c.Check(events, HasLen, 0)
+}
+
+func (s *s) TestStateEvents_Quit(c *C) {
+	st := eventState(c)
+
+	events, _ := st.Update(&irc.Message{
+		Name: irc.QUIT, Sender: users[0], Args: []string{"gone"},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		UserQuit{User: users[0], Message: "gone", Channels: channels},
+	})
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.QUIT, Sender: self.Host(), Args: []string{"gone"},
+	})
+	c.Check(events, HasLen, 0)
+}
+
+func (s *s) TestStateEvents_Mode(c *C) {
+	st := eventState(c)
+
+	events, _ := st.Update(&irc.Message{
+		Name: irc.MODE, Sender: users[0],
+		Args: []string{channels[0], "+mo-v", nicks[1], nicks[0]},
+	})
+	c.Assert(events, HasLen, 1)
+	ev, ok := events[0].(ModeChanged)
+	c.Assert(ok, Equals, true)
+	c.Check(ev.Channel, Equals, channels[0])
+	c.Check(ev.Sender, Equals, users[0])
+	c.Check(ev.Diff.IsSet("m"), Equals, true)
+	c.Check(ev.Set, DeepEquals, []UserMode{{'o', nicks[1]}})
+	c.Check(ev.Unset, DeepEquals, []UserMode{{'v', nicks[0]}})
+	c.Check(st.GetUsersChannelModes(users[1], channels[0]).HasMode('o'),
+		Equals, true)
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.MODE, Sender: self.Host(), Args: []string{self.Nick(), "+i"},
+	})
+	c.Assert(events, HasLen, 1)
+	ev, ok = events[0].(ModeChanged)
+	c.Assert(ok, Equals, true)
+	c.Check(ev.Channel, Equals, "")
+	c.Check(ev.Diff.IsSet("i"), Equals, true)
+	c.Check(st.Self.IsSet("i"), Equals, true)
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.MODE, Sender: users[0], Args: []string{"#untracked", "+m"},
+	})
+	c.Check(events, HasLen, 0)
+}
+
+func (s *s) TestStateEvents_Topic(c *C) {
+	st := eventState(c)
+
+	events, _ := st.Update(&irc.Message{
+		Name: irc.RPL_TOPIC, Sender: server,
+		Args: []string{self.Nick(), channels[0], "first"},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		TopicChanged{Channel: channels[0], New: "first"},
+	})
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.RPL_TOPIC, Sender: server,
+		Args: []string{self.Nick(), channels[0], "first"},
+	})
+	c.Check(events, HasLen, 0)
+
+	events, _ = st.Update(&irc.Message{
+		Name: irc.TOPIC, Sender: users[0], Args: []string{channels[0], "second"},
+	})
+	c.Check(events, DeepEquals, []StateEvent{
+		TopicChanged{Channel: channels[0], Sender: users[0], Old: "first",
+			New: "second"},
+	})
+}
@@ -0,0 +1,244 @@
+package data
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshots made by State.Snapshot,
+// State.Restore only accepts snapshots of this version.
+const SnapshotVersion = 1
+
+const (
+	// errFmtSnapshotVersion occurs when a snapshot of another version is
+	// restored.
+	errFmtSnapshotVersion = "data: snapshot version %v is not supported, " +
+		"it must be %v."
+)
+
+// Snapshot is a copy of everything a State knows about a server that can be
+// encoded to JSON or gob, and restored into a State later.
+type Snapshot struct {
+	Version int `json:"version"`
+	// Self is the fullhost of the bot, empty if it had not connected.
+	Self string `json:"self"`
+	// SelfModes are the bot's user modes.
+	SelfModes string            `json:"self_modes"`
+	Users     []UserSnapshot    `json:"users"`
+	Channels  []ChannelSnapshot `json:"channels"`
+}
+
+// UserSnapshot is what's known about a user in a Snapshot.
+type UserSnapshot struct {
+	Host        string `json:"host"`
+	Realname    string `json:"realname"`
+	Account     string `json:"account"`
+	Away        bool   `json:"away"`
+	AwayMessage string `json:"away_message"`
+}
+
+// ChannelSnapshot is what's known about a channel in a Snapshot. Modes are
+// the modes without arguments, Args the argument of each mode that has one
+// and Lists the entries of each address mode. Members are the nicks in the
+// channel with the modes they have in it.
+type ChannelSnapshot struct {
+	Name        string                 `json:"name"`
+	Topic       string                 `json:"topic"`
+	TopicSetter string                 `json:"topic_setter"`
+	TopicTime   time.Time              `json:"topic_time"`
+	Created     time.Time              `json:"created"`
+	Modes       string                 `json:"modes"`
+	Args        map[string]string      `json:"args"`
+	Lists       map[string][]ListEntry `json:"lists"`
+	Members     map[string]string      `json:"members"`
+}
+
+// Snapshot makes a Snapshot of the state. Users and channels are sorted by
+// name so the same state always makes the same snapshot.
+func (s *State) Snapshot() *Snapshot {
+	snap := &Snapshot{Version: SnapshotVersion}
+	if s.Self.User != nil {
+		snap.Self = s.Self.Host()
+	}
+	if s.Self.ChannelModes != nil {
+		snap.SelfModes = sortedModes(s.Self.modes)
+	}
+
+	for _, user := range s.users {
+		snap.Users = append(snap.Users, UserSnapshot{
+			Host:        user.Host(),
+			Realname:    user.Realname(),
+			Account:     user.Account(),
+			Away:        user.IsAway(),
+			AwayMessage: user.AwayMessage(),
+		})
+	}
+	sort.Sort(userSnapshots(snap.Users))
+
+	for key, ch := range s.channels {
+		chsnap := ChannelSnapshot{
+			Name:        ch.Name(),
+			Topic:       ch.Topic(),
+			TopicSetter: ch.TopicSetter(),
+			TopicTime:   ch.TopicTime(),
+			Created:     ch.Created(),
+			Modes:       sortedModes(ch.modes),
+			Args:        make(map[string]string, len(ch.argModes)),
+			Lists:       make(map[string][]ListEntry, len(ch.addressModes)),
+			Members:     make(map[string]string, len(s.channelUsers[key])),
+		}
+		for mode, arg := range ch.argModes {
+			chsnap.Args[string(mode)] = arg
+		}
+		for mode := range ch.addressModes {
+			chsnap.Lists[string(mode)] = ch.ListEntries(mode)
+		}
+		for _, cu := range s.channelUsers[key] {
+			chsnap.Members[cu.User.Nick()] = cu.UserModes.String()
+		}
+		snap.Channels = append(snap.Channels, chsnap)
+	}
+	sort.Sort(channelSnapshots(snap.Channels))
+
+	return snap
+}
+
+// Restore replaces everything the state knows with the contents of a
+// snapshot. WHOIS replies, resyncs, netsplits and seen users being gathered
+// are forgotten. Member modes the server's PREFIX doesn't have are dropped.
+func (s *State) Restore(snap *Snapshot) error {
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf(errFmtSnapshotVersion, snap.Version, SnapshotVersion)
+	}
+
+	s.channels = make(map[string]*Channel)
+	s.users = make(map[string]*User)
+	s.channelUsers = make(map[string]map[string]*ChannelUser)
+	s.userChannels = make(map[string]map[string]*UserChannel)
+	s.whois = make(map[string]*WhoisInfo)
+	s.whoisUntracked = make(map[string]*WhoisInfo)
+	s.split, s.netjoin = nil, nil
+	s.splitUsers = make(map[string]splitUser)
+	s.seen = make(map[string]Seen)
+	s.resyncs = make(map[string]*resync)
+	s.version++
+
+	for _, usnap := range snap.Users {
+		user := s.addUser(usnap.Host)
+		if user == nil {
+			continue
+		}
+		user.SetRealname(usnap.Realname)
+		user.SetAccount(usnap.Account)
+		user.SetAway(usnap.Away, usnap.AwayMessage)
+	}
+
+	s.Self.User = nil
+	if len(snap.Self) > 0 {
+		s.Self.User = s.addUser(snap.Self)
+	}
+	s.Self.ChannelModes = CreateChannelModes(&ChannelModeKinds{}, nil)
+	for _, mode := range snap.SelfModes {
+		s.Self.setMode(mode)
+	}
+
+	for _, chsnap := range snap.Channels {
+		ch := s.addChannel(chsnap.Name)
+		if ch == nil {
+			continue
+		}
+		ch.SetTopic(chsnap.Topic)
+		ch.SetTopicInfo(chsnap.TopicSetter, chsnap.TopicTime)
+		ch.SetCreated(chsnap.Created)
+		for _, mode := range chsnap.Modes {
+			ch.setMode(mode)
+		}
+		for mode, arg := range chsnap.Args {
+			if len(mode) > 0 {
+				ch.setArg([]rune(mode)[0], arg)
+			}
+		}
+		for mode, entries := range chsnap.Lists {
+			if len(mode) == 0 {
+				continue
+			}
+			for _, entry := range entries {
+				ch.AddListEntry([]rune(mode)[0], entry)
+			}
+		}
+
+		key := strings.ToLower(chsnap.Name)
+		for nick, modes := range chsnap.Members {
+			if s.addUser(nick) == nil {
+				continue
+			}
+			s.addToChannel(nick, chsnap.Name)
+			cu, ok := s.channelUsers[key][strings.ToLower(nick)]
+			if !ok {
+				continue
+			}
+			for _, mode := range modes {
+				cu.SetMode(mode)
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteJSON encodes the snapshot as JSON.
+func (snap *Snapshot) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// WriteGob encodes the snapshot as gob.
+func (snap *Snapshot) WriteGob(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(snap)
+}
+
+// ReadSnapshotJSON decodes a snapshot encoded with WriteJSON.
+func ReadSnapshotJSON(r io.Reader) (*Snapshot, error) {
+	snap := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// ReadSnapshotGob decodes a snapshot encoded with WriteGob.
+func ReadSnapshotGob(r io.Reader) (*Snapshot, error) {
+	snap := &Snapshot{}
+	if err := gob.NewDecoder(r).Decode(snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// sortedModes turns a set of modes into a sorted string of them.
+func sortedModes(modes map[rune]bool) string {
+	runes := make([]string, 0, len(modes))
+	for mode := range modes {
+		runes = append(runes, string(mode))
+	}
+	sort.Strings(runes)
+	return strings.Join(runes, "")
+}
+
+// userSnapshots sorts user snapshots by host.
+type userSnapshots []UserSnapshot
+
+func (u userSnapshots) Len() int           { return len(u) }
+func (u userSnapshots) Less(i, j int) bool { return u[i].Host < u[j].Host }
+func (u userSnapshots) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+// channelSnapshots sorts channel snapshots by name.
+type channelSnapshots []ChannelSnapshot
+
+func (c channelSnapshots) Len() int           { return len(c) }
+func (c channelSnapshots) Less(i, j int) bool { return c[i].Name < c[j].Name }
+func (c channelSnapshots) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
@@ -0,0 +1,139 @@
+package data
+
+import (
+	"bytes"
+	"github.com/aarondl/ultimateq/irc"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+// snapshotState creates a state with something in each part of a snapshot.
+func snapshotState(c *C) *State {
+	st := eventState(c)
+	update := func(name, sender string, args ...string) {
+		_, err := st.Update(&irc.Message{
+			Name: name, Sender: sender, Args: args,
+		})
+		c.Assert(err, IsNil)
+	}
+
+	update(irc.MODE, self.Host(), self.Nick(), "+iw")
+	update(irc.MODE, users[0], channels[0], "+ntlk-t", "10", "key")
+	update(irc.MODE, users[0], channels[0], "+bbo", "*!*@ban1", "*!*@ban2",
+		nicks[1])
+	update(irc.MODE, users[0], channels[1], "+v", nicks[0])
+	update(irc.TOPIC, users[0], channels[0], "the topic")
+	update(irc.RPL_CREATIONTIME, server, self.Nick(), channels[0],
+		"1401610000")
+	update(irc.AWAY, users[1], "not here")
+	update(irc.ACCOUNT, users[1], "account2")
+
+	st.GetUser(users[0]).SetRealname("real name")
+	return st
+}
+
+func (s *s) TestState_Snapshot(c *C) {
+	st := snapshotState(c)
+	snap := st.Snapshot()
+
+	c.Check(snap.Version, Equals, SnapshotVersion)
+	c.Check(snap.Self, Equals, self.Host())
+	c.Check(snap.SelfModes, Equals, "iw")
+	c.Check(snap.Users, HasLen, 3)
+	c.Check(snap.Users[0], DeepEquals, UserSnapshot{Host: self.Host()})
+	c.Check(snap.Users[1], DeepEquals, UserSnapshot{
+		Host: users[0], Realname: "real name",
+	})
+	c.Check(snap.Users[2], DeepEquals, UserSnapshot{
+		Host: users[1], Account: "account2", Away: true,
+		AwayMessage: "not here",
+	})
+
+	c.Assert(snap.Channels, HasLen, 2)
+	ch := snap.Channels[0]
+	c.Check(ch.Name, Equals, channels[0])
+	c.Check(ch.Topic, Equals, "the topic")
+	c.Check(ch.TopicSetter, Equals, users[0])
+	c.Check(ch.TopicTime.IsZero(), Equals, false)
+	c.Check(ch.Created.Unix(), Equals, int64(1401610000))
+	c.Check(ch.Modes, Equals, "n")
+	c.Check(ch.Args, DeepEquals, map[string]string{"l": "10", "k": "key"})
+	c.Assert(ch.Lists["b"], HasLen, 2)
+	c.Check(ch.Lists["b"][0].Mask, Equals, "*!*@ban1")
+	c.Check(ch.Lists["b"][1].Setter, Equals, users[0])
+	c.Check(ch.Members, DeepEquals, map[string]string{
+		self.Nick(): "", nicks[0]: "", nicks[1]: "o",
+	})
+	c.Check(snap.Channels[1].Members, DeepEquals, map[string]string{
+		self.Nick(): "", nicks[0]: "v",
+	})
+}
+
+func (s *s) TestState_Restore(c *C) {
+	snap := snapshotState(c).Snapshot()
+
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	st.addUser("stale!stale@stale")
+	st.addChannel("#stale")
+
+	c.Assert(st.Restore(snap), IsNil)
+	c.Check(st.Snapshot(), DeepEquals, snap)
+	c.Check(st.GetUser("stale"), IsNil)
+	c.Check(st.GetChannel("#stale"), IsNil)
+
+	c.Check(st.Self.Host(), Equals, self.Host())
+	c.Check(st.Self.IsSet("iw"), Equals, true)
+	c.Check(st.GetUser(nicks[1]).IsAway(), Equals, true)
+	c.Check(st.IsOn(nicks[1], channels[0]), Equals, true)
+	c.Check(st.GetUsersChannelModes(nicks[1], channels[0]).HasMode('o'),
+		Equals, true)
+	ch := st.GetChannel(channels[0])
+	c.Check(ch.GetArg('k'), Equals, "key")
+	c.Check(ch.BanEntries()[1].Setter, Equals, users[0])
+
+	// The restored state keeps tracking the server.
+	_, err = st.Update(&irc.Message{
+		Name: irc.PART, Sender: users[1], Args: []string{channels[0]},
+	})
+	c.Check(err, IsNil)
+	c.Check(st.IsOn(nicks[1], channels[0]), Equals, false)
+}
+
+func (s *s) TestState_RestoreVersion(c *C) {
+	st := snapshotState(c)
+	err := st.Restore(&Snapshot{Version: SnapshotVersion + 1})
+	c.Check(err, ErrorMatches, `.*snapshot version 2 is not supported.*`)
+	c.Check(st.GetChannel(channels[0]), NotNil)
+}
+
+func (s *s) TestSnapshot_Encoding(c *C) {
+	snap := snapshotState(c).Snapshot()
+	dump := &bytes.Buffer{}
+	c.Assert(snap.WriteJSON(dump), IsNil)
+
+	buf := &bytes.Buffer{}
+	c.Assert(snap.WriteGob(buf), IsNil)
+	fromGob, err := ReadSnapshotGob(buf)
+	c.Assert(err, IsNil)
+	buf.Reset()
+	c.Assert(fromGob.WriteJSON(buf), IsNil)
+	c.Check(buf.String(), Equals, dump.String())
+
+	fromJSON, err := ReadSnapshotJSON(bytes.NewReader(dump.Bytes()))
+	c.Assert(err, IsNil)
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Assert(err, IsNil)
+	c.Assert(st.Restore(fromJSON), IsNil)
+	buf.Reset()
+	c.Assert(st.Snapshot().WriteJSON(buf), IsNil)
+	c.Check(buf.String(), Equals, dump.String())
+
+	c.Check(fromJSON.Channels[0].Created.Equal(time.Unix(1401610000, 0)),
+		Equals, true)
+
+	_, err = ReadSnapshotJSON(bytes.NewBufferString("{"))
+	c.Check(err, NotNil)
+	_, err = ReadSnapshotGob(bytes.NewBufferString("nope"))
+	c.Check(err, NotNil)
+}
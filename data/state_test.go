@@ -2,10 +2,14 @@ package data
 
 import (
 	"code.google.com/p/go.crypto/bcrypt"
+	"flag"
 	"github.com/aarondl/ultimateq/irc"
 	. "gopkg.in/check.v1"
+	"math/rand"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test(t *testing.T) { TestingT(t) } //Hook into testing package
@@ -789,6 +793,263 @@ func (s *s) TestState_RplWhoReply(c *C) {
 		st.GetUsersChannelModes(users[0], channels[0]).String(), Equals, "o")
 }
 
+func (s *s) TestState_RplWhoxReply(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	c.Check(WhoxQuery(channels[0]), Equals, "WHO #CHAN1 %tcuhnfar,152")
+
+	m := &irc.Message{
+		Name:   irc.RPL_WHOSPCRPL,
+		Sender: server,
+		Args: []string{
+			self.Nick(), "152", channels[0], irc.Username(users[0]),
+			irc.Hostname(users[0]), nicks[0], "G@", "acct", "real name",
+		},
+	}
+
+	st.addChannel(channels[0])
+	st.Update(m)
+	user := st.GetUser(users[0])
+	c.Assert(user, NotNil)
+	c.Check(user.Host(), Equals, users[0])
+	c.Check(user.Account(), Equals, "acct")
+	c.Check(user.Realname(), Equals, "real name")
+	c.Check(user.IsAway(), Equals, true)
+	c.Check(st.IsOn(users[0], channels[0]), Equals, true)
+	c.Check(
+		st.GetUsersChannelModes(users[0], channels[0]).String(), Equals, "o")
+
+	m.Args[5], m.Args[6], m.Args[7] = nicks[1], "H", "0"
+	m.Args[3], m.Args[4] = irc.Username(users[1]), irc.Hostname(users[1])
+	st.Update(m)
+	user = st.GetUser(users[1])
+	c.Assert(user, NotNil)
+	c.Check(user.Account(), Equals, "")
+	c.Check(user.IsAway(), Equals, false)
+
+	m.Args[1], m.Args[5] = "1", "other"
+	st.Update(m)
+	c.Check(st.GetUser("other"), IsNil)
+}
+
+func (s *s) TestState_UpdateExtendedJoin(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self.User = self.User
+	st.addChannel(channels[0])
+
+	st.Update(&irc.Message{
+		Name:   irc.JOIN,
+		Sender: users[0],
+		Args:   []string{channels[0], "acct", "real name"},
+	})
+	user := st.GetUser(users[0])
+	c.Assert(user, NotNil)
+	c.Check(st.IsOn(users[0], channels[0]), Equals, true)
+	c.Check(user.Account(), Equals, "acct")
+	c.Check(user.Realname(), Equals, "real name")
+
+	st.Update(&irc.Message{
+		Name:   irc.JOIN,
+		Sender: users[1],
+		Args:   []string{channels[0], "*", "real name"},
+	})
+	c.Check(st.GetUser(users[1]).Account(), Equals, "")
+}
+
+func (s *s) TestState_UpdateAccount(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.addUser(users[0])
+
+	st.Update(&irc.Message{
+		Name: irc.ACCOUNT, Sender: users[0], Args: []string{"acct"},
+	})
+	c.Check(st.GetUser(users[0]).Account(), Equals, "acct")
+	st.Update(&irc.Message{
+		Name: irc.ACCOUNT, Sender: users[0], Args: []string{"*"},
+	})
+	c.Check(st.GetUser(users[0]).Account(), Equals, "")
+}
+
+func (s *s) TestState_UpdateAway(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self.User = CreateUser(self.Host())
+	st.addUser(users[0])
+
+	st.Update(&irc.Message{
+		Name: irc.AWAY, Sender: users[0], Args: []string{"lunch"},
+	})
+	c.Check(st.GetUser(users[0]).IsAway(), Equals, true)
+	c.Check(st.GetUser(users[0]).AwayMessage(), Equals, "lunch")
+	st.Update(&irc.Message{Name: irc.AWAY, Sender: users[0]})
+	c.Check(st.GetUser(users[0]).IsAway(), Equals, false)
+
+	st.Update(&irc.Message{
+		Name:   irc.RPL_AWAY,
+		Sender: server,
+		Args:   []string{self.Nick(), nicks[0], "dinner"},
+	})
+	c.Check(st.GetUser(users[0]).AwayMessage(), Equals, "dinner")
+
+	st.Update(&irc.Message{
+		Name:   irc.RPL_NOWAWAY,
+		Sender: server,
+		Args:   []string{self.Nick(), "You have been marked as away"},
+	})
+	c.Check(st.Self.IsAway(), Equals, true)
+	st.Update(&irc.Message{
+		Name:   irc.RPL_UNAWAY,
+		Sender: server,
+		Args:   []string{self.Nick(), "You are no longer away"},
+	})
+	c.Check(st.Self.IsAway(), Equals, false)
+}
+
+func (s *s) TestState_UpdateChghost(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.addUser(users[0])
+	st.addChannel(channels[0])
+	st.addToChannel(users[0], channels[0])
+
+	st.Update(&irc.Message{
+		Name: irc.CHGHOST, Sender: users[0], Args: []string{"new", "new.host"},
+	})
+	user := st.GetUser(nicks[0])
+	c.Check(user.Host(), Equals, nicks[0]+"!new@new.host")
+	c.Check(st.IsOn(nicks[0], channels[0]), Equals, true)
+}
+
+func (s *s) TestState_UpdateSetname(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.addUser(users[0])
+
+	st.Update(&irc.Message{
+		Name: irc.SETNAME, Sender: users[0], Args: []string{"new name"},
+	})
+	c.Check(st.GetUser(users[0]).Realname(), Equals, "new name")
+}
+
+func (s *s) TestState_UpdateWhois(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	c.Check(st.GetWhois(nicks[0]), IsNil)
+	st.addUser(nicks[0])
+
+	whois := func(name string, args ...string) {
+		st.Update(&irc.Message{
+			Name: name, Sender: server,
+			Args: append([]string{self.Nick(), nicks[0]}, args...),
+		})
+	}
+
+	whois(irc.RPL_WHOISSERVER, "ignored.server.net", "ignored")
+	whois(irc.RPL_WHOISUSER, irc.Username(users[0]), irc.Hostname(users[0]),
+		"*", "real name")
+	whois(irc.RPL_WHOISSERVER, "irc.server.net", "A server")
+	whois(irc.RPL_WHOISOPERATOR, "is an IRC operator")
+	whois(irc.RPL_WHOISIDLE, "30", "1400000000", "seconds idle, signon time")
+	whois(irc.RPL_WHOISCHANNELS, "@#chan1 #chan2")
+	whois(irc.RPL_WHOISCHANNELS, "+#chan3")
+	whois(irc.RPL_WHOISACCOUNT, "acct", "is logged in as")
+	whois(irc.RPL_WHOISSECURE, "is using a secure connection")
+	c.Check(st.GetWhois(nicks[0]), IsNil)
+	whois(irc.RPL_ENDOFWHOIS, "End of /WHOIS list.")
+
+	user := st.GetUser(users[0])
+	c.Assert(user, NotNil)
+	c.Check(user.Realname(), Equals, "real name")
+	c.Check(user.Account(), Equals, "acct")
+
+	info := st.GetWhois(nicks[0])
+	c.Assert(info, NotNil)
+	c.Check(info.Server, Equals, "irc.server.net")
+	c.Check(info.ServerInfo, Equals, "A server")
+	c.Check(info.Operator, Equals, true)
+	c.Check(info.Secure, Equals, true)
+	c.Check(info.Account, Equals, "acct")
+	c.Check(info.Channels, DeepEquals, []string{"@#chan1", "#chan2", "+#chan3"})
+	c.Check(info.Idle, Equals, 30*time.Second)
+	c.Check(info.SignOn.Unix(), Equals, int64(1400000000))
+	c.Check(time.Since(info.Updated) < time.Minute, Equals, true)
+	c.Check(user.Whois(), Equals, info)
+
+	// Follows the user through nick changes.
+	st.Update(&irc.Message{
+		Name: irc.NICK, Sender: users[0], Args: []string{"newnick"},
+	})
+	c.Check(st.GetWhois("newnick"), Equals, info)
+
+	info.Updated = time.Now().Add(-2 * DefaultWhoisLifetime)
+	c.Check(st.GetWhois("newnick"), IsNil)
+	st.SetWhoisLifetime(0)
+	c.Check(st.GetWhois("newnick"), Equals, info)
+}
+
+func (s *s) TestState_UpdateWhoisUntracked(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+
+	whois := func(nick string, name string, args ...string) {
+		st.Update(&irc.Message{
+			Name: name, Sender: server,
+			Args: append([]string{self.Nick(), nick}, args...),
+		})
+	}
+
+	whois(nicks[0], irc.RPL_WHOISUSER, irc.Username(users[0]),
+		irc.Hostname(users[0]), "*", "real name")
+	whois(nicks[0], irc.RPL_WHOISACCOUNT, "acct", "is logged in as")
+	whois(nicks[0], irc.RPL_ENDOFWHOIS, "End of /WHOIS list.")
+	c.Check(st.GetUser(nicks[0]), IsNil)
+	c.Check(st.users, HasLen, 0)
+
+	info := st.GetWhois(strings.ToUpper(nicks[0]))
+	c.Assert(info, NotNil)
+	c.Check(info.Account, Equals, "acct")
+	c.Check(st.GetWhois(users[0]), Equals, info)
+
+	// Old results are pruned when another reply ends.
+	info.Updated = time.Now().Add(-2 * DefaultWhoisLifetime)
+	c.Check(st.GetWhois(nicks[0]), IsNil)
+	whois(nicks[1], irc.RPL_WHOISUSER, irc.Username(users[1]),
+		irc.Hostname(users[1]), "*", "real name")
+	whois(nicks[1], irc.RPL_ENDOFWHOIS, "End of /WHOIS list.")
+	c.Check(st.whoisUntracked, HasLen, 1)
+	c.Check(st.GetWhois(nicks[1]), NotNil)
+
+	// Once the user is tracked the result is given to it.
+	st.addUser(users[1])
+	whois(nicks[1], irc.RPL_WHOISUSER, irc.Username(users[1]),
+		irc.Hostname(users[1]), "*", "real name")
+	whois(nicks[1], irc.RPL_ENDOFWHOIS, "End of /WHOIS list.")
+	c.Check(st.whoisUntracked, HasLen, 0)
+	c.Check(st.GetUser(nicks[1]).Whois(), Equals, st.GetWhois(nicks[1]))
+}
+
+func (s *s) TestState_UpdateWhoisNoSuchNick(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+
+	st.Update(&irc.Message{
+		Name: irc.RPL_WHOISUSER, Sender: server,
+		Args: []string{self.Nick(), nicks[0], irc.Username(users[0]),
+			irc.Hostname(users[0]), "*", "real name"},
+	})
+	st.Update(&irc.Message{
+		Name: irc.ERR_NOSUCHNICK, Sender: server,
+		Args: []string{self.Nick(), nicks[0], "No such nick/channel"},
+	})
+	st.Update(&irc.Message{
+		Name: irc.RPL_ENDOFWHOIS, Sender: server,
+		Args: []string{self.Nick(), nicks[0], "End of /WHOIS list."},
+	})
+	c.Check(st.GetWhois(nicks[0]), IsNil)
+}
+
 func (s *s) TestState_UpdateRplMode(c *C) {
 	st, err := CreateState(irc.CreateProtoCaps())
 	c.Check(err, IsNil)
@@ -821,4 +1082,370 @@ func (s *s) TestState_UpdateRplBanlist(c *C) {
 	c.Check(st.GetChannel(channels[0]).HasBan(nicks[0]+"!*@*"), Equals, false)
 	st.Update(m)
 	c.Check(st.GetChannel(channels[0]).HasBan(nicks[0]+"!*@*"), Equals, true)
+	c.Check(st.GetChannel(channels[0]).BanEntries(), DeepEquals, []ListEntry{{
+		Mask: nicks[0] + "!*@*", Setter: nicks[1],
+		Time: time.Unix(1367197165, 0),
+	}})
+
+	// Untracked channels and short replies are ignored.
+	m.Args[1] = "#untracked"
+	st.Update(m)
+	st.Update(&irc.Message{
+		Name: irc.RPL_BANLIST, Sender: server,
+		Args: []string{self.Nick(), channels[0]},
+	})
+}
+
+func (s *s) TestState_UpdateRplExceptInviteList(c *C) {
+	caps := irc.CreateProtoCaps()
+	st, err := CreateState(caps)
+	c.Check(err, IsNil)
+	st.Self = self
+	st.addChannel(channels[0])
+
+	except := &irc.Message{
+		Name: irc.RPL_EXCEPTLIST, Sender: server,
+		Args: []string{self.Nick(), channels[0], "a!*@*", nicks[0],
+			"1367197165"},
+	}
+	invite := &irc.Message{
+		Name: irc.RPL_INVITELIST, Sender: server,
+		Args: []string{self.Nick(), channels[0], "b!*@*"},
+	}
+
+	c.Check(st.ExceptMode(), Equals, rune(0))
+	c.Check(st.InvexMode(), Equals, rune(0))
+	st.Update(except)
+	st.Update(invite)
+	c.Check(st.GetChannel(channels[0]).ListEntries('e'), IsNil)
+	c.Check(st.GetChannel(channels[0]).ListEntries('I'), IsNil)
+
+	caps.ParseISupport(&irc.Message{
+		Args: []string{self.Nick(), "EXCEPTS", "INVEX=J"},
+	})
+	c.Check(st.ExceptMode(), Equals, 'e')
+	c.Check(st.InvexMode(), Equals, 'J')
+	st.Update(except)
+	st.Update(invite)
+	c.Check(st.GetChannel(channels[0]).ListEntries('e'), DeepEquals,
+		[]ListEntry{{Mask: "a!*@*", Setter: nicks[0],
+			Time: time.Unix(1367197165, 0)}})
+	c.Check(st.GetChannel(channels[0]).ListEntries('J'), DeepEquals,
+		[]ListEntry{{Mask: "b!*@*"}})
+}
+
+func (s *s) TestState_UpdateModeListEntries(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self = self
+	st.addChannel(channels[0])
+	ch := st.GetChannel(channels[0])
+
+	before := time.Now()
+	st.Update(&irc.Message{
+		Name: irc.MODE, Sender: users[0],
+		Args: []string{channels[0], "+b", "a!*@*"},
+	})
+	entries := ch.BanEntries()
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Mask, Equals, "a!*@*")
+	c.Check(entries[0].Setter, Equals, users[0])
+	c.Check(entries[0].Time.Before(before), Equals, false)
+
+	st.Update(&irc.Message{
+		Name: irc.MODE, Sender: users[0],
+		Args: []string{channels[0], "-b", "a!*@*"},
+	})
+	c.Check(ch.BanEntries(), IsNil)
+	c.Check(ch.lists, HasLen, 0)
+}
+
+func (s *s) TestState_UpdateTopicInfo(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self = self
+	st.addChannel(channels[0])
+	ch := st.GetChannel(channels[0])
+
+	st.Update(&irc.Message{
+		Name: irc.RPL_TOPICWHOTIME, Sender: server,
+		Args: []string{self.Nick(), channels[0], users[0], "1367197165"},
+	})
+	c.Check(ch.TopicSetter(), Equals, users[0])
+	c.Check(ch.TopicTime().Equal(time.Unix(1367197165, 0)), Equals, true)
+
+	st.Update(&irc.Message{
+		Name: irc.RPL_CREATIONTIME, Sender: server,
+		Args: []string{self.Nick(), channels[0], "1367190000"},
+	})
+	c.Check(ch.Created().Equal(time.Unix(1367190000, 0)), Equals, true)
+
+	st.Update(&irc.Message{
+		Name: irc.RPL_CREATIONTIME, Sender: server,
+		Args: []string{self.Nick(), channels[0], "bogus"},
+	})
+	c.Check(ch.Created().IsZero(), Equals, true)
+
+	st.Update(&irc.Message{
+		Name: irc.TOPIC, Sender: users[1], Args: []string{channels[0], "new"},
+	})
+	c.Check(ch.TopicSetter(), Equals, users[1])
+	c.Check(time.Since(ch.TopicTime()) < time.Minute, Equals, true)
+
+	st.Update(&irc.Message{
+		Name: irc.RPL_TOPICWHOTIME, Sender: server,
+		Args: []string{self.Nick(), "#untracked", users[0], "1367197165"},
+	})
+	st.Update(&irc.Message{
+		Name: irc.RPL_CREATIONTIME, Sender: server,
+		Args: []string{self.Nick(), channels[0]},
+	})
+}
+
+func (s *s) TestState_UpdateErrors(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self = self
+	st.addChannel(channels[0])
+
+	for name, need := range updateArity {
+		m := &irc.Message{
+			Name: name, Sender: users[0], Args: make([]string, need-1),
+		}
+		events, err := st.Update(m)
+		c.Check(events, IsNil)
+		c.Check(err, DeepEquals, UpdateError{
+			Name: name, Args: m.Args, Need: need,
+		}, Commentf("%v", name))
+	}
+	c.Check(st.GetUser(users[0]), IsNil)
+
+	err = UpdateError{Name: irc.KICK, Args: []string{channels[0]}, Need: 2}
+	c.Check(err.Error(), Equals,
+		"data: KICK message has 1 arguments, it needs 2.")
+
+	_, err = st.Update(&irc.Message{
+		Name: irc.QUIT, Sender: users[0], Args: nil,
+	})
+	c.Check(err, IsNil)
+}
+
+func (s *s) TestState_UpdateMalformed(c *C) {
+	st, err := CreateState(irc.CreateProtoCaps())
+	c.Check(err, IsNil)
+	st.Self = self
+	st.addChannel(channels[0])
+
+	msgs := []*irc.Message{
+		{Name: irc.RPL_WELCOME, Args: []string{self.Nick(), ""}},
+		{Name: irc.RPL_WELCOME, Args: []string{"", ""}},
+		{Name: irc.RPL_WHOREPLY, Args: []string{self.Nick(), channels[0],
+			"user", "host", server, "nick", "H", "0"}},
+		{Name: irc.RPL_WHOREPLY, Args: []string{self.Nick(), channels[0],
+			"", "", server, "", "H@", "0 name"}},
+		{Name: irc.RPL_NAMREPLY, Args: []string{self.Nick(), "=",
+			"#untracked", "@nick +other"}},
+		{Name: irc.RPL_NAMREPLY, Args: []string{self.Nick(), "=",
+			channels[0], "@ + @@"}},
+		{Name: irc.RPL_CHANNELMODEIS, Args: []string{self.Nick(),
+			"#untracked", "+nt"}},
+		{Name: irc.MODE, Sender: users[0], Args: []string{channels[0],
+			"+o-v", "notthere", "norhere"}},
+		{Name: irc.JOIN, Sender: self.Host(), Args: []string{""}},
+	}
+	for _, m := range msgs {
+		_, err = st.Update(m)
+		c.Check(err, IsNil)
+	}
+	c.Check(st.Self.Nick(), Equals, self.Nick())
+	c.Check(st.GetUser("nick").Realname(), Equals, "")
+	c.Check(st.GetUser(""), IsNil)
+	c.Check(st.GetNChannels(), Equals, 1)
+}
+
+// fuzzArgs are the arguments messages are built from by the fuzz test, they
+// are chosen to look like what the state expects and to be what it doesn't.
+var fuzzArgs = []string{
+	"", " ", "@", "+", "!", "!@", "@+", "*", "0", "-1", "1367197165",
+	"me", "nick1", "nick2", "nick1!user1@host1", "me!my@host.com", "a.b",
+	"#CHAN1", "#chan2", "&", "#", "+o", "-o", "+ov", "+b", "-b", "+k",
+	"+l", "+beI", "+o-v+b", "152", "H", "G@", "H*+", "0 real name",
+	"@nick1 +nick2 me", "#CHAN1 @#chan2",
+}
+
+// fuzzSeed is the seed of TestState_UpdateFuzz, it's fixed so failures can be
+// reproduced but can be changed to try other messages.
+var fuzzSeed = flag.Int64("fuzzseed", 1, "seed of the random state updates")
+
+// fuzzSenders are the senders of the messages built by the fuzz tests.
+var fuzzSenders = []string{"", users[0], users[1], self.Host(), server, "!@"}
+
+// fuzzNames are the names of the messages built by the fuzz tests, every
+// message the state checks the arguments of and a few that it doesn't.
+func fuzzNames() []string {
+	names := make([]string, 0, len(updateArity)+3)
+	for name := range updateArity {
+		names = append(names, name)
+	}
+	names = append(names, irc.QUIT, irc.AWAY, irc.RPL_NOWAWAY)
+	sort.Strings(names)
+	return names
+}
+
+// randomMessage builds a message out of the fuzz tables.
+func randomMessage(r *rand.Rand, names []string) *irc.Message {
+	m := &irc.Message{
+		Name:   names[r.Intn(len(names))],
+		Sender: fuzzSenders[r.Intn(len(fuzzSenders))],
+		Args:   make([]string, r.Intn(10)),
+	}
+	for j := range m.Args {
+		m.Args[j] = fuzzArgs[r.Intn(len(fuzzArgs))]
+	}
+	return m
+}
+
+func (s *s) TestState_UpdateFuzz(c *C) {
+	names := fuzzNames()
+	seed := *fuzzSeed
+
+	r := rand.New(rand.NewSource(seed))
+	for run := 0; run < 20; run++ {
+		st, err := CreateState(irc.CreateProtoCaps())
+		c.Assert(err, IsNil)
+		st.Self.User = CreateUser(self.Host())
+
+		for i := 0; i < 1000; i++ {
+			m := randomMessage(r, names)
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						c.Fatalf("Update panicked on %#v (seed %v): %v", m,
+							seed, r)
+					}
+				}()
+				st.Update(m)
+			}()
+		}
+	}
+}
+
+// encodeFuzz encodes messages into the input of FuzzStateUpdate.
+func encodeFuzz(msgs []*irc.Message) []byte {
+	lines := make([]string, len(msgs))
+	for i, m := range msgs {
+		lines[i] = strings.Join(append([]string{m.Name, m.Sender}, m.Args...),
+			"\x00")
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// decodeFuzz builds messages from the input of FuzzStateUpdate. Messages are
+// separated by newlines, and their name, sender and arguments by NUL bytes.
+func decodeFuzz(input []byte) (msgs []*irc.Message) {
+	for _, line := range strings.Split(string(input), "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) < 2 {
+			continue
+		}
+		msgs = append(msgs, &irc.Message{
+			Name: fields[0], Sender: fields[1], Args: fields[2:],
+		})
+	}
+	return msgs
+}
+
+func FuzzStateUpdate(f *testing.F) {
+	names := fuzzNames()
+	for i, name := range names {
+		m := &irc.Message{Name: name, Sender: fuzzSenders[i%len(fuzzSenders)]}
+		for j := 0; j <= updateArity[name]; j++ {
+			m.Args = append(m.Args, fuzzArgs[(i+j)%len(fuzzArgs)])
+		}
+		f.Add(encodeFuzz([]*irc.Message{m}))
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		msgs := make([]*irc.Message, 50)
+		for j := range msgs {
+			msgs[j] = randomMessage(r, names)
+		}
+		f.Add(encodeFuzz(msgs))
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		st, err := CreateState(irc.CreateProtoCaps())
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.Self.User = CreateUser(self.Host())
+		for _, m := range decodeFuzz(input) {
+			st.Update(m)
+		}
+	})
+}
+
+func (s *s) TestState_Clone(c *C) {
+	st := snapshotState(c)
+	clone := st.Clone()
+	c.Check(clone.Snapshot(), DeepEquals, st.Snapshot())
+	c.Check(clone.Self.User, Equals, clone.GetUser(self.Host()))
+	c.Check(clone.GetWhois(nicks[0]), IsNil)
+
+	cu := clone.GetUsersChannelModes(nicks[1], channels[0])
+	uc := clone.userChannels[nicks[1]][strings.ToLower(channels[0])]
+	c.Check(cu.HasMode('o'), Equals, true)
+	c.Check(uc.UserModes, Equals, cu)
+
+	update := func(name, sender string, args ...string) {
+		_, err := st.Update(&irc.Message{
+			Name: name, Sender: sender, Args: args,
+		})
+		c.Assert(err, IsNil)
+	}
+	update(irc.MODE, users[0], channels[0], "-o+b", nicks[1], "*!*@ban3")
+	update(irc.TOPIC, users[0], channels[0], "new topic")
+	update(irc.NICK, users[0], "newnick")
+	update(irc.PART, users[1], channels[0])
+	update(irc.MODE, self.Host(), self.Nick(), "-i")
+
+	c.Check(clone.GetChannel(channels[0]).Topic(), Equals, "the topic")
+	c.Check(clone.GetChannel(channels[0]).HasBan("*!*@ban3"), Equals, false)
+	c.Check(cu.HasMode('o'), Equals, true)
+	c.Check(clone.GetUser(nicks[0]), NotNil)
+	c.Check(clone.GetUser("newnick"), IsNil)
+	c.Check(clone.IsOn(nicks[1], channels[0]), Equals, true)
+	c.Check(clone.Self.IsSet("i"), Equals, true)
+}
+
+func (s *s) TestState_Version(c *C) {
+	st := eventState(c)
+	version := st.version
+	update := func(name, sender string, args ...string) {
+		_, err := st.Update(&irc.Message{
+			Name: name, Sender: sender, Args: args,
+		})
+		c.Assert(err, IsNil)
+	}
+
+	update(irc.PRIVMSG, users[0], channels[0], "hello")
+	c.Check(st.version, Equals, version)
+	update(irc.PRIVMSG, "nick1!changed@host1", channels[0], "hello")
+	c.Check(st.version, Not(Equals), version)
+
+	version = st.version
+	update(irc.NOTICE, users[1], channels[1], "joined by talking")
+	c.Check(st.version, Not(Equals), version)
+
+	version = st.version
+	update(irc.TOPIC, users[0], channels[0], "topic")
+	c.Check(st.version, Not(Equals), version)
+
+	version = st.version
+	update(irc.PING, "", "server")
+	c.Check(st.version, Equals, version)
+	update(irc.PART, users[0], "#unknown")
+	c.Check(st.version, Equals, version)
+	update(irc.MODE, users[0], channels[0], "+m")
+	c.Check(st.version, Not(Equals), version)
 }
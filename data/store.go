@@ -14,6 +14,7 @@ const (
 	AuthErrBadPassword = iota + 1
 	AuthErrHostNotFound
 	AuthErrUserNotFound
+	AuthErrAccountNotFound
 )
 
 // These error messages are put into the AuthError's string field and will
@@ -27,6 +28,9 @@ const (
 	// errFmtBadHost occurs when a user has hosts defined, and the user's
 	// current host is not a match.
 	errFmtBadHost = "Host [%v] does not match stored hosts for user [%v]."
+	// errFmtAccountNotFound occurs when no user has the services account
+	// linked to it.
+	errFmtAccountNotFound = "No user has the account [%v] linked."
 )
 
 // AuthError is returned when a user failure occurs (bad password etc.) during
@@ -77,6 +81,10 @@ func CreateStore(prov DbProvider) (*Store, error) {
 		cache:  make(map[string]*UserAccess),
 		authed: make(map[string]*UserAccess),
 	}
+	if err = s.indexAccounts(); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	return s, nil
 }
@@ -88,7 +96,7 @@ func (s *Store) Close() error {
 
 // GlobalUsers gets users with global access
 func (s *Store) GlobalUsers() (list []UserAccess, err error) {
-	var val []byte
+	var key, val []byte
 	var e *kv.Enumerator
 	var ua *UserAccess
 	var a *Access
@@ -102,7 +110,10 @@ func (s *Store) GlobalUsers() (list []UserAccess, err error) {
 		return nil, err
 	}
 
-	for ; stop == nil; _, val, stop = e.Next() {
+	for ; stop == nil; key, val, stop = e.Next() {
+		if isDataKey(key) {
+			continue
+		}
 		ua, err = deserialize(val)
 		if err != nil {
 			err = nil
@@ -119,7 +130,7 @@ func (s *Store) GlobalUsers() (list []UserAccess, err error) {
 
 // ServerUsers gets users with Server access
 func (s *Store) ServerUsers(server string) (list []UserAccess, err error) {
-	var val []byte
+	var key, val []byte
 	var e *kv.Enumerator
 	var ua *UserAccess
 	var a *Access
@@ -133,7 +144,10 @@ func (s *Store) ServerUsers(server string) (list []UserAccess, err error) {
 		return nil, err
 	}
 
-	for ; stop == nil; _, val, stop = e.Next() {
+	for ; stop == nil; key, val, stop = e.Next() {
+		if isDataKey(key) {
+			continue
+		}
 		ua, err = deserialize(val)
 		if err != nil {
 			err = nil
@@ -150,7 +164,7 @@ func (s *Store) ServerUsers(server string) (list []UserAccess, err error) {
 
 // ChanUsers gets users with access to a channel
 func (s *Store) ChanUsers(server, channel string) (list []UserAccess, err error) {
-	var val []byte
+	var key, val []byte
 	var e *kv.Enumerator
 	var ua *UserAccess
 	var a *Access
@@ -164,7 +178,10 @@ func (s *Store) ChanUsers(server, channel string) (list []UserAccess, err error)
 		return nil, err
 	}
 
-	for ; stop == nil; _, val, stop = e.Next() {
+	for ; stop == nil; key, val, stop = e.Next() {
+		if isDataKey(key) {
+			continue
+		}
 		ua, err = deserialize(val)
 		if err != nil {
 			err = nil
@@ -189,10 +206,22 @@ func (s *Store) AddUser(ua *UserAccess) error {
 		return err
 	}
 
-	s.db.Set([]byte(ua.Username), serialized)
+	// The user in the cache may be the one being changed, so the account it
+	// had is taken from the database.
+	var old string
+	if stored, err := s.fetchUser(ua.Username); err != nil {
+		return err
+	} else if stored != nil {
+		old = stored.Account
+	}
+
+	err = s.db.Set([]byte(ua.Username), serialized)
 	if err != nil {
 		return err
 	}
+	if err = s.indexAccount(ua.Username, old, ua.Account); err != nil {
+		return err
+	}
 
 	s.checkCacheLimits()
 	s.cache[ua.Username] = ua
@@ -208,11 +237,21 @@ func (s *Store) RemoveUser(username string) (removed bool, err error) {
 		return
 	}
 
+	var stored *UserAccess
+	if stored, err = s.fetchUser(username); err != nil {
+		return
+	}
+
 	delete(s.cache, username)
 	err = s.db.Delete([]byte(username))
 	if err != nil {
 		return
 	}
+	if stored != nil {
+		if err = s.indexAccount(username, stored.Account, ""); err != nil {
+			return
+		}
+	}
 	removed = true
 	return
 }
@@ -264,6 +303,41 @@ func (s *Store) AuthUser(
 	return user, nil
 }
 
+// AuthAccount authenticates a user by the services account they're logged in
+// to. UserAccess will be not nil iff a user has the account linked to it and
+// the host matches their masks.
+func (s *Store) AuthAccount(
+	server, host, account string) (*UserAccess, error) {
+
+	if user, ok := s.authed[server+host]; ok {
+		return user, nil
+	}
+
+	user, err := s.FindUserByAccount(account)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, AuthError{
+			errFmtAccountNotFound,
+			[]interface{}{account},
+			AuthErrAccountNotFound,
+		}
+	}
+
+	if !user.ValidateMask(host) {
+		return nil, AuthError{
+			errFmtBadHost,
+			[]interface{}{host, user.Username},
+			AuthErrHostNotFound,
+		}
+	}
+
+	s.authed[server+host] = user
+	return user, nil
+}
+
 // GetAuthedUser looks up a user that was authenticated previously.
 func (s *Store) GetAuthedUser(server, host string) *UserAccess {
 	return s.authed[server+host]
@@ -312,6 +386,25 @@ func (s *Store) FindUser(username string) (user *UserAccess, err error) {
 	return
 }
 
+// FindUserByAccount looks up the user a services account is linked to, the
+// account is case insensitive. The user is nil if there is none.
+func (s *Store) FindUserByAccount(account string) (*UserAccess, error) {
+	if len(account) == 0 {
+		return nil, nil
+	}
+
+	username, err := s.LoadData(accountNamespace, accountKey(account))
+	if err != nil || username == nil {
+		return nil, err
+	}
+
+	ua, err := s.FindUser(string(username))
+	if err != nil || ua == nil || !strings.EqualFold(ua.Account, account) {
+		return nil, err
+	}
+	return ua, nil
+}
+
 // fetchUser gets a user from the database based on username.
 func (s *Store) fetchUser(username string) (user *UserAccess, err error) {
 	username = strings.ToLower(username)
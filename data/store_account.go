@@ -0,0 +1,79 @@
+package data
+
+import (
+	"io"
+	"strings"
+)
+
+const (
+	// accountNamespace is where the username each services account is linked
+	// to is kept in the store, so users can be found by account without
+	// looking at every user.
+	accountNamespace = "accounts"
+	// storeNamespace is where the store keeps what it knows about itself.
+	storeNamespace = "store"
+	// accountsIndexed is set in the storeNamespace once the accountNamespace
+	// has every linked account in it.
+	accountsIndexed = "accounts.indexed"
+)
+
+// accountKey creates the key an account is indexed under.
+func accountKey(account string) string {
+	return strings.ToLower(account)
+}
+
+// indexAccount moves the index entry of a user from the account it had linked
+// to the one it has now, either can be empty.
+func (s *Store) indexAccount(username, old, account string) error {
+	if len(old) > 0 && !strings.EqualFold(old, account) {
+		linked, err := s.LoadData(accountNamespace, accountKey(old))
+		if err != nil {
+			return err
+		}
+		if string(linked) == username {
+			err = s.DeleteData(accountNamespace, accountKey(old))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(account) == 0 {
+		return nil
+	}
+	return s.SaveData(accountNamespace, accountKey(account), []byte(username))
+}
+
+// indexAccounts adds every linked account to the index, it's only done once
+// for stores that were created before the index existed.
+func (s *Store) indexAccounts() error {
+	done, err := s.LoadData(storeNamespace, accountsIndexed)
+	if err != nil || done != nil {
+		return err
+	}
+
+	e, err := s.db.SeekFirst()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for err == nil {
+		var key, val []byte
+		if key, val, err = e.Next(); err != nil {
+			break
+		}
+		if isDataKey(key) {
+			continue
+		}
+		ua, decodeErr := deserialize(val)
+		if decodeErr != nil || len(ua.Account) == 0 {
+			continue
+		}
+		if err = s.indexAccount(ua.Username, "", ua.Account); err != nil {
+			return err
+		}
+	}
+	if err != io.EOF {
+		return err
+	}
+
+	return s.SaveData(storeNamespace, accountsIndexed, []byte{1})
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"bytes"
+	"io"
+)
+
+// dataKeyMarker begins every key that holds something other than a user. Since
+// usernames can never begin with it, users and other data can share the same
+// database without stepping on each other.
+const dataKeyMarker = 0
+
+// dataKey creates the database key for a key in a namespace.
+func dataKey(namespace, key string) []byte {
+	k := make([]byte, 0, len(namespace)+len(key)+2)
+	k = append(k, dataKeyMarker)
+	k = append(k, namespace...)
+	k = append(k, dataKeyMarker)
+	return append(k, key...)
+}
+
+// isDataKey checks if a database key belongs to something other than a user.
+func isDataKey(key []byte) bool {
+	return len(key) > 0 && key[0] == dataKeyMarker
+}
+
+// SaveData stores an arbitrary value under a key in a namespace. Namespaces
+// allow different parts of the bot to keep their own data in the store
+// without worrying about clashing with each other or with users.
+func (s *Store) SaveData(namespace, key string, value []byte) error {
+	return s.db.Set(dataKey(namespace, key), value)
+}
+
+// LoadData fetches a value stored with SaveData. The value is nil if it
+// does not exist.
+func (s *Store) LoadData(namespace, key string) ([]byte, error) {
+	return s.db.Get(nil, dataKey(namespace, key))
+}
+
+// DeleteData removes a value stored with SaveData.
+func (s *Store) DeleteData(namespace, key string) error {
+	return s.db.Delete(dataKey(namespace, key))
+}
+
+// EachData calls fn with every key and value stored in the namespace, in key
+// order.
+func (s *Store) EachData(namespace string,
+	fn func(key string, value []byte)) error {
+
+	return s.eachData(namespace, "", fn)
+}
+
+// eachData calls fn with every key that begins with prefix and its value in
+// the namespace, in key order. Only the keys with the prefix are visited.
+func (s *Store) eachData(namespace, prefix string,
+	fn func(key string, value []byte)) error {
+
+	nsPrefix := dataKey(namespace, "")
+	start := dataKey(namespace, prefix)
+	e, _, err := s.db.Seek(start)
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+
+	for {
+		key, val, err := e.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(key, start) {
+			return nil
+		}
+		fn(string(key[len(nsPrefix):]), val)
+	}
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	. "testing"
+)
+
+func TestStore_Data(t *T) {
+	t.Parallel()
+	s, err := CreateStore(MemStoreProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	val, err := s.LoadData("ns", "key")
+	if val != nil || err != nil {
+		t.Error("Expected nothing to be found, got:", val, err)
+	}
+
+	if err = s.SaveData("ns", "key", []byte("value")); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if err = s.SaveData("other", "key", []byte("other")); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	val, err = s.LoadData("ns", "key")
+	if string(val) != "value" || err != nil {
+		t.Error("Expected the value to be found, got:", string(val), err)
+	}
+	val, err = s.LoadData("other", "key")
+	if string(val) != "other" || err != nil {
+		t.Error("Expected namespaces to be separate, got:", string(val), err)
+	}
+
+	if err = s.DeleteData("ns", "key"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if val, _ = s.LoadData("ns", "key"); val != nil {
+		t.Error("Expected the value to be deleted, got:", string(val))
+	}
+}
+
+func TestStore_EachData(t *T) {
+	t.Parallel()
+	s, err := CreateStore(MemStoreProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	called := false
+	err = s.EachData("ns", func(string, []byte) { called = true })
+	if err != nil || called {
+		t.Error("Expected nothing to enumerate in an empty store:", err)
+	}
+
+	s.SaveData("ns", "b", []byte("2"))
+	s.SaveData("ns", "a", []byte("1"))
+	s.SaveData("nsx", "c", []byte("3"))
+	s.SaveData("zz", "d", []byte("4"))
+
+	var keys, vals string
+	err = s.EachData("ns", func(key string, val []byte) {
+		keys += key
+		vals += string(val)
+	})
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if keys != "ab" || vals != "12" {
+		t.Error("Expected only the namespace's data in order, got:", keys, vals)
+	}
+}
+
+func TestStore_DataIsNotUsers(t *T) {
+	t.Parallel()
+	s, err := CreateStore(MemStoreProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ua := &UserAccess{Username: uname}
+	ua.GrantGlobalLevel(5)
+	ua.GrantServerLevel(server, 5)
+	ua.GrantChannelLevel(server, channel, 5)
+	if err = s.AddUser(ua); err != nil {
+		t.Fatal("Error adding user:", err)
+	}
+
+	// Data that would deserialize as a user must still not be seen as one.
+	serialized, err := ua.serialize()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	s.SaveData("ns", "user", serialized)
+
+	if list, _ := s.GlobalUsers(); len(list) != 1 {
+		t.Error("Expected exactly one global user, got:", len(list))
+	}
+	if list, _ := s.ServerUsers(server); len(list) != 1 {
+		t.Error("Expected exactly one server user, got:", len(list))
+	}
+	if list, _ := s.ChanUsers(server, channel); len(list) != 1 {
+		t.Error("Expected exactly one channel user, got:", len(list))
+	}
+}
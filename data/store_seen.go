@@ -0,0 +1,112 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"time"
+)
+
+// seenNamespace is where when users were last seen is kept in the store.
+const seenNamespace = "seen"
+
+// seenKey creates the key a nick on a server is seen under.
+func seenKey(server, nick string) string {
+	return server + " " + strings.ToLower(nick)
+}
+
+// SaveSeen stores when users were last seen on a server, replacing what was
+// stored for their nicks.
+func (s *Store) SaveSeen(server string, seen ...Seen) error {
+	for i := range seen {
+		buf := &bytes.Buffer{}
+		if err := gob.NewEncoder(buf).Encode(&seen[i]); err != nil {
+			return err
+		}
+		err := s.SaveData(seenNamespace, seenKey(server, seen[i].Nick),
+			buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindSeen looks up when a nick was last seen on a server. The seen is nil if
+// they have never been seen.
+func (s *Store) FindSeen(server, nick string) (*Seen, error) {
+	val, err := s.LoadData(seenNamespace, seenKey(server, nick))
+	if err != nil || val == nil {
+		return nil, err
+	}
+
+	seen := &Seen{}
+	if err = gob.NewDecoder(bytes.NewReader(val)).Decode(seen); err != nil {
+		return nil, err
+	}
+	return seen, nil
+}
+
+// MatchSeen looks up when the nicks on a server that match a mask were last
+// seen, in nick order. See Seen.Matches. Since the records are kept in nick
+// order only the nicks beginning with what comes before the first wildcard of
+// a nick mask are looked at, fullhost masks have to look at all of them.
+func (s *Store) MatchSeen(server, mask string) (list []Seen, err error) {
+	prefix := seenKey(server, "")
+	if !strings.ContainsAny(mask, "!@") {
+		literal := mask
+		if i := strings.IndexAny(mask, "*?"); i >= 0 {
+			literal = mask[:i]
+		}
+		prefix = seenKey(server, literal)
+	}
+
+	var decodeErr error
+	err = s.eachData(seenNamespace, prefix, func(key string, val []byte) {
+		if decodeErr != nil {
+			return
+		}
+
+		var seen Seen
+		decodeErr = gob.NewDecoder(bytes.NewReader(val)).Decode(&seen)
+		if decodeErr == nil && seen.Matches(mask) {
+			list = append(list, seen)
+		}
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	return list, err
+}
+
+// PruneSeen removes the users on a server that were last seen before a time
+// so the records don't grow forever. The number of users removed is returned.
+func (s *Store) PruneSeen(server string, before time.Time) (int, error) {
+	var expired []string
+	var decodeErr error
+	err := s.eachData(seenNamespace, seenKey(server, ""),
+		func(key string, val []byte) {
+			if decodeErr != nil {
+				return
+			}
+
+			var seen Seen
+			decodeErr = gob.NewDecoder(bytes.NewReader(val)).Decode(&seen)
+			if decodeErr == nil && seen.Time.Before(before) {
+				expired = append(expired, key)
+			}
+		})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for i, key := range expired {
+		if err = s.DeleteData(seenNamespace, key); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
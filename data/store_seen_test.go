@@ -0,0 +1,112 @@
+package data
+
+import (
+	. "testing"
+	"time"
+)
+
+func TestStore_Seen(t *T) {
+	t.Parallel()
+	s, err := CreateStore(MemStoreProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	seen, err := s.FindSeen("irc", "nick1")
+	if seen != nil || err != nil {
+		t.Error("Expected nothing to be found, got:", seen, err)
+	}
+
+	when := time.Unix(1401620000, 0)
+	err = s.SaveSeen("irc",
+		Seen{Nick: "Nick1", Host: "Nick1!user@host1", Action: SeenJoin,
+			Channel: "#chan", Time: when},
+		Seen{Nick: "nick2", Host: "nick2!user@host2", Action: SeenQuit,
+			Message: "bye", Time: when},
+	)
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	err = s.SaveSeen("other", Seen{Nick: "nick1", Host: "nick1!other@host",
+		Action: SeenPart, Time: when})
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	seen, err = s.FindSeen("irc", "NICK1")
+	if err != nil || seen == nil || seen.Host != "Nick1!user@host1" ||
+		seen.Action != SeenJoin || !seen.Time.Equal(when) {
+		t.Error("Expected nick1 to be found, got:", seen, err)
+	}
+
+	list, err := s.MatchSeen("irc", "nick*")
+	if err != nil || len(list) != 2 || list[0].Nick != "Nick1" ||
+		list[1].Nick != "nick2" {
+		t.Error("Expected both nicks on irc to match, got:", list, err)
+	}
+	list, err = s.MatchSeen("irc", "*!*@host2")
+	if err != nil || len(list) != 1 || list[0].Nick != "nick2" {
+		t.Error("Expected nick2 to match it's host, got:", list, err)
+	}
+	list, err = s.MatchSeen("none", "*")
+	if err != nil || len(list) != 0 {
+		t.Error("Expected nothing on an unknown server, got:", list, err)
+	}
+
+	err = s.SaveSeen("irc", Seen{Nick: "nick1", Host: "nick1!user@host1",
+		Action: SeenMessage, Channel: "#chan", Message: "hi", Time: when})
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if seen, _ = s.FindSeen("irc", "nick1"); seen == nil ||
+		seen.Action != SeenMessage {
+		t.Error("Expected the seen to be replaced, got:", seen)
+	}
+
+	list, err = s.MatchSeen("irc", "NICK2")
+	if err != nil || len(list) != 1 || list[0].Nick != "nick2" {
+		t.Error("Expected only nick2 to match, got:", list, err)
+	}
+	list, err = s.MatchSeen("irc", "ni?k1")
+	if err != nil || len(list) != 1 || list[0].Nick != "nick1" {
+		t.Error("Expected only nick1 to match, got:", list, err)
+	}
+}
+
+func TestStore_PruneSeen(t *T) {
+	t.Parallel()
+	s, err := CreateStore(MemStoreProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	when := time.Unix(1401620000, 0)
+	err = s.SaveSeen("irc",
+		Seen{Nick: "old", Host: "old!user@host", Time: when},
+		Seen{Nick: "new", Host: "new!user@host", Time: when.Add(time.Hour)},
+	)
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	err = s.SaveSeen("other", Seen{Nick: "old", Host: "old!user@host",
+		Time: when})
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	pruned, err := s.PruneSeen("irc", when.Add(time.Minute))
+	if err != nil || pruned != 1 {
+		t.Error("Expected one user to be pruned, got:", pruned, err)
+	}
+	if seen, _ := s.FindSeen("irc", "old"); seen != nil {
+		t.Error("Expected old to be pruned, got:", seen)
+	}
+	if seen, _ := s.FindSeen("irc", "new"); seen == nil {
+		t.Error("Expected new to be kept.")
+	}
+	if seen, _ := s.FindSeen("other", "old"); seen == nil {
+		t.Error("Expected other servers to be left alone.")
+	}
+}
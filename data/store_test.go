@@ -1,6 +1,7 @@
 package data
 
 import (
+	"github.com/cznic/kv"
 	. "testing"
 )
 
@@ -193,6 +194,130 @@ func TestStore_AuthUser(t *T) {
 	}
 }
 
+func TestStore_AuthAccount(t *T) {
+	t.Parallel()
+	s, err := CreateStore(MemStoreProvider)
+	defer s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ua1, err := CreateUserAccess(uname, password, `*!*@host`)
+	if err != nil {
+		t.Fatal("Error creating user:", err)
+	}
+	ua1.Account = "Acct"
+	ua2, err := CreateUserAccess(uname+uname, password)
+	if err != nil {
+		t.Fatal("Error creating user:", err)
+	}
+	if err = s.AddUser(ua1); err != nil {
+		t.Fatal("Error adding user:", err)
+	}
+	if err = s.AddUser(ua2); err != nil {
+		t.Fatal("Error adding user:", err)
+	}
+
+	if user, err := s.FindUserByAccount("acct"); err != nil || user == nil ||
+		user.Username != uname {
+		t.Error("Expected to find the user by account, got:", user, err)
+	}
+	if user, err := s.FindUserByAccount(""); err != nil || user != nil {
+		t.Error("Expected no user for an empty account, got:", user, err)
+	}
+
+	user, err := s.AuthAccount(server, host, "other")
+	if user != nil || err == nil {
+		t.Error("Failed to reject an unlinked account.")
+	}
+	if autherr, ok := err.(AuthError); ok {
+		if autherr.FailureType != AuthErrAccountNotFound {
+			t.Error("Wrong failure type:", autherr.FailureType)
+		}
+	} else {
+		t.Error("Error was not an AuthError:", err)
+	}
+
+	user, err = s.AuthAccount(server, `nick!user@host.com`, "acct")
+	if user != nil || err == nil {
+		t.Error("Failed to reject a bad host.")
+	}
+	if autherr, ok := err.(AuthError); ok {
+		if autherr.FailureType != AuthErrHostNotFound {
+			t.Error("Wrong failure type:", autherr.FailureType)
+		}
+	} else {
+		t.Error("Error was not an AuthError:", err)
+	}
+
+	user, err = s.AuthAccount(server, host, "acct")
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if user == nil || user.Username != uname {
+		t.Error("Rejected good authentication.")
+	}
+	if s.GetAuthedUser(server, host) == nil {
+		t.Error("User is not authenticated.")
+	}
+}
+
+func TestStore_AccountIndex(t *T) {
+	t.Parallel()
+	db, err := MemStoreProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prov := func() (*kv.DB, error) { return db, nil }
+	s, err := CreateStore(prov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ua, err := CreateUserAccess(uname, password)
+	if err != nil {
+		t.Fatal("Error creating user:", err)
+	}
+	ua.Account = "One"
+	if err = s.AddUser(ua); err != nil {
+		t.Fatal("Error adding user:", err)
+	}
+	if user, err := s.FindUserByAccount("one"); err != nil || user == nil {
+		t.Error("Expected to find the user by account, got:", user, err)
+	}
+
+	ua.Account = "Two"
+	if err = s.AddUser(ua); err != nil {
+		t.Fatal("Error adding user:", err)
+	}
+	if user, err := s.FindUserByAccount("one"); err != nil || user != nil {
+		t.Error("Expected the old account to be unlinked, got:", user, err)
+	}
+	if user, err := s.FindUserByAccount("two"); err != nil || user == nil {
+		t.Error("Expected to find the user by account, got:", user, err)
+	}
+
+	// Stores from before the index existed are indexed when they're opened.
+	s.DeleteData(storeNamespace, accountsIndexed)
+	s.DeleteData(accountNamespace, accountKey("two"))
+	again, err := CreateStore(prov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := again.FindUserByAccount("two"); err != nil || user == nil {
+		t.Error("Expected the accounts to be indexed, got:", user, err)
+	}
+
+	if _, err = s.RemoveUser(uname); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if user, err := s.FindUserByAccount("two"); err != nil || user != nil {
+		t.Error("Expected a removed user's account to be gone, got:", user,
+			err)
+	}
+}
+
 func TestStore_AuthLogout(t *T) {
 	t.Parallel()
 	s, err := CreateStore(MemStoreProvider)
@@ -2,12 +2,33 @@ package data
 
 import (
 	"github.com/aarondl/ultimateq/irc"
+	"time"
 )
 
+// WhoisInfo is what the server said about a user in reply to a WHOIS. It's
+// replaced rather than changed by newer replies so it must not be modified.
+type WhoisInfo struct {
+	Server     string
+	ServerInfo string
+	Operator   bool
+	Secure     bool
+	Account    string
+	// Channels are as the server gave them, with their mode prefixes.
+	Channels []string
+	Idle     time.Duration
+	SignOn   time.Time
+	// Updated is when the reply ended.
+	Updated time.Time
+}
+
 // User encapsulates all the data associated with a user.
 type User struct {
-	host irc.Host
-	name string
+	host    irc.Host
+	name    string
+	account string
+	away    bool
+	awayMsg string
+	whois   *WhoisInfo
 }
 
 // CreateUser creates a user object from a nickname or fullhost.
@@ -51,6 +72,53 @@ func (u *User) Realname() string {
 	return u.name
 }
 
+// SetAccount sets the services account this user is logged in to, empty
+// string or * if they're not logged in.
+func (u *User) SetAccount(account string) {
+	if account == "*" {
+		account = ""
+	}
+	u.account = account
+}
+
+// Account returns the services account this user is logged in to, or empty
+// string if they're not logged in or it's not known.
+func (u *User) Account() string {
+	return u.account
+}
+
+// SetAway marks this user as away with a message, or back if away is false.
+func (u *User) SetAway(away bool, message string) {
+	u.away = away
+	if away {
+		u.awayMsg = message
+	} else {
+		u.awayMsg = ""
+	}
+}
+
+// IsAway checks if this user is away.
+func (u *User) IsAway() bool {
+	return u.away
+}
+
+// AwayMessage returns the away message of this user, it may be empty even
+// if they're away when it's not known.
+func (u *User) AwayMessage() string {
+	return u.awayMsg
+}
+
+// SetWhois sets the result of the last WHOIS of this user.
+func (u *User) SetWhois(info *WhoisInfo) {
+	u.whois = info
+}
+
+// Whois returns the result of the last WHOIS of this user however old it is,
+// or nil if there has not been one. See State.GetWhois.
+func (u *User) Whois() *WhoisInfo {
+	return u.whois
+}
+
 // String returns a one-line representation of this user.
 func (u *User) String() string {
 	str := u.host.Nick()
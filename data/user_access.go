@@ -43,6 +43,10 @@ type UserAccess struct {
 	Global   *Access
 	Server   map[string]*Access
 	Channel  map[string]map[string]*Access
+
+	// Account is the services account that authenticates the user without
+	// a password when they're logged in to it, see Store.AuthAccount.
+	Account string
 }
 
 // UserAccessPwdCost is the cost factor for bcrypt. It should not be set
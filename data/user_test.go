@@ -28,6 +28,26 @@ func (s *s) TestUser_Realname(c *C) {
 	c.Check(u.Realname(), Equals, "realname realname")
 }
 
+func (s *s) TestUser_Account(c *C) {
+	u := CreateUser("nick!user@host")
+	c.Check(u.Account(), Equals, "")
+	u.SetAccount("acct")
+	c.Check(u.Account(), Equals, "acct")
+	u.SetAccount("*")
+	c.Check(u.Account(), Equals, "")
+}
+
+func (s *s) TestUser_Away(c *C) {
+	u := CreateUser("nick!user@host")
+	c.Check(u.IsAway(), Equals, false)
+	u.SetAway(true, "lunch")
+	c.Check(u.IsAway(), Equals, true)
+	c.Check(u.AwayMessage(), Equals, "lunch")
+	u.SetAway(false, "ignored")
+	c.Check(u.IsAway(), Equals, false)
+	c.Check(u.AwayMessage(), Equals, "")
+}
+
 func (s *s) TestUser_String(c *C) {
 	u := CreateUser("nick")
 	str := fmt.Sprint(u)
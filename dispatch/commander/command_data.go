@@ -12,17 +12,18 @@ import (
 // fills the CommandData structure with information about the user and channel
 // involved. It also embeds the State and Store for easy access.
 //
-// CommandData comes with the implication that the State and Store
-// have been locked for reading, A typical event handler that quickly does some
-// work and returns does not need to worry about calling Close() since it is
-// guaranteed to automatically be closed when the
-// handler returns. But a call to Close() must be given in a
-// command handler that will do some long running processes. Note that all data
-// in the CommandData struct becomes volatile and not thread-safe after a call
-// to Close() has been made, so the values in the CommandData struct are set to
-// nil but extra caution should be made when copying data from this struct and
-// calling Close() afterwards since this data is shared between other parts of
-// the bot.
+// CommandData comes with the implication that the Store has been locked for
+// reading, A typical event handler that quickly does some work and returns does
+// not need to worry about calling Close() since it is guaranteed to
+// automatically be closed when the handler returns. But a call to Close() must
+// be given in a command handler that will do some long running processes. The
+// State is the shared copy from DataEndpoint.CopyState, it doesn't hold a lock
+// but it's shared with the rest of the bot so it must not be modified. Note
+// that all data in the CommandData struct becomes volatile and not thread-safe
+// after a call to Close() has been made, so the values in the CommandData
+// struct are set to nil but extra caution should be made when copying data
+// from this struct and calling Close() afterwards since this data is shared
+// between other parts of the bot.
 //
 // Some parts of CommandData will be nil under certain circumstances so elements
 // within must be checked for nil, see each element's documentation
@@ -152,7 +153,6 @@ func (cd *CommandData) Close() error {
 		cd.Channel = nil
 		cd.State = nil
 		cd.Store = nil
-		cd.ep.CloseState()
 		cd.ep.CloseStore()
 	})
 	return nil
@@ -222,7 +222,10 @@ func (c *Commander) Unregister(server, cmd string) (found bool) {
 	return
 }
 
-// Dispatch dispatches an IrcEvent into the commander's event handlers.
+// Dispatch dispatches an IrcEvent into the commander's event handlers. In a
+// channel a command must begin with the overridePrefix if one is given, or the
+// commander's prefix if not. A channel with its own prefix uses it in place of
+// the commander's prefix, the overridePrefix is still accepted.
 func (c *Commander) Dispatch(server string, overridePrefix rune,
 	msg *irc.Message, ep *data.DataEndpoint) (err error) {
 
@@ -257,6 +260,9 @@ func (c *Commander) Dispatch(server string, overridePrefix rune,
 		firstChar := rune(cmd[0])
 		missingOverride := overridePrefix == 0 || firstChar != overridePrefix
 		missingPrefix := overridePrefix != 0 || firstChar != c.prefix
+		if prefix := c.GetChannelPrefix(server, msg.Args[0]); prefix != 0 {
+			missingPrefix = firstChar != prefix
+		}
 		if !hasChan || (missingOverride && missingPrefix) {
 			return nil
 		}
@@ -277,6 +283,9 @@ func (c *Commander) Dispatch(server string, overridePrefix rune,
 	if 0 == (msgtype&command.Msgtype) || 0 == (msgscope&command.Msgscope) {
 		return nil
 	}
+	if !c.IsEnabled(command.Extension, server, ch) {
+		return nil
+	}
 
 	var cmdata = &CommandData{
 		ep: ep,
@@ -287,13 +296,13 @@ func (c *Commander) Dispatch(server string, overridePrefix rune,
 		args = fields[1:]
 	}
 
-	state := ep.OpenState()
+	state := ep.CopyState()
 	store := ep.OpenStore()
 	cmdata.State = state
 	cmdata.Store = store
 
 	if command.RequireAuth {
-		if cmdata.UserAccess, err = filterAccess(store, command,
+		if cmdata.UserAccess, err = filterAccess(store, state, command,
 			server, ch, ep, msg); err != nil {
 
 			cmdata.Close()
@@ -378,9 +387,11 @@ func cmdNameDispatch(handler CommandHandler, cmd string, msg *irc.Message,
 }
 
 // filterAccess ensures that a user has the correct access to perform the given
-// command.
-func filterAccess(store *data.Store, command *Command, server, channel string,
-	ep *data.DataEndpoint, msg *irc.Message) (*data.UserAccess, error) {
+// command. Users that are not authenticated are authenticated by the services
+// account the state has for them if a user has it linked.
+func filterAccess(store *data.Store, state *data.State, command *Command,
+	server, channel string, ep *data.DataEndpoint,
+	msg *irc.Message) (*data.UserAccess, error) {
 
 	hasLevel := command.ReqLevel != 0
 	hasFlags := len(command.ReqFlags) != 0
@@ -390,6 +401,14 @@ func filterAccess(store *data.Store, command *Command, server, channel string,
 	}
 
 	var access = store.GetAuthedUser(ep.GetKey(), msg.Sender)
+	if access == nil && state != nil {
+		if user := state.GetUser(msg.Sender); user != nil &&
+			len(user.Account()) > 0 {
+
+			access, _ = store.AuthAccount(ep.GetKey(), msg.Sender,
+				user.Account())
+		}
+	}
 	if access == nil {
 		return nil, errors.New(errMsgNotAuthed)
 	}
@@ -701,6 +701,61 @@ func TestCommander_DispatchAuthed(t *T) {
 	}
 }
 
+func TestCommander_DispatchAuthedAccount(t *T) {
+	c := CreateCommander(prefix, core)
+	var buffer = &bytes.Buffer{}
+	var stateMutex, storeMutex sync.RWMutex
+	state, store, user := setupForAuth()
+	user.Account = "acct"
+	user.GrantGlobal(100)
+	if err := store.AddUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	other := "other!user@host"
+	state.Update(&irc.Message{
+		Sender: other, Name: irc.JOIN,
+		Args: []string{channel, "*", "real name"},
+	})
+
+	var dataEndpoint = data.CreateDataEndpoint(server, buffer, state, store,
+		&stateMutex, &storeMutex)
+
+	handler := &commandHandler{}
+	err := c.Register(GLOBAL, MkAuthCmd(ext, dsc, cmd, handler, ALL, ALL,
+		100, ""))
+	if err != nil {
+		t.Fatal("Failed to register:", err)
+	}
+	defer c.Unregister(GLOBAL, cmd)
+
+	msg := &irc.Message{
+		Sender: other,
+		Name:   irc.PRIVMSG,
+		Args:   []string{channel, string(prefix) + cmd},
+	}
+	c.Dispatch(server, 0, msg, dataEndpoint)
+	c.WaitForHandlers()
+	if handler.called {
+		t.Error("Expected a user without an account to be rejected.")
+	}
+
+	state.Update(&irc.Message{
+		Sender: other, Name: irc.ACCOUNT, Args: []string{"ACCT"},
+	})
+	c.Dispatch(server, 0, msg, dataEndpoint)
+	c.WaitForHandlers()
+	if !handler.called {
+		t.Error("Expected the user to be authenticated by account.")
+	}
+	if handler.access == nil || handler.access.Username != "user" {
+		t.Error("Expected the account's user, got:", handler.access)
+	}
+	if store.GetAuthedUser(server, other) == nil {
+		t.Error("Expected the authentication to be remembered.")
+	}
+}
+
 func TestCommander_DispatchNils(t *T) {
 	c := CreateCommander(prefix, core)
 	var buffer = &bytes.Buffer{}
@@ -1462,3 +1517,84 @@ func TestCommander_Panic(t *T) {
 		t.Error("Does not contain a reference to file that panic'd")
 	}
 }
+
+func TestCommander_DispatchFilter(t *T) {
+	filterCore := dispatch.CreateDispatchCore(irc.CreateProtoCaps())
+	c := CreateCommander(prefix, filterCore)
+	var buffer = &bytes.Buffer{}
+	var stateMutex, storeMutex sync.RWMutex
+
+	state, _ := setup()
+	var dataEndpoint = data.CreateDataEndpoint(server, buffer, state, nil,
+		&stateMutex, &storeMutex)
+
+	var filtered []string
+	filterCore.Filter(func(extension, server, channel string) bool {
+		filtered = []string{extension, server, channel}
+		return channel != "#off"
+	})
+
+	handler := &commandHandler{}
+	err := c.Register(GLOBAL, MkCmd(ext, dsc, "filtered", handler, ALL, ALL))
+	if err != nil {
+		t.Fatal("Unexpected:", err)
+	}
+	defer c.Unregister(GLOBAL, "filtered")
+
+	msg := &irc.Message{Name: irc.PRIVMSG, Sender: host,
+		Args: []string{channel, string(prefix) + "filtered"}}
+	c.Dispatch(server, 0, msg, dataEndpoint)
+	c.WaitForHandlers()
+	if !handler.called {
+		t.Error("Expected a call to the command.")
+	}
+	if filtered[0] != ext || filtered[1] != server || filtered[2] != channel {
+		t.Error("Expected the filter to be given the extension, server and "+
+			"channel, got:", filtered)
+	}
+
+	handler.called = false
+	msg.Args[0] = "#off"
+	c.Dispatch(server, 0, msg, dataEndpoint)
+	c.WaitForHandlers()
+	if handler.called {
+		t.Error("Expected the disabled command not to be called.")
+	}
+
+	msg.Args = []string{self, "filtered"}
+	c.Dispatch(server, 0, msg, dataEndpoint)
+	c.WaitForHandlers()
+	if !handler.called {
+		t.Error("Expected a call to the command.")
+	}
+	if len(filtered[2]) != 0 {
+		t.Error("Expected no channel for a private message, got:", filtered)
+	}
+
+	filterCore.Prefixes(func(srv, ch string) rune {
+		if srv == server && ch == channel {
+			return '!'
+		}
+		return 0
+	})
+	for _, test := range []struct {
+		prefix   string
+		override rune
+		called   bool
+	}{
+		{"!", 0, true},
+		{"!", '@', true},
+		{string(prefix), 0, false},
+		{"@", '@', true},
+		{string(prefix), '@', false},
+	} {
+		handler.called = false
+		msg.Args = []string{channel, test.prefix + "filtered"}
+		c.Dispatch(server, test.override, msg, dataEndpoint)
+		c.WaitForHandlers()
+		if handler.called != test.called {
+			t.Errorf("Expected called to be %v for %q with override %q",
+				test.called, test.prefix, test.override)
+		}
+	}
+}
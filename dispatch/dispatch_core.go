@@ -22,6 +22,15 @@ var (
 		"dispatching: Cannot create a dispatcher without ProtoCaps.")
 )
 
+// ExtensionFilter decides if the handlers and commands of an extension may see
+// an event from a server. channel is the channel the event happened in, or
+// empty if it was not in a channel.
+type ExtensionFilter func(extension, server, channel string) bool
+
+// PrefixFilter gives the command prefix used in a channel on a server, or 0 if
+// the channel uses the usual prefix.
+type PrefixFilter func(server, channel string) rune
+
 // DispatchCore is a core for any dispatching mechanisms that includes a sync'd
 // list of channels, channel identification services, and a waiter to
 // synchronize the exit of all the event handlers sharing this core.
@@ -29,6 +38,8 @@ type DispatchCore struct {
 	waiter  sync.WaitGroup
 	caps    *irc.ProtoCaps
 	chans   []string
+	filter  ExtensionFilter
+	prefix  PrefixFilter
 	protect sync.RWMutex
 }
 
@@ -50,6 +61,43 @@ func (d *DispatchCore) Protocaps(caps *irc.ProtoCaps) {
 	d.caps = caps
 }
 
+// Filter sets the filter used to check if an extension is enabled. With no
+// filter every extension is enabled.
+func (d *DispatchCore) Filter(filter ExtensionFilter) {
+	d.protect.Lock()
+	defer d.protect.Unlock()
+	d.filter = filter
+}
+
+// IsEnabled checks if an extension is enabled for a server and channel. Events
+// without an extension are always enabled.
+func (d *DispatchCore) IsEnabled(extension, server, channel string) bool {
+	d.protect.RLock()
+	filter := d.filter
+	d.protect.RUnlock()
+	return len(extension) == 0 || filter == nil ||
+		filter(extension, server, channel)
+}
+
+// Prefixes sets the filter used to look up the command prefix of a channel.
+func (d *DispatchCore) Prefixes(prefix PrefixFilter) {
+	d.protect.Lock()
+	defer d.protect.Unlock()
+	d.prefix = prefix
+}
+
+// GetChannelPrefix gets the command prefix of a channel on a server, or 0 if
+// the channel uses the usual prefix.
+func (d *DispatchCore) GetChannelPrefix(server, channel string) rune {
+	d.protect.RLock()
+	prefix := d.prefix
+	d.protect.RUnlock()
+	if prefix == nil {
+		return 0
+	}
+	return prefix(server, channel)
+}
+
 // Channels sets the active channels for this dispatcher.
 func (d *DispatchCore) Channels(chans []string) {
 	d.protect.Lock()
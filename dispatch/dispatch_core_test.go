@@ -189,3 +189,45 @@ func TestDispatchCore_filterChannelDispatch(t *T) {
 		t.Error("It should not have this channel.")
 	}
 }
+
+func TestDispatchCore_Filter(t *T) {
+	t.Parallel()
+	d := CreateDispatchCore(caps)
+	if !d.IsEnabled("ext", "srv", "#chan") {
+		t.Error("Expected extensions to be enabled without a filter.")
+	}
+
+	d.Filter(func(extension, server, channel string) bool {
+		return extension != "ext" || channel != "#chan"
+	})
+	if d.IsEnabled("ext", "srv", "#chan") {
+		t.Error("Expected the filter to disable the extension.")
+	}
+	if !d.IsEnabled("ext", "srv", "") || !d.IsEnabled("other", "srv", "#chan") {
+		t.Error("Expected the filter to enable the extension.")
+	}
+	if !d.IsEnabled("", "srv", "#chan") {
+		t.Error("Expected events without an extension to be enabled.")
+	}
+}
+
+func TestDispatchCore_Prefixes(t *T) {
+	t.Parallel()
+	d := CreateDispatchCore(caps)
+	if d.GetChannelPrefix("srv", "#chan") != 0 {
+		t.Error("Expected no channel prefix without a filter.")
+	}
+
+	d.Prefixes(func(server, channel string) rune {
+		if server == "srv" && channel == "#chan" {
+			return '!'
+		}
+		return 0
+	})
+	if p := d.GetChannelPrefix("srv", "#chan"); p != '!' {
+		t.Errorf("Expected the channel prefix to be !, got: %c", p)
+	}
+	if d.GetChannelPrefix("srv", "#other") != 0 {
+		t.Error("Expected no channel prefix.")
+	}
+}
@@ -1,10 +1,12 @@
 package dispatch
 
 import (
+	"log"
 	"math/rand"
 	"strings"
 	"sync"
 
+	"github.com/aarondl/ultimateq/data"
 	"github.com/aarondl/ultimateq/irc"
 )
 
@@ -15,10 +17,34 @@ type EventHandler interface {
 	HandleRaw(event *irc.Message, endpoint irc.Endpoint)
 }
 
+// OrderedHandler is implemented by handlers that must be given messages one at
+// a time in the order they were dispatched, rather than each in it's own
+// goroutine. Handlers of irc.STATE are always ordered.
+type OrderedHandler interface {
+	Ordered() bool
+}
+
+const (
+	// handlerQueueSize is how many dispatches can wait for an ordered
+	// handler, more are dropped until it catches up.
+	handlerQueueSize = 1024
+	// errFmtQueueFull is logged when a dispatch to an ordered handler is
+	// dropped because too many are waiting for it.
+	errFmtQueueFull = "dispatch: Dropped %v for %T, it's too far behind.\n"
+)
+
 type (
-	// eventTable is the storage used to keep id -> interface{} mappings in the
+	// eventHandler is a registered handler and the extension that registered
+	// it, if any. Ordered handlers have a queue of the dispatches waiting for
+	// them.
+	eventHandler struct {
+		extension string
+		handler   interface{}
+		queue     *handlerQueue
+	}
+	// eventTable is the storage used to keep id -> handler mappings in the
 	// eventTableState map.
-	eventTable map[int]interface{}
+	eventTable map[int]eventHandler
 	// eventTableState is the map used to hold the event handlers for an event
 	eventTableState map[string]eventTable
 )
@@ -42,6 +68,15 @@ func CreateDispatcher(core *DispatchCore) *Dispatcher {
 // unique identifer is given to later pass into Unregister in case of a need
 // to unregister the event handler.
 func (d *Dispatcher) Register(event string, handler interface{}) int {
+	return d.RegisterExtension("", event, handler)
+}
+
+// RegisterExtension registers an event handler on behalf of an extension. The
+// handler is only dispatched to when the core's filter says the extension is
+// enabled for the server and channel of the event.
+func (d *Dispatcher) RegisterExtension(extension, event string,
+	handler interface{}) int {
+
 	event = strings.ToUpper(event)
 	id := rand.Int()
 
@@ -55,7 +90,12 @@ func (d *Dispatcher) Register(event string, handler interface{}) int {
 		}
 	}
 
-	d.events[event][id] = handler
+	ev := eventHandler{extension: extension, handler: handler}
+	if ordered, ok := handler.(OrderedHandler); event == irc.STATE ||
+		ok && ordered.Ordered() {
+		ev.queue = &handlerQueue{}
+	}
+	d.events[event][id] = ev
 	return id
 }
 
@@ -98,15 +138,145 @@ func (d *Dispatcher) dispatchHelper(event string,
 	msg *irc.Message, ep irc.Endpoint) bool {
 
 	if evtable, ok := d.events[event]; ok {
-		for _, handler := range evtable {
-			d.HandlerStarted()
-			go d.resolveHandler(handler, event, msg, ep)
+		for _, ev := range evtable {
+			if len(ev.extension) != 0 && !d.extensionEnabled(ev.extension,
+				d.messageChannel(msg), ep) {
+				continue
+			}
+			if ev.queue == nil {
+				d.HandlerStarted()
+				go d.resolveHandler(ev.handler, event, msg, ep)
+				continue
+			}
+			handler := ev.handler
+			ev.queue.push(d, msg.Name, handler, func() {
+				d.resolveHandler(handler, event, msg, ep)
+			})
 		}
 		return true
 	}
 	return false
 }
 
+// DispatchState sends the changes made to the state to the StateHandlers
+// registered for the irc.STATE event. Each handler is given the events one at
+// a time in the order they were dispatched, across calls as well. See
+// OrderedHandler.
+func (d *Dispatcher) DispatchState(events []data.StateEvent,
+	ep irc.Endpoint) {
+
+	if len(events) == 0 {
+		return
+	}
+
+	d.protectEvents.RLock()
+	defer d.protectEvents.RUnlock()
+
+	for _, ev := range d.events[irc.STATE] {
+		handler, ok := ev.handler.(StateHandler)
+		if !ok {
+			continue
+		}
+
+		var enabled []data.StateEvent
+		for _, event := range events {
+			if len(ev.extension) == 0 || d.extensionEnabled(ev.extension,
+				event.GetChannel(), ep) {
+				enabled = append(enabled, event)
+			}
+		}
+		if len(enabled) == 0 {
+			continue
+		}
+
+		ev.queue.push(d, irc.STATE, handler, func() {
+			d.resolveState(handler, enabled, ep)
+		})
+	}
+}
+
+// handlerQueue gives an ordered handler the dispatches to it one after the
+// other. It runs a goroutine only while dispatches are waiting, and holds at
+// most handlerQueueSize of them.
+type handlerQueue struct {
+	pending []func()
+	running bool
+	protect sync.Mutex
+}
+
+// push adds a dispatch to the queue, starting the goroutine that runs them if
+// it's not running. The dispatch is dropped if the queue is full.
+func (q *handlerQueue) push(d *Dispatcher, event string, handler interface{},
+	fn func()) {
+
+	q.protect.Lock()
+	defer q.protect.Unlock()
+
+	if len(q.pending) >= handlerQueueSize {
+		log.Printf(errFmtQueueFull, event, handler)
+		return
+	}
+
+	d.HandlerStarted()
+	q.pending = append(q.pending, fn)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+// run runs the dispatches waiting until there are none left.
+func (q *handlerQueue) run() {
+	for {
+		q.protect.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.protect.Unlock()
+			return
+		}
+		fn := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.protect.Unlock()
+
+		fn()
+	}
+}
+
+// resolveState calls the handler with each of the events.
+func (d *Dispatcher) resolveState(handler StateHandler,
+	events []data.StateEvent, ep irc.Endpoint) {
+
+	defer PanicHandler()
+	defer d.HandlerFinished()
+
+	for _, event := range events {
+		handler.HandleState(event, ep)
+	}
+}
+
+// messageChannel returns the channel a message was sent to, or empty string.
+func (d *Dispatcher) messageChannel(msg *irc.Message) string {
+	if len(msg.Args) > 0 {
+		if isChan, _ := d.CheckTarget(msg.Args[0]); isChan {
+			return msg.Args[0]
+		}
+	}
+	return ""
+}
+
+// extensionEnabled checks if an extension is enabled for the server and
+// channel an event came from.
+func (d *Dispatcher) extensionEnabled(extension, channel string,
+	ep irc.Endpoint) bool {
+
+	var server string
+	if ep != nil {
+		server = ep.GetKey()
+	}
+	return d.IsEnabled(extension, server, channel)
+}
+
 // resolveHandler checks the type of the handler passed in, resolves it to a
 // real type, coerces the IrcMessage in whatever way necessary and then
 // calls that handlers primary dispatch method with the coerced message.
@@ -2,9 +2,13 @@ package dispatch
 
 import (
 	"bytes"
+	"fmt"
 	"log"
+	"reflect"
+	"sync"
 	. "testing"
 
+	"github.com/aarondl/ultimateq/data"
 	"github.com/aarondl/ultimateq/irc"
 )
 
@@ -724,3 +728,186 @@ func TestDispatch_Panic(t *T) {
 		t.Error("Does not contain a reference to file that panic'd")
 	}
 }
+
+func TestDispatcher_RegisterExtension(t *T) {
+	t.Parallel()
+	var msg1, msg2 *irc.Message
+	h1 := testHandler{func(m *irc.Message, _ irc.Endpoint) {
+		msg1 = m
+	}}
+	h2 := testHandler{func(m *irc.Message, _ irc.Endpoint) {
+		msg2 = m
+	}}
+
+	d := CreateDispatcher(CreateDispatchCore(irc.CreateProtoCaps()))
+	d.Filter(func(extension, server, channel string) bool {
+		return channel != "#off"
+	})
+	send := testPoint{&irc.Helper{}}
+
+	id := d.RegisterExtension("ext", irc.PRIVMSG, h1)
+	d.Register(irc.PRIVMSG, h2)
+
+	d.Dispatch(&irc.Message{Name: irc.PRIVMSG, Args: []string{"#on", "hi"}},
+		send)
+	d.WaitForHandlers()
+	if msg1 == nil || msg2 == nil {
+		t.Error("Expected both handlers to be dispatched to.")
+	}
+
+	msg1, msg2 = nil, nil
+	d.Dispatch(&irc.Message{Name: irc.PRIVMSG, Args: []string{"#off", "hi"}},
+		send)
+	d.WaitForHandlers()
+	if msg1 != nil {
+		t.Error("Expected the disabled extension not to be dispatched to.")
+	}
+	if msg2 == nil {
+		t.Error("Expected handlers without an extension to be dispatched to.")
+	}
+
+	if !d.Unregister(irc.PRIVMSG, id) {
+		t.Error("Expected the extension's handler to unregister.")
+	}
+}
+
+type testStateHandler struct {
+	protect *sync.Mutex
+	events  *[]data.StateEvent
+}
+
+func (t testStateHandler) HandleState(ev data.StateEvent, _ irc.Endpoint) {
+	t.protect.Lock()
+	defer t.protect.Unlock()
+	*t.events = append(*t.events, ev)
+}
+
+func TestDispatcher_DispatchState(t *T) {
+	t.Parallel()
+	var protect sync.Mutex
+	var all, filtered []data.StateEvent
+	var raw *irc.Message
+
+	d := CreateDispatcher(CreateDispatchCore(irc.CreateProtoCaps()))
+	d.Filter(func(extension, server, channel string) bool {
+		return channel != "#off"
+	})
+	send := testPoint{&irc.Helper{}}
+
+	d.Register(irc.STATE, testStateHandler{&protect, &all})
+	d.RegisterExtension("ext", irc.STATE,
+		testStateHandler{&protect, &filtered})
+	d.Register(irc.STATE, testHandler{func(m *irc.Message, _ irc.Endpoint) {
+		raw = m
+	}})
+
+	events := []data.StateEvent{
+		data.UserJoined{Channel: "#on", User: "nick!user@host"},
+		data.UserJoined{Channel: "#off", User: "nick!user@host"},
+		data.UserQuit{User: "nick!user@host"},
+	}
+	d.DispatchState(events, send)
+	d.WaitForHandlers()
+
+	if !reflect.DeepEqual(all, events) {
+		t.Error("Expected all the events in order, got:", all)
+	}
+	if !reflect.DeepEqual(filtered, []data.StateEvent{events[0], events[2]}) {
+		t.Error("Expected the disabled channel to be filtered, got:",
+			filtered)
+	}
+	if raw != nil {
+		t.Error("Expected handlers that aren't StateHandlers to be skipped.")
+	}
+
+	all = nil
+	d.DispatchState(nil, send)
+	d.WaitForHandlers()
+	if all != nil {
+		t.Error("Expected no events to be dispatched.")
+	}
+}
+
+// testOrderedHandler is a raw handler that's given messages in order.
+type testOrderedHandler struct {
+	testHandler
+}
+
+func (t testOrderedHandler) Ordered() bool {
+	return true
+}
+
+func TestDispatcher_Ordered(t *T) {
+	t.Parallel()
+	var protect sync.Mutex
+	var got []string
+
+	d := CreateDispatcher(CreateDispatchCore(irc.CreateProtoCaps()))
+	d.Register(irc.RAW, testOrderedHandler{testHandler{
+		func(m *irc.Message, _ irc.Endpoint) {
+			protect.Lock()
+			defer protect.Unlock()
+			got = append(got, m.Args[0])
+		},
+	}})
+
+	var sent []string
+	for i := 0; i < 200; i++ {
+		sent = append(sent, fmt.Sprint(i))
+		d.Dispatch(&irc.Message{Name: irc.PRIVMSG, Args: sent[i:]}, nil)
+	}
+	d.WaitForHandlers()
+
+	if !reflect.DeepEqual(got, sent) {
+		t.Error("Expected the messages in order, got:", got)
+	}
+}
+
+func TestDispatcher_OrderedQueueFull(t *T) {
+	t.Parallel()
+	var protect sync.Mutex
+	handled := 0
+	block := make(chan int)
+
+	d := CreateDispatcher(CreateDispatchCore(irc.CreateProtoCaps()))
+	d.Register(irc.RAW, testOrderedHandler{testHandler{
+		func(m *irc.Message, _ irc.Endpoint) {
+			<-block
+			protect.Lock()
+			defer protect.Unlock()
+			handled++
+		},
+	}})
+
+	for i := 0; i < handlerQueueSize+10; i++ {
+		d.Dispatch(&irc.Message{Name: irc.PRIVMSG, Args: []string{"x"}}, nil)
+	}
+	close(block)
+	d.WaitForHandlers()
+
+	if handled < handlerQueueSize || handled > handlerQueueSize+1 {
+		t.Error("Expected dispatches past the queue size to be dropped, "+
+			"handled:", handled)
+	}
+}
+
+func TestDispatcher_DispatchStateOrder(t *T) {
+	t.Parallel()
+	var protect sync.Mutex
+	var got []data.StateEvent
+
+	d := CreateDispatcher(CreateDispatchCore(irc.CreateProtoCaps()))
+	d.Register(irc.STATE, testStateHandler{&protect, &got})
+
+	var sent []data.StateEvent
+	for i := 0; i < 200; i++ {
+		ev := data.UserJoined{Channel: "#chan", User: fmt.Sprint(i)}
+		sent = append(sent, ev)
+		d.DispatchState([]data.StateEvent{ev}, nil)
+	}
+	d.WaitForHandlers()
+
+	if !reflect.DeepEqual(got, sent) {
+		t.Error("Expected the events of every message in order, got:", got)
+	}
+}
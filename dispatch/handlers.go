@@ -1,6 +1,9 @@
 package dispatch
 
-import "github.com/aarondl/ultimateq/irc"
+import (
+	"github.com/aarondl/ultimateq/data"
+	"github.com/aarondl/ultimateq/irc"
+)
 
 // PrivmsgHandler is for handling privmsgs going to channel or user targets.
 type PrivmsgHandler interface {
@@ -49,3 +52,9 @@ type CTCPChannelHandler interface {
 type CTCPReplyHandler interface {
 	CTCPReply(*irc.Message, string, string, irc.Endpoint)
 }
+
+// StateHandler is for handling the changes made to the state by irc messages.
+// It must be registered for the irc.STATE event.
+type StateHandler interface {
+	HandleState(data.StateEvent, irc.Endpoint)
+}
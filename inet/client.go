@@ -110,6 +110,9 @@ type IrcClient struct {
 
 	keepalive time.Duration
 
+	// Session recording
+	recorder *Recorder
+
 	// buffering for io.Reader interface
 	readbuf []byte
 	pos     int
@@ -147,6 +150,12 @@ func CreateIrcClient(conn net.Conn, name string, lenPenaltyFactor int,
 	return c
 }
 
+// SetRecorder makes the client record every line it reads and writes to
+// the recorder. It should be called before SpawnWorkers.
+func (c *IrcClient) SetRecorder(recorder *Recorder) {
+	c.recorder = recorder
+}
+
 // SpawnWorkers creates two goroutines, one that is constantly reading using
 // Siphon, and one that is constantly working on eliminating the write queue by
 // writing. Also sets up the instances kill channels.
@@ -269,6 +278,9 @@ func (c *IrcClient) writeMessage(msg []byte) error {
 		log.Printf(fmtWrite, c.name, wrote)
 		c.lastwrite = time.Now()
 	}
+	if c.recorder != nil {
+		c.recorder.Record(true, msg)
+	}
 	return nil
 }
 
@@ -311,6 +323,10 @@ func (c *IrcClient) extractMessages(buf []byte) (int, bool) {
 	send := func(chunk []byte) bool {
 		cpy := make([]byte, len(chunk)-2)
 		copy(cpy, chunk[:len(chunk)-2])
+		if c.recorder != nil {
+			// Record before handing it off so replies can't be recorded first.
+			c.recorder.Record(false, cpy)
+		}
 		select {
 		case c.siphonchan <- cpy:
 			log.Printf(fmtRead, c.name, cpy)
@@ -0,0 +1,207 @@
+package inet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// recordRead marks a line that was read from the server.
+	recordRead = "->"
+	// recordWrite marks a line that was written to the server.
+	recordWrite = "<-"
+	// fmtRecord is the format of a single line in a recording.
+	fmtRecord = "%s %s %s\n"
+	// errFmtBadRecording occurs when a line in a recording can't be parsed.
+	errFmtBadRecording = "inet: Bad recording on line %d (%s)"
+	// recordRedacted replaces credentials in a recording.
+	recordRedacted = "*"
+)
+
+var (
+	// saslMechanisms are the AUTHENTICATE arguments that are not credentials.
+	saslMechanisms = map[string]bool{
+		"+": true, "*": true, "PLAIN": true, "EXTERNAL": true,
+		"SCRAM-SHA-1": true, "SCRAM-SHA-256": true, "SCRAM-SHA-512": true,
+	}
+	// nickservCommands are the NickServ commands that carry passwords.
+	nickservCommands = map[string]bool{
+		"IDENTIFY": true, "REGISTER": true, "GHOST": true, "RECOVER": true,
+		"RELEASE": true, "REGAIN": true, "SET": true,
+	}
+)
+
+var (
+	// errRecorderClosed is returned when recording to a closed recorder.
+	errRecorderClosed = errors.New("inet: Recorder closed")
+)
+
+// RecordedLine is a single line in a recording.
+type RecordedLine struct {
+	// Time is when the line was read or written.
+	Time time.Time
+	// Sent is true if the line was written to the server, and false if it was
+	// read from it.
+	Sent bool
+	// Line is the raw irc line with no \r\n.
+	Line string
+}
+
+// String turns the recorded line back into the form it's stored in a
+// recording.
+func (r RecordedLine) String() string {
+	dir := recordRead
+	if r.Sent {
+		dir = recordWrite
+	}
+	return fmt.Sprintf(fmtRecord, r.Time.Format(time.RFC3339Nano), dir, r.Line)
+}
+
+// Recorder writes a timestamped copy of every line read and written by an
+// IrcClient to a writer. A recording is plain text with one line per irc line:
+// the time in RFC3339 format, an arrow describing the direction (-> for read,
+// <- for written) and the raw line itself. Recordings can be read back with
+// ReadRecording. Credentials sent with PASS, OPER, AUTHENTICATE and to NickServ
+// are replaced with a * so recordings can be shared.
+type Recorder struct {
+	writer io.Writer
+	now    func() time.Time
+	closed bool
+
+	protect sync.Mutex
+}
+
+// CreateRecorder creates a recorder that writes to writer.
+func CreateRecorder(writer io.Writer) *Recorder {
+	return &Recorder{writer: writer, now: time.Now}
+}
+
+// Record writes a single line to the recording. Sent is true if the line was
+// written to the server. Trailing \r\n are removed.
+func (r *Recorder) Record(sent bool, line []byte) error {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+
+	if r.closed {
+		return errRecorderClosed
+	}
+
+	rec := RecordedLine{
+		Time: r.now(),
+		Sent: sent,
+		Line: redact(strings.TrimRight(string(line), "\r\n")),
+	}
+	_, err := io.WriteString(r.writer, rec.String())
+	return err
+}
+
+// redact replaces the credentials in an irc line with recordRedacted.
+func redact(line string) string {
+	var head string
+	rest := line
+	for strings.HasPrefix(rest, "@") || strings.HasPrefix(rest, ":") {
+		i := strings.IndexByte(rest, ' ')
+		if i < 0 {
+			return line
+		}
+		head, rest = head+rest[:i+1], rest[i+1:]
+	}
+
+	parts := strings.SplitN(rest, " ", 2)
+	if len(parts) < 2 {
+		return line
+	}
+	cmd, args := parts[0], parts[1]
+	switch strings.ToUpper(cmd) {
+	case "PASS":
+		return head + cmd + " " + recordRedacted
+	case "OPER":
+		return head + cmd + " " + strings.SplitN(args, " ", 2)[0] + " " +
+			recordRedacted
+	case "AUTHENTICATE":
+		if saslMechanisms[strings.ToUpper(strings.TrimPrefix(args, ":"))] {
+			return line
+		}
+		return head + cmd + " " + recordRedacted
+	case "NS", "NICKSERV":
+		if text, ok := redactNickserv(args); ok {
+			return head + cmd + " " + text
+		}
+	case "PRIVMSG", "NOTICE":
+		parts = strings.SplitN(args, " ", 2)
+		target := strings.ToLower(strings.SplitN(parts[0], "@", 2)[0])
+		if len(parts) < 2 || target != "nickserv" {
+			break
+		}
+		if text, ok := redactNickserv(parts[1]); ok {
+			return head + cmd + " " + parts[0] + " :" + text
+		}
+	}
+	return line
+}
+
+// redactNickserv redacts the arguments of a NickServ command if it carries a
+// password, ok is false if it doesn't.
+func redactNickserv(text string) (redacted string, ok bool) {
+	words := strings.Fields(strings.TrimPrefix(text, ":"))
+	if len(words) < 2 || !nickservCommands[strings.ToUpper(words[0])] {
+		return "", false
+	}
+	return words[0] + " " + recordRedacted, true
+}
+
+// Close stops the recorder, closing the underlying writer if it's an
+// io.Closer.
+func (r *Recorder) Close() error {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if closer, ok := r.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ReadRecording reads all the lines in a recording created by a Recorder.
+// Blank lines are ignored.
+func ReadRecording(reader io.Reader) ([]RecordedLine, error) {
+	var lines []RecordedLine
+	scanner := bufio.NewScanner(reader)
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		if len(strings.TrimSpace(text)) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(text, " ", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf(errFmtBadRecording, n, text)
+		}
+
+		var rec RecordedLine
+		var err error
+		if rec.Time, err = time.Parse(time.RFC3339Nano, parts[0]); err != nil {
+			return nil, fmt.Errorf(errFmtBadRecording, n, err)
+		}
+		switch parts[1] {
+		case recordRead:
+		case recordWrite:
+			rec.Sent = true
+		default:
+			return nil, fmt.Errorf(errFmtBadRecording, n, text)
+		}
+		rec.Line = parts[2]
+		lines = append(lines, rec)
+	}
+
+	return lines, scanner.Err()
+}
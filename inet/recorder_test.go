@@ -0,0 +1,129 @@
+package inet
+
+import (
+	"bytes"
+	"github.com/aarondl/ultimateq/mocks"
+	. "gopkg.in/check.v1"
+	"io"
+	"strings"
+	"time"
+)
+
+// closeBuffer is a bytes.Buffer that remembers being closed.
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeBuffer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (s *s) TestRecorder_Record(c *C) {
+	start := time.Date(2014, 1, 2, 3, 4, 5, 6, time.UTC)
+	buf := &closeBuffer{}
+	r := CreateRecorder(buf)
+	r.now = func() time.Time {
+		start = start.Add(time.Second)
+		return start
+	}
+
+	c.Check(r.Record(false, []byte("PING :12345\r\n")), IsNil)
+	c.Check(r.Record(true, []byte("PONG :12345")), IsNil)
+	c.Check(buf.String(), Equals,
+		"2014-01-02T03:04:06.000000006Z -> PING :12345\n"+
+			"2014-01-02T03:04:07.000000006Z <- PONG :12345\n")
+
+	c.Check(r.Close(), IsNil)
+	c.Check(buf.closed, Equals, true)
+	c.Check(r.Close(), IsNil)
+	c.Check(r.Record(false, []byte("PING :1")), Equals, errRecorderClosed)
+}
+
+func (s *s) TestRecorder_Redact(c *C) {
+	redacted := map[string]string{
+		"PASS secret":                       "PASS *",
+		"OPER admin secret":                 "OPER admin *",
+		"AUTHENTICATE PLAIN":                "AUTHENTICATE PLAIN",
+		"AUTHENTICATE +":                    "AUTHENTICATE +",
+		"AUTHENTICATE AG5pY2sAc2VjcmV0":     "AUTHENTICATE *",
+		"PRIVMSG NickServ :IDENTIFY secret": "PRIVMSG NickServ :IDENTIFY *",
+		"PRIVMSG nickserv@services :identify nick secret": "PRIVMSG " +
+			"nickserv@services :identify *",
+		"NS GHOST nick secret":        "NS GHOST *",
+		"NICKSERV :IDENTIFY secret":   "NICKSERV IDENTIFY *",
+		"@a=b :nick!u@h PASS secret":  "@a=b :nick!u@h PASS *",
+		"PRIVMSG NickServ :INFO nick": "PRIVMSG NickServ :INFO nick",
+		"PRIVMSG #chan :IDENTIFY x":   "PRIVMSG #chan :IDENTIFY x",
+		"PRIVMSG NickServ :IDENTIFY":  "PRIVMSG NickServ :IDENTIFY",
+		"PING :12345":                 "PING :12345",
+	}
+	for line, expect := range redacted {
+		c.Check(redact(line), Equals, expect, Commentf("%s", line))
+	}
+
+	var buf bytes.Buffer
+	r := CreateRecorder(&buf)
+	c.Check(r.Record(true, []byte("PASS secret\r\n")), IsNil)
+	c.Check(strings.HasSuffix(buf.String(), "<- PASS *\n"), Equals, true)
+}
+
+func (s *s) TestReadRecording(c *C) {
+	lines, err := ReadRecording(strings.NewReader(
+		"2014-01-02T03:04:06.000000006Z -> PING :12345\n\n" +
+			"2014-01-02T03:04:07Z <- PONG :12345\n"))
+	c.Check(err, IsNil)
+	c.Assert(len(lines), Equals, 2)
+	c.Check(lines[0].Sent, Equals, false)
+	c.Check(lines[0].Line, Equals, "PING :12345")
+	c.Check(lines[1].Sent, Equals, true)
+	c.Check(lines[1].Line, Equals, "PONG :12345")
+	c.Check(lines[1].Time.Sub(lines[0].Time), Equals,
+		time.Second-6*time.Nanosecond)
+	c.Check(lines[1].String(), Equals, "2014-01-02T03:04:07Z <- PONG :12345\n")
+
+	_, err = ReadRecording(strings.NewReader("notatime -> PING :1\n"))
+	c.Check(err, ErrorMatches, `inet: Bad recording on line 1.*`)
+	_, err = ReadRecording(strings.NewReader("2014-01-02T03:04:07Z PING\n"))
+	c.Check(err, ErrorMatches, `inet: Bad recording on line 1.*`)
+	_, err = ReadRecording(strings.NewReader("2014-01-02T03:04:07Z >> PING\n"))
+	c.Check(err, ErrorMatches, `inet: Bad recording on line 1.*`)
+}
+
+func (s *s) TestIrcClient_Recording(c *C) {
+	read := []byte("PRIVMSG #chan :msg\r\n")
+	write := []byte("NOTICE nick :msg\r\n")
+
+	var buf bytes.Buffer
+	conn := mocks.CreateConn()
+	client := createIrcClient(conn, "")
+	client.SetRecorder(CreateRecorder(&buf))
+	client.SpawnWorkers(true, true)
+
+	go func() {
+		conn.Send(read, len(read), nil)
+	}()
+	msg, ok := client.ReadMessage()
+	c.Check(ok, Equals, true)
+	c.Check(string(msg), Equals, string(read[:len(read)-2]))
+
+	go func() {
+		client.Write(write)
+	}()
+	c.Check(string(conn.Receive(len(write), nil)), Equals, string(write))
+
+	go func() {
+		conn.Send([]byte{}, 0, io.EOF)
+	}()
+	client.Close()
+	conn.WaitForDeath()
+
+	lines, err := ReadRecording(&buf)
+	c.Check(err, IsNil)
+	c.Assert(len(lines), Equals, 2)
+	c.Check(lines[0].Sent, Equals, false)
+	c.Check(lines[0].Line, Equals, "PRIVMSG #chan :msg")
+	c.Check(lines[1].Sent, Equals, true)
+	c.Check(lines[1].Line, Equals, "NOTICE nick :msg")
+}
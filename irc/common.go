@@ -53,6 +53,18 @@ const (
 	QUIT    = "QUIT"
 	TOPIC   = "TOPIC"
 
+	// IRCv3 capability negotiation, batches and SASL.
+	CAP          = "CAP"
+	BATCH        = "BATCH"
+	ACK          = "ACK"
+	AUTHENTICATE = "AUTHENTICATE"
+
+	// IRCv3 user tracking, sent once the caps of the same names are enabled.
+	ACCOUNT = "ACCOUNT"
+	AWAY    = "AWAY"
+	CHGHOST = "CHGHOST"
+	SETNAME = "SETNAME"
+
 	CTCP      = PRIVMSG
 	CTCPReply = NOTICE
 )
@@ -76,6 +88,8 @@ const (
 	RPL_WHOISIDLE       = "317"
 	RPL_ENDOFWHOIS      = "318"
 	RPL_WHOISCHANNELS   = "319"
+	RPL_WHOISACCOUNT    = "330"
+	RPL_WHOISSECURE     = "671"
 	RPL_WHOWASUSER      = "314"
 	RPL_ENDOFWHOWAS     = "369"
 	RPL_LISTSTART       = "321"
@@ -85,6 +99,8 @@ const (
 	RPL_CHANNELMODEIS   = "324"
 	RPL_NOTOPIC         = "331"
 	RPL_TOPIC           = "332"
+	RPL_TOPICWHOTIME    = "333"
+	RPL_CREATIONTIME    = "329"
 	RPL_INVITING        = "341"
 	RPL_SUMMONING       = "342"
 	RPL_INVITELIST      = "346"
@@ -93,6 +109,7 @@ const (
 	RPL_ENDOFEXCEPTLIST = "349"
 	RPL_VERSION         = "351"
 	RPL_WHOREPLY        = "352"
+	RPL_WHOSPCRPL       = "354"
 	RPL_ENDOFWHO        = "315"
 	RPL_NAMREPLY        = "353"
 	RPL_ENDOFNAMES      = "366"
@@ -198,6 +215,16 @@ const (
 	ERR_NOOPERHOST        = "491"
 	ERR_UMODEUNKNOWNFLAG  = "501"
 	ERR_USERSDONTMATCH    = "502"
+
+	// IRCv3 SASL replies.
+	RPL_LOGGEDIN    = "900"
+	RPL_LOGGEDOUT   = "901"
+	ERR_NICKLOCKED  = "902"
+	RPL_SASLSUCCESS = "903"
+	ERR_SASLFAIL    = "904"
+	ERR_SASLTOOLONG = "905"
+	ERR_SASLABORTED = "906"
+	ERR_SASLALREADY = "907"
 )
 
 // Pseudo Messages, these messages are not real messages defined by the irc
@@ -208,6 +235,9 @@ const (
 	RAW        = "RAW"
 	CONNECT    = "CONNECT"
 	DISCONNECT = "DISCONNECT"
+	// STATE is the event handlers implementing dispatch.StateHandler are
+	// registered for to be given the changes made to the state.
+	STATE = "STATE"
 )
 
 // Endpoint represents the source of an event, and should allow replies on a
@@ -273,8 +303,15 @@ type Message struct {
 	Sender string
 	// Args split by space delimiting.
 	Args []string
+	// Tags are the IRCv3 message tags sent with the message, nil if there
+	// were none. Tags without a value map to empty string.
+	Tags map[string]string
 	// Times is the time this message was received.
 	Time time.Time
+	// Channels is filled in by the bot for QUIT and NICK messages with the
+	// channels the user shared with it. By the time handlers see the message
+	// the state may already have forgotten them.
+	Channels []string
 }
 
 // NewMessage constructs a message object that has a timestamp.
@@ -284,7 +321,12 @@ func NewMessage(name, sender string, args ...string) *Message {
 		setArgs = make([]string, len(args))
 		copy(setArgs, args)
 	}
-	return &Message{name, sender, setArgs, time.Now().UTC()}
+	return &Message{
+		Name:   name,
+		Sender: sender,
+		Args:   setArgs,
+		Time:   time.Now().UTC(),
+	}
 }
 
 // Nick returns the nick of the sender. Will be empty string if it was
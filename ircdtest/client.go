@@ -0,0 +1,68 @@
+package ircdtest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ClientTimeout is how long a Client waits to send or receive a line before
+// failing the test.
+const ClientTimeout = 2 * time.Second
+
+// Client is an irc client connection that drives a server in tests, be it the
+// Server in this package or anything else that speaks irc, like a bouncer.
+// Failures to send or receive end the test it was created with.
+type Client struct {
+	t       testing.TB
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+// CreateClient creates a client that talks over conn.
+func CreateClient(t testing.TB, conn net.Conn) *Client {
+	return &Client{t, conn, bufio.NewScanner(conn)}
+}
+
+// DialClient connects a client to an address over tcp.
+func DialClient(t testing.TB, address string) *Client {
+	conn, err := net.DialTimeout("tcp", address, ClientTimeout)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return CreateClient(t, conn)
+}
+
+// Send writes a line to the server, the \r\n is added.
+func (c *Client) Send(line string) {
+	c.conn.SetWriteDeadline(time.Now().Add(ClientTimeout))
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatal("Unexpected error:", err)
+	}
+}
+
+// Expect reads lines until one contains str and returns it.
+func (c *Client) Expect(str string) string {
+	c.conn.SetReadDeadline(time.Now().Add(ClientTimeout))
+	for c.scanner.Scan() {
+		if line := c.scanner.Text(); strings.Contains(line, str) {
+			return line
+		}
+	}
+	c.t.Fatalf("Expected a line containing %q: %v", str, c.scanner.Err())
+	return ""
+}
+
+// Drain reads lines until the server closes the connection.
+func (c *Client) Drain() {
+	c.conn.SetReadDeadline(time.Now().Add(ClientTimeout))
+	for c.scanner.Scan() {
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
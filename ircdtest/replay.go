@@ -0,0 +1,252 @@
+package ircdtest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarondl/ultimateq/inet"
+)
+
+const (
+	// syncTimeout is how long the replayer waits for the bot to write what it
+	// wrote in the recording before carrying on without it.
+	syncTimeout = time.Second
+	// errFmtGoldenLength occurs when the golden file and output differ in
+	// length but agree on every line they share.
+	errFmtGoldenLength = "ircdtest: Expected %d lines, got %d (%s)"
+	// errFmtGoldenLine occurs when a line differs from the golden file.
+	errFmtGoldenLine = "ircdtest: Line %d differs, expected: %q got: %q (%s)"
+)
+
+var (
+	// errReplayed is returned when dialing a replayer a second time.
+	errReplayed = errors.New("ircdtest: Replay already dialed.")
+)
+
+// Replayer plays a recording created by inet.Recorder back to whoever dials
+// it. Only the lines that were read from the server are replayed, the lines
+// the bot wrote during the recording are used to keep the replay in step:
+// before each line is delivered the replayer waits for the bot to have written
+// as many lines as it had at that point in the recording. This makes the
+// replay deterministic as long as the bot behaves the way it did when it was
+// recorded.
+//
+// Everything the bot writes is kept and can be compared to a golden file
+// with CompareGolden.
+type Replayer struct {
+	lines []inet.RecordedLine
+	speed float64
+
+	conn    net.Conn
+	written []string
+	dialed  bool
+	done    chan struct{}
+	notify  chan struct{}
+
+	protect sync.Mutex
+}
+
+// CreateReplayer creates a replayer for the lines. Speed is a multiplier of
+// the recorded time between lines, 1 is real time, 10 is ten times faster,
+// and 0 delivers lines as fast as the bot will take them.
+func CreateReplayer(lines []inet.RecordedLine, speed float64) *Replayer {
+	return &Replayer{
+		lines:  lines,
+		speed:  speed,
+		done:   make(chan struct{}),
+		notify: make(chan struct{}),
+	}
+}
+
+// LoadReplayer reads a recording from a file and creates a replayer for it.
+func LoadReplayer(filename string, speed float64) (*Replayer, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines, err := inet.ReadRecording(file)
+	if err != nil {
+		return nil, err
+	}
+	return CreateReplayer(lines, speed), nil
+}
+
+// Dial starts the replay and returns the bot's end of the connection. The
+// address is ignored. It has the same signature as bot.ConnProvider but a
+// replay can only be dialed once, every subsequent dial fails.
+func (r *Replayer) Dial(addr string) (net.Conn, error) {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+
+	if r.dialed {
+		return nil, errReplayed
+	}
+	r.dialed = true
+
+	local, remote := net.Pipe()
+	r.conn = local
+	go r.reader()
+	go r.play()
+
+	return remote, nil
+}
+
+// Done returns a channel that is closed once every recorded line has been
+// delivered. The connection is left open so the bot can be inspected.
+func (r *Replayer) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait waits for the replay to finish.
+func (r *Replayer) Wait(timeout time.Duration) error {
+	select {
+	case <-r.done:
+		return nil
+	case <-time.After(timeout):
+		return errTimeout
+	}
+}
+
+// Close drops the connection to the bot.
+func (r *Replayer) Close() error {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+// Written returns a copy of every line the bot has written so far.
+func (r *Replayer) Written() []string {
+	r.protect.Lock()
+	defer r.protect.Unlock()
+	lines := make([]string, len(r.written))
+	copy(lines, r.written)
+	return lines
+}
+
+// Recorded returns the lines the bot wrote in the recording.
+func (r *Replayer) Recorded() []string {
+	var lines []string
+	for _, line := range r.lines {
+		if line.Sent {
+			lines = append(lines, line.Line)
+		}
+	}
+	return lines
+}
+
+// reader collects everything the bot writes.
+func (r *Replayer) reader() {
+	scanner := bufio.NewScanner(r.conn)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if len(line) == 0 {
+			continue
+		}
+
+		r.protect.Lock()
+		r.written = append(r.written, line)
+		close(r.notify)
+		r.notify = make(chan struct{})
+		r.protect.Unlock()
+	}
+}
+
+// play delivers the recorded lines to the bot.
+func (r *Replayer) play() {
+	defer close(r.done)
+
+	var last time.Time
+	sent := 0
+	for _, line := range r.lines {
+		if line.Sent {
+			sent++
+			continue
+		}
+
+		if r.speed > 0 && !last.IsZero() {
+			time.Sleep(time.Duration(float64(line.Time.Sub(last)) / r.speed))
+		}
+		last = line.Time
+
+		r.waitWritten(sent, syncTimeout)
+		if _, err := r.conn.Write([]byte(line.Line + "\r\n")); err != nil {
+			return
+		}
+	}
+}
+
+// WaitWritten waits until the bot has written at least n lines. Useful after
+// Done to wait on the bot's replies to the last few lines of a replay.
+func (r *Replayer) WaitWritten(n int, timeout time.Duration) error {
+	if !r.waitWritten(n, timeout) {
+		return errTimeout
+	}
+	return nil
+}
+
+// waitWritten waits until the bot has written n lines or the timeout passes.
+func (r *Replayer) waitWritten(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		r.protect.Lock()
+		written := len(r.written)
+		notify := r.notify
+		r.protect.Unlock()
+		if written >= n {
+			return true
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// CompareGolden compares lines against the contents of a golden file, one
+// line per line. If update is true the golden file is overwritten with the
+// lines instead. The returned error describes the first difference.
+func CompareGolden(filename string, lines []string, update bool) error {
+	if update {
+		contents := strings.Join(lines, "\n")
+		if len(lines) > 0 {
+			contents += "\n"
+		}
+		return ioutil.WriteFile(filename, []byte(contents), 0644)
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var golden []string
+	if contents := strings.TrimRight(string(buf), "\n"); len(contents) > 0 {
+		golden = strings.Split(contents, "\n")
+	}
+
+	for i := 0; i < len(golden) && i < len(lines); i++ {
+		if golden[i] != lines[i] {
+			return fmt.Errorf(errFmtGoldenLine, i+1, golden[i], lines[i],
+				filename)
+		}
+	}
+	if len(golden) != len(lines) {
+		return fmt.Errorf(errFmtGoldenLength, len(golden), len(lines), filename)
+	}
+	return nil
+}
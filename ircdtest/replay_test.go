@@ -0,0 +1,139 @@
+package ircdtest
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarondl/ultimateq/inet"
+)
+
+var recording = `2014-06-01T12:00:00Z <- NICK :bot
+2014-06-01T12:00:00.010Z -> :irc.test.net 001 bot :Welcome
+2014-06-01T12:00:00.020Z -> PING :1
+2014-06-01T12:00:00.020Z <- PONG :1
+2014-06-01T12:00:00.030Z -> PING :2
+2014-06-01T12:00:00.030Z <- PONG :2
+`
+
+func TestReplayer(t *testing.T) {
+	t.Parallel()
+	lines, err := inet.ReadRecording(strings.NewReader(recording))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	r := CreateReplayer(lines, 1)
+	defer r.Close()
+
+	if rec := r.Recorded(); len(rec) != 3 || rec[0] != "NICK :bot" {
+		t.Error("Expected the bot's recorded lines, got:", rec)
+	}
+
+	conn, err := r.Dial("")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, err = r.Dial(""); err != errReplayed {
+		t.Error("Expected a second dial to fail, got:", err)
+	}
+
+	start := time.Now()
+	conn.Write([]byte("NICK :bot\r\n"))
+	scanner := bufio.NewScanner(conn)
+	for i := 0; i < 3 && scanner.Scan(); i++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "PING ") {
+			conn.Write([]byte("PONG " + line[5:] + "\r\n"))
+		}
+	}
+
+	if err = r.Wait(timeout); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Error("Expected the replay to happen in real time, took:", elapsed)
+	}
+	if err = r.WaitWritten(3, timeout); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.WaitWritten(4, time.Millisecond); err != errTimeout {
+		t.Error("Expected a timeout, got:", err)
+	}
+
+	written := r.Written()
+	for i, line := range r.Recorded() {
+		if written[i] != line {
+			t.Errorf("Expected %q, got %q", line, written[i])
+		}
+	}
+}
+
+func TestLoadReplayer(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "ircdtest")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "session.rec")
+	if err = ioutil.WriteFile(filename, []byte(recording), 0644); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	r, err := LoadReplayer(filename, 0)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(r.lines) != 6 {
+		t.Error("Expected all the lines to be loaded, got:", len(r.lines))
+	}
+
+	if _, err = LoadReplayer(filepath.Join(dir, "none"), 0); err == nil {
+		t.Error("Expected an error loading a non existent file.")
+	}
+	ioutil.WriteFile(filename, []byte("garbage\n"), 0644)
+	if _, err = LoadReplayer(filename, 0); err == nil {
+		t.Error("Expected an error loading a bad recording.")
+	}
+}
+
+func TestCompareGolden(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "ircdtest")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	golden := filepath.Join(dir, "test.golden")
+	lines := []string{"NICK :bot", "USER bot 0 * :bot"}
+	if err = CompareGolden(golden, lines, false); err == nil {
+		t.Error("Expected an error for a missing golden file.")
+	}
+	if err = CompareGolden(golden, lines, true); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if err = CompareGolden(golden, lines, false); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	err = CompareGolden(golden, []string{"NICK :bot", "USER other"}, false)
+	if err == nil || !strings.Contains(err.Error(), "Line 2") {
+		t.Error("Expected line 2 to differ, got:", err)
+	}
+	err = CompareGolden(golden, lines[:1], false)
+	if err == nil || !strings.Contains(err.Error(), "Expected 2 lines") {
+		t.Error("Expected the lengths to differ, got:", err)
+	}
+
+	if err = CompareGolden(golden, nil, true); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if err = CompareGolden(golden, nil, false); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
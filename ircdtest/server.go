@@ -0,0 +1,1331 @@
+/*
+Package ircdtest provides an in-process irc server stand-in for integration
+testing. It speaks enough of RFC 2812 (registration, ISUPPORT, JOIN, PART,
+NAMES, WHO, MODE, TOPIC, PRIVMSG) to let a whole bot be driven end to end
+without a network. The server is scriptable so tests can have users join
+channels, change modes and kick the bot at will.
+
+The Dial method has the same signature as bot.ConnProvider so it can be handed
+straight to the bot, every call to it is a new connection which makes
+reconnection logic testable as well. A Client plays the part of an irc client
+for tests that need to talk to a server, or to anything else that speaks irc.
+
+Sessions recorded with inet.Recorder can be played back to a bot with a
+Replayer, and what the bot writes compared against a golden file with
+CompareGolden. This allows bugs seen on real networks to be reproduced.
+*/
+package ircdtest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarondl/ultimateq/irc"
+	"github.com/aarondl/ultimateq/parse"
+)
+
+const (
+	// DefaultName is the name the server uses for itself when none is given.
+	DefaultName = "irc.ircdtest.net"
+	// DefaultHost is the hostname given to connecting clients.
+	DefaultHost = "ircdtest.local"
+	// version is the ircd version reported in RPL_MYINFO.
+	version = "ircdtest-1.0"
+	// userModes is the list of user modes reported in RPL_MYINFO.
+	userModes = "iowx"
+	// chanModes is the list of channel modes reported in RPL_MYINFO.
+	chanModes = "beiIklmnostv"
+	// nBufferedLines is how many lines can be queued for a client before
+	// the server starts blocking.
+	nBufferedLines = 1024
+)
+
+var (
+	// defaultISupport is the set of tokens sent in RPL_ISUPPORT.
+	defaultISupport = []string{
+		"RFC2812", "CASEMAPPING=ascii", "PREFIX=(ov)@+", "CHANTYPES=#&",
+		"CHANMODES=beI,k,l,imnst", "CHANLIMIT=#&:20", "NICKLEN=30",
+		"TOPICLEN=390", "MODES=4",
+	}
+
+	// errTimeout is returned when a wait on the server times out.
+	errTimeout = errors.New("ircdtest: Timed out waiting.")
+	// errClosed is returned when dialing a closed server.
+	errClosed = errors.New("ircdtest: Server closed.")
+	// errUnknownChannel is returned when scripting a non existent channel.
+	errUnknownChannel = errors.New("ircdtest: Unknown channel.")
+	// errUnknownUser is returned when scripting a non existent user.
+	errUnknownUser = errors.New("ircdtest: Unknown user.")
+)
+
+// member is a nick's presence on a channel along with it's modes.
+type member struct {
+	nick  string
+	modes string
+}
+
+// prefix returns the highest prefix char for the member's modes.
+func (m *member) prefix() string {
+	switch {
+	case strings.ContainsRune(m.modes, 'o'):
+		return "@"
+	case strings.ContainsRune(m.modes, 'v'):
+		return "+"
+	}
+	return ""
+}
+
+// channel is the server's record of a channel.
+type channel struct {
+	name        string
+	topic       string
+	topicSetter string
+	topicTime   time.Time
+	created     time.Time
+	modes       string
+	bans        []string
+	members     map[string]*member
+}
+
+// user is a scripted user, one that exists only in the server's imagination.
+type user struct {
+	nick     string
+	username string
+	host     string
+	realname string
+}
+
+// fullhost creates the nick!user@host of the user.
+func (u *user) fullhost() string {
+	return u.nick + "!" + u.username + "@" + u.host
+}
+
+// Server is a fake irc server. Connections are made to it with Dial. All of
+// it's methods are safe to call from multiple goroutines.
+type Server struct {
+	name     string
+	isupport []string
+	caps     []string
+	accounts map[string]string
+
+	clients  []*client
+	users    map[string]*user
+	channels map[string]*channel
+	received []string
+	dials    int
+	closed   bool
+
+	// notify is closed and replaced each time something happens that a
+	// waiter may be interested in.
+	notify chan struct{}
+
+	protect sync.Mutex
+}
+
+// CreateServer creates a new fake irc server. If name is empty DefaultName is
+// used.
+func CreateServer(name string) *Server {
+	if len(name) == 0 {
+		name = DefaultName
+	}
+	isupport := make([]string, len(defaultISupport))
+	copy(isupport, defaultISupport)
+
+	return &Server{
+		name:     name,
+		isupport: isupport,
+		users:    make(map[string]*user),
+		accounts: make(map[string]string),
+		channels: make(map[string]*channel),
+		notify:   make(chan struct{}),
+	}
+}
+
+// Name returns the name of the server.
+func (s *Server) Name() string {
+	return s.name
+}
+
+// ISupport replaces the tokens sent in RPL_ISUPPORT to newly registered
+// clients.
+func (s *Server) ISupport(tokens ...string) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	s.isupport = make([]string, len(tokens))
+	copy(s.isupport, tokens)
+}
+
+// Caps sets the IRCv3 capabilities the server offers to clients that
+// negotiate them with CAP LS. labeled-response is understood by the server,
+// other capabilities are only acknowledged.
+func (s *Server) Caps(caps ...string) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	s.caps = make([]string, len(caps))
+	copy(s.caps, caps)
+}
+
+// Account creates an account that clients can log in to with SASL PLAIN.
+// The server must offer the sasl capability for clients to use it.
+func (s *Server) Account(name, password string) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	s.accounts[name] = password
+}
+
+// Dial creates a new connection to the server. The address is ignored. It
+// has the same signature as bot.ConnProvider.
+func (s *Server) Dial(addr string) (net.Conn, error) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	if s.closed {
+		return nil, errClosed
+	}
+
+	local, remote := net.Pipe()
+	c := &client{
+		srv:  s,
+		conn: local,
+		host: DefaultHost,
+		out:  make(chan string, nBufferedLines),
+		done: make(chan struct{}),
+	}
+	s.clients = append(s.clients, c)
+	s.dials++
+	s.signal()
+
+	go c.reader()
+	go c.writer()
+
+	return remote, nil
+}
+
+// Dials returns the number of times the server has been dialed.
+func (s *Server) Dials() int {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	return s.dials
+}
+
+// Close disconnects all clients and refuses any further connections.
+func (s *Server) Close() {
+	s.protect.Lock()
+	s.closed = true
+	s.protect.Unlock()
+	s.Disconnect()
+}
+
+// Disconnect drops all currently connected clients as if the server had
+// gone away, the server will still accept new connections.
+func (s *Server) Disconnect() {
+	s.protect.Lock()
+	clients := s.clients
+	s.clients = nil
+	for _, c := range clients {
+		s.partAll(c.nick)
+	}
+	s.signal()
+	s.protect.Unlock()
+
+	for _, c := range clients {
+		c.close()
+	}
+}
+
+// Received returns a copy of every line received from clients so far.
+func (s *Server) Received() []string {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	lines := make([]string, len(s.received))
+	copy(lines, s.received)
+	return lines
+}
+
+// WaitFor waits for a line matching the regular expression pattern to be
+// received from any client, it looks through all lines received so far first.
+func (s *Server) WaitFor(pattern string, timeout time.Duration) (string,
+	error) {
+
+	rgx, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	var line string
+	err = s.wait(timeout, func() bool {
+		for _, l := range s.received {
+			if rgx.MatchString(l) {
+				line = l
+				return true
+			}
+		}
+		return false
+	})
+	return line, err
+}
+
+// WaitForRegistered waits until n clients have completed registration.
+func (s *Server) WaitForRegistered(n int, timeout time.Duration) error {
+	return s.wait(timeout, func() bool {
+		registered := 0
+		for _, c := range s.clients {
+			if c.registered {
+				registered++
+			}
+		}
+		return registered >= n
+	})
+}
+
+// WaitForJoin waits until a client with the nick has joined the channel.
+func (s *Server) WaitForJoin(nick, channel string,
+	timeout time.Duration) error {
+
+	nick = strings.ToLower(nick)
+	return s.wait(timeout, func() bool {
+		ch, ok := s.channels[strings.ToLower(channel)]
+		if !ok {
+			return false
+		}
+		_, ok = ch.members[nick]
+		return ok
+	})
+}
+
+// wait calls fn with the server locked each time something happens until it
+// returns true or the timeout is hit.
+func (s *Server) wait(timeout time.Duration, fn func() bool) error {
+	deadline := time.After(timeout)
+	for {
+		s.protect.Lock()
+		done := fn()
+		notify := s.notify
+		s.protect.Unlock()
+		if done {
+			return nil
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return errTimeout
+		}
+	}
+}
+
+// signal wakes up all waiters. Not thread safe.
+func (s *Server) signal() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// Nick returns the nickname of the most recently connected client or empty
+// string if there is none.
+func (s *Server) Nick() string {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	if c := s.lastClient(); c != nil {
+		return c.nick
+	}
+	return ""
+}
+
+// AddUser adds a scripted user to the server. The realname is optional.
+func (s *Server) AddUser(fullhost string, realname ...string) {
+	nick, username, host := irc.Split(fullhost)
+	if len(nick) == 0 {
+		nick = fullhost
+	}
+	u := &user{nick: nick, username: username, host: host}
+	if len(u.username) == 0 {
+		u.username = nick
+	}
+	if len(u.host) == 0 {
+		u.host = DefaultHost
+	}
+	if len(realname) > 0 {
+		u.realname = realname[0]
+	} else {
+		u.realname = nick
+	}
+
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	s.users[strings.ToLower(nick)] = u
+}
+
+// Join makes a scripted user join a channel, the user is created if it does
+// not exist. The channel is created if it does not exist.
+func (s *Server) Join(fullhost, channel string) {
+	nick := irc.Nick(fullhost)
+	if len(nick) == 0 {
+		nick = fullhost
+	}
+
+	s.protect.Lock()
+	_, ok := s.users[strings.ToLower(nick)]
+	s.protect.Unlock()
+	if !ok {
+		s.AddUser(fullhost)
+	}
+
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	u := s.users[strings.ToLower(nick)]
+	ch := s.ensureChannel(channel)
+	if _, ok := ch.members[strings.ToLower(nick)]; ok {
+		return
+	}
+	ch.members[strings.ToLower(nick)] = &member{nick: nick}
+	s.toChannel(ch, "", ":%s JOIN :%s", u.fullhost(), ch.name)
+	s.signal()
+}
+
+// Part makes a scripted user leave a channel.
+func (s *Server) Part(nick, channel, reason string) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	u, ch, err := s.userOnChannel(nick, channel)
+	if err != nil {
+		return err
+	}
+	s.toChannel(ch, "", ":%s PART %s :%s", u.fullhost(), ch.name, reason)
+	delete(ch.members, strings.ToLower(u.nick))
+	s.signal()
+	return nil
+}
+
+// Quit makes a scripted user quit the server.
+func (s *Server) Quit(nick, reason string) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	u, ok := s.users[strings.ToLower(nick)]
+	if !ok {
+		return errUnknownUser
+	}
+	s.toCommon(u.nick, "", ":%s QUIT :%s", u.fullhost(), reason)
+	s.partAll(u.nick)
+	delete(s.users, strings.ToLower(nick))
+	s.signal()
+	return nil
+}
+
+// Rename changes a scripted user's nickname.
+func (s *Server) Rename(nick, newnick string) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	u, ok := s.users[strings.ToLower(nick)]
+	if !ok {
+		return errUnknownUser
+	}
+	s.toCommon(u.nick, "", ":%s NICK :%s", u.fullhost(), newnick)
+	s.renameMember(u.nick, newnick)
+	delete(s.users, strings.ToLower(nick))
+	u.nick = newnick
+	s.users[strings.ToLower(newnick)] = u
+	s.signal()
+	return nil
+}
+
+// Privmsg sends a privmsg from a scripted user to a channel or to the nick of
+// a connected client.
+func (s *Server) Privmsg(nick, target, message string) error {
+	return s.message(irc.PRIVMSG, nick, target, message)
+}
+
+// Notice sends a notice from a scripted user to a channel or to the nick of
+// a connected client.
+func (s *Server) Notice(nick, target, message string) error {
+	return s.message(irc.NOTICE, nick, target, message)
+}
+
+// message delivers a message from a scripted user.
+func (s *Server) message(kind, nick, target, message string) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	u, ok := s.users[strings.ToLower(nick)]
+	if !ok {
+		return errUnknownUser
+	}
+
+	if ch, ok := s.channels[strings.ToLower(target)]; ok {
+		s.toChannel(ch, "", ":%s %s %s :%s", u.fullhost(), kind, ch.name,
+			message)
+		return nil
+	}
+
+	c := s.client(target)
+	if c == nil {
+		return errUnknownUser
+	}
+	c.sendf(":%s %s %s :%s", u.fullhost(), kind, c.nick, message)
+	return nil
+}
+
+// Mode has a scripted user or the server (when setter is empty) set modes on
+// a channel. The o and v modes alter the membership of the nick given as the
+// argument.
+func (s *Server) Mode(setter, channel, modes string, args ...string) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	ch, ok := s.channels[strings.ToLower(channel)]
+	if !ok {
+		return errUnknownChannel
+	}
+
+	source := s.name
+	if len(setter) > 0 {
+		if u, ok := s.users[strings.ToLower(setter)]; ok {
+			source = u.fullhost()
+		} else if c := s.client(setter); c != nil {
+			source = c.fullhost()
+		} else {
+			return errUnknownUser
+		}
+	}
+
+	s.applyModes(ch, modes, args)
+	line := fmt.Sprintf(":%s MODE %s %s", source, ch.name, modes)
+	if len(args) > 0 {
+		line += " " + strings.Join(args, " ")
+	}
+	s.toChannel(ch, "", "%s", line)
+	s.signal()
+	return nil
+}
+
+// Topic has a scripted user change the topic of a channel.
+func (s *Server) Topic(setter, channel, topic string) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	u, ch, err := s.userOnChannel(setter, channel)
+	if err != nil {
+		return err
+	}
+	ch.topic, ch.topicSetter, ch.topicTime = topic, u.nick, time.Now()
+	s.toChannel(ch, "", ":%s TOPIC %s :%s", u.fullhost(), ch.name, topic)
+	s.signal()
+	return nil
+}
+
+// Kick has a scripted user kick a nick (scripted or a client) from a channel.
+func (s *Server) Kick(kicker, channel, nick, reason string) error {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+
+	u, ch, err := s.userOnChannel(kicker, channel)
+	if err != nil {
+		return err
+	}
+	m, ok := ch.members[strings.ToLower(nick)]
+	if !ok {
+		return errUnknownUser
+	}
+	s.toChannel(ch, "", ":%s KICK %s %s :%s", u.fullhost(), ch.name, m.nick,
+		reason)
+	delete(ch.members, strings.ToLower(nick))
+	s.signal()
+	return nil
+}
+
+// Send sends a raw line to all connected clients.
+func (s *Server) Send(format string, args ...interface{}) {
+	s.protect.Lock()
+	defer s.protect.Unlock()
+	for _, c := range s.clients {
+		c.sendf(format, args...)
+	}
+}
+
+// userOnChannel looks up a scripted user and a channel they're on. Not
+// thread safe.
+func (s *Server) userOnChannel(nick, channel string) (*user, *channel,
+	error) {
+
+	u, ok := s.users[strings.ToLower(nick)]
+	if !ok {
+		return nil, nil, errUnknownUser
+	}
+	ch, ok := s.channels[strings.ToLower(channel)]
+	if !ok {
+		return nil, nil, errUnknownChannel
+	}
+	if _, ok = ch.members[strings.ToLower(nick)]; !ok {
+		return nil, nil, errUnknownUser
+	}
+	return u, ch, nil
+}
+
+// ensureChannel gets or creates a channel. Not thread safe.
+func (s *Server) ensureChannel(name string) *channel {
+	key := strings.ToLower(name)
+	ch, ok := s.channels[key]
+	if !ok {
+		ch = &channel{
+			name:    name,
+			created: time.Now(),
+			modes:   "nt",
+			members: make(map[string]*member),
+		}
+		s.channels[key] = ch
+	}
+	return ch
+}
+
+// applyModes changes the channel's modes. Not thread safe.
+func (s *Server) applyModes(ch *channel, modes string, args []string) {
+	add := true
+	arg := 0
+	nextArg := func() string {
+		if arg < len(args) {
+			arg++
+			return args[arg-1]
+		}
+		return ""
+	}
+
+	for _, mode := range modes {
+		switch mode {
+		case '+':
+			add = true
+		case '-':
+			add = false
+		case 'o', 'v':
+			m, ok := ch.members[strings.ToLower(nextArg())]
+			if !ok {
+				continue
+			}
+			if add && !strings.ContainsRune(m.modes, mode) {
+				m.modes += string(mode)
+			} else if !add {
+				m.modes = strings.Replace(m.modes, string(mode), "", -1)
+			}
+		case 'b':
+			mask := nextArg()
+			if add {
+				ch.bans = append(ch.bans, mask)
+			} else {
+				for i, b := range ch.bans {
+					if b == mask {
+						ch.bans = append(ch.bans[:i], ch.bans[i+1:]...)
+						break
+					}
+				}
+			}
+		case 'k', 'l':
+			if add || mode == 'k' {
+				nextArg()
+			}
+			fallthrough
+		default:
+			if add && !strings.ContainsRune(ch.modes, mode) {
+				ch.modes += string(mode)
+			} else if !add {
+				ch.modes = strings.Replace(ch.modes, string(mode), "", -1)
+			}
+		}
+	}
+}
+
+// partAll removes a nick from all channels. Not thread safe.
+func (s *Server) partAll(nick string) {
+	nick = strings.ToLower(nick)
+	for _, ch := range s.channels {
+		delete(ch.members, nick)
+	}
+}
+
+// renameMember changes a nick in all channels. Not thread safe.
+func (s *Server) renameMember(nick, newnick string) {
+	nick = strings.ToLower(nick)
+	for _, ch := range s.channels {
+		if m, ok := ch.members[nick]; ok {
+			delete(ch.members, nick)
+			m.nick = newnick
+			ch.members[strings.ToLower(newnick)] = m
+		}
+	}
+}
+
+// client finds a connected client by nick. Not thread safe.
+func (s *Server) client(nick string) *client {
+	for _, c := range s.clients {
+		if strings.EqualFold(c.nick, nick) {
+			return c
+		}
+	}
+	return nil
+}
+
+// lastClient finds the most recently connected client. Not thread safe.
+func (s *Server) lastClient() *client {
+	if len(s.clients) == 0 {
+		return nil
+	}
+	return s.clients[len(s.clients)-1]
+}
+
+// toChannel sends a line to every client on a channel except the one with
+// the nick skip. Not thread safe.
+func (s *Server) toChannel(ch *channel, skip string, format string,
+	args ...interface{}) {
+
+	for _, c := range s.clients {
+		if !c.registered || strings.EqualFold(c.nick, skip) {
+			continue
+		}
+		if _, ok := ch.members[strings.ToLower(c.nick)]; ok {
+			c.sendf(format, args...)
+		}
+	}
+}
+
+// toCommon sends a line to every client that shares a channel with nick
+// except the one with the nick skip. Not thread safe.
+func (s *Server) toCommon(nick, skip string, format string,
+	args ...interface{}) {
+
+	nick = strings.ToLower(nick)
+	for _, c := range s.clients {
+		if !c.registered || strings.EqualFold(c.nick, skip) {
+			continue
+		}
+		me := strings.ToLower(c.nick)
+		for _, ch := range s.channels {
+			_, them := ch.members[nick]
+			_, us := ch.members[me]
+			if them && us {
+				c.sendf(format, args...)
+				break
+			}
+		}
+	}
+}
+
+// removeClient drops a client from the server. Not thread safe.
+func (s *Server) removeClient(c *client) {
+	for i, other := range s.clients {
+		if other == c {
+			s.clients = append(s.clients[:i], s.clients[i+1:]...)
+			break
+		}
+	}
+	s.partAll(c.nick)
+	s.signal()
+}
+
+// client is a single connection to the server.
+type client struct {
+	srv  *Server
+	conn net.Conn
+
+	nick       string
+	username   string
+	host       string
+	realname   string
+	registered bool
+
+	// IRCv3 capabilities
+	capping bool
+	caps    map[string]bool
+	capture *[]string
+	batches int
+
+	// SASL
+	authenticating bool
+	saslPayload    string
+	account        string
+
+	out       chan string
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// fullhost creates the nick!user@host of the client.
+func (c *client) fullhost() string {
+	return c.nick + "!" + c.username + "@" + c.host
+}
+
+// sendf queues a line for the client. If replies are being captured for a
+// labeled response the line is captured instead.
+func (c *client) sendf(format string, args ...interface{}) {
+	if c.capture != nil {
+		*c.capture = append(*c.capture, fmt.Sprintf(format, args...))
+		return
+	}
+	c.queue(fmt.Sprintf(format, args...))
+}
+
+// queue queues a line for the client.
+func (c *client) queue(line string) {
+	select {
+	case c.out <- line:
+	case <-c.done:
+	}
+}
+
+// numeric queues a numeric reply from the server for the client.
+func (c *client) numeric(num string, args ...string) {
+	nick := c.nick
+	if len(nick) == 0 {
+		nick = "*"
+	}
+	line := ":" + c.srv.name + " " + num + " " + nick
+	for i, arg := range args {
+		if i == len(args)-1 {
+			line += " :" + arg
+		} else {
+			line += " " + arg
+		}
+	}
+	c.sendf("%s", line)
+}
+
+// close closes the client connection.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// writer writes the queued lines to the connection.
+func (c *client) writer() {
+	for {
+		select {
+		case line := <-c.out:
+			if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// reader reads lines from the connection and handles them.
+func (c *client) reader() {
+	defer func() {
+		c.srv.protect.Lock()
+		c.srv.removeClient(c)
+		c.srv.protect.Unlock()
+		c.close()
+	}()
+
+	scanner := bufio.NewScanner(c.conn)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if len(line) == 0 {
+			continue
+		}
+		msg, err := parse.Parse([]byte(line))
+
+		c.srv.protect.Lock()
+		c.srv.received = append(c.srv.received, line)
+		if err == nil {
+			if label := msg.Tags["label"]; len(label) > 0 &&
+				c.caps["labeled-response"] {
+				c.handleLabeled(msg, label)
+			} else {
+				c.handle(msg)
+			}
+		}
+		c.srv.signal()
+		c.srv.protect.Unlock()
+
+		if err == nil && msg.Name == irc.QUIT {
+			return
+		}
+	}
+}
+
+// handle handles a message from the client. Not thread safe.
+func (c *client) handle(m *irc.Message) {
+	switch m.Name {
+	case irc.NICK:
+		c.handleNick(m)
+	case "USER":
+		if len(m.Args) < 4 {
+			c.numeric(irc.ERR_NEEDMOREPARAMS, "USER", "Not enough parameters")
+			return
+		}
+		c.username, c.realname = m.Args[0], m.Args[3]
+		c.register()
+	case irc.PING:
+		arg := c.srv.name
+		if len(m.Args) > 0 {
+			arg = m.Args[0]
+		}
+		c.sendf(":%s PONG %s :%s", c.srv.name, c.srv.name, arg)
+	case irc.PONG:
+	case irc.CAP:
+		c.cap(m)
+	case irc.AUTHENTICATE:
+		c.authenticate(m)
+	case irc.QUIT:
+		c.srv.toCommon(c.nick, c.nick, ":%s QUIT :%s", c.fullhost(),
+			strings.Join(m.Args, " "))
+		c.sendf("ERROR :Closing Link: %s (Quit)", c.host)
+	default:
+		if !c.registered {
+			c.numeric(irc.ERR_NOTREGISTERED, "You have not registered")
+			return
+		}
+		c.handleRegistered(m)
+	}
+}
+
+// handleRegistered handles the messages that require registration. Not
+// thread safe.
+func (c *client) handleRegistered(m *irc.Message) {
+	if len(m.Args) == 0 {
+		c.numeric(irc.ERR_NEEDMOREPARAMS, m.Name, "Not enough parameters")
+		return
+	}
+
+	switch m.Name {
+	case irc.JOIN:
+		for _, name := range strings.Split(m.Args[0], ",") {
+			c.join(name)
+		}
+	case irc.PART:
+		for _, name := range strings.Split(m.Args[0], ",") {
+			c.part(name, strings.Join(m.Args[1:], " "))
+		}
+	case irc.MODE:
+		c.mode(m)
+	case irc.TOPIC:
+		c.topic(m)
+	case "NAMES":
+		for _, name := range strings.Split(m.Args[0], ",") {
+			c.names(name)
+		}
+	case "WHO":
+		c.who(m.Args[0])
+	case "WHOIS":
+		c.whois(m.Args[len(m.Args)-1])
+	case "ISON":
+		c.ison(m.Args)
+	case irc.PRIVMSG, irc.NOTICE:
+		if len(m.Args) < 2 {
+			c.numeric(irc.ERR_NOTEXTTOSEND, "No text to send")
+			return
+		}
+		c.message(m)
+	default:
+		c.numeric(irc.ERR_UNKNOWNCOMMAND, m.Name, "Unknown command")
+	}
+}
+
+// handleNick handles a nick change or initial nick. Not thread safe.
+func (c *client) handleNick(m *irc.Message) {
+	if len(m.Args) == 0 {
+		c.numeric(irc.ERR_NONICKNAMEGIVEN, "No nickname given")
+		return
+	}
+	nick := m.Args[0]
+	_, taken := c.srv.users[strings.ToLower(nick)]
+	if other := c.srv.client(nick); other != nil && other != c {
+		taken = true
+	}
+	if taken {
+		c.numeric(irc.ERR_NICKNAMEINUSE, nick, "Nickname is already in use")
+		return
+	}
+
+	if c.registered {
+		line := fmt.Sprintf(":%s NICK :%s", c.fullhost(), nick)
+		c.srv.toCommon(c.nick, c.nick, "%s", line)
+		c.sendf("%s", line)
+		c.srv.renameMember(c.nick, nick)
+	}
+	c.nick = nick
+	c.register()
+}
+
+// register sends the registration burst once both NICK and USER have been
+// received. Not thread safe.
+func (c *client) register() {
+	if c.registered || c.capping || len(c.nick) == 0 ||
+		len(c.username) == 0 {
+		return
+	}
+	c.registered = true
+
+	s := c.srv
+	c.numeric(irc.RPL_WELCOME,
+		"Welcome to the Internet Relay Network "+c.fullhost())
+	c.numeric(irc.RPL_YOURHOST,
+		fmt.Sprintf("Your host is %s, running version %s", s.name, version))
+	c.numeric(irc.RPL_CREATED, "This server was created just now")
+	c.sendf(":%s %s %s %s %s %s %s", s.name, irc.RPL_MYINFO, c.nick, s.name,
+		version, userModes, chanModes)
+	c.sendf(":%s %s %s %s :are supported by this server", s.name,
+		irc.RPL_ISUPPORT, c.nick, strings.Join(s.isupport, " "))
+	c.numeric(irc.RPL_MOTDSTART, "- "+s.name+" Message of the day - ")
+	c.numeric(irc.RPL_MOTD, "- This is a test server.")
+	c.numeric(irc.RPL_ENDOFMOTD, "End of MOTD command")
+}
+
+// join has the client join a channel. Not thread safe.
+func (c *client) join(name string) {
+	if len(name) == 0 || !strings.ContainsAny(name[:1], "#&") {
+		c.numeric(irc.ERR_NOSUCHCHANNEL, name, "No such channel")
+		return
+	}
+
+	ch := c.srv.ensureChannel(name)
+	nick := strings.ToLower(c.nick)
+	if _, ok := ch.members[nick]; ok {
+		return
+	}
+	m := &member{nick: c.nick}
+	if len(ch.members) == 0 {
+		m.modes = "o"
+	}
+	ch.members[nick] = m
+
+	c.srv.toChannel(ch, "", ":%s JOIN :%s", c.fullhost(), ch.name)
+	if len(ch.topic) > 0 {
+		c.numeric(irc.RPL_TOPIC, ch.name, ch.topic)
+		c.sendf(":%s 333 %s %s %s %d", c.srv.name, c.nick, ch.name,
+			ch.topicSetter, ch.topicTime.Unix())
+	}
+	c.names(ch.name)
+}
+
+// part has the client leave a channel. Not thread safe.
+func (c *client) part(name, reason string) {
+	ch, ok := c.srv.channels[strings.ToLower(name)]
+	if !ok {
+		c.numeric(irc.ERR_NOSUCHCHANNEL, name, "No such channel")
+		return
+	}
+	nick := strings.ToLower(c.nick)
+	if _, ok := ch.members[nick]; !ok {
+		c.numeric(irc.ERR_NOTONCHANNEL, ch.name,
+			"You're not on that channel")
+		return
+	}
+	c.srv.toChannel(ch, "", ":%s PART %s :%s", c.fullhost(), ch.name, reason)
+	delete(ch.members, nick)
+}
+
+// names sends the names list for a channel. Not thread safe.
+func (c *client) names(name string) {
+	if ch, ok := c.srv.channels[strings.ToLower(name)]; ok {
+		nicks := make([]string, 0, len(ch.members))
+		for _, m := range ch.members {
+			nicks = append(nicks, m.prefix()+m.nick)
+		}
+		c.numeric(irc.RPL_NAMREPLY, "=", ch.name, strings.Join(nicks, " "))
+	}
+	c.numeric(irc.RPL_ENDOFNAMES, name, "End of NAMES list")
+}
+
+// who sends the who list for a channel. Not thread safe.
+func (c *client) who(name string) {
+	if ch, ok := c.srv.channels[strings.ToLower(name)]; ok {
+		for key, m := range ch.members {
+			username, host, realname := "", "", ""
+			if u, ok := c.srv.users[key]; ok {
+				username, host, realname = u.username, u.host, u.realname
+			} else if other := c.srv.client(m.nick); other != nil {
+				username, host, realname = other.username, other.host,
+					other.realname
+			}
+			c.numeric(irc.RPL_WHOREPLY, ch.name, username, host, c.srv.name,
+				m.nick, "H"+m.prefix(), "0 "+realname)
+		}
+	}
+	c.numeric(irc.RPL_ENDOFWHO, name, "End of WHO list")
+}
+
+// mode handles mode queries and changes from the client. Not thread safe.
+func (c *client) mode(m *irc.Message) {
+	target := m.Args[0]
+	if strings.EqualFold(target, c.nick) {
+		c.numeric(irc.RPL_UMODEIS, "+i")
+		return
+	}
+
+	ch, ok := c.srv.channels[strings.ToLower(target)]
+	if !ok {
+		c.numeric(irc.ERR_NOSUCHCHANNEL, target, "No such channel")
+		return
+	}
+
+	if len(m.Args) == 1 {
+		c.sendf(":%s %s %s %s +%s", c.srv.name, irc.RPL_CHANNELMODEIS,
+			c.nick, ch.name, ch.modes)
+		c.sendf(":%s 329 %s %s %d", c.srv.name, c.nick, ch.name,
+			ch.created.Unix())
+		return
+	}
+
+	if len(m.Args) == 2 && strings.Trim(m.Args[1], "+") == "b" {
+		for _, ban := range ch.bans {
+			c.sendf(":%s %s %s %s %s", c.srv.name, irc.RPL_BANLIST,
+				c.nick, ch.name, ban)
+		}
+		c.numeric(irc.RPL_ENDOFBANLIST, ch.name, "End of channel ban list")
+		return
+	}
+
+	c.srv.applyModes(ch, m.Args[1], m.Args[2:])
+	c.srv.toChannel(ch, "", ":%s MODE %s %s", c.fullhost(), ch.name,
+		strings.Join(m.Args[1:], " "))
+}
+
+// topic handles topic queries and changes from the client. Not thread safe.
+func (c *client) topic(m *irc.Message) {
+	ch, ok := c.srv.channels[strings.ToLower(m.Args[0])]
+	if !ok {
+		c.numeric(irc.ERR_NOSUCHCHANNEL, m.Args[0], "No such channel")
+		return
+	}
+
+	if len(m.Args) == 1 {
+		if len(ch.topic) == 0 {
+			c.numeric(irc.RPL_NOTOPIC, ch.name, "No topic is set")
+		} else {
+			c.numeric(irc.RPL_TOPIC, ch.name, ch.topic)
+		}
+		return
+	}
+
+	ch.topic, ch.topicSetter, ch.topicTime = m.Args[1], c.nick, time.Now()
+	c.srv.toChannel(ch, "", ":%s TOPIC %s :%s", c.fullhost(), ch.name,
+		ch.topic)
+}
+
+// message relays privmsgs and notices to other clients. Not thread safe.
+func (c *client) message(m *irc.Message) {
+	target := m.Args[0]
+	if ch, ok := c.srv.channels[strings.ToLower(target)]; ok {
+		c.srv.toChannel(ch, c.nick, ":%s %s %s :%s", c.fullhost(), m.Name,
+			ch.name, m.Args[1])
+		return
+	}
+	if other := c.srv.client(target); other != nil {
+		other.sendf(":%s %s %s :%s", c.fullhost(), m.Name, other.nick,
+			m.Args[1])
+		return
+	}
+	if _, ok := c.srv.users[strings.ToLower(target)]; !ok {
+		c.numeric(irc.ERR_NOSUCHNICK, target, "No such nick/channel")
+	}
+}
+
+// handleLabeled handles a message and sends the replies to it as a labeled
+// response. Not thread safe.
+func (c *client) handleLabeled(m *irc.Message, label string) {
+	var replies []string
+	c.capture = &replies
+	c.handle(m)
+	c.capture = nil
+
+	switch len(replies) {
+	case 0:
+		c.queue(fmt.Sprintf("@label=%s :%s ACK", label, c.srv.name))
+	case 1:
+		c.queue(fmt.Sprintf("@label=%s %s", label, replies[0]))
+	default:
+		c.batches++
+		id := fmt.Sprintf("b%d", c.batches)
+		c.queue(fmt.Sprintf("@label=%s :%s BATCH +%s labeled-response",
+			label, c.srv.name, id))
+		for _, reply := range replies {
+			c.queue(fmt.Sprintf("@batch=%s %s", id, reply))
+		}
+		c.queue(fmt.Sprintf(":%s BATCH -%s", c.srv.name, id))
+	}
+}
+
+// cap handles IRCv3 capability negotiation. Not thread safe.
+func (c *client) cap(m *irc.Message) {
+	if len(m.Args) == 0 {
+		c.numeric(irc.ERR_NEEDMOREPARAMS, irc.CAP, "Not enough parameters")
+		return
+	}
+
+	nick := c.nick
+	if len(nick) == 0 {
+		nick = "*"
+	}
+
+	switch strings.ToUpper(m.Args[0]) {
+	case "LS":
+		if !c.registered {
+			c.capping = true
+		}
+		c.sendf(":%s CAP %s LS :%s", c.srv.name, nick,
+			strings.Join(c.srv.caps, " "))
+	case "LIST":
+		var enabled []string
+		for cp := range c.caps {
+			enabled = append(enabled, cp)
+		}
+		c.sendf(":%s CAP %s LIST :%s", c.srv.name, nick,
+			strings.Join(enabled, " "))
+	case "REQ":
+		if !c.registered {
+			c.capping = true
+		}
+		var requested []string
+		if len(m.Args) > 1 {
+			requested = strings.Fields(m.Args[1])
+		}
+		for _, cp := range requested {
+			if !c.srv.hasCap(strings.TrimPrefix(cp, "-")) {
+				c.sendf(":%s CAP %s NAK :%s", c.srv.name, nick, m.Args[1])
+				return
+			}
+		}
+		if c.caps == nil {
+			c.caps = make(map[string]bool)
+		}
+		for _, cp := range requested {
+			if strings.HasPrefix(cp, "-") {
+				delete(c.caps, cp[1:])
+			} else {
+				c.caps[cp] = true
+			}
+		}
+		c.sendf(":%s CAP %s ACK :%s", c.srv.name, nick, m.Args[1])
+	case "END":
+		c.capping = false
+		c.register()
+	default:
+		c.numeric("410", m.Args[0], "Invalid CAP command")
+	}
+}
+
+// authenticate handles SASL PLAIN authentication. Not thread safe.
+func (c *client) authenticate(m *irc.Message) {
+	if len(m.Args) == 0 {
+		c.numeric(irc.ERR_NEEDMOREPARAMS, irc.AUTHENTICATE,
+			"Not enough parameters")
+		return
+	}
+	arg := m.Args[0]
+
+	switch {
+	case !c.caps["sasl"]:
+		c.numeric(irc.ERR_SASLFAIL, "SASL authentication failed")
+	case len(c.account) > 0:
+		c.numeric(irc.ERR_SASLALREADY, "You have already authenticated")
+	case arg == "*":
+		c.authenticating, c.saslPayload = false, ""
+		c.numeric(irc.ERR_SASLABORTED, "SASL authentication aborted")
+	case !c.authenticating:
+		if !strings.EqualFold(arg, "PLAIN") {
+			c.numeric(irc.ERR_SASLFAIL, "SASL authentication failed")
+			return
+		}
+		c.authenticating = true
+		c.sendf("%s +", irc.AUTHENTICATE)
+	default:
+		if arg != "+" {
+			c.saslPayload += arg
+		}
+		if len(arg) == 400 {
+			return
+		}
+		payload := c.saslPayload
+		c.authenticating, c.saslPayload = false, ""
+		c.login(payload)
+	}
+}
+
+// login checks the base64 PLAIN credentials and logs the client in to the
+// account if they're correct. Not thread safe.
+func (c *client) login(payload string) {
+	decoded, err := base64.StdEncoding.DecodeString(payload)
+	parts := strings.Split(string(decoded), "\x00")
+	if err != nil || len(parts) != 3 {
+		c.numeric(irc.ERR_SASLFAIL, "SASL authentication failed")
+		return
+	}
+
+	name, password := parts[1], parts[2]
+	if pass, ok := c.srv.accounts[name]; !ok || pass != password {
+		c.numeric(irc.ERR_SASLFAIL, "SASL authentication failed")
+		return
+	}
+
+	c.account = name
+	c.numeric(irc.RPL_LOGGEDIN, c.fullhost(), name,
+		"You are now logged in as "+name)
+	c.numeric(irc.RPL_SASLSUCCESS, "SASL authentication successful")
+}
+
+// hasCap checks if the server offers a capability. Not thread safe.
+func (s *Server) hasCap(name string) bool {
+	for _, cp := range s.caps {
+		if cp == name || strings.HasPrefix(cp, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// whois sends whois information about a nick. Not thread safe.
+func (c *client) whois(nick string) {
+	var username, host, realname string
+	if u, ok := c.srv.users[strings.ToLower(nick)]; ok {
+		nick, username, host, realname = u.nick, u.username, u.host,
+			u.realname
+	} else if other := c.srv.client(nick); other != nil {
+		nick, username, host, realname = other.nick, other.username,
+			other.host, other.realname
+	} else {
+		c.numeric(irc.ERR_NOSUCHNICK, nick, "No such nick/channel")
+		c.numeric(irc.RPL_ENDOFWHOIS, nick, "End of WHOIS list")
+		return
+	}
+
+	c.numeric(irc.RPL_WHOISUSER, nick, username, host, "*", realname)
+	var channels []string
+	for _, ch := range c.srv.channels {
+		if m, ok := ch.members[strings.ToLower(nick)]; ok {
+			channels = append(channels, m.prefix()+ch.name)
+		}
+	}
+	if len(channels) > 0 {
+		c.numeric(irc.RPL_WHOISCHANNELS, nick, strings.Join(channels, " "))
+	}
+	c.numeric(irc.RPL_WHOISSERVER, nick, c.srv.name, "ircdtest server")
+	c.numeric(irc.RPL_ENDOFWHOIS, nick, "End of WHOIS list")
+}
+
+// ison sends which of the nicks are online. Not thread safe.
+func (c *client) ison(args []string) {
+	var online []string
+	for _, arg := range args {
+		for _, nick := range strings.Fields(arg) {
+			if u, ok := c.srv.users[strings.ToLower(nick)]; ok {
+				online = append(online, u.nick)
+			} else if other := c.srv.client(nick); other != nil {
+				online = append(online, other.nick)
+			}
+		}
+	}
+	c.numeric(irc.RPL_ISON, strings.Join(online, " "))
+}
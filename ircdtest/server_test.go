@@ -0,0 +1,350 @@
+package ircdtest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var timeout = 2 * time.Second
+
+func dial(t *testing.T, s *Server) *Client {
+	conn, err := s.Dial("ignored:6667")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return CreateClient(t, conn)
+}
+
+func register(t *testing.T, s *Server, nick string) *Client {
+	c := dial(t, s)
+	c.Send("NICK :" + nick)
+	c.Send("USER " + nick + " 0 * :Real Name")
+	c.Expect(" 001 " + nick + " :Welcome")
+	c.Expect(" 005 " + nick + " ")
+	c.Expect(" 376 ")
+	return c
+}
+
+func TestServer_Register(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+	defer s.Close()
+
+	if s.Name() != DefaultName {
+		t.Error("Expected the default name, got:", s.Name())
+	}
+
+	c := dial(t, s)
+	c.Send("PRIVMSG #chan :hi")
+	c.Expect(" 451 ")
+
+	c.Send("NICK :bot")
+	c.Send("USER bot 0 * :Real Name")
+	line := c.Expect(" 001 ")
+	if !strings.HasSuffix(line, "bot!bot@"+DefaultHost) {
+		t.Error("Expected the fullhost in the welcome, got:", line)
+	}
+	c.Expect("PREFIX=(ov)@+")
+
+	if err := s.WaitForRegistered(1, timeout); err != nil {
+		t.Error(err)
+	}
+	if s.Nick() != "bot" {
+		t.Error("Expected the nick to be bot, got:", s.Nick())
+	}
+	if s.Dials() != 1 {
+		t.Error("Expected one dial, got:", s.Dials())
+	}
+
+	c.Send("PING :12345")
+	c.Expect("PONG " + DefaultName + " :12345")
+}
+
+func TestServer_ISupport(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("irc.test.net")
+	defer s.Close()
+
+	s.ISupport("CHANTYPES=#", "PREFIX=(qov)~@+")
+	c := dial(t, s)
+	c.Send("NICK :bot")
+	c.Send("USER bot 0 * :Real Name")
+	c.Expect(":irc.test.net 005 bot CHANTYPES=# PREFIX=(qov)~@+")
+}
+
+func TestServer_NickInUse(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+	defer s.Close()
+
+	s.AddUser("taken!user@host")
+	c := dial(t, s)
+	c.Send("NICK :taken")
+	c.Expect(" 433 * taken ")
+}
+
+func TestServer_JoinNamesWho(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+	defer s.Close()
+
+	s.AddUser("fish!fishy@fish.net", "Big Fish")
+	s.Join("fish!fishy@fish.net", "#chan")
+	if err := s.Topic("fish", "#chan", "the topic"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	c := register(t, s, "bot")
+	c.Send("JOIN :#chan")
+	c.Expect(":bot!bot@" + DefaultHost + " JOIN :#chan")
+	c.Expect(" 332 bot #chan :the topic")
+	names := c.Expect(" 353 bot = #chan :")
+	if !strings.Contains(names, "fish") || !strings.Contains(names, "bot") {
+		t.Error("Expected both nicks in names:", names)
+	}
+	c.Expect(" 366 bot #chan ")
+
+	if err := s.WaitForJoin("bot", "#chan", timeout); err != nil {
+		t.Error(err)
+	}
+
+	c.Send("WHO :#chan")
+	c.Expect(" 352 bot #chan fishy fish.net " + DefaultName +
+		" fish H :0 Big Fish")
+	c.Expect(" 315 bot #chan ")
+
+	c.Send("MODE :#chan")
+	c.Expect(" 324 bot #chan +nt")
+	c.Expect(" 329 bot #chan ")
+}
+
+func TestServer_Scripting(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+	defer s.Close()
+
+	c := register(t, s, "bot")
+	c.Send("JOIN :#chan")
+	c.Expect(" 366 ")
+
+	s.Join("fish!fishy@fish.net", "#chan")
+	c.Expect(":fish!fishy@fish.net JOIN :#chan")
+
+	if err := s.Privmsg("fish", "#chan", "hello there"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	c.Expect(":fish!fishy@fish.net PRIVMSG #chan :hello there")
+
+	if err := s.Notice("fish", "bot", "psst"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	c.Expect(":fish!fishy@fish.net NOTICE bot :psst")
+
+	if err := s.Mode("", "#chan", "+ov", "fish", "bot"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	c.Expect(":" + DefaultName + " MODE #chan +ov fish bot")
+
+	c.Send("NAMES :#chan")
+	names := c.Expect(" 353 ")
+	if !strings.Contains(names, "@fish") || !strings.Contains(names, "@bot") {
+		t.Error("Expected the modes to be reflected in names:", names)
+	}
+
+	if err := s.Rename("fish", "shark"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	c.Expect(":fish!fishy@fish.net NICK :shark")
+
+	if err := s.Kick("shark", "#chan", "bot", "bye"); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	c.Expect(":shark!fishy@fish.net KICK #chan bot :bye")
+
+	if err := s.Kick("shark", "#chan", "bot", "bye"); err == nil {
+		t.Error("Expected an error kicking someone not on the channel.")
+	}
+	if err := s.Quit("nobody", "bye"); err == nil {
+		t.Error("Expected an error quitting a non existent user.")
+	}
+	if err := s.Mode("", "#nochan", "+m"); err == nil {
+		t.Error("Expected an error setting modes on a non existent channel.")
+	}
+}
+
+func TestServer_Received(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+	defer s.Close()
+
+	c := register(t, s, "bot")
+	c.Send("PRIVMSG #nowhere :some text")
+
+	line, err := s.WaitFor(`^PRIVMSG #nowhere`, timeout)
+	if err != nil {
+		t.Error(err)
+	}
+	if line != "PRIVMSG #nowhere :some text" {
+		t.Error("Wrong line matched:", line)
+	}
+
+	if _, err = s.WaitFor(`^NEVER`, 10*time.Millisecond); err != errTimeout {
+		t.Error("Expected a timeout, got:", err)
+	}
+	if len(s.Received()) < 3 {
+		t.Error("Expected all the lines to be received:", s.Received())
+	}
+}
+
+func TestServer_Disconnect(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+
+	c := register(t, s, "bot")
+	s.Disconnect()
+	c.Drain()
+
+	c = register(t, s, "bot")
+	if s.Dials() != 2 {
+		t.Error("Expected two dials, got:", s.Dials())
+	}
+
+	s.Close()
+	if _, err := s.Dial(""); err != errClosed {
+		t.Error("Expected the server to refuse connections, got:", err)
+	}
+}
+
+func TestServer_Cap(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+	defer s.Close()
+	s.Caps("batch", "labeled-response", "sasl=PLAIN")
+
+	c := dial(t, s)
+	c.Send("CAP LS 302")
+	line := c.Expect(" CAP * LS ")
+	if !strings.HasSuffix(line, ":batch labeled-response sasl=PLAIN") {
+		t.Error("Expected the caps to be listed, got:", line)
+	}
+
+	c.Send("NICK :bot")
+	c.Send("USER bot 0 * :Real Name")
+	c.Send("CAP REQ :batch nope")
+	c.Expect(" CAP bot NAK :batch nope")
+	c.Send("CAP REQ :batch labeled-response")
+	c.Expect(" CAP bot ACK :batch labeled-response")
+
+	if err := s.WaitForRegistered(1, 50*time.Millisecond); err == nil {
+		t.Error("Expected registration to wait for CAP END.")
+	}
+	c.Send("CAP END")
+	c.Expect(" 001 bot ")
+}
+
+func TestServer_Sasl(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+	defer s.Close()
+	s.Caps("sasl")
+	s.Account("bot", "secret")
+
+	c := dial(t, s)
+	c.Send("CAP LS 302")
+	c.Send("NICK :bot")
+	c.Send("USER bot 0 * :Real Name")
+	c.Send("AUTHENTICATE PLAIN")
+	c.Expect(" 904 bot :")
+	c.Send("CAP REQ :sasl")
+	c.Expect(" CAP bot ACK :sasl")
+
+	c.Send("AUTHENTICATE PLAIN")
+	c.Expect("AUTHENTICATE +")
+	c.Send("AUTHENTICATE Ym90AGJvdAB3cm9uZw==") // bot\0bot\0wrong
+	c.Expect(" 904 bot :")
+
+	c.Send("AUTHENTICATE PLAIN")
+	c.Expect("AUTHENTICATE +")
+	c.Send("AUTHENTICATE *")
+	c.Expect(" 906 bot :")
+
+	c.Send("AUTHENTICATE PLAIN")
+	c.Expect("AUTHENTICATE +")
+	c.Send("AUTHENTICATE Ym90AGJvdABzZWNyZXQ=") // bot\0bot\0secret
+	c.Expect(" 900 bot bot!bot@" + DefaultHost + " bot :")
+	c.Expect(" 903 bot :")
+	c.Send("AUTHENTICATE PLAIN")
+	c.Expect(" 907 bot :")
+
+	c.Send("CAP END")
+	c.Expect(" 001 bot ")
+}
+
+func TestServer_LabeledResponse(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+	defer s.Close()
+	s.Caps("batch", "labeled-response")
+
+	c := dial(t, s)
+	c.Send("CAP REQ :batch labeled-response")
+	c.Send("NICK :bot")
+	c.Send("USER bot 0 * :Real Name")
+	c.Send("CAP END")
+	c.Expect(" 376 ")
+
+	c.Send("@label=a PING :x")
+	line := c.Expect(" PONG ")
+	if !strings.HasPrefix(line, "@label=a :") {
+		t.Error("Expected a single reply to be labeled, got:", line)
+	}
+
+	c.Send("@label=b PONG :x")
+	c.Expect("@label=b :" + DefaultName + " ACK")
+
+	c.Send("@label=c WHOIS :bot")
+	line = c.Expect(" BATCH +")
+	if !strings.HasPrefix(line, "@label=c ") ||
+		!strings.HasSuffix(line, " labeled-response") {
+		t.Error("Expected a labeled batch to start, got:", line)
+	}
+	id := line[strings.Index(line, "BATCH +")+7 : strings.LastIndex(line, " ")]
+	line = c.Expect(" 311 ")
+	if !strings.HasPrefix(line, "@batch="+id+" ") {
+		t.Error("Expected the reply to be in the batch, got:", line)
+	}
+	c.Expect(" BATCH -" + id)
+}
+
+func TestServer_WhoisIson(t *testing.T) {
+	t.Parallel()
+	s := CreateServer("")
+	defer s.Close()
+
+	c := register(t, s, "bot")
+	s.Join("fish!fishy@fish.net", "#chan")
+	s.Mode("", "#chan", "+v", "fish")
+
+	c.Send("WHOIS :fish")
+	line := c.Expect(" 311 ")
+	if !strings.HasSuffix(line, " 311 bot fish fishy fish.net * :fish") {
+		t.Error("Expected the whois user reply, got:", line)
+	}
+	line = c.Expect(" 319 ")
+	if !strings.HasSuffix(line, " 319 bot fish :+#chan") {
+		t.Error("Expected the whois channels reply, got:", line)
+	}
+	c.Expect(" 312 bot fish " + DefaultName)
+	c.Expect(" 318 bot fish ")
+
+	c.Send("WHOIS :nobody")
+	c.Expect(" 401 bot nobody ")
+	c.Expect(" 318 bot nobody ")
+
+	c.Send("ISON :nobody FISH bot")
+	line = c.Expect(" 303 ")
+	if !strings.HasSuffix(line, " 303 bot :fish bot") {
+		t.Error("Expected the online nicks, got:", line)
+	}
+}
@@ -4,6 +4,7 @@ Package parse has functions to parse the irc protocol into irc.IrcMessages.
 package parse
 
 import (
+	"bytes"
 	"github.com/aarondl/ultimateq/irc"
 	"regexp"
 	"strings"
@@ -38,6 +39,16 @@ func (p ParseError) Error() string {
 // protocol message, split by \r\n, and \r\n should not be
 // present at the end of the string.
 func Parse(str []byte) (*irc.Message, error) {
+	var tags map[string]string
+	if len(str) > 0 && str[0] == '@' {
+		i := bytes.IndexByte(str, ' ')
+		if i < 0 {
+			return nil, ParseError{Msg: errMsgParseFailure, Irc: string(str)}
+		}
+		tags = parseTags(string(str[1:i]))
+		str = bytes.TrimLeft(str[i:], " ")
+	}
+
 	parts := ircRegex.FindSubmatch(str)
 	if parts == nil {
 		return nil, ParseError{Msg: errMsgParseFailure, Irc: string(str)}
@@ -58,5 +69,56 @@ func Parse(str []byte) (*irc.Message, error) {
 		}
 	}
 
-	return irc.NewMessage(name, sender, args...), nil
+	msg := irc.NewMessage(name, sender, args...)
+	msg.Tags = tags
+	return msg, nil
+}
+
+// parseTags parses the IRCv3 message tags: key=value;key2 with values
+// unescaped.
+func parseTags(str string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(str, ";") {
+		if len(tag) == 0 {
+			continue
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 1 {
+			tags[kv[0]] = ""
+		} else {
+			tags[kv[0]] = unescapeTag(kv[1])
+		}
+	}
+	return tags
+}
+
+// unescapeTag unescapes a tag value.
+func unescapeTag(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf.WriteByte(value[i])
+			continue
+		}
+		if i++; i == len(value) {
+			break
+		}
+		switch value[i] {
+		case ':':
+			buf.WriteByte(';')
+		case 's':
+			buf.WriteByte(' ')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'n':
+			buf.WriteByte('\n')
+		default:
+			buf.WriteByte(value[i])
+		}
+	}
+	return buf.String()
 }
@@ -85,3 +85,29 @@ func (s *s) TestParse_Error(c *C) {
 	c.Check(ok, Equals, true)
 	c.Check(e.Irc, Equals, irc)
 }
+
+func (s *s) TestParse_Tags(c *C) {
+	msg, err := Parse([]byte(
+		`@label=abc;batch;msgid=a\:b\sc\\d\r\n :irc.test.net 318 nick :End`))
+	c.Check(err, IsNil)
+	c.Check(msg.Name, Equals, "318")
+	c.Check(msg.Sender, Equals, "irc.test.net")
+	c.Check(len(msg.Tags), Equals, 3)
+	c.Check(msg.Tags["label"], Equals, "abc")
+	c.Check(msg.Tags["msgid"], Equals, "a;b c\\d\r\n")
+	v, ok := msg.Tags["batch"]
+	c.Check(ok, Equals, true)
+	c.Check(v, Equals, "")
+
+	msg, err = Parse([]byte(`@a=b\ PING :1`))
+	c.Check(err, IsNil)
+	c.Check(msg.Tags["a"], Equals, "b")
+	c.Check(msg.Args[0], Equals, "1")
+
+	msg, err = Parse([]byte("PING :1"))
+	c.Check(err, IsNil)
+	c.Check(msg.Tags, IsNil)
+
+	_, err = Parse([]byte("@a=b"))
+	c.Check(err, NotNil)
+}